@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/pprof"
 	"regexp"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -38,6 +40,7 @@ type controllerInformer interface {
 	ListQueue(workerID uint32) (*spec.QueueDump, error)
 	GetWorkersCnt() uint32
 	WorkerStatus(workerID uint32) (*spec.WorkerStatus, error)
+	ClusterMetrics() map[spec.NamespacedName]spec.ClusterMetrics
 }
 
 // Server describes HTTP API server
@@ -80,6 +83,7 @@ func New(controller controllerInformer, port int, logger *logrus.Logger) *Server
 	mux.HandleFunc("/clusters/", s.clusters)
 	mux.HandleFunc("/workers/", s.workers)
 	mux.HandleFunc("/databases/", s.databases)
+	mux.HandleFunc("/metrics", s.metrics)
 
 	s.http = http.Server{
 		Addr:        fmt.Sprintf(":%d", port),
@@ -267,3 +271,41 @@ func (s *Server) allWorkers(w http.ResponseWriter, r *http.Request) {
 
 	s.respond(resp, nil, w)
 }
+
+// metrics exposes the cluster metrics in the Prometheus text format, so that they can be scraped
+// and used by external autoscalers.
+func (s *Server) metrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeClusterMetrics(w, s.controller.ClusterMetrics())
+}
+
+func writeClusterMetrics(w io.Writer, clusterMetrics map[spec.NamespacedName]spec.ClusterMetrics) {
+	clusters := make([]spec.NamespacedName, 0, len(clusterMetrics))
+	for name, metrics := range clusterMetrics {
+		// skip the clusters no metrics have been collected for yet
+		if !metrics.CollectedAt.IsZero() {
+			clusters = append(clusters, name)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].String() < clusters[j].String() })
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(spec.ClusterMetrics) float64
+	}{
+		{"postgres_operator_cluster_active_connections", "Number of active connections.",
+			func(m spec.ClusterMetrics) float64 { return float64(m.ActiveConnections) }},
+		{"postgres_operator_cluster_transactions_per_second", "Transactions per second since the previous sync.",
+			func(m spec.ClusterMetrics) float64 { return m.TransactionsPerSecond }},
+		{"postgres_operator_cluster_cache_hit_ratio", "Ratio of the blocks found in the shared buffers.",
+			func(m spec.ClusterMetrics) float64 { return m.CacheHitRatio }},
+	}
+	for _, gauge := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, name := range clusters {
+			fmt.Fprintf(w, "%s{namespace=%q,cluster=%q} %g\n",
+				gauge.name, name.Namespace, name.Name, gauge.value(clusterMetrics[name]))
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package cluster
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+)
+
+// the environment of the archiving in Spilo, read by both WAL-E and WAL-G
+const walEnvDir = "/home/postgres/etc/wal-e.d/env"
+
+// checks that the archive has no gaps from the last base backup on and that the timeline history is complete
+var walVerifyCommand = fmt.Sprintf("envdir %s wal-g wal-verify integrity timeline", walEnvDir)
+
+// no row is returned until the master has archived its first WAL segment
+const getWALArchiveLagSQL = `SELECT EXTRACT(EPOCH FROM now() - last_archived_time)::bigint
+	 FROM pg_stat_archiver WHERE last_archived_time IS NOT NULL;`
+
+// TriggerBackup takes an immediate base backup of the cluster on its master pod and returns once it is done.
+// The cluster lock is not held, so that a long running backup does not stall the syncs of the cluster; the spec is
+// read from a snapshot taken under the spec lock, since a sync running in parallel may replace it.
+func (c *Cluster) TriggerBackup() error {
+	pg, err := c.GetSpec()
+	if err != nil {
+		return fmt.Errorf("could not get the spec of the cluster: %v", err)
+	}
+
+	return c.takeBaseBackup(&pg.Spec, c.execOnMaster)
+}
+
+// baseBackupCommand returns the script Spilo runs from its own cron for the scheduled base backups.
+func baseBackupCommand(volume *spec.Volume) string {
+	return fmt.Sprintf("envdir %s /scripts/postgres_backup.sh %s/data", walEnvDir, dataPath(volume))
+}
+
+func (c *Cluster) takeBaseBackup(pgSpec *spec.PostgresSpec, commandExecutor func(cmd string) (string, error)) error {
+	if bucket, _ := c.walLocation(pgSpec.Backup); bucket == "" {
+		return fmt.Errorf("no WAL bucket is configured for the cluster")
+	}
+
+	c.logger.Infof("taking a base backup of the cluster")
+	out, err := commandExecutor(baseBackupCommand(&pgSpec.Volume))
+	if err != nil {
+		return fmt.Errorf("could not take base backup: %v", err)
+	}
+	c.logger.Debugf("base backup output: %s", strings.TrimSpace(out))
+	c.logger.Infof("base backup of the cluster has been taken")
+
+	return nil
+}
+
+// WALLocation returns the bucket and the prefix Spilo archives the WAL and the base backups of the cluster to.
+func (c *Cluster) WALLocation() (bucket, prefix string) {
+	c.specMu.RLock()
+	defer c.specMu.RUnlock()
+
+	return c.walLocation(c.Spec.Backup)
+}
+
+// shouldTakeFinalBackup tells whether a base backup is taken before the cluster is deleted. The manifest overrides
+// the operator configuration, standby clusters and clusters without pods have nothing to back up.
+func (c *Cluster) shouldTakeFinalBackup() bool {
+	if c.isStandbyCluster() || c.getNumberOfInstances(&c.Spec) <= 0 {
+		return false
+	}
+	if c.Spec.FinalBackupOnDelete != nil {
+		return *c.Spec.FinalBackupOnDelete
+	}
+	return c.OpConfig.FinalBackupOnDelete
+}
+
+// execOnMaster runs the shell command in the postgres container of the master pod.
+func (c *Cluster) execOnMaster(cmd string) (string, error) {
+	masterPods, err := c.getRolePods(Master)
+	if err != nil {
+		return "", fmt.Errorf("could not get master pod: %v", err)
+	}
+	if len(masterPods) == 0 {
+		return "", fmt.Errorf("no master pod is running in the cluster")
+	}
+	podName := util.NameFromMeta(masterPods[0].ObjectMeta)
+
+	return c.ExecCommand(&podName, "sh", "-c", cmd)
+}
+
+// backupPruneDue tells whether the manifest limits the backups and the last pruning is long enough ago.
+func (c *Cluster) backupPruneDue(now time.Time) bool {
+	backup := c.Spec.Backup
+	if backup == nil || (backup.Retention == nil && backup.RetentionDays == nil) {
+		return false
+	}
+	return now.Sub(c.lastBackupPrune) >= c.OpConfig.BackupPruneInterval
+}
+
+// pruneBackups removes the base backups and the WAL beyond the retention of the manifest from the master pod.
+func (c *Cluster) pruneBackups() error {
+	c.setProcessName("removing old base backups")
+
+	if bucket, _ := c.WALLocation(); bucket == "" {
+		return fmt.Errorf("no WAL bucket is configured for the cluster")
+	}
+
+	now := time.Now()
+	command, err := backupPruneCommand(c.Spec.Backup, now, c.usesWALG())
+	if err != nil {
+		// nothing changes until the manifest does, the error is reported once per interval
+		c.lastBackupPrune = now
+		return err
+	}
+	out, err := c.execOnMaster(command)
+	if err != nil {
+		return err
+	}
+	c.logger.Debugf("backup pruning output: %s", strings.TrimSpace(out))
+	c.lastBackupPrune = now
+
+	return nil
+}
+
+// backupPruneCommand returns the command keeping the newest base backups given by the retention as well as the ones
+// taken within the retention days, whichever are more, and removing the rest. WAL-E only keeps a number of backups,
+// the retention days need WAL-G.
+func backupPruneCommand(backup *spec.Backup, now time.Time, walg bool) (string, error) {
+	if !walg {
+		if backup.RetentionDays != nil {
+			return "", fmt.Errorf("the retention days need the base backups taken by WAL-G, " +
+				"which USE_WALG_BACKUP or the delta backups enable")
+		}
+		return fmt.Sprintf("envdir %s wal-e delete --confirm retain %d", walEnvDir, *backup.Retention), nil
+	}
+
+	var args []string
+	if backup.RetentionDays != nil {
+		after := now.AddDate(0, 0, -int(*backup.RetentionDays)).UTC().Format(time.RFC3339)
+		if backup.Retention != nil {
+			args = []string{"retain", "FULL", fmt.Sprint(*backup.Retention), "--after", after}
+		} else {
+			args = []string{"before", "FIND_FULL", after}
+		}
+	} else {
+		args = []string{"retain", "FULL", fmt.Sprint(*backup.Retention)}
+	}
+
+	return fmt.Sprintf("envdir %s wal-g delete %s --confirm", walEnvDir, strings.Join(args, " ")), nil
+}
+
+// usesWALG tells whether Spilo takes the base backups with WAL-G rather than WAL-E, as the USE_WALG_BACKUP variable
+// of the postgres container says, set either for the delta backups or by the pod environment configmap.
+func (c *Cluster) usesWALG() bool {
+	if c.Statefulset == nil {
+		return false
+	}
+	walg := false
+	for _, container := range c.Statefulset.Spec.Template.Spec.Containers {
+		if container.Name != constants.PostgresContainerName {
+			continue
+		}
+		// the last value of a variable defined more than once wins
+		for _, env := range container.Env {
+			if env.Name == "USE_WALG_BACKUP" {
+				walg = env.Value == "true"
+			}
+		}
+	}
+	return walg
+}
+
+// updateBackupStatus probes the WAL archiving lag of the master and the newest base backup in the archive. The
+// results go to the status of the cluster, which the sync writes to the manifest along with the phase.
+func (c *Cluster) updateBackupStatus() error {
+	c.setProcessName("probing the backups")
+
+	if err := c.initDbConn(); err != nil {
+		return fmt.Errorf("could not init database connection: %v", err)
+	}
+	defer func() {
+		if err := c.closeDbConn(); err != nil {
+			c.logger.Errorf("could not close database connection: %v", err)
+		}
+	}()
+	lag, err := readWALArchiveLag(c.pgDb)
+	if err != nil {
+		return err
+	}
+	c.Status.WALArchiveLagSeconds = lag
+
+	if bucket, _ := c.WALLocation(); bucket == "" {
+		return nil
+	}
+	out, err := c.execOnMaster(backupListCommand(c.usesWALG()))
+	if err != nil {
+		return fmt.Errorf("could not list base backups: %v", err)
+	}
+	lastBackup, err := parseLastBackupTime(out)
+	if err != nil {
+		return err
+	}
+	c.Status.LastBackupTime = lastBackup
+
+	return nil
+}
+
+func readWALArchiveLag(db *sql.DB) (*int64, error) {
+	var lag int64
+	err := db.QueryRow(getWALArchiveLagSQL).Scan(&lag)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not query WAL archiving lag: %v", err)
+	}
+	return &lag, nil
+}
+
+// backupListCommand returns the listing of the base backups in the archive by the tool taking them.
+func backupListCommand(walg bool) string {
+	if walg {
+		return fmt.Sprintf("envdir %s wal-g backup-list", walEnvDir)
+	}
+	return fmt.Sprintf("envdir %s wal-e backup-list", walEnvDir)
+}
+
+// parseLastBackupTime returns the modification time of the newest base backup in the output of the backup-list,
+// a header followed by the name and the modification time of each backup, then further columns differing between
+// WAL-G and WAL-E.
+func parseLastBackupTime(out string) (*metav1.Time, error) {
+	var last *metav1.Time
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == "name" {
+			continue
+		}
+		modified, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse time of the base backup %q: %v", fields[0], err)
+		}
+		if last == nil || modified.After(last.Time) {
+			t := metav1.NewTime(modified)
+			last = &t
+		}
+	}
+	return last, nil
+}
+
+// walVerifyDue tells whether the manifest asks for the verification of the archive and the last one is long enough ago.
+func (c *Cluster) walVerifyDue(now time.Time) bool {
+	if c.Spec.Backup == nil || !c.Spec.Backup.VerifyWAL {
+		return false
+	}
+	return now.Sub(c.lastWALVerify) >= c.OpConfig.WALVerifyInterval
+}
+
+// walVerifyFailed tells whether the last verification of the archive has found a problem. A result left in the
+// status from the time the verification was enabled does not count once it has been turned off.
+func (c *Cluster) walVerifyFailed() bool {
+	return c.Spec.Backup != nil && c.Spec.Backup.VerifyWAL &&
+		c.Status.WALVerified != nil && !*c.Status.WALVerified
+}
+
+// verifyWALArchive checks that the archive is restorable with wal-g wal-verify on the master pod, records the result in
+// the status and reports a failure with a warning event of the cluster.
+func (c *Cluster) verifyWALArchive() error {
+	c.setProcessName("verifying the WAL archive")
+
+	if bucket, _ := c.WALLocation(); bucket == "" {
+		return fmt.Errorf("no WAL bucket is configured for the cluster")
+	}
+
+	now := time.Now()
+	out, err := c.execOnMaster(walVerifyCommand)
+	if err != nil {
+		return fmt.Errorf("could not verify WAL archive: %v", err)
+	}
+	c.logger.Debugf("WAL archive verification output: %s", strings.TrimSpace(out))
+	c.lastWALVerify = now
+
+	failures := walVerifyFailures(out)
+	verified := len(failures) == 0
+	verifiedAt := metav1.NewTime(now)
+	c.Status.WALVerified = &verified
+	c.Status.LastWALVerifyTime = &verifiedAt
+	if !verified {
+		message := fmt.Sprintf("the WAL archive is not restorable: %s", strings.Join(failures, ", "))
+		c.logger.Warning(message)
+		c.createWarningEvent(eventReasonWALVerifyFailed, message)
+	}
+
+	return nil
+}
+
+// walVerifyFailures returns the checks reported as failed in the output of wal-g wal-verify, i.e. "integrity" for
+// "[wal-verify] integrity check status: FAILURE". Warnings are not failures, they are reported for the segments of
+// the last minutes that are not uploaded yet.
+func walVerifyFailures(out string) []string {
+	var failures []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "[wal-verify]"))
+		if len(fields) == 4 && fields[1] == "check" && fields[2] == "status:" && fields[3] == "FAILURE" {
+			failures = append(failures, fields[0])
+		}
+	}
+	return failures
+}
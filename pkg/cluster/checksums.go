@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// available starting from Postgres 12, the row without a database name counts the failures in the shared catalogs
+const getChecksumFailuresSQL = `SELECT COALESCE(datname, ''), checksum_failures
+	 FROM pg_stat_database
+	 WHERE checksum_failures > 0;`
+
+func readChecksumFailures(db *sql.DB) (map[string]int64, error) {
+	rows, err := db.Query(getChecksumFailuresSQL)
+	if err != nil {
+		return nil, fmt.Errorf("could not query checksum failures: %v", err)
+	}
+	defer rows.Close()
+
+	failures := make(map[string]int64)
+	for rows.Next() {
+		var (
+			datname string
+			count   int64
+		)
+		if err := rows.Scan(&datname, &count); err != nil {
+			return nil, fmt.Errorf("error when processing checksum failures: %v", err)
+		}
+		failures[datname] = count
+	}
+
+	return failures, rows.Err()
+}
+
+// checkDataChecksums reads the data checksum failures Postgres has detected while reading the data pages and
+// reports the affected databases. It returns true when there are any, which marks the cluster as degraded.
+// The counters are never reset by the operator, so the cluster stays degraded until the statistics are reset.
+func (c *Cluster) checkDataChecksums() bool {
+	c.setProcessName("checking data checksums")
+
+	if err := c.initDbConn(); err != nil {
+		c.logger.Warningf("could not init database connection to check data checksums: %v", err)
+		return false
+	}
+	defer func() {
+		if err := c.closeDbConn(); err != nil {
+			c.logger.Errorf("could not close database connection: %v", err)
+		}
+	}()
+
+	failures, err := readChecksumFailures(c.pgDb)
+	if err != nil {
+		c.logger.Warningf("could not check data checksums: %v", err)
+		return false
+	}
+	c.metricsMu.Lock()
+	c.checksumFailures = failures
+	c.metricsMu.Unlock()
+
+	if len(failures) == 0 {
+		return false
+	}
+	message := fmt.Sprintf("data checksum failures detected in %s", describeChecksumFailures(failures))
+	c.logger.Warning(message)
+	c.createWarningEvent(eventReasonChecksumFailed, message)
+
+	return true
+}
+
+func describeChecksumFailures(failures map[string]int64) string {
+	descriptions := make([]string, 0, len(failures))
+	for datname, count := range failures {
+		if datname == "" {
+			descriptions = append(descriptions, fmt.Sprintf("the shared catalogs (%d)", count))
+		} else {
+			descriptions = append(descriptions, fmt.Sprintf("database %q (%d)", datname, count))
+		}
+	}
+	sort.Strings(descriptions)
+
+	return strings.Join(descriptions, ", ")
+}
+
+// GetChecksumFailures returns the data checksum failures per database found during the last sync
+func (c *Cluster) GetChecksumFailures() map[string]int64 {
+	c.metricsMu.RLock()
+	defer c.metricsMu.RUnlock()
+
+	return c.checksumFailures
+}
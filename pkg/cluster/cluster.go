@@ -16,6 +16,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/apps/v1beta1"
+	batchv2alpha1 "k8s.io/client-go/pkg/apis/batch/v2alpha1"
 	policybeta1 "k8s.io/client-go/pkg/apis/policy/v1beta1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -27,6 +28,7 @@ import (
 	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/patroni"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/teams"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/tracing"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/users"
 )
 
@@ -43,6 +45,7 @@ type Config struct {
 	RestConfig          *rest.Config
 	InfrastructureRoles map[string]spec.PgUser // inherited from the controller
 	PodServiceAccount   *v1.ServiceAccount
+	Tracer              *tracing.Tracer // nil when tracing is disabled
 }
 
 type kubeResources struct {
@@ -51,6 +54,7 @@ type kubeResources struct {
 	Secrets             map[types.UID]*v1.Secret
 	Statefulset         *v1beta1.StatefulSet
 	PodDisruptionBudget *policybeta1.PodDisruptionBudget
+	LogicalBackupJob    *batchv2alpha1.CronJob
 	//Pods are treated separately
 	//PVCs are treated separately
 }
@@ -64,16 +68,40 @@ type Cluster struct {
 	patroni          patroni.Interface
 	pgUsers          map[string]spec.PgUser
 	systemUsers      map[string]spec.PgUser
+	rotatedSecrets   map[string]bool // the roles whose secrets hold the credentials of one of their rotation roles
 	podSubscribers   map[spec.NamespacedName]chan spec.PodEvent
 	podSubscribersMu sync.RWMutex
 	pgDb             *sql.DB
+	pgReadDb         *sql.DB
 	mu               sync.Mutex
 	userSyncStrategy spec.UserSyncer
 	deleteOptions    *metav1.DeleteOptions
 	podEventsQueue   *cache.FIFO
+	crashLoopingPods map[spec.NamespacedName]bool
+	statusPending    bool
+	podHealthMu      sync.Mutex // protects crashLoopingPods and statusPending, must not depend on the master mutex
+	lastVolumeResize time.Time
+	lastBackupPrune  time.Time
+	lastWALVerify    time.Time
+	lastPgStats      *pgStats
+	metrics          spec.ClusterMetrics
+	recommendation   *spec.ResourceRecommendation
+	lastUsage        map[string]containerUsage
+	usagePeaks       resourcePeaks
+	checksumFailures map[string]int64
+	logicalSlots     []spec.LogicalSlot
+	replication      *spec.ReplicationStatus
+	metricsMu        sync.RWMutex // protects the metrics for reporting, no need to hold the master mutex
+	roleLabel        string
+	roleLabelMu      sync.RWMutex // protects the detected role label, which is also read when processing pod events
+	span             *tracing.Span
+
+	// protects the InfrastructureRoles of the config, which the controller replaces at any time
+	infrastructureRolesMu sync.RWMutex
 
 	teamsAPIClient   teams.Interface
 	oauthTokenGetter OAuthTokenGetter
+	credentialsStore credentialsStore         // nil unless the credentials are stored outside of the secrets as well
 	KubeClient       k8sutil.KubernetesClient //TODO: move clients to the better place?
 	currentProcess   spec.Process
 	processMu        sync.RWMutex // protects the current operation for reporting, no need to hold the master mutex
@@ -85,6 +113,7 @@ type compareStatefulsetResult struct {
 	replace       bool
 	rollingUpdate bool
 	reasons       []string
+	rejectReason  string // set when the change cannot be applied to the existing statefulset
 }
 
 // New creates a new cluster. This function should be called from a controller.
@@ -101,16 +130,18 @@ func New(cfg Config, kubeClient k8sutil.KubernetesClient, pgSpec spec.Postgresql
 	})
 
 	cluster := &Cluster{
-		Config:         cfg,
-		Postgresql:     pgSpec,
-		pgUsers:        make(map[string]spec.PgUser),
-		systemUsers:    make(map[string]spec.PgUser),
-		podSubscribers: make(map[spec.NamespacedName]chan spec.PodEvent),
+		Config:           cfg,
+		Postgresql:       pgSpec,
+		pgUsers:          make(map[string]spec.PgUser),
+		systemUsers:      make(map[string]spec.PgUser),
+		podSubscribers:   make(map[spec.NamespacedName]chan spec.PodEvent),
+		crashLoopingPods: make(map[spec.NamespacedName]bool),
+		lastUsage:        make(map[string]containerUsage),
 		kubeResources: kubeResources{
 			Secrets:   make(map[types.UID]*v1.Secret),
 			Services:  make(map[PostgresRole]*v1.Service),
 			Endpoints: make(map[PostgresRole]*v1.Endpoints)},
-		userSyncStrategy: users.DefaultUserSyncStrategy{},
+		userSyncStrategy: users.DefaultUserSyncStrategy{PasswordEncryption: cfg.OpConfig.PasswordEncryption},
 		deleteOptions:    &metav1.DeleteOptions{OrphanDependents: &orphanDependents},
 		podEventsQueue:   podEventsQueue,
 		KubeClient:       kubeClient,
@@ -118,6 +149,7 @@ func New(cfg Config, kubeClient k8sutil.KubernetesClient, pgSpec spec.Postgresql
 	cluster.logger = logger.WithField("pkg", "cluster").WithField("cluster-name", cluster.clusterName())
 	cluster.teamsAPIClient = teams.NewTeamsAPI(cfg.OpConfig.TeamsAPIUrl, logger)
 	cluster.oauthTokenGetter = NewSecretOauthTokenGetter(&kubeClient, cfg.OpConfig.OAuthTokenSecretName)
+	cluster.credentialsStore = newCredentialsStore(cluster, &cfg.OpConfig)
 	cluster.patroni = patroni.New(cluster.logger)
 
 	return cluster
@@ -145,9 +177,25 @@ func (c *Cluster) setProcessName(procName string, args ...interface{}) {
 	}
 }
 
-func (c *Cluster) setStatus(status spec.PostgresStatus) {
-	c.Status = status
-	b, err := json.Marshal(status)
+// startSpan opens a tracing span for the reconcile step, nested in the step currently in progress,
+// and returns the function that ends it. Must be called with the master mutex held.
+func (c *Cluster) startSpan(name string) func() {
+	var attributes map[string]string
+	if c.span == nil {
+		attributes = map[string]string{"cluster": c.clusterName().String(), "team": c.teamName()}
+	}
+	span := c.Tracer.Start(c.span, name, attributes)
+	c.span = span
+
+	return func() {
+		span.End()
+		c.span = span.Parent()
+	}
+}
+
+func (c *Cluster) setStatus(phase spec.ClusterPhase) {
+	c.Status.Phase = phase
+	b, err := json.Marshal(c.Status)
 	if err != nil {
 		c.logger.Fatalf("could not marshal status: %v", err)
 	}
@@ -161,21 +209,22 @@ func (c *Cluster) setStatus(status spec.PostgresStatus) {
 		DoRaw()
 
 	if k8sutil.ResourceNotFound(err) {
-		c.logger.Warningf("could not set %q status for the non-existing cluster", status)
+		c.logger.Warningf("could not set %q status for the non-existing cluster", phase)
 		return
 	}
 
 	if err != nil {
-		c.logger.Warningf("could not set %q status for the cluster: %v", status, err)
+		c.logger.Warningf("could not set %q status for the cluster: %v", phase, err)
 	}
 }
 
 func (c *Cluster) isNewCluster() bool {
-	return c.Status == spec.ClusterStatusCreating
+	return c.Status.Phase == spec.ClusterStatusCreating
 }
 
 // initUsers populates c.systemUsers and c.pgUsers maps.
 func (c *Cluster) initUsers() error {
+	defer c.startSpan("initUsers")()
 	c.setProcessName("initializing users")
 
 	// clear our the previous state of the cluster users (in case we are running a sync).
@@ -188,6 +237,9 @@ func (c *Cluster) initUsers() error {
 		return fmt.Errorf("could not init infrastructure roles: %v", err)
 	}
 
+	if c.reportInvalidRoleEntries(c.validateProtectedRoles()) {
+		c.setStatus(c.Status.Phase)
+	}
 	if err := c.initRobotUsers(); err != nil {
 		return fmt.Errorf("could not init robot users: %v", err)
 	}
@@ -236,8 +288,10 @@ func (c *Cluster) createPodServiceAccounts() error {
 func (c *Cluster) Create() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	defer c.startSpan("Create")()
 	var (
-		err error
+		err         error
+		cloneFailed bool
 
 		service *v1.Service
 		ep      *v1.Endpoints
@@ -247,6 +301,8 @@ func (c *Cluster) Create() error {
 	defer func() {
 		if err == nil {
 			c.setStatus(spec.ClusterStatusRunning) //TODO: are you sure it's running?
+		} else if cloneFailed {
+			c.setStatus(spec.ClusterStatusCloneFailed)
 		} else {
 			c.setStatus(spec.ClusterStatusAddFailed)
 		}
@@ -298,11 +354,25 @@ func (c *Cluster) Create() error {
 	}
 	c.logger.Infof("pod disruption budget %q has been successfully created", util.NameFromMeta(pdb.ObjectMeta))
 
+	if c.Spec.EnableLogicalBackup {
+		job, err := c.createLogicalBackupJob()
+		if err != nil {
+			return fmt.Errorf("could not create logical backup job: %v", err)
+		}
+		c.logger.Infof("logical backup job %q has been successfully created", util.NameFromMeta(job.ObjectMeta))
+	}
+
 	if err = c.createPodServiceAccounts(); err != nil {
 		return fmt.Errorf("could not create pod service account %v : %v", c.OpConfig.PodServiceAccountName, err)
 	}
 	c.logger.Infof("pod service accounts have been successfully synced")
 
+	if c.Spec.Clone.ClusterName != "" && c.Spec.Clone.EndTimestamp == "" {
+		if err = c.checkBasebackupCloneSource(); err != nil {
+			return fmt.Errorf("could not clone cluster %q: %v", c.Spec.Clone.ClusterName, err)
+		}
+	}
+
 	if c.Statefulset != nil {
 		return fmt.Errorf("statefulset already exists in the cluster")
 	}
@@ -316,21 +386,19 @@ func (c *Cluster) Create() error {
 
 	if err = c.waitStatefulsetPodsReady(); err != nil {
 		c.logger.Errorf("failed to create cluster: %v", err)
+		cloneFailed = c.handleFailedClone()
 		return err
 	}
 	c.logger.Infof("pods are ready")
 
 	// create database objects unless we are running without pods or disabled that feature explicitely
-	if !(c.databaseAccessDisabled() || c.getNumberOfInstances(&c.Spec) <= 0) {
-		if err = c.createRoles(); err != nil {
-			return fmt.Errorf("could not create users: %v", err)
+	if c.isStandbyCluster() {
+		c.logger.Infof("not creating users and databases in the standby cluster")
+	} else if !(c.databaseAccessDisabled() || c.getNumberOfInstances(&c.Spec) <= 0) {
+		if err = c.syncDatabaseObjects(); err != nil {
+			return fmt.Errorf("could not create database objects: %v", err)
 		}
-		c.logger.Infof("users have been successfully created")
-
-		if err = c.syncDatabases(); err != nil {
-			return fmt.Errorf("could not sync databases: %v", err)
-		}
-		c.logger.Infof("databases have been successfully created")
+		c.logger.Infof("users and databases have been successfully created")
 	}
 
 	if err := c.listResources(); err != nil {
@@ -340,9 +408,73 @@ func (c *Cluster) Create() error {
 	return nil
 }
 
+// failedBootstrapStates are the states Patroni reports when the custom bootstrap restoring the clone has failed.
+var failedBootstrapStates = map[string]bool{"custom bootstrap failed": true, "initdb failed": true}
+
+// isFailedClone tells a clone whose restore has failed from a clone that is merely slow to become ready, i.e. still
+// taking the base backup or replaying the WAL. The clone roles sync flag of the statefulset is cleared only after the
+// roles have been synced against the restored database and Patroni sets the master role label once the restore has
+// completed, so a failed clone has neither. On top of that, the failure must be reported: either Patroni reports a
+// failed bootstrap or the pod is crash looping.
+func (c *Cluster) isFailedClone() (bool, error) {
+	if c.Spec.Clone.ClusterName == "" || c.Statefulset == nil || !c.getCloneRolesSyncFlagFromStatefulSet(c.Statefulset) {
+		return false, nil
+	}
+	pods, err := c.listPods()
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods {
+		if PostgresRole(pod.Labels[c.podRoleLabel()]) == Master {
+			return false, nil
+		}
+	}
+	for i, pod := range pods {
+		if isPodCrashLooping(&pods[i]) {
+			c.logger.Debugf("pod %q of the clone is crash looping", util.NameFromMeta(pod.ObjectMeta))
+			return true, nil
+		}
+		if status, err := c.patroni.GetMemberStatus(&pods[i]); err == nil && failedBootstrapStates[status.State] {
+			c.logger.Debugf("patroni in the pod %q of the clone reports %q", util.NameFromMeta(pod.ObjectMeta), status.State)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// handleFailedClone removes the statefulset, the pods and the persistent volume claims of a failed clone, so that
+// the next sync re-creates the statefulset and restores into empty volumes instead of the partial data of the failed
+// attempt. Services, endpoints and secrets do not depend on the data and are kept. Returns whether the clone failed.
+func (c *Cluster) handleFailedClone() bool {
+	failed, err := c.isFailedClone()
+	if err != nil {
+		c.logger.Warningf("could not check whether the clone has failed: %v", err)
+		return false
+	}
+	if !failed {
+		return false
+	}
+	if !c.OpConfig.FailedCloneCleanup {
+		c.logger.Warningf("clone of the cluster %q has failed, keeping its resources", c.Spec.Clone.ClusterName)
+		return true
+	}
+
+	c.logger.Warningf("clone of the cluster %q has failed, removing its partial resources before the next attempt",
+		c.Spec.Clone.ClusterName)
+	if err := c.deleteStatefulSet(); err != nil {
+		c.logger.Errorf("could not remove the resources of the failed clone: %v", err)
+	} else if err := c.deletePersistenVolumeClaims(); err != nil {
+		c.logger.Errorf("could not remove the volumes of the failed clone: %v", err)
+	}
+
+	return true
+}
+
 func (c *Cluster) compareStatefulSetWith(statefulSet *v1beta1.StatefulSet) *compareStatefulsetResult {
 	reasons := make([]string, 0)
 	var match, needsRollUpdate, needsReplace bool
+	var rejectReason string
 
 	match = true
 	//TODO: improve me
@@ -354,12 +486,22 @@ func (c *Cluster) compareStatefulSetWith(statefulSet *v1beta1.StatefulSet) *comp
 		match = false
 		reasons = append(reasons, "new statefulset's annotations doesn't match the current one")
 	}
+	if c.Statefulset.Spec.UpdateStrategy.Type != statefulSet.Spec.UpdateStrategy.Type {
+		match = false
+		reasons = append(reasons, "new statefulset's update strategy doesn't match the current one")
+	}
 	if len(c.Statefulset.Spec.Template.Spec.Containers) != len(statefulSet.Spec.Template.Spec.Containers) {
 		needsRollUpdate = true
 		reasons = append(reasons, "new statefulset's container specification doesn't match the current one")
 	} else {
 		needsRollUpdate, reasons = c.compareContainers(c.Statefulset, statefulSet)
 	}
+	if dataVolumeSubPath(c.Statefulset) != dataVolumeSubPath(statefulSet) {
+		// the pods would start from an empty directory of the volume
+		rejectReason = "new statefulset's subPath of the data volume doesn't match the current one: " +
+			"the subPath can only be set when the cluster is created"
+		reasons = append(reasons, rejectReason)
+	}
 	if len(c.Statefulset.Spec.Template.Spec.Containers) == 0 {
 		c.logger.Warningf("statefulset %q has no container", util.NameFromMeta(c.Statefulset.ObjectMeta))
 		return &compareStatefulsetResult{}
@@ -410,11 +552,21 @@ func (c *Cluster) compareStatefulSetWith(statefulSet *v1beta1.StatefulSet) *comp
 		needsRollUpdate = true
 		reasons = append(reasons, "new statefulset's pod template metadata annotations doesn't match the current one")
 	}
-	if len(c.Statefulset.Spec.VolumeClaimTemplates) != len(statefulSet.Spec.VolumeClaimTemplates) {
+	if (len(c.Statefulset.Spec.VolumeClaimTemplates) == 0) != (len(statefulSet.Spec.VolumeClaimTemplates) == 0) {
+		// only ephemeral clusters run without volume claims, the data would be lost or left behind
+		rejectReason = "new statefulset's volume mode doesn't match the current one: " +
+			"the volume mode can only be set when the cluster is created"
+		reasons = append(reasons, rejectReason)
+	} else if len(c.Statefulset.Spec.VolumeClaimTemplates) > len(statefulSet.Spec.VolumeClaimTemplates) {
+		// the pods would not start without the volumes of their tablespaces
+		rejectReason = "new statefulset's volumeClaimTemplates contains fewer volumes than the old one: " +
+			"tablespaces cannot be removed from the manifest"
+		reasons = append(reasons, rejectReason)
+	} else if len(c.Statefulset.Spec.VolumeClaimTemplates) != len(statefulSet.Spec.VolumeClaimTemplates) {
 		needsReplace = true
 		reasons = append(reasons, "new statefulset's volumeClaimTemplates contains different number of volumes to the old one")
 	}
-	for i := 0; i < len(c.Statefulset.Spec.VolumeClaimTemplates); i++ {
+	for i := 0; i < len(c.Statefulset.Spec.VolumeClaimTemplates) && i < len(statefulSet.Spec.VolumeClaimTemplates); i++ {
 		name := c.Statefulset.Spec.VolumeClaimTemplates[i].Name
 		// Some generated fields like creationTimestamp make it not possible to use DeepCompare on ObjectMeta
 		if name != statefulSet.Spec.VolumeClaimTemplates[i].Name {
@@ -422,11 +574,27 @@ func (c *Cluster) compareStatefulSetWith(statefulSet *v1beta1.StatefulSet) *comp
 			reasons = append(reasons, fmt.Sprintf("new statefulset's name for volume %d doesn't match the current one", i))
 			continue
 		}
-		if !reflect.DeepEqual(c.Statefulset.Spec.VolumeClaimTemplates[i].Annotations, statefulSet.Spec.VolumeClaimTemplates[i].Annotations) {
+		if volumeClaimStorageClass(&c.Statefulset.Spec.VolumeClaimTemplates[i]) != volumeClaimStorageClass(&statefulSet.Spec.VolumeClaimTemplates[i]) {
+			// the existing persistent volumes would stay in the old storage class, only the new ones would move
+			rejectReason = fmt.Sprintf("new statefulset's storage class for volume %q doesn't match the current one: "+
+				"the storage class can only be set when the cluster is created", name)
+			reasons = append(reasons, rejectReason)
+		} else if !reflect.DeepEqual(c.Statefulset.Spec.VolumeClaimTemplates[i].Annotations, statefulSet.Spec.VolumeClaimTemplates[i].Annotations) {
 			needsReplace = true
 			reasons = append(reasons, fmt.Sprintf("new statefulset's annotations for volume %q doesn't match the current one", name))
 		}
-		if !reflect.DeepEqual(c.Statefulset.Spec.VolumeClaimTemplates[i].Spec, statefulSet.Spec.VolumeClaimTemplates[i].Spec) {
+		if !reflect.DeepEqual(c.Statefulset.Spec.VolumeClaimTemplates[i].Labels, statefulSet.Spec.VolumeClaimTemplates[i].Labels) {
+			// the labels of the existing persistent volume claims would not change anyway
+			rejectReason = fmt.Sprintf("new statefulset's labels for volume %q don't match the current one: "+
+				"volume claim template labels can only be set when the cluster is created", name)
+			reasons = append(reasons, rejectReason)
+		}
+		if volumeClaimShrinks(&c.Statefulset.Spec.VolumeClaimTemplates[i], &statefulSet.Spec.VolumeClaimTemplates[i]) &&
+			!c.forceVolumeShrink() {
+			rejectReason = fmt.Sprintf("new statefulset's size of volume %q is smaller than the current one: "+
+				"volumes cannot shrink unless forced", name)
+			reasons = append(reasons, rejectReason)
+		} else if !reflect.DeepEqual(c.Statefulset.Spec.VolumeClaimTemplates[i].Spec, statefulSet.Spec.VolumeClaimTemplates[i].Spec) {
 			name := c.Statefulset.Spec.VolumeClaimTemplates[i].Name
 			needsReplace = true
 			reasons = append(reasons, fmt.Sprintf("new statefulset's volumeClaimTemplates specification for volume %q doesn't match the current one", name))
@@ -437,7 +605,12 @@ func (c *Cluster) compareStatefulSetWith(statefulSet *v1beta1.StatefulSet) *comp
 		match = false
 	}
 
-	return &compareStatefulsetResult{match: match, reasons: reasons, rollingUpdate: needsRollUpdate, replace: needsReplace}
+	if rejectReason != "" {
+		match = false
+	}
+
+	return &compareStatefulsetResult{match: match, reasons: reasons, rollingUpdate: needsRollUpdate, replace: needsReplace,
+		rejectReason: rejectReason}
 }
 
 type ContainerCondition func(a, b v1.Container) bool
@@ -471,6 +644,8 @@ func (c *Cluster) compareContainers(setA, setB *v1beta1.StatefulSet) (bool, []st
 			func(a, b v1.Container) bool { return !reflect.DeepEqual(a.Env, b.Env) }),
 		NewCheck("new statefulset's container %d environment sources don't match the current one",
 			func(a, b v1.Container) bool { return !reflect.DeepEqual(a.EnvFrom, b.EnvFrom) }),
+		NewCheck("new statefulset's container %d volume mounts don't match the current ones",
+			func(a, b v1.Container) bool { return !reflect.DeepEqual(a.VolumeMounts, b.VolumeMounts) }),
 	}
 
 	for index, containerA := range setA.Spec.Template.Spec.Containers {
@@ -516,13 +691,57 @@ func compareResoucesAssumeFirstNotNil(a *v1.ResourceRequirements, b *v1.Resource
 
 }
 
+// compareLogicalBackupJob compares only the fields of the logical backup job the operator sets, since the API server
+// fills in the defaults of the others. It returns the reason of the first difference found.
+func compareLogicalBackupJob(cur, new *batchv2alpha1.CronJob) (match bool, reason string) {
+	if cur.Spec.Schedule != new.Spec.Schedule {
+		return false, fmt.Sprintf("new job's schedule %q doesn't match the current one %q",
+			new.Spec.Schedule, cur.Spec.Schedule)
+	}
+	if cur.Spec.ConcurrencyPolicy != new.Spec.ConcurrencyPolicy {
+		return false, "new job's concurrency policy doesn't match the current one"
+	}
+	curPod, newPod := cur.Spec.JobTemplate.Spec.Template, new.Spec.JobTemplate.Spec.Template
+	if !reflect.DeepEqual(curPod.Labels, newPod.Labels) {
+		return false, "new job's pod labels don't match the current ones"
+	}
+	if curPod.Spec.ServiceAccountName != newPod.Spec.ServiceAccountName ||
+		curPod.Spec.RestartPolicy != newPod.Spec.RestartPolicy {
+		return false, "new job's service account or restart policy doesn't match the current one"
+	}
+	if len(curPod.Spec.Containers) != len(newPod.Spec.Containers) {
+		return false, "new job's containers don't match the current ones"
+	}
+	for i, container := range newPod.Spec.Containers {
+		curContainer := curPod.Spec.Containers[i]
+		if curContainer.Name != container.Name || curContainer.Image != container.Image ||
+			curContainer.ImagePullPolicy != container.ImagePullPolicy {
+			return false, fmt.Sprintf("new job's container %q doesn't match the current one", container.Name)
+		}
+		if !reflect.DeepEqual(curContainer.Env, container.Env) {
+			return false, fmt.Sprintf("new job's container %q environment doesn't match the current one",
+				container.Name)
+		}
+	}
+
+	return true, ""
+}
+
 // Update changes Kubernetes objects according to the new specification. Unlike the sync case, the missing object.
 // (i.e. service) is treated as an error.
 func (c *Cluster) Update(oldSpec, newSpec *spec.Postgresql) error {
-	updateFailed := false
+	updateFailed, quorumLost := false, false
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	defer c.startSpan("Update")()
+
+	// edits of the labels or annotations of the manifest do not concern any of the cluster resources
+	if reflect.DeepEqual(c.Spec, newSpec.Spec) {
+		c.logger.Debugf("the specification has not changed, skipping the update")
+		c.setSpec(newSpec)
+		return nil
+	}
 
 	c.setStatus(spec.ClusterStatusUpdating)
 	c.setSpec(newSpec)
@@ -530,7 +749,9 @@ func (c *Cluster) Update(oldSpec, newSpec *spec.Postgresql) error {
 	defer func() {
 		if updateFailed {
 			c.setStatus(spec.ClusterStatusUpdateFailed)
-		} else if c.Status != spec.ClusterStatusRunning {
+		} else if quorumLost {
+			c.setStatus(spec.ClusterStatusDCSQuorumLost)
+		} else if c.Status.Phase != spec.ClusterStatusRunning {
 			c.setStatus(spec.ClusterStatusRunning)
 		}
 	}()
@@ -551,7 +772,10 @@ func (c *Cluster) Update(oldSpec, newSpec *spec.Postgresql) error {
 		}
 	}
 
-	if !reflect.DeepEqual(oldSpec.Spec.Users, newSpec.Spec.Users) {
+	if !reflect.DeepEqual(oldSpec.Spec.Users, newSpec.Spec.Users) ||
+		!reflect.DeepEqual(oldSpec.Spec.UserMemberships, newSpec.Spec.UserMemberships) ||
+		!reflect.DeepEqual(oldSpec.Spec.UserConnectionLimits, newSpec.Spec.UserConnectionLimits) ||
+		!reflect.DeepEqual(oldSpec.Spec.TeamMemberPrivileges, newSpec.Spec.TeamMemberPrivileges) {
 		c.logger.Debugf("syncing secrets")
 		if err := c.initUsers(); err != nil {
 			c.logger.Errorf("could not init users: %v", err)
@@ -567,8 +791,49 @@ func (c *Cluster) Update(oldSpec, newSpec *spec.Postgresql) error {
 		}
 	}
 
+	if oldSpec.Spec.EnableLogicalBackup != newSpec.Spec.EnableLogicalBackup ||
+		oldSpec.Spec.LogicalBackupSchedule != newSpec.Spec.LogicalBackupSchedule {
+		c.logger.Debugf("syncing logical backup job")
+		if err := c.syncLogicalBackupJob(); err != nil {
+			c.logger.Errorf("could not sync logical backup job: %v", err)
+			updateFailed = true
+		}
+	}
+
+	// volumes and the statefulset are left alone while Patroni keeps the cluster without a master
+	if quorumLost = c.checkDCSQuorum(); quorumLost {
+		return nil
+	}
+
+	// Standby promotion, before the pods are rolled without the standby environment
+	promoted, readOnly := false, c.isStandbyCluster()
+	if oldSpec.Spec.StandbyCluster != nil && newSpec.Spec.StandbyCluster == nil {
+		c.logger.Infof("promoting the standby cluster")
+		if err := c.promoteStandbyCluster(); err != nil {
+			c.logger.Errorf("could not promote the standby cluster: %v", err)
+			updateFailed, readOnly = true, true
+		} else {
+			promoted = true
+			// the master service and endpoint have followed the standby leader so far
+			if err := c.syncServices(); err != nil {
+				c.logger.Errorf("could not sync services: %v", err)
+				updateFailed = true
+			}
+		}
+	} else if oldSpec.Spec.StandbyCluster == nil && newSpec.Spec.StandbyCluster != nil {
+		c.logger.Warningf("turning the cluster into a standby cluster has no effect on the running cluster")
+	}
+
 	// Volume
-	if oldSpec.Spec.Size != newSpec.Spec.Size {
+	if !sameVolumeProperties(oldSpec.Spec.Volume, newSpec.Spec.Volume) {
+		c.logger.Debugf("modifying persistent volumes")
+		c.logVolumeChanges(oldSpec.Spec.Volume, newSpec.Spec.Volume)
+
+		if err := c.modifyVolumes(); err != nil {
+			c.logger.Errorf("could not modify persistent volumes: %v", err)
+			updateFailed = true
+		}
+	} else if oldSpec.Spec.Size != newSpec.Spec.Size {
 		c.logger.Debugf("syncing persistent volumes")
 		c.logVolumeChanges(oldSpec.Spec.Volume, newSpec.Spec.Volume)
 
@@ -604,20 +869,49 @@ func (c *Cluster) Update(oldSpec, newSpec *spec.Postgresql) error {
 		}
 	}()
 
-	// Roles and Databases
-	if !(c.databaseAccessDisabled() || c.getNumberOfInstances(&c.Spec) <= 0) {
+	if oldSpec.Spec.Size != newSpec.Spec.Size {
+		if err := c.syncVolumeShrink(); err != nil {
+			c.logger.Errorf("could not shrink volumes: %v", err)
+			updateFailed = true
+		}
+	}
+
+	// Roles and Databases, the promoted cluster gets the users and the databases of its manifest at this point
+	if !(readOnly || c.databaseAccessDisabled() || c.getNumberOfInstances(&c.Spec) <= 0) {
 		c.logger.Debugf("syncing roles")
 		if err := c.syncRoles(); err != nil {
 			c.logger.Errorf("could not sync roles: %v", err)
 			updateFailed = true
 		}
-		if !reflect.DeepEqual(oldSpec.Spec.Databases, newSpec.Spec.Databases) {
+		if removed := c.removedManifestRoles(oldSpec.Spec.Users, newSpec.Spec.Users); len(removed) > 0 {
+			c.logger.Infof("deprovisioning roles removed from the manifest")
+			if err := c.deprovisionRoles(removed); err != nil {
+				c.logger.Errorf("could not deprovision roles: %v", err)
+				updateFailed = true
+			}
+		}
+		if promoted || !reflect.DeepEqual(oldSpec.Spec.Tablespaces, newSpec.Spec.Tablespaces) {
+			c.logger.Infof("syncing tablespaces")
+			if err := c.syncTablespaces(); err != nil {
+				c.logger.Errorf("could not sync tablespaces: %v", err)
+				updateFailed = true
+			}
+		}
+		if promoted || !reflect.DeepEqual(oldSpec.Spec.Databases, newSpec.Spec.Databases) {
 			c.logger.Infof("syncing databases")
 			if err := c.syncDatabases(); err != nil {
 				c.logger.Errorf("could not sync databases: %v", err)
 				updateFailed = true
 			}
 		}
+		if promoted || !reflect.DeepEqual(oldSpec.Spec.Databases, newSpec.Spec.Databases) ||
+			!reflect.DeepEqual(oldSpec.Spec.DefaultPrivileges, newSpec.Spec.DefaultPrivileges) {
+			c.logger.Infof("syncing default privileges")
+			if err := c.syncDefaultPrivileges(); err != nil {
+				c.logger.Errorf("could not sync default privileges: %v", err)
+				updateFailed = true
+			}
+		}
 	}
 
 	return nil
@@ -628,14 +922,22 @@ func (c *Cluster) Update(oldSpec, newSpec *spec.Postgresql) error {
 // DCS, reuses the master's endpoint to store the leader related metadata. If we remove the endpoint
 // before the pods, it will be re-created by the current master pod and will remain, obstructing the
 // creation of the new cluster with the same name. Therefore, the endpoints should be deleted last.
-func (c *Cluster) Delete() {
+func (c *Cluster) Delete() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-
-	if err := c.deleteStatefulSet(); err != nil {
-		c.logger.Warningf("could not delete statefulset: %v", err)
+	defer c.startSpan("Delete")()
+
+	// nothing is removed yet, so the data is still there when the backup fails; the pod events keep being processed
+	// since the cluster stays in place until the controller retries the deletion
+	if c.shouldTakeFinalBackup() {
+		c.logger.Infof("taking a final base backup before deleting the cluster")
+		if err := c.TriggerBackup(); err != nil {
+			return fmt.Errorf("could not take final base backup, keeping the resources of the cluster: %v", err)
+		}
 	}
 
+	c.teardownStatefulSet()
+
 	for _, obj := range c.Secrets {
 		if delete, user := c.shouldDeleteSecret(obj); !delete {
 			c.logger.Warningf("not removing secret %q for the system user %q", obj.GetName(), user)
@@ -645,11 +947,36 @@ func (c *Cluster) Delete() {
 			c.logger.Warningf("could not delete secret: %v", err)
 		}
 	}
+	for _, user := range c.pgUsers {
+		if user.Origin == spec.RoleOriginManifest && c.storedExternally(user) {
+			if err := c.deleteStoredCredentials(user.Name); err != nil {
+				c.logger.Warningf("%v", err)
+			}
+		}
+	}
+
+	if err := c.deleteCloneCredentials(); err != nil {
+		c.logger.Warningf("could not delete the copy of the clone credentials: %v", err)
+	}
+
+	if test, err := c.getRestoreTestCluster(); err != nil {
+		c.logger.Warningf("could not get restore test cluster: %v", err)
+	} else if test != nil {
+		if err := c.deleteRestoreTestCluster(); err != nil {
+			c.logger.Warningf("could not delete restore test cluster: %v", err)
+		}
+	}
 
 	if err := c.deletePodDisruptionBudget(); err != nil {
 		c.logger.Warningf("could not delete pod disruption budget: %v", err)
 	}
 
+	if c.LogicalBackupJob != nil {
+		if err := c.deleteLogicalBackupJob(); err != nil {
+			c.logger.Warningf("could not delete logical backup job: %v", err)
+		}
+	}
+
 	for _, role := range []PostgresRole{Master, Replica} {
 
 		if err := c.deleteEndpoint(role); err != nil {
@@ -664,10 +991,36 @@ func (c *Cluster) Delete() {
 	if err := c.deletePatroniClusterObjects(); err != nil {
 		c.logger.Warningf("could not remove leftover patroni objects; %v", err)
 	}
+
+	// the pod events are needed above to wait for the pods deletion, but nothing should act on them afterwards
+	c.stopPodEvents()
+
+	return nil
+}
+
+// teardownStatefulSet removes the statefulset along with its pods and, depending on the policy, its volumes. Unless
+// disabled, the pods are stopped first by scaling the statefulset down, which keeps Patroni from failing over while
+// they go away.
+func (c *Cluster) teardownStatefulSet() {
+	if c.OpConfig.DeleteScaleDown {
+		if err := c.scaleDownStatefulSet(); err != nil {
+			c.logger.Warningf("could not scale down statefulset: %v", err)
+		}
+	}
+	if err := c.deleteStatefulSet(); err != nil {
+		c.logger.Warningf("could not delete statefulset: %v", err)
+	}
+	if err := c.removeVolumes(); err != nil {
+		c.logger.Warningf("could not remove volumes: %v", err)
+	}
 }
 
 // ReceivePodEvent is called back by the controller in order to add the cluster's pod event to the queue.
 func (c *Cluster) ReceivePodEvent(event spec.PodEvent) {
+	if c.podEventsQueue.IsClosed() {
+		c.logger.Debugf("skipping the %s event for the pod %q of the deleted cluster", event.EventType, event.PodName)
+		return
+	}
 	if err := c.podEventsQueue.Add(event); err != nil {
 		c.logger.Errorf("error when receiving pod events: %v", err)
 	}
@@ -686,6 +1039,11 @@ func (c *Cluster) processPodEvent(obj interface{}) error {
 		subscriber <- event
 	}
 
+	// the status is updated for every pod, not only those someone waits for
+	if c.trackPodHealth(event) {
+		c.scheduleStatusUpdate()
+	}
+
 	return nil
 }
 
@@ -701,12 +1059,25 @@ func (c *Cluster) processPodEventQueue(stopCh <-chan struct{}) {
 			return
 		default:
 			if _, err := c.podEventsQueue.Pop(cache.PopProcessFunc(c.processPodEvent)); err != nil {
+				if err == cache.FIFOClosedError {
+					c.logger.Debugf("pod event queue has been closed")
+					return
+				}
 				c.logger.Errorf("error when processing pod event queue %v", err)
 			}
 		}
 	}
 }
 
+// stopPodEvents discards the pod events still queued for the cluster and closes the queue, which ends
+// the pod event processing started by Run.
+func (c *Cluster) stopPodEvents() {
+	if err := c.podEventsQueue.Replace([]interface{}{}, ""); err != nil {
+		c.logger.Warningf("could not discard the queued pod events: %v", err)
+	}
+	c.podEventsQueue.Close()
+}
+
 func (c *Cluster) initSystemUsers() {
 	// We don't actually use that to create users, delegating this
 	// task to Patroni. Those definitions are only used to create
@@ -725,29 +1096,67 @@ func (c *Cluster) initSystemUsers() {
 }
 
 func (c *Cluster) initRobotUsers() error {
+	// the entries naming protected or system roles are reported by initUsers and left out here
+	for username := range c.Spec.UserMemberships {
+		if _, ok := c.Spec.Users[username]; !ok && c.reservedRoleReason(username) == "" {
+			return fmt.Errorf("user %q with memberships is not in the users section", username)
+		}
+	}
+	for username, limit := range c.Spec.UserConnectionLimits {
+		if c.reservedRoleReason(username) != "" {
+			continue
+		}
+		if _, ok := c.Spec.Users[username]; !ok {
+			return fmt.Errorf("user %q with a connection limit is not in the users section", username)
+		}
+		if limit < -1 {
+			return fmt.Errorf("invalid connection limit %d of user %q", limit, username)
+		}
+	}
 	for username, userFlags := range c.Spec.Users {
+		if c.reservedRoleReason(username) != "" {
+			continue
+		}
 		if !isValidUsername(username) {
 			return fmt.Errorf("invalid username: %q", username)
 		}
 
-		if c.shouldAvoidProtectedOrSystemRole(username, "manifest robot role") {
-			continue
-		}
-		flags, err := normalizeUserFlags(userFlags)
+		flagEntries, inRoles := splitUserFlags(userFlags)
+		flags, err := normalizeUserFlags(flagEntries)
 		if err != nil {
 			return fmt.Errorf("invalid flags for user %q: %v", username, err)
 		}
+		memberOf, err := c.manifestMemberships(username, inRoles)
+		if err != nil {
+			return err
+		}
+		// the roles of the manifest converge to their limits, a role without one has no limit
+		connectionLimit := -1
+		if limit, ok := c.Spec.UserConnectionLimits[username]; ok {
+			connectionLimit = limit
+		}
 		newRole := spec.PgUser{
-			Origin:   spec.RoleOriginManifest,
-			Name:     username,
-			Password: util.RandomPassword(constants.PasswordLength),
-			Flags:    flags,
+			Origin:          spec.RoleOriginManifest,
+			Name:            username,
+			Flags:           flags,
+			MemberOf:        memberOf,
+			ConnectionLimit: &connectionLimit,
+		}
+		// group roles cannot log in, so they get neither a password nor a secret
+		if ref, ok := c.Spec.UserSecrets[username]; ok {
+			if newRole.Password, err = c.getUserSecretPassword(ref); err != nil {
+				return fmt.Errorf("could not get password of user %q: %v", username, err)
+			}
+		} else if isLoginRole(flags) {
+			newRole.Password = util.RandomPassword(constants.PasswordLength)
 		}
 		if currentRole, present := c.pgUsers[username]; present {
-			c.pgUsers[username] = c.resolveNameConflict(&currentRole, &newRole)
-		} else {
-			c.pgUsers[username] = newRole
+			newRole = c.resolveNameConflict(&currentRole, &newRole)
 		}
+		if !isLoginRole(flags) && newRole.Password != "" {
+			return fmt.Errorf("NOLOGIN role %q cannot have a password", username)
+		}
+		c.pgUsers[username] = newRole
 	}
 	return nil
 }
@@ -757,26 +1166,20 @@ func (c *Cluster) initHumanUsers() error {
 	if err != nil {
 		return fmt.Errorf("could not get list of team members: %v", err)
 	}
+	flags, memberOf, err := c.teamMemberPrivileges()
+	if err != nil {
+		return err
+	}
 	for _, username := range teamMembers {
-		flags := []string{constants.RoleFlagLogin}
-		memberOf := []string{c.OpConfig.PamRoleName}
-
 		if c.shouldAvoidProtectedOrSystemRole(username, "API role") {
 			continue
 		}
-		if c.OpConfig.EnableTeamSuperuser {
-			flags = append(flags, constants.RoleFlagSuperuser)
-		} else {
-			if c.OpConfig.TeamAdminRole != "" {
-				memberOf = append(memberOf, c.OpConfig.TeamAdminRole)
-			}
-		}
 
 		newRole := spec.PgUser{
 			Origin:     spec.RoleOriginTeamsAPI,
 			Name:       username,
-			Flags:      flags,
-			MemberOf:   memberOf,
+			Flags:      append([]string{}, flags...),
+			MemberOf:   append([]string{}, memberOf...),
 			Parameters: c.OpConfig.TeamAPIRoleConfiguration,
 		}
 
@@ -790,7 +1193,51 @@ func (c *Cluster) initHumanUsers() error {
 	return nil
 }
 
+// SetInfrastructureRoles replaces the infrastructure roles inherited from the controller, they are created or altered
+// on the next sync.
+func (c *Cluster) SetInfrastructureRoles(roles map[string]spec.PgUser) {
+	c.infrastructureRolesMu.Lock()
+	defer c.infrastructureRolesMu.Unlock()
+	c.InfrastructureRoles = roles
+}
+
+// teamMemberPrivileges returns the flags and the roles of the team members: the ones of the manifest, of the
+// team_member_flags and team_member_roles or, when those are empty as well, either SUPERUSER or the team_admin_role.
+// The members always log in and are members of the pam role, so that they can authenticate with their tokens.
+func (c *Cluster) teamMemberPrivileges() (flags, memberOf []string, err error) {
+	var extraFlags, extraRoles []string
+	if privileges := c.Spec.TeamMemberPrivileges; privileges != nil {
+		extraFlags, extraRoles = privileges.Flags, privileges.InRoles
+	} else if len(c.OpConfig.TeamMemberFlags) > 0 || len(c.OpConfig.TeamMemberRoles) > 0 {
+		extraFlags, extraRoles = c.OpConfig.TeamMemberFlags, c.OpConfig.TeamMemberRoles
+	} else if c.OpConfig.EnableTeamSuperuser {
+		extraFlags = []string{constants.RoleFlagSuperuser}
+	} else if c.OpConfig.TeamAdminRole != "" {
+		extraRoles = []string{c.OpConfig.TeamAdminRole}
+	}
+
+	if flags, err = normalizeUserFlags(extraFlags); err != nil {
+		return nil, nil, fmt.Errorf("invalid flags of the team members: %v", err)
+	}
+	if !isLoginRole(flags) {
+		return nil, nil, fmt.Errorf("invalid flags of the team members: team members must be able to log in")
+	}
+	memberOf = []string{c.OpConfig.PamRoleName}
+	for _, role := range extraRoles {
+		if !isValidUsername(role) || c.isSystemUsername(role) {
+			return nil, nil, fmt.Errorf("role %q cannot be granted to the team members", role)
+		}
+		if role != c.OpConfig.PamRoleName {
+			memberOf = append(memberOf, role)
+		}
+	}
+	return flags, memberOf, nil
+}
+
 func (c *Cluster) initInfrastructureRoles() error {
+	c.infrastructureRolesMu.RLock()
+	defer c.infrastructureRolesMu.RUnlock()
+
 	// add infrastructure roles from the operator's definition
 	for username, newRole := range c.InfrastructureRoles {
 		if !isValidUsername(username) {
@@ -862,6 +1309,12 @@ func (c *Cluster) GetStatus() *spec.ClusterStatus {
 		PodDisruptionBudget: c.GetPodDisruptionBudget(),
 		CurrentProcess:      c.GetCurrentProcess(),
 
+		ResourceRecommendation: c.GetResourceRecommendation(),
+		ChecksumFailures:       c.GetChecksumFailures(),
+		LogicalSlots:           c.GetLogicalSlots(),
+		Replication:            c.GetReplicationStatus(),
+		ReadOnly:               c.isStandbyCluster(),
+
 		Error: c.Error,
 	}
 }
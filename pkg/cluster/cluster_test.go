@@ -1,15 +1,43 @@
 package cluster
 
 import (
+	"database/sql/driver"
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/lib/pq"
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/patroni"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/teams"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/tracing"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/users"
+	"io/ioutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	appsv1beta1 "k8s.io/client-go/kubernetes/typed/apps/v1beta1"
+	batchv2alpha1 "k8s.io/client-go/kubernetes/typed/batch/v2alpha1"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/apps/v1beta1"
+	batchv2alpha1api "k8s.io/client-go/pkg/apis/batch/v2alpha1"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 const (
@@ -25,6 +53,7 @@ var cl = New(Config{OpConfig: config.Config{ProtectedRoles: []string{"admin"},
 
 func TestInitRobotUsers(t *testing.T) {
 	testName := "TestInitRobotUsers"
+	unlimited := -1
 	tests := []struct {
 		manifestUsers map[string]spec.UserFlags
 		infraRoles    map[string]spec.PgUser
@@ -57,10 +86,24 @@ func TestInitRobotUsers(t *testing.T) {
 				`conflicting user flags: "NOINHERIT" and "INHERIT"`),
 		},
 		{
-			manifestUsers: map[string]spec.UserFlags{"admin": {"superuser"}, superUserName: {"createdb"}},
+			manifestUsers: map[string]spec.UserFlags{"readers": {"nologin"}},
 			infraRoles:    map[string]spec.PgUser{},
-			result:        map[string]spec.PgUser{},
-			err:           nil,
+			result: map[string]spec.PgUser{"readers": {Origin: spec.RoleOriginManifest, Name: "readers", Flags: []string{},
+				ConnectionLimit: &unlimited}},
+			err: nil,
+		},
+		{
+			manifestUsers: map[string]spec.UserFlags{"readers": {"nologin"}},
+			infraRoles:    map[string]spec.PgUser{"readers": {Origin: spec.RoleOriginInfrastructure, Name: "readers", Password: "bar"}},
+			err:           fmt.Errorf(`NOLOGIN role "readers" cannot have a password`),
+		},
+		{
+			manifestUsers: map[string]spec.UserFlags{"admin": {"superuser"}, superUserName: {"createdb"},
+				"app": {"nologin", "inrole:admin"}},
+			infraRoles: map[string]spec.PgUser{},
+			result: map[string]spec.PgUser{"app": {Origin: spec.RoleOriginManifest, Name: "app", Flags: []string{},
+				ConnectionLimit: &unlimited}},
+			err: nil,
 		},
 	}
 	for _, tt := range tests {
@@ -81,6 +124,404 @@ func TestInitRobotUsers(t *testing.T) {
 	}
 }
 
+func TestValidateProtectedRoles(t *testing.T) {
+	testName := "TestValidateProtectedRoles"
+	tests := []struct {
+		about string
+		spec  spec.PostgresSpec
+		err   string
+	}{
+		{
+			about: "no protected roles",
+			spec: spec.PostgresSpec{Users: map[string]spec.UserFlags{"app": {"inrole:readers"}, "readers": {"nologin"}},
+				UserMemberships: map[string][]string{"app": {"readers"}}},
+		},
+		{
+			about: "protected user secret",
+			spec: spec.PostgresSpec{Users: map[string]spec.UserFlags{"app": {}},
+				UserSecrets: map[string]spec.UserSecret{"admin": {Name: "admin-secret"}}},
+			err: `invalid manifest entry userSecrets.admin: role "admin" is a protected role`,
+		},
+		{
+			about: "system user connection limit",
+			spec:  spec.PostgresSpec{UserConnectionLimits: map[string]int{replicationUserName: 10}},
+			err: fmt.Sprintf(`invalid manifest entry userConnectionLimits.%s: role %q is a system role`,
+				replicationUserName, replicationUserName),
+		},
+		{
+			about: "membership in a protected role",
+			spec:  spec.PostgresSpec{Users: map[string]spec.UserFlags{"app": {"login", "inrole:admin"}}},
+			err:   `invalid manifest entry users.app: inrole:admin: role "admin" is a protected role`,
+		},
+		{
+			about: "protected role in the memberships",
+			spec: spec.PostgresSpec{Users: map[string]spec.UserFlags{"app": {}},
+				UserMemberships: map[string][]string{"app": {superUserName}}},
+			err: fmt.Sprintf(`invalid manifest entry userMemberships.app: role %q is a system role`, superUserName),
+		},
+	}
+	defer func(saved spec.PostgresSpec) { cl.Spec = saved }(cl.Spec)
+	for _, tt := range tests {
+		cl.Spec = tt.spec
+		errs := cl.validateProtectedRoles()
+		if tt.err == "" && len(errs) > 0 {
+			t.Errorf("%s %s: unexpected errors: %v", testName, tt.about, errs)
+		} else if tt.err != "" && (len(errs) != 1 || errs[0].Error() != tt.err) {
+			t.Errorf("%s %s: expected error %q, got %v", testName, tt.about, tt.err, errs)
+		}
+	}
+}
+
+func TestReportInvalidRoleEntries(t *testing.T) {
+	testName := "TestReportInvalidRoleEntries"
+	events := &fakeEvents{}
+	cluster := New(Config{OpConfig: config.Config{ProtectedRoles: []string{"admin"}}},
+		k8sutil.KubernetesClient{EventsGetter: events},
+		spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+			Spec:       spec.PostgresSpec{Users: map[string]spec.UserFlags{"admin": {}, "app": {}}},
+		}, logger)
+
+	if !cluster.reportInvalidRoleEntries(cluster.validateProtectedRoles()) {
+		t.Errorf("%s: expected the status to change", testName)
+	}
+	if cluster.reportInvalidRoleEntries(cluster.validateProtectedRoles()) {
+		t.Errorf("%s: expected the status to stay the same for the same entries", testName)
+	}
+	expected := []string{`invalid manifest entry users.admin: role "admin" is a protected role`}
+	if !reflect.DeepEqual(cluster.Status.InvalidRoleEntries, expected) {
+		t.Errorf("%s: expected the invalid entries %v in the status, got %v", testName, expected,
+			cluster.Status.InvalidRoleEntries)
+	}
+	if len(events.created) != 1 || events.created[0].Reason != eventReasonInvalidRoleEntries {
+		t.Errorf("%s: expected one %s event, got %d events", testName, eventReasonInvalidRoleEntries, len(events.created))
+	}
+
+	cluster.Spec.Users = map[string]spec.UserFlags{"app": {}}
+	if !cluster.reportInvalidRoleEntries(cluster.validateProtectedRoles()) {
+		t.Errorf("%s: expected the status to change once the manifest is fixed", testName)
+	}
+	if cluster.Status.InvalidRoleEntries != nil {
+		t.Errorf("%s: expected the invalid entries to be cleared, got %v", testName, cluster.Status.InvalidRoleEntries)
+	}
+	if len(events.created) != 1 {
+		t.Errorf("%s: expected no event once the manifest is fixed, got %d events", testName, len(events.created))
+	}
+}
+
+func TestCheckDeclaredInfrastructureRole(t *testing.T) {
+	testName := "TestCheckDeclaredInfrastructureRole"
+	opConfig := &config.Config{ProtectedRoles: []string{"admin"},
+		Auth: config.Auth{SuperUsername: superUserName, ReplicationUsername: replicationUserName}}
+	tests := []struct {
+		about string
+		role  spec.PgUser
+		flags []string
+		err   string
+	}{
+		{"normalized flags", spec.PgUser{Name: "robot_zmon", Flags: []string{"login", "createdb"},
+			MemberOf: []string{"pg_monitor"}}, []string{"CREATEDB", "LOGIN"}, ""},
+		{"superuser", spec.PgUser{Name: "robot_zmon", Flags: []string{"superuser"}}, nil,
+			`role "robot_zmon" cannot have the SUPERUSER flag`},
+		{"injected flag", spec.PgUser{Name: "robot_zmon", Flags: []string{"login; DROP ROLE x"}}, nil,
+			`invalid flags of role "robot_zmon": user flag "login; DROP ROLE x" is not alphanumeric`},
+		{"protected membership", spec.PgUser{Name: "robot_zmon", AdminOf: []string{"admin"}}, nil,
+			`role "admin" cannot be granted to role "robot_zmon"`},
+		{"system membership", spec.PgUser{Name: "robot_zmon", MemberOf: []string{superUserName}}, nil,
+			fmt.Sprintf(`role %q cannot be granted to role "robot_zmon"`, superUserName)},
+	}
+	for _, tt := range tests {
+		role := tt.role
+		err := CheckDeclaredInfrastructureRole(&role, opConfig)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s %s: expected error %q, got %v", testName, tt.about, tt.err, err)
+			}
+		} else if err != nil || !reflect.DeepEqual(role.Flags, tt.flags) {
+			t.Errorf("%s %s: expected flags %v, got %v, error: %v", testName, tt.about, tt.flags, role.Flags, err)
+		}
+	}
+}
+
+func TestRemovedManifestRoles(t *testing.T) {
+	testName := "TestRemovedManifestRoles"
+	tests := []struct {
+		about    string
+		oldUsers map[string]spec.UserFlags
+		newUsers map[string]spec.UserFlags
+		pgUsers  map[string]spec.PgUser
+		removed  []string
+	}{
+		{"nothing removed", map[string]spec.UserFlags{"foo": {}}, map[string]spec.UserFlags{"foo": {}, "bar": {}},
+			map[string]spec.PgUser{}, nil},
+		{"users removed", map[string]spec.UserFlags{"foo": {}, "bar": {}, "baz": {}}, map[string]spec.UserFlags{"baz": {}},
+			map[string]spec.PgUser{}, []string{"bar", "foo"}},
+		{"user still defined by the teams API", map[string]spec.UserFlags{"foo": {}}, map[string]spec.UserFlags{},
+			map[string]spec.PgUser{"foo": {Origin: spec.RoleOriginTeamsAPI, Name: "foo"}}, nil},
+		{"protected and system users", map[string]spec.UserFlags{"admin": {}, superUserName: {}},
+			map[string]spec.UserFlags{}, map[string]spec.PgUser{}, nil},
+	}
+	for _, tt := range tests {
+		cl.pgUsers = tt.pgUsers
+		if removed := cl.removedManifestRoles(tt.oldUsers, tt.newUsers); !reflect.DeepEqual(removed, tt.removed) {
+			t.Errorf("%s %s: expected %v, got %v", testName, tt.about, tt.removed, removed)
+		}
+	}
+}
+
+// fakeManifestRoleSecrets lists the secrets of the manifest roles, wherever they are.
+type fakeManifestRoleSecrets struct {
+	v1core.SecretInterface
+	items     []v1.Secret
+	selectors []string
+}
+
+func (s *fakeManifestRoleSecrets) Secrets(namespace string) v1core.SecretInterface {
+	return s
+}
+
+func (s *fakeManifestRoleSecrets) List(opts metav1.ListOptions) (*v1.SecretList, error) {
+	s.selectors = append(s.selectors, opts.LabelSelector)
+	return &v1.SecretList{Items: s.items}, nil
+}
+
+func TestRemovedSecretRoles(t *testing.T) {
+	testName := "TestRemovedSecretRoles"
+	secret := func(namespace, role string) v1.Secret {
+		return v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: role + ".acid-test.credentials", Namespace: namespace,
+			Labels: map[string]string{"cluster-name": "acid-test", constants.ManifestRoleLabel: role}}}
+	}
+	secrets := &fakeManifestRoleSecrets{items: []v1.Secret{
+		secret("test", "app"), secret("test", "reporter"), secret("other", "orders"), secret("test", "admin"),
+	}}
+	cluster := New(Config{OpConfig: config.Config{ProtectedRoles: []string{"admin"},
+		Resources: config.Resources{ClusterNameLabel: "cluster-name"}}},
+		k8sutil.KubernetesClient{SecretsGetter: secrets}, spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"},
+			Spec:       spec.PostgresSpec{Users: map[string]spec.UserFlags{"app": {}}},
+		}, logger)
+
+	// the secret in the other namespace belongs to a cluster of the same name there
+	removed, err := cluster.removedSecretRoles()
+	if err != nil {
+		t.Fatalf("%s: unexpected error: %v", testName, err)
+	}
+	if expected := []string{"reporter"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("%s: expected the removed roles %v, got %v", testName, expected, removed)
+	}
+	if selector := "acid.zalan.do/manifest-role,cluster-name=acid-test"; secrets.selectors[0] != selector {
+		t.Errorf("%s: expected the label selector %q, got %q", testName, selector, secrets.selectors[0])
+	}
+
+	current := v1.Secret{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"cluster-name": "acid-test"}}}
+	generated := secret("test", "app")
+	if !setManifestRoleLabel(&current, &generated) || current.Labels[constants.ManifestRoleLabel] != "app" {
+		t.Errorf("%s: expected the label to be added to the secret, got %v", testName, current.Labels)
+	}
+	if setManifestRoleLabel(&current, &generated) {
+		t.Errorf("%s: expected no change of the labeled secret", testName)
+	}
+}
+
+func TestNoLoginGroupRole(t *testing.T) {
+	testName := "TestNoLoginGroupRole"
+	cl.Spec.Users = map[string]spec.UserFlags{"readers": {"nologin"}, "reporter": {}}
+	cl.pgUsers = map[string]spec.PgUser{}
+	if err := cl.initRobotUsers(); err != nil {
+		t.Fatalf("%s: could not init users: %v", testName, err)
+	}
+
+	group := cl.pgUsers["readers"]
+	if group.Password != "" || isLoginRole(group.Flags) {
+		t.Errorf("%s: expected a NOLOGIN role without a password, got %#v", testName, group)
+	}
+	secrets := cl.generateUserSecrets()
+	if _, ok := secrets["readers"]; ok {
+		t.Errorf("%s: expected no secret for the NOLOGIN role", testName)
+	}
+	if _, ok := secrets["reporter"]; !ok {
+		t.Errorf("%s: expected a secret for the login role", testName)
+	}
+
+	// the group role is created as is and members are granted to it
+	member := cl.pgUsers["reporter"]
+	member.MemberOf = []string{"readers"}
+	newUsers := spec.PgUserMap{"readers": group, "reporter": member}
+	dbUsers := spec.PgUserMap{"reporter": {Name: "reporter", Password: util.PGUserPassword(member), Flags: member.Flags}}
+	reqs := users.DefaultUserSyncStrategy{}.ProduceSyncRequests(dbUsers, newUsers)
+
+	var created, granted bool
+	for _, r := range reqs {
+		switch {
+		case r.Kind == spec.PGSyncUserAdd && r.User.Name == "readers":
+			created = r.User.Password == ""
+		case r.Kind == spec.PGsyncUserAlter && r.User.Name == "reporter":
+			granted = reflect.DeepEqual(r.User.MemberOf, []string{"readers"})
+		}
+	}
+	if !created {
+		t.Errorf("%s: expected the NOLOGIN role to be created without a password, got %#v", testName, reqs)
+	}
+	if !granted {
+		t.Errorf("%s: expected membership in the NOLOGIN role to be granted, got %#v", testName, reqs)
+	}
+}
+
+func TestUserSecrets(t *testing.T) {
+	testName := "TestUserSecrets"
+	src := &fakeCloneSource{secrets: map[string]bool{"test/app-credentials": true}}
+	cluster := New(Config{OpConfig: config.Config{SecretNameTemplate: "{username}.{cluster}.credentials"}},
+		k8sutil.KubernetesClient{SecretsGetter: src},
+		spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"},
+			Spec: spec.PostgresSpec{
+				Users:       map[string]spec.UserFlags{"app": {}, "reporter": {}},
+				UserSecrets: map[string]spec.UserSecret{"app": {Name: "app-credentials"}},
+			},
+		}, logger)
+	if err := cluster.initRobotUsers(); err != nil {
+		t.Fatalf("%s: could not init users: %v", testName, err)
+	}
+	if password := cluster.pgUsers["app"].Password; password != "secret" {
+		t.Errorf("%s: expected the password from the secret, got %q", testName, password)
+	}
+	secrets := cluster.generateUserSecrets()
+	if _, ok := secrets["app"]; ok {
+		t.Errorf("%s: expected no secret for the user with a provisioned secret", testName)
+	}
+	if _, ok := secrets["reporter"]; !ok {
+		t.Errorf("%s: expected a secret for the other user", testName)
+	}
+
+	cluster.Spec.UserSecrets["app"] = spec.UserSecret{Name: "app-credentials", PasswordKey: "pgpassword"}
+	if err := cluster.initRobotUsers(); err == nil {
+		t.Errorf("%s: expected an error for the missing key of the secret", testName)
+	}
+	cluster.Spec.UserSecrets["app"] = spec.UserSecret{Name: "missing"}
+	if err := cluster.initRobotUsers(); err == nil {
+		t.Errorf("%s: expected an error for the missing secret", testName)
+	}
+}
+
+func TestUserConnectionLimits(t *testing.T) {
+	testName := "TestUserConnectionLimits"
+	tests := []struct {
+		about  string
+		limits map[string]int
+		limit  int
+		err    bool
+	}{
+		{"no limit", nil, -1, false},
+		{"limit", map[string]int{"app": 10}, 10, false},
+		{"no connections", map[string]int{"app": 0}, 0, false},
+		{"invalid limit", map[string]int{"app": -2}, 0, true},
+		{"unknown user", map[string]int{"robot": 10}, 0, true},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"},
+			Spec: spec.PostgresSpec{
+				Users:                map[string]spec.UserFlags{"app": {}},
+				UserConnectionLimits: tt.limits,
+			},
+		}, logger)
+		err := cluster.initRobotUsers()
+		if (err != nil) != tt.err {
+			t.Errorf("%s %s: expected error %t, got %v", testName, tt.about, tt.err, err)
+			continue
+		}
+		if limit := cluster.pgUsers["app"].ConnectionLimit; err == nil && (limit == nil || *limit != tt.limit) {
+			t.Errorf("%s %s: expected connection limit %d, got %v", testName, tt.about, tt.limit, limit)
+		}
+	}
+}
+
+func TestManifestMemberships(t *testing.T) {
+	testName := "TestManifestMemberships"
+	tests := []struct {
+		about       string
+		appFlags    spec.UserFlags
+		memberships map[string][]string
+		memberOf    []string
+		err         bool
+	}{
+		{"no memberships", nil, nil, nil, false},
+		{"group role", nil, map[string][]string{"app": {"readonly"}}, []string{"readonly"}, false},
+		{"login role", nil, map[string][]string{"app": {"reporter"}}, nil, true},
+		{"role outside of the manifest", nil, map[string][]string{"app": {"writers"}}, []string{"writers"}, false},
+		{"system role", nil, map[string][]string{"app": {"postgres"}}, nil, false},
+		{"itself", nil, map[string][]string{"app": {"app"}}, nil, true},
+		{"unknown user", nil, map[string][]string{"robot": {"readonly"}}, nil, true},
+		{"user entry", spec.UserFlags{"createdb", "inrole:readonly", "inrole:pg_monitor"}, nil,
+			[]string{"readonly", "pg_monitor"}, false},
+		{"user entry and memberships", spec.UserFlags{"inrole:readonly"},
+			map[string][]string{"app": {"readonly", "writers"}}, []string{"readonly", "writers"}, false},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{SuperUsername: "postgres"}}, k8sutil.KubernetesClient{},
+			spec.Postgresql{
+				ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"},
+				Spec: spec.PostgresSpec{
+					Users: map[string]spec.UserFlags{
+						"app":      tt.appFlags,
+						"reporter": {},
+						"readonly": {"nologin"},
+					},
+					UserMemberships: tt.memberships,
+				},
+			}, logger)
+		err := cluster.initRobotUsers()
+		if (err != nil) != tt.err {
+			t.Errorf("%s %s: expected error %t, got %v", testName, tt.about, tt.err, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(cluster.pgUsers["app"].MemberOf, tt.memberOf) {
+			t.Errorf("%s %s: expected memberships %v, got %v", testName, tt.about, tt.memberOf,
+				cluster.pgUsers["app"].MemberOf)
+		}
+	}
+}
+
+func TestCrossNamespaceSecrets(t *testing.T) {
+	testName := "TestCrossNamespaceSecrets"
+	tests := []struct {
+		about     string
+		enabled   bool
+		username  string
+		origin    spec.RoleOrigin
+		namespace string
+		name      string
+	}{
+		{"disabled", false, "apps.orders", spec.RoleOriginManifest, "test", "apps.orders.acid-test.credentials"},
+		{"manifest user", true, "apps.orders", spec.RoleOriginManifest, "apps", "orders.acid-test.credentials"},
+		{"user without namespace", true, "orders", spec.RoleOriginManifest, "test", "orders.acid-test.credentials"},
+		{"infrastructure role", true, "robot.zmon", spec.RoleOriginInfrastructure, "test",
+			"robot.zmon.acid-test.credentials"},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{
+			SecretNameTemplate:         "{username}.{cluster}.credentials",
+			EnableCrossNamespaceSecret: tt.enabled,
+		}}, k8sutil.KubernetesClient{}, spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"},
+		}, logger)
+		cluster.pgUsers[tt.username] = spec.PgUser{Name: tt.username, Password: "secret", Origin: tt.origin}
+
+		secret := cluster.generateUserSecrets()[tt.username]
+		if secret == nil {
+			t.Fatalf("%s %s: expected a secret for the user", testName, tt.about)
+		}
+		if secret.Namespace != tt.namespace || secret.Name != tt.name {
+			t.Errorf("%s %s: expected secret %s/%s, got %s/%s",
+				testName, tt.about, tt.namespace, tt.name, secret.Namespace, secret.Name)
+		}
+		if string(secret.Data["username"]) != tt.username {
+			t.Errorf("%s %s: expected the role %q in the secret, got %q",
+				testName, tt.about, tt.username, secret.Data["username"])
+		}
+	}
+}
+
 type mockOAuthTokenGetter struct {
 }
 
@@ -145,6 +586,87 @@ func TestInitHumanUsers(t *testing.T) {
 	}
 }
 
+func TestTeamMemberPrivileges(t *testing.T) {
+	testName := "TestTeamMemberPrivileges"
+	tests := []struct {
+		about       string
+		superuser   bool
+		adminRole   string
+		configFlags []string
+		configRoles []string
+		privileges  *spec.TeamMemberPrivileges
+		flags       []string
+		memberOf    []string
+		err         string
+	}{
+		{
+			about:     "superuser",
+			superuser: true,
+			adminRole: "admin",
+			flags:     []string{"LOGIN", "SUPERUSER"},
+			memberOf:  []string{"zalandos"},
+		},
+		{
+			about:     "team admin role",
+			adminRole: "admin",
+			flags:     []string{"LOGIN"},
+			memberOf:  []string{"zalandos", "admin"},
+		},
+		{
+			about:       "operator configuration",
+			superuser:   true,
+			configFlags: []string{"createdb"},
+			configRoles: []string{"admin", "zalandos"},
+			flags:       []string{"CREATEDB", "LOGIN"},
+			memberOf:    []string{"zalandos", "admin"},
+		},
+		{
+			about:       "manifest",
+			superuser:   true,
+			configFlags: []string{"createdb"},
+			privileges:  &spec.TeamMemberPrivileges{InRoles: []string{"readers"}},
+			flags:       []string{"LOGIN"},
+			memberOf:    []string{"zalandos", "readers"},
+		},
+		{
+			about:      "no login",
+			privileges: &spec.TeamMemberPrivileges{Flags: []string{"nologin"}},
+			err:        "invalid flags of the team members: team members must be able to log in",
+		},
+		{
+			about:      "system role",
+			privileges: &spec.TeamMemberPrivileges{InRoles: []string{superUserName}},
+			err:        fmt.Sprintf("role %q cannot be granted to the team members", superUserName),
+		},
+	}
+	cluster := New(Config{OpConfig: config.Config{PamRoleName: "zalandos",
+		Auth: config.Auth{SuperUsername: superUserName, ReplicationUsername: replicationUserName}}},
+		k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	for _, tt := range tests {
+		cluster.OpConfig.EnableTeamSuperuser = tt.superuser
+		cluster.OpConfig.TeamAdminRole = tt.adminRole
+		cluster.OpConfig.TeamMemberFlags = tt.configFlags
+		cluster.OpConfig.TeamMemberRoles = tt.configRoles
+		cluster.Spec.TeamMemberPrivileges = tt.privileges
+
+		flags, memberOf, err := cluster.teamMemberPrivileges()
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s %s: expected error %q, got %v", testName, tt.about, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, tt.about, err)
+			continue
+		}
+		if !reflect.DeepEqual(flags, tt.flags) || !reflect.DeepEqual(memberOf, tt.memberOf) {
+			t.Errorf("%s %s: expected flags %v and roles %v, got %v and %v",
+				testName, tt.about, tt.flags, tt.memberOf, flags, memberOf)
+		}
+	}
+}
+
 func TestShouldDeleteSecret(t *testing.T) {
 	testName := "TestShouldDeleteSecret"
 
@@ -174,3 +696,1935 @@ func TestShouldDeleteSecret(t *testing.T) {
 		}
 	}
 }
+
+func TestComparePgVersion(t *testing.T) {
+	testName := "TestComparePgVersion"
+
+	tests := []struct {
+		specVersion     string
+		detectedVersion string
+		action          string
+		degrade         bool
+		err             error
+	}{
+		{
+			specVersion:     "10",
+			detectedVersion: "10",
+			action:          config.PgVersionMismatchActionDegrade,
+			degrade:         false,
+			err:             nil,
+		},
+		{
+			specVersion:     "9.6",
+			detectedVersion: "10",
+			action:          config.PgVersionMismatchActionWarn,
+			degrade:         false,
+			err:             fmt.Errorf(`running postgres major version "10" does not match the version "9.6" requested in the manifest`),
+		},
+		{
+			specVersion:     "9.6",
+			detectedVersion: "10",
+			action:          config.PgVersionMismatchActionDegrade,
+			degrade:         true,
+			err:             fmt.Errorf(`running postgres major version "10" does not match the version "9.6" requested in the manifest`),
+		},
+	}
+
+	for _, tt := range tests {
+		cl.Spec.PgVersion = tt.specVersion
+		cl.OpConfig.PgVersionMismatchAction = tt.action
+		degrade, err := cl.comparePgVersion(tt.detectedVersion)
+		if degrade != tt.degrade {
+			t.Errorf("%s expects degrade to be %t for detected version %q and requested %q, got %t",
+				testName, tt.degrade, tt.detectedVersion, tt.specVersion, degrade)
+		}
+		if (err == nil) != (tt.err == nil) || (err != nil && err.Error() != tt.err.Error()) {
+			t.Errorf("%s expects error %v, got %v", testName, tt.err, err)
+		}
+	}
+	cl.Spec.PgVersion = ""
+	cl.OpConfig.PgVersionMismatchAction = ""
+}
+
+func TestPgMajorVersionFromVersionNum(t *testing.T) {
+	testName := "TestPgMajorVersionFromVersionNum"
+
+	tests := []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{"90605", "9.6", false},
+		{"90224", "9.2", false},
+		{"100003", "10", false},
+		{"110000", "11", false},
+		{"foo", "", true},
+	}
+
+	for _, tt := range tests {
+		version, err := pgMajorVersionFromVersionNum(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%s unexpected error status for %q: %v", testName, tt.in, err)
+		}
+		if version != tt.out {
+			t.Errorf("%s expects version %q for %q, got %q", testName, tt.out, tt.in, version)
+		}
+	}
+}
+
+func TestUserQuery(t *testing.T) {
+	testName := "TestUserQuery"
+
+	tests := []struct {
+		versionNum int
+		bypassRLS  bool
+	}{
+		{90424, false},
+		{90500, true},
+		{100003, true},
+	}
+
+	for _, tt := range tests {
+		query := userQuery(tt.versionNum)
+		if strings.Contains(query, "a.rolbypassrls") != tt.bypassRLS {
+			t.Errorf("%s expects selecting rolbypassrls to be %t for %d, got %q", testName, tt.bypassRLS,
+				tt.versionNum, query)
+		}
+	}
+}
+
+func TestPgConnectionStringUsesSuperuser(t *testing.T) {
+	testName := "TestPgConnectionStringUsesSuperuser"
+
+	cl.systemUsers = map[string]spec.PgUser{}
+	cl.initSystemUsers()
+	connString := cl.pgConnectionString()
+	if !strings.Contains(connString, fmt.Sprintf("user='%s'", superUserName)) {
+		t.Errorf("%s expects the connection string to use the superuser %q, got %q", testName, superUserName, connString)
+	}
+}
+
+func TestPgConnectionStringStatementTimeout(t *testing.T) {
+	testName := "TestPgConnectionStringStatementTimeout"
+	tests := []struct {
+		timeout time.Duration
+		setting string
+	}{
+		{time.Minute, "statement_timeout='60000'"},
+		{1500 * time.Millisecond, "statement_timeout='1500'"},
+		{0, "statement_timeout='0'"},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{Resources: config.Resources{PgStatementTimeout: tt.timeout}}},
+			k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+		if connString := cluster.pgConnectionString(); !strings.Contains(connString, tt.setting) {
+			t.Errorf("%s expects the connection string to contain %q, got %q", testName, tt.setting, connString)
+		}
+	}
+}
+
+func TestDbConnRejectsReplica(t *testing.T) {
+	testName := "TestDbConnRejectsReplica"
+	cluster := New(Config{OpConfig: config.Config{Resources: config.Resources{
+		PgTargetSessionAttrs: config.TargetSessionAttrsReadWrite}}},
+		k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	_, server := openFakeDB(t, "target-session-attrs", []driver.Value{true})
+	defer func(name string) { pgDriverName = name }(pgDriverName)
+	pgDriverName = "target-session-attrs"
+
+	conn, done, err := cluster.tryDbConn(cluster.pgConnectionString())
+	if err != nil || done || conn != nil {
+		t.Errorf("%s expects the connection to a replica to be rejected and retried, got done %t and error %v",
+			testName, done, err)
+	}
+
+	// the next attempt lands on the master
+	server.rows = [][]driver.Value{{false}}
+	conn, done, err = cluster.tryDbConn(cluster.pgConnectionString())
+	if err != nil || !done || conn == nil {
+		t.Fatalf("%s expects the connection to the master to succeed, got done %t and error %v", testName, done, err)
+	}
+	conn.Close()
+}
+
+func TestDescribeStatementError(t *testing.T) {
+	testName := "TestDescribeStatementError"
+	cluster := New(Config{OpConfig: config.Config{Resources: config.Resources{PgStatementTimeout: time.Minute}}},
+		k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+
+	timeout := &pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"}
+	if err := cluster.describeStatementError(timeout); !strings.HasPrefix(err.Error(), "statement timeout of 1m0s exceeded") {
+		t.Errorf("%s expects a timed out statement to be reported as such, got %q", testName, err)
+	}
+	other := &pq.Error{Code: "42710", Message: "role \"foo\" already exists"}
+	if err := cluster.describeStatementError(other); err != other {
+		t.Errorf("%s expects other errors to be passed through, got %q", testName, err)
+	}
+}
+
+func statefulSetWithVolumeLabels(labels map[string]string) *v1beta1.StatefulSet {
+	replicas := int32(2)
+	gracePeriod := int64(300)
+
+	return &v1beta1.StatefulSet{
+		Spec: v1beta1.StatefulSetSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers:                    []v1.Container{{Name: "postgres"}},
+					TerminationGracePeriodSeconds: &gracePeriod,
+				},
+			},
+			VolumeClaimTemplates: []v1.PersistentVolumeClaim{
+				{ObjectMeta: metav1.ObjectMeta{Name: "pgdata", Labels: labels}},
+			},
+		},
+	}
+}
+
+func TestCompareStatefulSetVolumeClaimTemplateLabels(t *testing.T) {
+	testName := "TestCompareStatefulSetVolumeClaimTemplateLabels"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.Statefulset = statefulSetWithVolumeLabels(map[string]string{"team": "acid"})
+
+	cmp := cluster.compareStatefulSetWith(statefulSetWithVolumeLabels(map[string]string{"team": "acid"}))
+	if !cmp.match || cmp.rejectReason != "" {
+		t.Errorf("%s expects identical volume claim templates to match, got reasons %v", testName, cmp.reasons)
+	}
+
+	cmp = cluster.compareStatefulSetWith(statefulSetWithVolumeLabels(map[string]string{"team": "foo"}))
+	if cmp.match {
+		t.Errorf("%s expects a volume claim template label change not to match", testName)
+	}
+	if !strings.Contains(cmp.rejectReason, `labels for volume "pgdata"`) {
+		t.Errorf("%s expects a volume claim template label change to be rejected with a reason naming the volume, got %q",
+			testName, cmp.rejectReason)
+	}
+}
+
+func TestCompareStatefulSetStorageClass(t *testing.T) {
+	testName := "TestCompareStatefulSetStorageClass"
+	withStorageClass := func(class string) *v1beta1.StatefulSet {
+		ss := statefulSetWithVolumeLabels(nil)
+		pvc, err := generatePersistentVolumeClaimTemplate(constants.DataVolumeName, "10Gi", class)
+		if err != nil {
+			t.Fatalf("%s: could not generate volume claim template: %v", testName, err)
+		}
+		ss.Spec.VolumeClaimTemplates[0].Annotations = pvc.Annotations
+		return ss
+	}
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.Statefulset = withStorageClass("gp2")
+
+	cmp := cluster.compareStatefulSetWith(withStorageClass("gp2"))
+	if !cmp.match || cmp.rejectReason != "" {
+		t.Errorf("%s expects identical storage classes to match, got reasons %v", testName, cmp.reasons)
+	}
+
+	for _, class := range []string{"gp3", ""} {
+		cmp = cluster.compareStatefulSetWith(withStorageClass(class))
+		if !strings.Contains(cmp.rejectReason, `storage class for volume "pgdata"`) {
+			t.Errorf("%s expects the change of the storage class to %q to be rejected, got %q", testName, class, cmp.rejectReason)
+		}
+	}
+}
+
+func TestCompareStatefulSetDataSubPath(t *testing.T) {
+	testName := "TestCompareStatefulSetDataSubPath"
+	withSubPath := func(subPath string) *v1beta1.StatefulSet {
+		ss := statefulSetWithVolumeLabels(nil)
+		ss.Spec.Template.Spec.Containers[0].VolumeMounts = generateVolumeMounts(&spec.Volume{SubPath: subPath}, nil)
+		return ss
+	}
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.Statefulset = withSubPath("")
+
+	if cmp := cluster.compareStatefulSetWith(withSubPath("")); !cmp.match {
+		t.Errorf("%s expects identical volume mounts to match, got reasons %v", testName, cmp.reasons)
+	}
+	cmp := cluster.compareStatefulSetWith(withSubPath("pgdata"))
+	if !strings.Contains(cmp.rejectReason, "subPath of the data volume") {
+		t.Errorf("%s expects the change of the sub path to be rejected, got %q", testName, cmp.rejectReason)
+	}
+}
+
+func TestCompareStatefulSetVolumeShrink(t *testing.T) {
+	testName := "TestCompareStatefulSetVolumeShrink"
+	withSize := func(size string) *v1beta1.StatefulSet {
+		ss := statefulSetWithVolumeLabels(nil)
+		ss.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse(size)}
+		return ss
+	}
+	for _, tt := range []struct {
+		about  string
+		force  string
+		reject bool
+	}{
+		{"shrink is refused", "", true},
+		{"shrink is forced", "true", false},
+	} {
+		cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{constants.ForceVolumeShrinkAnnotation: tt.force}}}, logger)
+		cluster.Statefulset = withSize("10Gi")
+
+		if cmp := cluster.compareStatefulSetWith(withSize("20Gi")); cmp.rejectReason != "" || !cmp.replace {
+			t.Errorf("%s %s: expected the growth to replace the statefulset, got reasons %v", testName, tt.about, cmp.reasons)
+		}
+		cmp := cluster.compareStatefulSetWith(withSize("5Gi"))
+		if rejected := strings.Contains(cmp.rejectReason, "volumes cannot shrink"); rejected != tt.reject {
+			t.Errorf("%s %s: expected the rejection to be %t, got %q", testName, tt.about, tt.reject, cmp.rejectReason)
+		}
+		if !tt.reject && !cmp.replace {
+			t.Errorf("%s %s: expected the forced shrink to replace the statefulset", testName, tt.about)
+		}
+	}
+}
+
+func TestCompareStatefulSetTablespaces(t *testing.T) {
+	testName := "TestCompareStatefulSetTablespaces"
+	withTablespaces := func(names ...string) *v1beta1.StatefulSet {
+		ss := statefulSetWithVolumeLabels(nil)
+		for _, name := range names {
+			ss.Spec.VolumeClaimTemplates = append(ss.Spec.VolumeClaimTemplates,
+				v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: tablespaceVolumeName(name)}})
+		}
+		return ss
+	}
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.Statefulset = withTablespaces("archive")
+
+	cmp := cluster.compareStatefulSetWith(withTablespaces("archive", "fast"))
+	if !cmp.replace || cmp.rejectReason != "" {
+		t.Errorf("%s expects the new tablespace to replace the statefulset, got reasons %v", testName, cmp.reasons)
+	}
+	cmp = cluster.compareStatefulSetWith(withTablespaces())
+	if !strings.Contains(cmp.rejectReason, "tablespaces cannot be removed") {
+		t.Errorf("%s expects the removal of the tablespace to be rejected, got %q", testName, cmp.rejectReason)
+	}
+	ephemeral := withTablespaces()
+	ephemeral.Spec.VolumeClaimTemplates = nil
+	cmp = cluster.compareStatefulSetWith(ephemeral)
+	if !strings.Contains(cmp.rejectReason, "volume mode can only be set") {
+		t.Errorf("%s expects the switch to the ephemeral volumes to be rejected, got %q", testName, cmp.rejectReason)
+	}
+}
+
+func TestSyncRolesCreatesRolesMissingInRestore(t *testing.T) {
+	testName := "TestSyncRolesCreatesRolesMissingInRestore"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.pgUsers = map[string]spec.PgUser{
+		"foo": {Name: "foo", Password: "bar", Flags: []string{"LOGIN"}},
+	}
+	// the restored database knows none of the desired roles
+	db, restored := openFakeDB(t, "restored-clone")
+	cluster.pgDb = db
+
+	if err := cluster.syncRoles(); err != nil {
+		t.Fatalf("%s: could not sync roles: %v", testName, err)
+	}
+	created := false
+	for _, query := range restored.executedStatements() {
+		if strings.Contains(query, `CREATE ROLE "foo"`) {
+			created = true
+		}
+	}
+	if !created {
+		t.Errorf("%s expects the role missing in the restore to be created, executed %v", testName, restored.executedStatements())
+	}
+}
+
+func TestCloneRolesSyncFlag(t *testing.T) {
+	testName := "TestCloneRolesSyncFlag"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	sset := &v1beta1.StatefulSet{}
+
+	if cluster.getCloneRolesSyncFlagFromStatefulSet(sset) {
+		t.Errorf("%s expects the flag to be unset for a statefulset without annotations", testName)
+	}
+	cluster.setCloneRolesSyncFlagForStatefulSet(sset, true)
+	if !cluster.getCloneRolesSyncFlagFromStatefulSet(sset) {
+		t.Errorf("%s expects the flag to be set after a clone", testName)
+	}
+	cluster.setCloneRolesSyncFlagForStatefulSet(sset, false)
+	if cluster.getCloneRolesSyncFlagFromStatefulSet(sset) {
+		t.Errorf("%s expects the flag to be cleared after the roles sync", testName)
+	}
+}
+
+func TestServiceSelectorFollowsPodRoleLabel(t *testing.T) {
+	testName := "TestServiceSelectorFollowsPodRoleLabel"
+	cluster := New(
+		Config{OpConfig: config.Config{Resources: config.Resources{
+			PodRoleLabel:          "spilo-role",
+			PodRoleLabelFallbacks: []string{"role"},
+		}}}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+
+	cluster.updatePodRoleLabel([]v1.Pod{})
+	if selector := cluster.generateService(Replica, &cluster.Spec).Spec.Selector; selector["spilo-role"] != "replica" {
+		t.Errorf("%s expects the configured role label in the selector without pods, got %v", testName, selector)
+	}
+
+	cluster.updatePodRoleLabel([]v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "acid-test-0", Labels: map[string]string{"role": "master"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "acid-test-1", Labels: map[string]string{"role": "replica"}}},
+	})
+	selector := cluster.generateService(Replica, &cluster.Spec).Spec.Selector
+	if selector["role"] != "replica" {
+		t.Errorf("%s expects the selector to use the role label of the pods, got %v", testName, selector)
+	}
+	if _, ok := selector["spilo-role"]; ok {
+		t.Errorf("%s expects the configured role label to be dropped from the selector, got %v", testName, selector)
+	}
+}
+
+func TestDatabaseObjectStepsOrder(t *testing.T) {
+	testName := "TestDatabaseObjectStepsOrder"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+
+	names := make([]string, 0)
+	for _, step := range cluster.databaseObjectSteps() {
+		names = append(names, step.name)
+	}
+	// roles own the databases, so they must exist before the databases are created
+	expected := []string{"roles", "tablespaces", "databases", "default privileges"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("%s expects the steps %v, got %v", testName, expected, names)
+	}
+}
+
+func crashLoopingPod(name string, crashLooping bool) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	state := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+	if crashLooping {
+		state = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}
+	}
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "postgres", State: state}}
+
+	return pod
+}
+
+func TestStatusAfterPodEvent(t *testing.T) {
+	testName := "TestStatusAfterPodEvent"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.Status.Phase = spec.ClusterStatusRunning
+
+	pod0 := spec.NamespacedName{Namespace: "default", Name: "acid-test-0"}
+	pod1 := spec.NamespacedName{Namespace: "default", Name: "acid-test-1"}
+
+	tests := []struct {
+		subtest string
+		event   spec.PodEvent
+		status  spec.ClusterPhase
+		changed bool
+	}{
+		{
+			subtest: "healthy pod keeps the cluster running",
+			event:   spec.PodEvent{PodName: pod0, EventType: spec.EventUpdate, CurPod: crashLoopingPod(pod0.Name, false)},
+			status:  spec.ClusterStatusRunning,
+			changed: false,
+		},
+		{
+			subtest: "crash looping pod degrades the cluster",
+			event:   spec.PodEvent{PodName: pod1, EventType: spec.EventUpdate, CurPod: crashLoopingPod(pod1.Name, true)},
+			status:  spec.ClusterStatusDegraded,
+			changed: true,
+		},
+		{
+			subtest: "another crash loop event keeps the cluster degraded",
+			event:   spec.PodEvent{PodName: pod1, EventType: spec.EventUpdate, CurPod: crashLoopingPod(pod1.Name, true)},
+			status:  spec.ClusterStatusDegraded,
+			changed: false,
+		},
+		{
+			subtest: "recovered pod restores the running status",
+			event:   spec.PodEvent{PodName: pod1, EventType: spec.EventUpdate, CurPod: crashLoopingPod(pod1.Name, false)},
+			status:  spec.ClusterStatusRunning,
+			changed: true,
+		},
+		{
+			subtest: "crash looping pod is forgotten once deleted",
+			event:   spec.PodEvent{PodName: pod0, EventType: spec.EventDelete, CurPod: crashLoopingPod(pod0.Name, true)},
+			status:  spec.ClusterStatusRunning,
+			changed: false,
+		},
+	}
+
+	for _, tt := range tests {
+		cluster.trackPodHealth(tt.event)
+		status, changed := cluster.statusAfterPodHealthChange()
+		if status != tt.status || changed != tt.changed {
+			t.Errorf("%s %s: expected status %q (changed: %t), got %q (changed: %t)",
+				testName, tt.subtest, tt.status, tt.changed, status, changed)
+		}
+		// emulate the scheduled status update applying the new status
+		cluster.Status.Phase = status
+	}
+}
+
+func TestTrackPodHealth(t *testing.T) {
+	testName := "TestTrackPodHealth"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	podName := spec.NamespacedName{Namespace: "default", Name: "acid-test-0"}
+
+	tests := []struct {
+		subtest string
+		event   spec.PodEvent
+		changed bool
+	}{
+		{"healthy pod", spec.PodEvent{PodName: podName, EventType: spec.EventUpdate,
+			CurPod: crashLoopingPod(podName.Name, false)}, false},
+		{"pod starts crash looping", spec.PodEvent{PodName: podName, EventType: spec.EventUpdate,
+			CurPod: crashLoopingPod(podName.Name, true)}, true},
+		{"pod keeps crash looping", spec.PodEvent{PodName: podName, EventType: spec.EventUpdate,
+			CurPod: crashLoopingPod(podName.Name, true)}, false},
+		{"crash looping pod deleted", spec.PodEvent{PodName: podName, EventType: spec.EventDelete,
+			CurPod: crashLoopingPod(podName.Name, true)}, true},
+	}
+	for _, tt := range tests {
+		if changed := cluster.trackPodHealth(tt.event); changed != tt.changed {
+			t.Errorf("%s %s: expected changed %t, got %t", testName, tt.subtest, tt.changed, changed)
+		}
+	}
+}
+
+func TestProcessPodEventWithSubscriber(t *testing.T) {
+	testName := "TestProcessPodEventWithSubscriber"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	// the cluster being created does not get its status changed by pod events
+	cluster.Status.Phase = spec.ClusterStatusCreating
+
+	podName := spec.NamespacedName{Namespace: "default", Name: "acid-test-0"}
+	event := spec.PodEvent{PodName: podName, EventType: spec.EventUpdate, CurPod: crashLoopingPod(podName.Name, true)}
+
+	ch := cluster.registerPodSubscriber(podName)
+	defer cluster.unregisterPodSubscriber(podName)
+
+	received := make(chan spec.PodEvent, 1)
+	go func() { received <- <-ch }()
+
+	if err := cluster.processPodEvent(event); err != nil {
+		t.Fatalf("%s: unexpected error: %v", testName, err)
+	}
+	if got := <-received; got.PodName != podName {
+		t.Errorf("%s: subscriber expected the event for %q, got %q", testName, podName, got.PodName)
+	}
+	if !cluster.hasCrashLoopingPods() {
+		t.Errorf("%s: expected the crash looping pod to be tracked", testName)
+	}
+	// the status update waits for the cluster mutex
+	cluster.Lock()
+	defer cluster.Unlock()
+	if cluster.Status.Phase != spec.ClusterStatusCreating {
+		t.Errorf("%s: expected status %q, got %q", testName, spec.ClusterStatusCreating, cluster.Status.Phase)
+	}
+}
+
+// fakeCreateClient accepts the endpoints and the services of a new cluster and refuses to create its secrets, which
+// makes the creation of the cluster fail once the users are initialized.
+type fakeCreateClient struct{}
+
+type fakeCreatedEndpoints struct {
+	v1core.EndpointsInterface
+}
+
+type fakeCreatedServices struct {
+	v1core.ServiceInterface
+}
+
+type fakeRefusedSecrets struct {
+	v1core.SecretInterface
+}
+
+func (c *fakeCreateClient) Endpoints(namespace string) v1core.EndpointsInterface {
+	return &fakeCreatedEndpoints{}
+}
+
+func (c *fakeCreateClient) Services(namespace string) v1core.ServiceInterface {
+	return &fakeCreatedServices{}
+}
+
+func (c *fakeCreateClient) Secrets(namespace string) v1core.SecretInterface {
+	return &fakeRefusedSecrets{}
+}
+
+func (e *fakeCreatedEndpoints) Create(endpoints *v1.Endpoints) (*v1.Endpoints, error) {
+	return endpoints, nil
+}
+
+func (s *fakeCreatedServices) Create(service *v1.Service) (*v1.Service, error) {
+	return service, nil
+}
+
+func (s *fakeRefusedSecrets) Create(secret *v1.Secret) (*v1.Secret, error) {
+	return nil, fmt.Errorf("secrets are not allowed")
+}
+
+// fakeStatusPatches records the bodies of the status patches received by the local server of newFakeCRDREST.
+type fakeStatusPatches struct {
+	sync.Mutex
+	bodies []string
+}
+
+func (p *fakeStatusPatches) received() []string {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string{}, p.bodies...)
+}
+
+// newFakeCRDREST returns a client of the operator resources talking to a local server, which accepts any request.
+func newFakeCRDREST(t *testing.T, patches *fakeStatusPatches) (rest.Interface, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		patches.Lock()
+		patches.bodies = append(patches.bodies, string(body))
+		patches.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host:    server.URL,
+		APIPath: constants.K8sAPIPath,
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &schema.GroupVersion{Group: constants.CRDGroup, Version: constants.CRDApiVersion},
+			NegotiatedSerializer: serializer.DirectCodecFactory{CodecFactory: api.Codecs},
+		},
+	})
+	if err != nil {
+		server.Close()
+		t.Fatalf("could not create the rest client: %v", err)
+	}
+	return client, server.Close
+}
+
+func TestReconcileSpanHierarchy(t *testing.T) {
+	testName := "TestReconcileSpanHierarchy"
+	exporter := &tracing.InMemoryExporter{}
+	patches := &fakeStatusPatches{}
+	crdREST, closeServer := newFakeCRDREST(t, patches)
+	defer closeServer()
+	kubeClient := &fakeCreateClient{}
+	cluster := New(Config{Tracer: tracing.NewTracer(exporter), OpConfig: config.Config{Auth: config.Auth{
+		SuperUsername: superUserName, ReplicationUsername: replicationUserName}}},
+		k8sutil.KubernetesClient{EndpointsGetter: kubeClient, ServicesGetter: kubeClient, SecretsGetter: kubeClient,
+			CRDREST: crdREST},
+		spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+			Spec:       spec.PostgresSpec{TeamID: "acid"},
+		}, logger)
+
+	if err := cluster.Create(); err == nil || !strings.Contains(err.Error(), "secrets are not allowed") {
+		t.Fatalf("%s: expected the creation to fail on the secrets, got %v", testName, err)
+	}
+	if patches := patches.received(); len(patches) != 2 ||
+		!strings.Contains(patches[0], string(spec.ClusterStatusCreating)) ||
+		!strings.Contains(patches[1], string(spec.ClusterStatusAddFailed)) {
+		t.Errorf("%s: expected the %s and %s status, got %v", testName, spec.ClusterStatusCreating,
+			spec.ClusterStatusAddFailed, patches)
+	}
+
+	spans := exporter.Spans()
+	names := []string{"createService", "createService", "initUsers", "syncSecrets"}
+	if len(spans) != len(names)+1 {
+		t.Fatalf("%s expects %d spans, got %d: %#v", testName, len(names)+1, len(spans), spans)
+	}
+	create := spans[len(names)]
+	if create.Name != "Create" || create.ParentSpanID != "" {
+		t.Fatalf("%s expects the Create span to be the root one, got %#v", testName, create)
+	}
+	for i, name := range names {
+		span := spans[i]
+		if span.Name != name {
+			t.Errorf("%s expects span %d to be %q, got %q", testName, i, name, span.Name)
+		}
+		if span.TraceID != create.TraceID || span.ParentSpanID != create.SpanID {
+			t.Errorf("%s expects the %q span to be nested under Create", testName, span.Name)
+		}
+		if span.Attributes["cluster"] != "default/acid-test" || span.Attributes["team"] != "acid" {
+			t.Errorf("%s expects the cluster and the team as attributes of %q, got %v", testName, span.Name, span.Attributes)
+		}
+	}
+	if cluster.span != nil {
+		t.Errorf("%s expects no span in progress after the Create span has ended", testName)
+	}
+}
+
+func TestReplaceSubsumesRollingUpdate(t *testing.T) {
+	testName := "TestReplaceSubsumesRollingUpdate"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.Statefulset = statefulSetWithVolumeLabels(nil)
+
+	// the pod affinity change requires both a replace and a rolling update
+	desired := statefulSetWithVolumeLabels(nil)
+	desired.Spec.Template.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{}}
+	cmp := cluster.compareStatefulSetWith(desired)
+	if !cmp.replace || !cmp.rollingUpdate {
+		t.Fatalf("%s expects the change to require a replace and a rolling update, got %#v", testName, cmp)
+	}
+
+	// the replacement statefulset never rolls the pods it adopts, so they are recreated once by the operator
+	plan := planStatefulSetSync(cmp, false)
+	if !plan.replace || plan.update {
+		t.Errorf("%s expects the statefulset to be replaced, got %#v", testName, plan)
+	}
+	if !plan.recreatePods {
+		t.Errorf("%s expects the pods to be recreated after the replace", testName)
+	}
+
+	// a pending rolling update from an interrupted sync is not performed twice either
+	plan = planStatefulSetSync(cmp, true)
+	if !plan.replace || !plan.recreatePods {
+		t.Errorf("%s expects the pending rolling update to be merged with the replace, got %#v", testName, plan)
+	}
+}
+
+func TestCompareStatefulSetUpdateStrategy(t *testing.T) {
+	testName := "TestCompareStatefulSetUpdateStrategy"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.Statefulset = statefulSetWithVolumeLabels(nil)
+	cluster.Statefulset.Spec.UpdateStrategy.Type = v1beta1.RollingUpdateStatefulSetStrategyType
+
+	desired := statefulSetWithVolumeLabels(nil)
+	desired.Spec.UpdateStrategy.Type = v1beta1.OnDeleteStatefulSetStrategyType
+	cmp := cluster.compareStatefulSetWith(desired)
+	if cmp.match || cmp.replace || cmp.rollingUpdate {
+		t.Errorf("%s expects the update strategy change to be applied with an update, got %#v", testName, cmp)
+	}
+}
+
+// fakePatroni answers the member status requests with a fixed error and records any disruptive call.
+type fakePatroni struct {
+	memberStatusErr error
+	disruptiveCalls []string
+	role            string
+	promotedRole    string // role reported once the standby cluster has been promoted
+	state           string // state reported by the members, running when empty
+	dcsLastSeen     int64  // last contact with the DCS reported by the members of the unlocked cluster, if set
+}
+
+func (p *fakePatroni) Switchover(master *v1.Pod, candidate string) error {
+	p.disruptiveCalls = append(p.disruptiveCalls, "switchover to "+candidate)
+	return nil
+}
+
+func (p *fakePatroni) SetPostgresParameters(server *v1.Pod, options map[string]string) error {
+	p.disruptiveCalls = append(p.disruptiveCalls, "set parameters on "+server.Name)
+	return nil
+}
+
+func (p *fakePatroni) Pause(server *v1.Pod) error {
+	p.disruptiveCalls = append(p.disruptiveCalls, "pause on "+server.Name)
+	return nil
+}
+
+func (p *fakePatroni) PromoteStandbyCluster(server *v1.Pod) error {
+	p.disruptiveCalls = append(p.disruptiveCalls, "promote on "+server.Name)
+	p.role = p.promotedRole
+	return nil
+}
+
+func (p *fakePatroni) GetMemberStatus(server *v1.Pod) (*patroni.MemberStatus, error) {
+	if p.memberStatusErr != nil {
+		return nil, p.memberStatusErr
+	}
+	state, role := "running", "replica"
+	if p.state != "" {
+		state = p.state
+	}
+	if p.role != "" {
+		role = p.role
+	}
+	return &patroni.MemberStatus{State: state, Role: role, ClusterUnlocked: p.dcsLastSeen > 0,
+		DCSLastSeen: p.dcsLastSeen}, nil
+}
+
+func TestDCSQuorumLoss(t *testing.T) {
+	testName := "TestDCSQuorumLoss"
+	dcsError := fmt.Errorf("patroni returned '%s'", `{"message": "DCS is not accessible"}`)
+	pods := func(roles ...string) []v1.Pod {
+		result := make([]v1.Pod, 0)
+		for i, role := range roles {
+			result = append(result, v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:   fmt.Sprintf("acid-test-%d", i),
+				Labels: map[string]string{"spilo-role": role},
+			}})
+		}
+		return result
+	}
+	longAgo := time.Now().Add(-5 * time.Minute).Unix()
+	justNow := time.Now().Unix()
+	tests := []struct {
+		about       string
+		action      string
+		pods        []v1.Pod
+		statusErr   error
+		dcsLastSeen int64
+		lost        bool
+	}{
+		{"masterless cluster with the DCS unreachable", "", pods("replica", "replica"), dcsError, 0, true},
+		{"protection requested explicitly", spec.DCSQuorumLossActionProtect, pods("replica"), dcsError, 0, true},
+		{"protection disabled", spec.DCSQuorumLossActionIgnore, pods("replica", "replica"), dcsError, 0, false},
+		{"cluster with a master", "", pods("master", "replica"), dcsError, 0, false},
+		{"masterless cluster with the DCS reachable", "", pods("replica", "replica"), nil, 0, false},
+		{"masterless cluster with an unrelated API error", "", pods("replica"), fmt.Errorf("could not make request: timeout"), 0, false},
+		{"unlocked cluster with the DCS last seen long ago", "", pods("replica", "replica"), nil, longAgo, true},
+		{"unlocked cluster with the DCS seen just now", "", pods("replica", "replica"), nil, justNow, false},
+	}
+	for _, tt := range tests {
+		fake := &fakePatroni{memberStatusErr: tt.statusErr, dcsLastSeen: tt.dcsLastSeen}
+		cluster := New(
+			Config{OpConfig: config.Config{Resources: config.Resources{PodRoleLabel: "spilo-role"}}},
+			k8sutil.KubernetesClient{},
+			spec.Postgresql{Spec: spec.PostgresSpec{Patroni: spec.Patroni{DCSQuorumLossAction: tt.action}}},
+			logger)
+		cluster.patroni = fake
+
+		lost := cluster.dcsQuorumLost(tt.pods)
+		if lost != tt.lost {
+			t.Errorf("%s %s: expected the quorum loss to be %t, got %t", testName, tt.about, tt.lost, lost)
+		}
+		if len(fake.disruptiveCalls) > 0 {
+			t.Errorf("%s %s: expected no disruptive patroni calls, got %v", testName, tt.about, fake.disruptiveCalls)
+		}
+		if status := syncResultStatus(nil, lost, false); lost && status != spec.ClusterStatusDCSQuorumLost {
+			t.Errorf("%s %s: expected the %q status, got %q", testName, tt.about, spec.ClusterStatusDCSQuorumLost, status)
+		}
+	}
+}
+
+func TestSyncResultStatus(t *testing.T) {
+	testName := "TestSyncResultStatus"
+	tests := []struct {
+		err        error
+		quorumLost bool
+		degraded   bool
+		status     spec.ClusterPhase
+	}{
+		{nil, false, false, spec.ClusterStatusRunning},
+		{nil, false, true, spec.ClusterStatusDegraded},
+		{nil, true, true, spec.ClusterStatusDCSQuorumLost},
+		{fmt.Errorf("could not sync services"), true, false, spec.ClusterStatusSyncFailed},
+	}
+	for _, tt := range tests {
+		if status := syncResultStatus(tt.err, tt.quorumLost, tt.degraded); status != tt.status {
+			t.Errorf("%s: expected %q for error %v, quorum lost %t and degraded %t, got %q",
+				testName, tt.status, tt.err, tt.quorumLost, tt.degraded, status)
+		}
+	}
+}
+
+func TestRecreatePodsMaxUnavailable(t *testing.T) {
+	testName := "TestRecreatePodsMaxUnavailable"
+	percent := intstr.FromString("50%")
+	two := intstr.FromInt(2)
+	tests := []struct {
+		about          string
+		operatorValue  string
+		manifestValue  *intstr.IntOrString
+		pods           int
+		maxUnavailable int
+		batches        []int
+	}{
+		{"operator default", "1", nil, 4, 1, []int{1, 1, 1}},
+		{"operator value", "2", nil, 4, 2, []int{2, 1}},
+		{"manifest value takes precedence", "1", &two, 4, 2, []int{2, 1}},
+		{"percentage rounded down", "1", &percent, 5, 2, []int{2, 2}},
+		{"percentage of a small cluster", "10%", nil, 3, 1, []int{1, 1}},
+		{"unset operator value", "", nil, 3, 1, []int{1, 1}},
+	}
+	for _, tt := range tests {
+		cluster := New(
+			Config{OpConfig: config.Config{Resources: config.Resources{PodMaxUnavailable: tt.operatorValue}}},
+			k8sutil.KubernetesClient{}, spec.Postgresql{Spec: spec.PostgresSpec{MaxUnavailable: tt.manifestValue}}, logger)
+
+		maxUnavailable := cluster.maxUnavailablePods(tt.pods)
+		if maxUnavailable != tt.maxUnavailable {
+			t.Errorf("%s %s: expected %d pods recreated at once, got %d", testName, tt.about, tt.maxUnavailable, maxUnavailable)
+		}
+
+		// the master is recreated on its own after the replicas
+		replicas := make([]spec.NamespacedName, tt.pods-1)
+		for i := range replicas {
+			replicas[i] = spec.NamespacedName{Namespace: "default", Name: fmt.Sprintf("acid-test-%d", i+1)}
+		}
+		batches := podBatches(replicas, maxUnavailable)
+		sizes := make([]int, len(batches))
+		for i, batch := range batches {
+			sizes[i] = len(batch)
+		}
+		if !reflect.DeepEqual(sizes, tt.batches) {
+			t.Errorf("%s %s: expected batches of %v replicas, got %v", testName, tt.about, tt.batches, sizes)
+		}
+	}
+}
+
+// fakeCloneResources keeps the statefulset, the pods and the persistent volume claims of a cluster and records
+// their deletion.
+type fakeCloneResources struct {
+	cluster *Cluster
+	pods    []v1.Pod
+	pvcs    []v1.PersistentVolumeClaim
+	deleted []string
+}
+
+type fakeStatefulSets struct {
+	appsv1beta1.StatefulSetInterface
+	res *fakeCloneResources
+}
+
+type fakePods struct {
+	v1core.PodInterface
+	res *fakeCloneResources
+}
+
+type fakePersistentVolumeClaims struct {
+	v1core.PersistentVolumeClaimInterface
+	res *fakeCloneResources
+}
+
+func (r *fakeCloneResources) StatefulSets(namespace string) appsv1beta1.StatefulSetInterface {
+	return &fakeStatefulSets{res: r}
+}
+
+func (r *fakeCloneResources) Pods(namespace string) v1core.PodInterface {
+	return &fakePods{res: r}
+}
+
+func (r *fakeCloneResources) PersistentVolumeClaims(namespace string) v1core.PersistentVolumeClaimInterface {
+	return &fakePersistentVolumeClaims{res: r}
+}
+
+func (s *fakeStatefulSets) Delete(name string, options *metav1.DeleteOptions) error {
+	s.res.deleted = append(s.res.deleted, "statefulset/"+name)
+	return nil
+}
+
+// Patch scales the statefulset, the pods are gone once it is scaled to zero
+func (s *fakeStatefulSets) Patch(name string, pt types.PatchType, data []byte,
+	subresources ...string) (*v1beta1.StatefulSet, error) {
+	s.res.deleted = append(s.res.deleted, "patch statefulset/"+name+" "+string(data))
+	if string(data) == `{"spec":{"replicas":0}}` {
+		s.res.pods = nil
+	}
+	return &v1beta1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+}
+
+func (p *fakePods) List(opts metav1.ListOptions) (*v1.PodList, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	result := &v1.PodList{}
+	for _, pod := range p.res.pods {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			result.Items = append(result.Items, pod)
+		}
+	}
+	return result, nil
+}
+
+func (p *fakePods) Delete(name string, options *metav1.DeleteOptions) error {
+	p.res.deleted = append(p.res.deleted, "pod/"+name)
+	podName := spec.NamespacedName{Namespace: p.res.cluster.Namespace, Name: name}
+	p.res.cluster.podSubscribersMu.RLock()
+	subscriber := p.res.cluster.podSubscribers[podName]
+	p.res.cluster.podSubscribersMu.RUnlock()
+	go func() { subscriber <- spec.PodEvent{PodName: podName, EventType: spec.EventDelete} }()
+	return nil
+}
+
+func (c *fakePersistentVolumeClaims) List(opts metav1.ListOptions) (*v1.PersistentVolumeClaimList, error) {
+	return &v1.PersistentVolumeClaimList{Items: c.res.pvcs}, nil
+}
+
+func (c *fakePersistentVolumeClaims) Delete(name string, options *metav1.DeleteOptions) error {
+	c.res.deleted = append(c.res.deleted, "pvc/"+name)
+	return nil
+}
+
+func TestFailedCloneCleanup(t *testing.T) {
+	testName := "TestFailedCloneCleanup"
+	clusterLabels := map[string]string{"cluster-name": "acid-clone"}
+	pod := func(name, role string) v1.Pod {
+		podLabels := map[string]string{"cluster-name": "acid-clone"}
+		if role != "" {
+			podLabels["spilo-role"] = role
+		}
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: podLabels}}
+	}
+	pvc := v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pgdata-acid-clone-0", Namespace: "default",
+		Labels: clusterLabels}}
+	crashLooping := func(pod v1.Pod) v1.Pod {
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{{State: v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{Reason: podReasonCrashLoopBackOff}}}}
+		return pod
+	}
+
+	tests := []struct {
+		about     string
+		clone     string
+		cleanup   bool
+		rolesFlag bool
+		pods      []v1.Pod
+		state     string
+		failed    bool
+		deleted   []string
+	}{
+		{
+			about:     "failed clone is removed",
+			clone:     "acid-source",
+			cleanup:   true,
+			rolesFlag: true,
+			pods:      []v1.Pod{pod("acid-clone-0", "")},
+			state:     "custom bootstrap failed",
+			failed:    true,
+			deleted:   []string{"statefulset/acid-clone", "pod/acid-clone-0", "pvc/pgdata-acid-clone-0"},
+		},
+		{
+			about:     "crash looping clone is removed",
+			clone:     "acid-source",
+			cleanup:   true,
+			rolesFlag: true,
+			pods:      []v1.Pod{crashLooping(pod("acid-clone-0", ""))},
+			failed:    true,
+			deleted:   []string{"statefulset/acid-clone", "pod/acid-clone-0", "pvc/pgdata-acid-clone-0"},
+		},
+		{
+			about:     "slow clone is kept",
+			clone:     "acid-source",
+			cleanup:   true,
+			rolesFlag: true,
+			pods:      []v1.Pod{pod("acid-clone-0", "")},
+			state:     "running custom bootstrap script",
+		},
+		{
+			about:     "failed clone is kept when the cleanup is disabled",
+			clone:     "acid-source",
+			cleanup:   false,
+			rolesFlag: true,
+			pods:      []v1.Pod{pod("acid-clone-0", "")},
+			state:     "custom bootstrap failed",
+			failed:    true,
+		},
+		{
+			about:     "restored clone with a master is kept",
+			clone:     "acid-source",
+			cleanup:   true,
+			rolesFlag: true,
+			pods:      []v1.Pod{pod("acid-clone-0", "master"), pod("acid-clone-1", "")},
+		},
+		{
+			about:   "clone with synced roles is kept",
+			clone:   "acid-source",
+			cleanup: true,
+			pods:    []v1.Pod{pod("acid-clone-0", "")},
+		},
+		{
+			about:     "cluster that is not a clone is kept",
+			cleanup:   true,
+			rolesFlag: true,
+			pods:      []v1.Pod{pod("acid-clone-0", "")},
+		},
+	}
+	for _, tt := range tests {
+		res := &fakeCloneResources{pods: tt.pods, pvcs: []v1.PersistentVolumeClaim{pvc}}
+		cluster := New(
+			Config{OpConfig: config.Config{FailedCloneCleanup: tt.cleanup, Resources: config.Resources{
+				ClusterNameLabel: "cluster-name", PodRoleLabel: "spilo-role", PodDeletionWaitTimeout: time.Second}}},
+			k8sutil.KubernetesClient{StatefulSetsGetter: res, PodsGetter: res, PersistentVolumeClaimsGetter: res},
+			spec.Postgresql{
+				ObjectMeta: metav1.ObjectMeta{Name: "acid-clone", Namespace: "default"},
+				Spec:       spec.PostgresSpec{Clone: spec.CloneDescription{ClusterName: tt.clone}},
+			}, logger)
+		res.cluster = cluster
+		cluster.patroni = &fakePatroni{state: tt.state}
+		cluster.Statefulset = &v1beta1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "acid-clone", Namespace: "default"}}
+		cluster.setCloneRolesSyncFlagForStatefulSet(cluster.Statefulset, tt.rolesFlag)
+
+		if failed := cluster.handleFailedClone(); failed != tt.failed {
+			t.Errorf("%s %s: expected the clone failed to be %t, got %t", testName, tt.about, tt.failed, failed)
+		}
+		if !reflect.DeepEqual(res.deleted, tt.deleted) {
+			t.Errorf("%s %s: expected deleted resources %v, got %v", testName, tt.about, tt.deleted, res.deleted)
+		}
+		// the next sync re-creates the missing statefulset and retries the clone from empty volumes
+		if removed := cluster.Statefulset == nil; removed != (len(tt.deleted) > 0) {
+			t.Errorf("%s %s: expected the statefulset removed to be %t, got %t",
+				testName, tt.about, len(tt.deleted) > 0, removed)
+		}
+	}
+}
+
+func TestPodEventsAfterDelete(t *testing.T) {
+	testName := "TestPodEventsAfterDelete"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	podName := spec.NamespacedName{Namespace: "default", Name: "acid-test-0"}
+	subscriber := cluster.registerPodSubscriber(podName)
+	defer cluster.unregisterPodSubscriber(podName)
+
+	cluster.ReceivePodEvent(spec.PodEvent{PodName: podName, EventType: spec.EventUpdate, ResourceVersion: "1"})
+	cluster.stopPodEvents()
+	cluster.ReceivePodEvent(spec.PodEvent{PodName: podName, EventType: spec.EventDelete, ResourceVersion: "2"})
+
+	done := make(chan struct{})
+	go func() {
+		cluster.processPodEventQueue(make(chan struct{}))
+		close(done)
+	}()
+	select {
+	case event := <-subscriber:
+		t.Errorf("%s: expected no pod events processed after the deletion, got %#v", testName, event)
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("%s: expected the pod event processing to stop after the deletion", testName)
+	}
+}
+
+func TestUpdateMetadataOnly(t *testing.T) {
+	testName := "TestUpdateMetadataOnly"
+	pgSpec := spec.PostgresSpec{
+		PostgresqlParam:   spec.PostgresqlParam{PgVersion: "10"},
+		NumberOfInstances: 2,
+		Volume:            spec.Volume{Size: "1Gi"},
+	}
+	oldSpec := spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"}, Spec: pgSpec}
+	newSpec := oldSpec
+	newSpec.ObjectMeta.Labels = map[string]string{"environment": "test"}
+	newSpec.ObjectMeta.Annotations = map[string]string{"owner": "acid"}
+
+	// the empty Kubernetes client panics on any call, i.e. on the statefulset or service changes
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, oldSpec, logger)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("%s: expected no changes of the cluster resources, got %v", testName, r)
+		}
+	}()
+	if err := cluster.Update(&oldSpec, &newSpec); err != nil {
+		t.Errorf("%s: could not update the cluster: %v", testName, err)
+	}
+	if !reflect.DeepEqual(cluster.ObjectMeta.Labels, newSpec.ObjectMeta.Labels) {
+		t.Errorf("%s: expected the manifest labels %v, got %v", testName, newSpec.ObjectMeta.Labels, cluster.ObjectMeta.Labels)
+	}
+}
+
+type fakeEvents struct {
+	v1core.EventInterface
+	created []*v1.Event
+}
+
+func (e *fakeEvents) Events(namespace string) v1core.EventInterface {
+	return e
+}
+
+func (e *fakeEvents) Create(event *v1.Event) (*v1.Event, error) {
+	e.created = append(e.created, event)
+	return event, nil
+}
+
+func TestChecksumFailures(t *testing.T) {
+	testName := "TestChecksumFailures"
+	events := &fakeEvents{}
+	cluster := New(Config{}, k8sutil.KubernetesClient{EventsGetter: events},
+		spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+			Spec:       spec.PostgresSpec{CheckDataChecksums: true},
+		}, logger)
+	db, _ := openFakeDB(t, "checksum-failures", []driver.Value{"mydb", int64(3)})
+	cluster.pgDb = db
+
+	degraded := cluster.checkDataChecksums()
+	if !degraded {
+		t.Fatalf("%s: expected the checksum failures to degrade the cluster", testName)
+	}
+	if status := syncResultStatus(nil, false, degraded); status != spec.ClusterStatusDegraded {
+		t.Errorf("%s: expected status %q, got %q", testName, spec.ClusterStatusDegraded, status)
+	}
+	if failures := cluster.GetStatus().ChecksumFailures; failures["mydb"] != 3 {
+		t.Errorf("%s: expected 3 checksum failures of mydb in the status, got %v", testName, failures)
+	}
+	if len(events.created) != 1 {
+		t.Fatalf("%s: expected one event, got %d", testName, len(events.created))
+	}
+	if event := events.created[0]; event.Reason != eventReasonChecksumFailed || !strings.Contains(event.Message, `"mydb"`) {
+		t.Errorf("%s: expected a %s event naming mydb, got %q: %q", testName, eventReasonChecksumFailed, event.Reason, event.Message)
+	}
+}
+
+func TestDeleteScaleDown(t *testing.T) {
+	testName := "TestDeleteScaleDown"
+	pod := func(name, role string) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+			Labels: map[string]string{"cluster-name": "acid-test", "spilo-role": role}}}
+	}
+	tests := []struct {
+		about     string
+		scaleDown bool
+		pause     bool
+		deleted   []string
+		patroni   []string
+	}{
+		{
+			about:     "statefulset is scaled to zero before it is deleted",
+			scaleDown: true,
+			deleted:   []string{`patch statefulset/acid-test {"spec":{"replicas":0}}`, "statefulset/acid-test"},
+		},
+		{
+			about:     "failovers are paused before the scale down",
+			scaleDown: true,
+			pause:     true,
+			deleted:   []string{`patch statefulset/acid-test {"spec":{"replicas":0}}`, "statefulset/acid-test"},
+			patroni:   []string{"pause on acid-test-0"},
+		},
+		{
+			about:   "pods are deleted along with the statefulset when the scale down is disabled",
+			deleted: []string{"statefulset/acid-test", "pod/acid-test-0", "pod/acid-test-1"},
+		},
+	}
+	for _, tt := range tests {
+		res := &fakeCloneResources{pods: []v1.Pod{pod("acid-test-0", "master"), pod("acid-test-1", "replica")}}
+		patroniClient := &fakePatroni{}
+		cluster := New(
+			Config{OpConfig: config.Config{DeleteScaleDown: tt.scaleDown, DeletePauseFailovers: tt.pause,
+				Resources: config.Resources{ClusterNameLabel: "cluster-name", PodRoleLabel: "spilo-role",
+					PodDeletionWaitTimeout: time.Second, ResourceCheckInterval: time.Millisecond,
+					ResourceCheckTimeout: time.Second}}},
+			k8sutil.KubernetesClient{StatefulSetsGetter: res, PodsGetter: res, PersistentVolumeClaimsGetter: res},
+			spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"}}, logger)
+		res.cluster = cluster
+		cluster.patroni = patroniClient
+		cluster.Statefulset = &v1beta1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"}}
+
+		cluster.teardownStatefulSet()
+		if !reflect.DeepEqual(res.deleted, tt.deleted) {
+			t.Errorf("%s %s: expected the calls %v, got %v", testName, tt.about, tt.deleted, res.deleted)
+		}
+		if !reflect.DeepEqual(patroniClient.disruptiveCalls, tt.patroni) {
+			t.Errorf("%s %s: expected the patroni calls %v, got %v", testName, tt.about, tt.patroni, patroniClient.disruptiveCalls)
+		}
+	}
+}
+
+// fakePersistentVolumes records the reclaim policies set on the persistent volumes.
+type fakePersistentVolumes struct {
+	v1core.PersistentVolumeInterface
+	patched []string
+}
+
+func (v *fakePersistentVolumes) PersistentVolumes() v1core.PersistentVolumeInterface {
+	return v
+}
+
+func (v *fakePersistentVolumes) Patch(name string, pt types.PatchType, data []byte,
+	subresources ...string) (*v1.PersistentVolume, error) {
+	v.patched = append(v.patched, name+" "+string(data))
+	return &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+}
+
+func TestRemoveVolumes(t *testing.T) {
+	testName := "TestRemoveVolumes"
+	retain, remove := false, true
+	tests := []struct {
+		about          string
+		operatorDelete bool
+		manifestDelete *bool
+		policy         string
+		deleted        []string
+		reason         string
+	}{
+		{"volumes deleted by default", true, nil, "", []string{"pvc/pgdata-acid-test-0"}, eventReasonVolumesDeleted},
+		{"volumes retained by the operator", false, nil, "Retain", nil, eventReasonVolumesRetained},
+		{"manifest retains the volumes", true, &retain, "Retain", nil, eventReasonVolumesRetained},
+		{"manifest deletes the volumes", false, &remove, "", []string{"pvc/pgdata-acid-test-0"}, eventReasonVolumesDeleted},
+	}
+	for _, tt := range tests {
+		res := &fakeCloneResources{pvcs: []v1.PersistentVolumeClaim{{
+			ObjectMeta: metav1.ObjectMeta{Name: "pgdata-acid-test-0", Namespace: "default"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-0"},
+		}}}
+		pvs, events := &fakePersistentVolumes{}, &fakeEvents{}
+		cluster := New(Config{OpConfig: config.Config{DeleteVolumesOnDelete: tt.operatorDelete}},
+			k8sutil.KubernetesClient{PersistentVolumeClaimsGetter: res, PersistentVolumesGetter: pvs, EventsGetter: events},
+			spec.Postgresql{
+				ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+				Spec:       spec.PostgresSpec{DeleteVolumesOnDelete: tt.manifestDelete},
+			}, logger)
+
+		if err := cluster.removeVolumes(); err != nil {
+			t.Fatalf("%s %s: could not remove volumes: %v", testName, tt.about, err)
+		}
+		// the deleted claims leave their volumes to their reclaim policy
+		var expectedPatch []string
+		if tt.policy != "" {
+			expectedPatch = []string{`pv-0 {"spec":{"persistentVolumeReclaimPolicy":"` + tt.policy + `"}}`}
+		}
+		if !reflect.DeepEqual(pvs.patched, expectedPatch) {
+			t.Errorf("%s %s: expected the patches %v, got %v", testName, tt.about, expectedPatch, pvs.patched)
+		}
+		if !reflect.DeepEqual(res.deleted, tt.deleted) {
+			t.Errorf("%s %s: expected deleted resources %v, got %v", testName, tt.about, tt.deleted, res.deleted)
+		}
+		if len(events.created) != 1 || events.created[0].Reason != tt.reason || events.created[0].Type != v1.EventTypeNormal {
+			t.Errorf("%s %s: expected one %s event, got %v", testName, tt.about, tt.reason, events.created)
+		}
+	}
+}
+
+func TestReplicaReads(t *testing.T) {
+	testName := "TestReplicaReads"
+	cluster := New(Config{OpConfig: config.Config{PgVersionMismatchAction: config.PgVersionMismatchActionDegrade}},
+		k8sutil.KubernetesClient{},
+		spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+			Spec: spec.PostgresSpec{TeamID: "acid", ReplicaReads: true,
+				PostgresqlParam: spec.PostgresqlParam{PgVersion: "10"},
+				Users:           map[string]spec.UserFlags{"app": {"createdb"}}},
+		}, logger)
+	if err := cluster.initUsers(); err != nil {
+		t.Fatalf("%s: could not init users: %v", testName, err)
+	}
+	master, masterDriver := openFakeDB(t, "replica-reads-master")
+	replica, replicaDriver := openFakeDB(t, "replica-reads-replica", []driver.Value{"100005"})
+	cluster.pgDb = master
+	cluster.pgReadDb = replica
+
+	if degraded := cluster.checkPgVersion(); degraded {
+		t.Errorf("%s: expected the version read from the replica to match the manifest", testName)
+	}
+	if err := cluster.syncRoles(); err != nil {
+		t.Fatalf("%s: could not sync roles: %v", testName, err)
+	}
+
+	if queries := replicaDriver.executedQueries(); len(queries) != 1 || queries[0] != getServerVersionNumSQL {
+		t.Errorf("%s: expected the version to be read from the replica, got %v", testName, queries)
+	}
+	if executed := replicaDriver.executedStatements(); len(executed) != 0 {
+		t.Errorf("%s: expected no changes on the replica connection, got %v", testName, executed)
+	}
+	for _, query := range masterDriver.executedQueries() {
+		if query == getServerVersionNumSQL {
+			t.Errorf("%s: expected the version not to be read from the master", testName)
+		}
+	}
+	if executed := masterDriver.executedStatements(); len(executed) == 0 ||
+		!strings.Contains(strings.Join(executed, "\n"), `CREATE ROLE "app"`) {
+		t.Errorf("%s: expected the roles to be created on the master connection, got %v", testName, executed)
+	}
+	if cluster.pgReadDb != nil || cluster.pgDb != nil {
+		t.Errorf("%s: expected both connections to be closed", testName)
+	}
+}
+
+// fakeCloneSource has the services and the secrets of the running clusters, keyed by namespace/name. The operator
+// is not allowed to read the namespaces marked as forbidden.
+type fakeCloneSource struct {
+	services  map[string]bool
+	secrets   map[string]bool
+	forbidden map[string]bool
+	copied    []string
+}
+
+type fakeServices struct {
+	v1core.ServiceInterface
+	src       *fakeCloneSource
+	namespace string
+}
+
+type fakeSecrets struct {
+	v1core.SecretInterface
+	src       *fakeCloneSource
+	namespace string
+}
+
+func (s *fakeCloneSource) Services(namespace string) v1core.ServiceInterface {
+	return &fakeServices{src: s, namespace: namespace}
+}
+
+func (s *fakeCloneSource) Secrets(namespace string) v1core.SecretInterface {
+	return &fakeSecrets{src: s, namespace: namespace}
+}
+
+func (s *fakeServices) Get(name string, options metav1.GetOptions) (*v1.Service, error) {
+	if s.src.forbidden[s.namespace] {
+		return nil, apierrors.NewForbidden(schema.GroupResource{Resource: "services"}, name, fmt.Errorf("no RBAC rule"))
+	}
+	if !s.src.services[s.namespace+"/"+name] {
+		return nil, fmt.Errorf("services %q not found", name)
+	}
+	return &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+}
+
+func (s *fakeSecrets) Get(name string, options metav1.GetOptions) (*v1.Secret, error) {
+	if s.src.forbidden[s.namespace] {
+		return nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, name, fmt.Errorf("no RBAC rule"))
+	}
+	if !s.src.secrets[s.namespace+"/"+name] {
+		return nil, fmt.Errorf("secrets %q not found", name)
+	}
+	return &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name}, Data: map[string][]byte{"password": []byte("secret")}}, nil
+}
+
+func (s *fakeSecrets) Create(secret *v1.Secret) (*v1.Secret, error) {
+	s.src.copied = append(s.src.copied, s.namespace+"/"+secret.Name)
+	return secret, nil
+}
+
+func TestBasebackupCloneSource(t *testing.T) {
+	testName := "TestBasebackupCloneSource"
+	secretName := "standby.acid-batman.credentials"
+	tests := []struct {
+		about     string
+		namespace string
+		services  map[string]bool
+		secrets   map[string]bool
+		forbidden map[string]bool
+		copied    []string
+		err       string
+	}{
+		{
+			about:    "running cluster with the replication credentials",
+			services: map[string]bool{"default/acid-batman": true},
+			secrets:  map[string]bool{"default/" + secretName: true},
+		},
+		{
+			about:   "cluster that is not running",
+			secrets: map[string]bool{"default/" + secretName: true},
+			err:     `could not get the service "acid-batman" of the running cluster: services "acid-batman" not found`,
+		},
+		{
+			about:    "missing replication credentials",
+			services: map[string]bool{"default/acid-batman": true},
+			err:      `could not get the replication credentials "standby.acid-batman.credentials": secrets "standby.acid-batman.credentials" not found`,
+		},
+		{
+			about:     "cluster in another namespace",
+			namespace: "team-batman",
+			services:  map[string]bool{"team-batman/acid-batman": true},
+			secrets:   map[string]bool{"team-batman/" + secretName: true},
+			copied:    []string{"default/team-batman." + secretName},
+		},
+		{
+			about:     "cluster in another namespace with the same name as a local one",
+			namespace: "team-batman",
+			services:  map[string]bool{"default/acid-batman": true},
+			secrets:   map[string]bool{"default/" + secretName: true},
+			err:       `could not get the service "acid-batman" of the running cluster: services "acid-batman" not found`,
+		},
+		{
+			about:     "namespace the operator cannot read",
+			namespace: "team-batman",
+			services:  map[string]bool{"team-batman/acid-batman": true},
+			secrets:   map[string]bool{"team-batman/" + secretName: true},
+			forbidden: map[string]bool{"team-batman": true},
+			err:       `operator is not allowed to get the services in the namespace "team-batman": services "acid-batman" is forbidden: no RBAC rule`,
+		},
+	}
+	for _, tt := range tests {
+		src := &fakeCloneSource{services: tt.services, secrets: tt.secrets, forbidden: tt.forbidden}
+		cluster := New(
+			Config{OpConfig: config.Config{Auth: config.Auth{SecretNameTemplate: "{username}.{cluster}.credentials",
+				ReplicationUsername: replicationUserName}}},
+			k8sutil.KubernetesClient{ServicesGetter: src, SecretsGetter: src},
+			spec.Postgresql{
+				ObjectMeta: metav1.ObjectMeta{Name: "acid-clone", Namespace: "default"},
+				Spec:       spec.PostgresSpec{Clone: spec.CloneDescription{ClusterName: "acid-batman", Namespace: tt.namespace}},
+			}, logger)
+
+		err := cluster.checkBasebackupCloneSource()
+		if tt.err == "" && err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, tt.about, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s %s: expected error %q, got %v", testName, tt.about, tt.err, err)
+		}
+		if !reflect.DeepEqual(src.copied, tt.copied) {
+			t.Errorf("%s %s: expected the copied secrets %v, got %v", testName, tt.about, tt.copied, src.copied)
+		}
+	}
+}
+
+func TestCloneEnvironmentFromAnotherNamespace(t *testing.T) {
+	testName := "TestCloneEnvironmentFromAnotherNamespace"
+	cluster := New(
+		Config{OpConfig: config.Config{Auth: config.Auth{SecretNameTemplate: "{username}.{cluster}.credentials",
+			ReplicationUsername: replicationUserName}}},
+		k8sutil.KubernetesClient{},
+		spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-clone", Namespace: "default"}}, logger)
+
+	envVars := cluster.generateCloneEnvironment(&spec.CloneDescription{ClusterName: "acid-batman", Namespace: "team-batman"})
+	values := make(map[string]string)
+	for _, env := range envVars {
+		if env.ValueFrom != nil {
+			values[env.Name] = env.ValueFrom.SecretKeyRef.Name
+		} else {
+			values[env.Name] = env.Value
+		}
+	}
+	if values["CLONE_HOST"] != "acid-batman.team-batman" {
+		t.Errorf("%s: expected the host in the namespace of the cluster, got %q", testName, values["CLONE_HOST"])
+	}
+	if values["CLONE_PASSWORD"] != "team-batman.standby.acid-batman.credentials" {
+		t.Errorf("%s: expected the copy of the credentials, got %q", testName, values["CLONE_PASSWORD"])
+	}
+}
+
+type fakeCronJobs struct {
+	batchv2alpha1.CronJobInterface
+	jobs    map[string]*batchv2alpha1api.CronJob
+	actions []string
+}
+
+func (j *fakeCronJobs) CronJobs(namespace string) batchv2alpha1.CronJobInterface {
+	return j
+}
+
+func (j *fakeCronJobs) Get(name string, options metav1.GetOptions) (*batchv2alpha1api.CronJob, error) {
+	job, ok := j.jobs[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: "batch", Resource: "cronjobs"}, name)
+	}
+	return job, nil
+}
+
+func (j *fakeCronJobs) Create(job *batchv2alpha1api.CronJob) (*batchv2alpha1api.CronJob, error) {
+	j.actions = append(j.actions, "create "+job.Spec.Schedule)
+	j.jobs[job.Name] = job
+	return job, nil
+}
+
+func (j *fakeCronJobs) Update(job *batchv2alpha1api.CronJob) (*batchv2alpha1api.CronJob, error) {
+	j.actions = append(j.actions, "update "+job.Spec.Schedule)
+	j.jobs[job.Name] = job
+	return job, nil
+}
+
+func (j *fakeCronJobs) Delete(name string, options *metav1.DeleteOptions) error {
+	j.actions = append(j.actions, "delete")
+	delete(j.jobs, name)
+	return nil
+}
+
+func TestSyncLogicalBackupJob(t *testing.T) {
+	testName := "TestSyncLogicalBackupJob"
+	jobs := &fakeCronJobs{jobs: make(map[string]*batchv2alpha1api.CronJob)}
+	cluster := New(
+		Config{OpConfig: config.Config{LogicalBackupSchedule: "30 00 * * *"}},
+		k8sutil.KubernetesClient{CronJobsGetter: jobs},
+		spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"}}, logger)
+
+	steps := []struct {
+		about    string
+		spec     spec.PostgresSpec
+		actions  []string
+		jobFound bool
+	}{
+		{"disabled", spec.PostgresSpec{}, nil, false},
+		{"enabled", spec.PostgresSpec{EnableLogicalBackup: true}, []string{"create 30 00 * * *"}, true},
+		{"unchanged", spec.PostgresSpec{EnableLogicalBackup: true}, nil, true},
+		{"schedule changed", spec.PostgresSpec{EnableLogicalBackup: true, LogicalBackupSchedule: "0 1 * * *"},
+			[]string{"update 0 1 * * *"}, true},
+		{"disabled again", spec.PostgresSpec{}, []string{"delete"}, false},
+	}
+	for _, step := range steps {
+		jobs.actions = nil
+		cluster.Spec = step.spec
+		if err := cluster.syncLogicalBackupJob(); err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, step.about, err)
+		}
+		if !reflect.DeepEqual(jobs.actions, step.actions) {
+			t.Errorf("%s %s: expected actions %v, got %v", testName, step.about, step.actions, jobs.actions)
+		}
+		if found := cluster.GetLogicalBackupJob() != nil; found != step.jobFound {
+			t.Errorf("%s %s: expected the job to be present: %t, got %t", testName, step.about, step.jobFound, found)
+		}
+	}
+}
+
+func TestTakeBaseBackup(t *testing.T) {
+	testName := "TestTakeBaseBackup"
+	tests := []struct {
+		about     string
+		opBucket  string
+		backup    *spec.Backup
+		execErr   error
+		executed  bool
+		errPrefix string
+	}{
+		{about: "operator bucket", opBucket: "wal-bucket", executed: true},
+		{about: "manifest bucket", backup: &spec.Backup{S3Bucket: "team-bucket"}, executed: true},
+		{about: "no bucket", errPrefix: "no WAL bucket is configured"},
+		{about: "failing script", opBucket: "wal-bucket", execErr: fmt.Errorf("exit code 1"), executed: true,
+			errPrefix: "could not take base backup: exit code 1"},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{WALES3Bucket: tt.opBucket}}, k8sutil.KubernetesClient{},
+			spec.Postgresql{Spec: spec.PostgresSpec{Backup: tt.backup}}, logger)
+		executed := false
+		err := cluster.takeBaseBackup(&cluster.Spec, func(cmd string) (string, error) {
+			executed = true
+			if cmd != "envdir /home/postgres/etc/wal-e.d/env /scripts/postgres_backup.sh /home/postgres/pgdata/pgroot/data" {
+				t.Errorf("%s %s: unexpected command %q", testName, tt.about, cmd)
+			}
+			return "", tt.execErr
+		})
+		if executed != tt.executed {
+			t.Errorf("%s %s: expected the backup script to run: %t, got %t", testName, tt.about, tt.executed, executed)
+		}
+		if tt.errPrefix == "" && err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, tt.about, err)
+		}
+		if tt.errPrefix != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.errPrefix)) {
+			t.Errorf("%s %s: expected error %q, got %v", testName, tt.about, tt.errPrefix, err)
+		}
+	}
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func TestBackupPruneCommand(t *testing.T) {
+	now := time.Date(2018, 5, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		backup  spec.Backup
+		walg    bool
+		command string
+		err     string
+	}{
+		{spec.Backup{Retention: int32Ptr(5)}, true,
+			"envdir /home/postgres/etc/wal-e.d/env wal-g delete retain FULL 5 --confirm", ""},
+		{spec.Backup{RetentionDays: int32Ptr(14)}, true,
+			"envdir /home/postgres/etc/wal-e.d/env wal-g delete before FIND_FULL 2018-05-01T12:00:00Z --confirm", ""},
+		{spec.Backup{Retention: int32Ptr(5), RetentionDays: int32Ptr(14)}, true,
+			"envdir /home/postgres/etc/wal-e.d/env wal-g delete retain FULL 5 --after 2018-05-01T12:00:00Z --confirm", ""},
+		{spec.Backup{Retention: int32Ptr(5)}, false,
+			"envdir /home/postgres/etc/wal-e.d/env wal-e delete --confirm retain 5", ""},
+		{spec.Backup{Retention: int32Ptr(5), RetentionDays: int32Ptr(14)}, false, "",
+			"the retention days need the base backups taken by WAL-G, which USE_WALG_BACKUP or the delta backups enable"},
+	}
+	for _, tt := range tests {
+		command, err := backupPruneCommand(&tt.backup, now, tt.walg)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("TestBackupPruneCommand: expected error %q, got %v", tt.err, err)
+			}
+			continue
+		}
+		if err != nil || command != tt.command {
+			t.Errorf("TestBackupPruneCommand: expected %q, got %q, error: %v", tt.command, command, err)
+		}
+	}
+}
+
+func TestUsesWALG(t *testing.T) {
+	tests := []struct {
+		about string
+		env   []v1.EnvVar
+		walg  bool
+	}{
+		{"WAL-E by default", nil, false},
+		{"WAL-G enabled", []v1.EnvVar{{Name: "USE_WALG_BACKUP", Value: "true"}}, true},
+		{"last value wins", []v1.EnvVar{{Name: "USE_WALG_BACKUP", Value: "true"},
+			{Name: "USE_WALG_BACKUP", Value: "false"}}, false},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+		cluster.Statefulset = &v1beta1.StatefulSet{Spec: v1beta1.StatefulSetSpec{Template: v1.PodTemplateSpec{
+			Spec: v1.PodSpec{Containers: []v1.Container{{Name: constants.PostgresContainerName, Env: tt.env}}}}}}
+		if walg := cluster.usesWALG(); walg != tt.walg {
+			t.Errorf("TestUsesWALG %s: expected %t, got %t", tt.about, tt.walg, walg)
+		}
+	}
+}
+
+func TestBackupPruneDue(t *testing.T) {
+	now := time.Date(2018, 5, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		about     string
+		backup    *spec.Backup
+		lastPrune time.Time
+		due       bool
+	}{
+		{"no backup section", nil, time.Time{}, false},
+		{"no retention", &spec.Backup{S3Bucket: "team-bucket"}, time.Time{}, false},
+		{"never pruned", &spec.Backup{Retention: int32Ptr(5)}, time.Time{}, true},
+		{"pruned recently", &spec.Backup{RetentionDays: int32Ptr(14)}, now.Add(-time.Hour), false},
+		{"pruned a day ago", &spec.Backup{RetentionDays: int32Ptr(14)}, now.Add(-24 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{BackupPruneInterval: 24 * time.Hour}}, k8sutil.KubernetesClient{},
+			spec.Postgresql{Spec: spec.PostgresSpec{Backup: tt.backup}}, logger)
+		cluster.lastBackupPrune = tt.lastPrune
+		if due := cluster.backupPruneDue(now); due != tt.due {
+			t.Errorf("TestBackupPruneDue %s: expected %t, got %t", tt.about, tt.due, due)
+		}
+	}
+}
+
+func TestParseLastBackupTime(t *testing.T) {
+	tests := []struct {
+		about    string
+		out      string
+		expected string
+		err      string
+	}{
+		{"empty archive", "name last_modified wal_segment_backup_start\n", "", ""},
+		{"newest backup listed last",
+			"name last_modified wal_segment_backup_start\n" +
+				"base_000000010000000000000002 2018-10-14T02:30:11Z 000000010000000000000002\n" +
+				"base_000000010000000000000009 2018-10-15T02:30:42Z 000000010000000000000009\n",
+			"2018-10-15T02:30:42Z", ""},
+		{"newest backup listed first",
+			"name last_modified wal_segment_backup_start\n" +
+				"base_000000010000000000000009 2018-10-15T02:30:42Z 000000010000000000000009\n" +
+				"base_000000010000000000000002 2018-10-14T02:30:11Z 000000010000000000000002\n",
+			"2018-10-15T02:30:42Z", ""},
+		{"WAL-E listing",
+			"name\tlast_modified\texpanded_size_bytes\twal_segment_backup_start\twal_segment_offset_backup_start\n" +
+				"base_000000010000000000000009_00000040\t2018-10-15T02:30:42.000Z\t\t000000010000000000000009\t00000040\n",
+			"2018-10-15T02:30:42Z", ""},
+		{"unexpected output", "base_000000010000000000000002 yesterday 000000010000000000000002", "",
+			`could not parse time of the base backup "base_000000010000000000000002": parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`},
+	}
+	for _, tt := range tests {
+		last, err := parseLastBackupTime(tt.out)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("TestParseLastBackupTime %s: expected error %q, got %v", tt.about, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("TestParseLastBackupTime %s: unexpected error: %v", tt.about, err)
+			continue
+		}
+		result := ""
+		if last != nil {
+			result = last.UTC().Format(time.RFC3339)
+		}
+		if result != tt.expected {
+			t.Errorf("TestParseLastBackupTime %s: expected %q, got %q", tt.about, tt.expected, result)
+		}
+	}
+}
+
+func TestReadWALArchiveLag(t *testing.T) {
+	db, _ := openFakeDB(t, "wal-archive-lag", []driver.Value{int64(42)})
+	if lag, err := readWALArchiveLag(db); err != nil || lag == nil || *lag != 42 {
+		t.Errorf("TestReadWALArchiveLag: expected a lag of 42 seconds, got %v, error: %v", lag, err)
+	}
+
+	db, _ = openFakeDB(t, "wal-archive-lag-nothing-archived")
+	if lag, err := readWALArchiveLag(db); err != nil || lag != nil {
+		t.Errorf("TestReadWALArchiveLag: expected no lag before the first archived segment, got %v, error: %v", lag, err)
+	}
+}
+
+func TestWALVerifyFailures(t *testing.T) {
+	tests := []struct {
+		about    string
+		out      string
+		failures []string
+	}{
+		{"archive restorable",
+			"[wal-verify] integrity check status: OK\n[wal-verify] integrity check details:\n" +
+				"+-----+--------------------------+--------------------------+----------------+--------+\n" +
+				"[wal-verify] timeline check status: OK\n[wal-verify] timeline check details:\n",
+			nil},
+		{"segments not uploaded yet",
+			"[wal-verify] integrity check status: WARNING\n[wal-verify] timeline check status: OK\n",
+			nil},
+		{"missing segments",
+			"[wal-verify] integrity check status: FAILURE\n[wal-verify] timeline check status: OK\n",
+			[]string{"integrity"}},
+		{"missing segments and timeline history",
+			"[wal-verify] integrity check status: FAILURE\n[wal-verify] timeline check status: FAILURE\n",
+			[]string{"integrity", "timeline"}},
+	}
+	for _, tt := range tests {
+		if failures := walVerifyFailures(tt.out); !reflect.DeepEqual(failures, tt.failures) {
+			t.Errorf("TestWALVerifyFailures %s: expected %v, got %v", tt.about, tt.failures, failures)
+		}
+	}
+}
+
+func TestWALVerifyDue(t *testing.T) {
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	verified, failed := true, false
+	tests := []struct {
+		about      string
+		backup     *spec.Backup
+		lastVerify time.Time
+		verified   *bool
+		due        bool
+		degraded   bool
+	}{
+		{"no backup section", nil, time.Time{}, nil, false, false},
+		{"verification disabled", &spec.Backup{}, time.Time{}, &failed, false, false},
+		{"never verified", &spec.Backup{VerifyWAL: true}, time.Time{}, nil, true, false},
+		{"verified recently", &spec.Backup{VerifyWAL: true}, now.Add(-time.Hour), &verified, false, false},
+		{"failed a day ago", &spec.Backup{VerifyWAL: true}, now.Add(-24 * time.Hour), &failed, true, true},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{WALVerifyInterval: 24 * time.Hour}}, k8sutil.KubernetesClient{},
+			spec.Postgresql{Spec: spec.PostgresSpec{Backup: tt.backup}}, logger)
+		cluster.lastWALVerify = tt.lastVerify
+		cluster.Status.WALVerified = tt.verified
+		if due := cluster.walVerifyDue(now); due != tt.due {
+			t.Errorf("TestWALVerifyDue %s: expected due %t, got %t", tt.about, tt.due, due)
+		}
+		if degraded := cluster.walVerifyFailed(); degraded != tt.degraded {
+			t.Errorf("TestWALVerifyDue %s: expected failed %t, got %t", tt.about, tt.degraded, degraded)
+		}
+	}
+}
+
+func TestShouldTakeFinalBackup(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		about    string
+		operator bool
+		spec     spec.PostgresSpec
+		expected bool
+	}{
+		{"disabled by default", false, spec.PostgresSpec{NumberOfInstances: 2}, false},
+		{"enabled in the operator", true, spec.PostgresSpec{NumberOfInstances: 2}, true},
+		{"enabled in the manifest", false, spec.PostgresSpec{NumberOfInstances: 2, FinalBackupOnDelete: &enabled}, true},
+		{"disabled in the manifest", true, spec.PostgresSpec{NumberOfInstances: 2, FinalBackupOnDelete: &disabled}, false},
+		{"no pods to back up", true, spec.PostgresSpec{NumberOfInstances: 0}, false},
+		{"standby cluster", true, spec.PostgresSpec{NumberOfInstances: 2,
+			StandbyCluster: &spec.StandbyDescription{StandbyHost: "acid-source"}}, false},
+	}
+	for _, tt := range tests {
+		cluster := New(
+			Config{OpConfig: config.Config{FinalBackupOnDelete: tt.operator,
+				Resources: config.Resources{MinInstances: -1, MaxInstances: -1}}},
+			k8sutil.KubernetesClient{}, spec.Postgresql{Spec: tt.spec}, logger)
+		if result := cluster.shouldTakeFinalBackup(); result != tt.expected {
+			t.Errorf("TestShouldTakeFinalBackup %s: expected %t, got %t", tt.about, tt.expected, result)
+		}
+	}
+}
+
+func TestDeleteWithoutFinalBackup(t *testing.T) {
+	res := &fakeCloneResources{pods: []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "acid-test-1", Namespace: "default",
+		Labels: map[string]string{"cluster-name": "acid-test", "spilo-role": "replica"}}}}}
+	cluster := New(
+		Config{OpConfig: config.Config{FinalBackupOnDelete: true,
+			Resources: config.Resources{ClusterNameLabel: "cluster-name", PodRoleLabel: "spilo-role",
+				MinInstances: -1, MaxInstances: -1}}},
+		k8sutil.KubernetesClient{StatefulSetsGetter: res, PodsGetter: res, PersistentVolumeClaimsGetter: res},
+		spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+			Spec: spec.PostgresSpec{NumberOfInstances: 2}}, logger)
+	res.cluster = cluster
+	cluster.Statefulset = &v1beta1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"}}
+
+	expected := "could not take final base backup, keeping the resources of the cluster: no master pod is running in the cluster"
+	if err := cluster.Delete(); err == nil || err.Error() != expected {
+		t.Errorf("TestDeleteWithoutFinalBackup: expected error %q, got %v", expected, err)
+	}
+	if len(res.deleted) > 0 {
+		t.Errorf("TestDeleteWithoutFinalBackup: expected no resources to be deleted, got %v", res.deleted)
+	}
+}
+
+func TestPromoteStandbyCluster(t *testing.T) {
+	testName := "TestPromoteStandbyCluster"
+	pod := func(name, role string) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+			Labels: map[string]string{"cluster-name": "acid-test", "spilo-role": role}}}
+	}
+	tests := []struct {
+		about        string
+		pods         []v1.Pod
+		promotedRole string
+		patroni      []string
+		err          string
+	}{
+		{
+			about:        "standby leader promoted",
+			pods:         []v1.Pod{pod("acid-test-0", "master"), pod("acid-test-1", "replica")},
+			promotedRole: "master",
+			patroni:      []string{"promote on acid-test-0"},
+		},
+		{
+			about:        "standby leader not promoted in time",
+			pods:         []v1.Pod{pod("acid-test-0", "master")},
+			promotedRole: "standby_leader",
+			patroni:      []string{"promote on acid-test-0"},
+			err:          `standby leader "acid-test-0" has not been promoted: still failing after 100 retries`,
+		},
+		{
+			about: "no standby leader",
+			pods:  []v1.Pod{pod("acid-test-1", "replica")},
+			err:   "no standby leader is running in the cluster",
+		},
+	}
+	for _, tt := range tests {
+		res := &fakeCloneResources{pods: tt.pods}
+		patroniClient := &fakePatroni{role: "standby_leader", promotedRole: tt.promotedRole}
+		cluster := New(
+			Config{OpConfig: config.Config{
+				Resources: config.Resources{ClusterNameLabel: "cluster-name", PodRoleLabel: "spilo-role",
+					ResourceCheckInterval: time.Millisecond, ResourceCheckTimeout: 100 * time.Millisecond}}},
+			k8sutil.KubernetesClient{PodsGetter: res},
+			spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"}}, logger)
+		res.cluster = cluster
+		cluster.patroni = patroniClient
+
+		err := cluster.promoteStandbyCluster()
+		if tt.err == "" && err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, tt.about, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s %s: expected error %q, got %v", testName, tt.about, tt.err, err)
+		}
+		if !reflect.DeepEqual(patroniClient.disruptiveCalls, tt.patroni) {
+			t.Errorf("%s %s: expected the patroni calls %v, got %v", testName, tt.about, tt.patroni, patroniClient.disruptiveCalls)
+		}
+	}
+}
+
+func TestCompareLogicalBackupJob(t *testing.T) {
+	testName := "TestCompareLogicalBackupJob"
+	cluster := New(Config{OpConfig: config.Config{LogicalBackupSchedule: "30 00 * * *",
+		LogicalBackupDockerImage: "logical-backup:1"}},
+		k8sutil.KubernetesClient{}, spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"}},
+		logger)
+	tests := []struct {
+		about  string
+		modify func(job *batchv2alpha1api.CronJob)
+		match  bool
+	}{
+		{
+			about:  "same job",
+			modify: func(job *batchv2alpha1api.CronJob) {},
+			match:  true,
+		},
+		{
+			about: "defaults filled in by the API server",
+			modify: func(job *batchv2alpha1api.CronJob) {
+				limit := int32(3)
+				job.Spec.SuccessfulJobsHistoryLimit = &limit
+				job.Spec.JobTemplate.Spec.Template.Spec.DNSPolicy = v1.DNSClusterFirst
+				job.Spec.JobTemplate.Spec.Template.Spec.Containers[0].TerminationMessagePath = "/dev/termination-log"
+			},
+			match: true,
+		},
+		{
+			about:  "schedule changed",
+			modify: func(job *batchv2alpha1api.CronJob) { job.Spec.Schedule = "00 01 * * *" },
+		},
+		{
+			about: "image changed",
+			modify: func(job *batchv2alpha1api.CronJob) {
+				job.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Image = "logical-backup:2"
+			},
+		},
+	}
+	for _, tt := range tests {
+		cur, new := cluster.generateLogicalBackupJob(), cluster.generateLogicalBackupJob()
+		tt.modify(cur)
+		if match, reason := compareLogicalBackupJob(cur, new); match != tt.match {
+			t.Errorf("%s %s: expected match %t, got %t: %s", testName, tt.about, tt.match, match, reason)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/awssecrets"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/vault"
+)
+
+// credentialsStore keeps a copy of the credentials of the roles outside of Kubernetes, for the applications that
+// cannot read the secrets. The secrets stay authoritative, the store is written after them.
+type credentialsStore interface {
+	// String names the store in the messages.
+	String() string
+	// syncCredentials writes the credentials of the users of the cluster to the store.
+	syncCredentials() error
+	// writeCredentials stores the new credentials of the role.
+	writeCredentials(role, username, password string) error
+	// deleteCredentials removes the credentials of the role.
+	deleteCredentials(role string) error
+}
+
+// newCredentialsStore returns the store configured for the operator, nil when the credentials are kept in the
+// secrets only.
+func newCredentialsStore(c *Cluster, cfg *config.Config) credentialsStore {
+	switch cfg.CredentialsStore {
+	case config.CredentialsStoreVault:
+		return &vaultStore{cluster: c, client: vault.NewVaultAPI(cfg.VaultAddress, cfg.VaultKVVersion, c.logger)}
+	case config.CredentialsStoreAWSSecretsManager:
+		return &awsSecretsStore{cluster: c, client: awssecrets.NewSecretsManagerAPI(cfg.AWSRegion, cfg.AWSRoleARN,
+			cfg.AWSProxy, cfg.AWSSecretsManagerKMSKeyID, cfg.AWSSecretsManagerRecoveryWindowDays, c.logger)}
+	}
+	return nil
+}
+
+// vaultStore keeps the credentials under the paths of a key/value secrets engine of Vault.
+type vaultStore struct {
+	cluster *Cluster
+	client  vault.Interface
+}
+
+// awsSecretsStore keeps the credentials in the secrets of AWS Secrets Manager.
+type awsSecretsStore struct {
+	cluster *Cluster
+	client  awssecrets.Interface
+}
+
+func (s *vaultStore) String() string {
+	return "Vault"
+}
+
+func (s *awsSecretsStore) String() string {
+	return "AWS Secrets Manager"
+}
+
+// vaultCredentialsPath returns the path the credentials of the role are stored under in Vault.
+func (c *Cluster) vaultCredentialsPath(username string) string {
+	return c.OpConfig.VaultPathTemplate.Format(
+		"namespace", c.Namespace,
+		"cluster", c.Name,
+		"username", username)
+}
+
+// vaultToken reads the token the operator authenticates to Vault with from the secret of the operator.
+func (c *Cluster) vaultToken() (string, error) {
+	name := c.OpConfig.VaultTokenSecretName
+	secret, err := c.KubeClient.Secrets(name.Namespace).Get(name.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get Vault token secret %q: %v", name, err)
+	}
+	token := string(secret.Data["token"])
+	if token == "" {
+		return "", fmt.Errorf("no token key in the Vault token secret %q", name)
+	}
+	return token, nil
+}
+
+// vaultOnlyUser tells whether the credentials of the user are kept in Vault instead of a secret.
+func (c *Cluster) vaultOnlyUser(user spec.PgUser) bool {
+	_, vaultUsed := c.credentialsStore.(*vaultStore)
+	return vaultUsed && c.OpConfig.VaultReplaceUserSecrets && user.Origin == spec.RoleOriginManifest
+}
+
+// storedExternally tells whether the credentials of the user go to the credentials store: the ones of the system users
+// and of the manifest users, unless their passwords come from the secrets provisioned outside of the operator.
+func (c *Cluster) storedExternally(user spec.PgUser) bool {
+	if user.Password == "" {
+		return false
+	}
+	switch user.Origin {
+	case spec.RoleOriginSystem:
+		return true
+	case spec.RoleOriginManifest:
+		_, provisioned := c.Spec.UserSecrets[user.Name]
+		return !provisioned
+	}
+	return false
+}
+
+// syncCredentials writes the credentials of the users to Vault. It runs once the passwords have been read from the
+// secrets, which stay authoritative; the users kept in Vault only take their passwords from there instead, the
+// generated ones are written for the new users.
+func (s *vaultStore) syncCredentials() error {
+	c := s.cluster
+	token, err := c.vaultToken()
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, userMap := range []map[string]spec.PgUser{c.systemUsers, c.pgUsers} {
+		for key, user := range userMap {
+			if !c.storedExternally(user) {
+				continue
+			}
+			path := c.vaultCredentialsPath(user.Name)
+			username, password, found, err := s.client.ReadCredentials(path, token)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("could not read credentials of role %q: %v", user.Name, err))
+				continue
+			}
+			if found && c.vaultOnlyUser(user) {
+				user.Password = password
+				userMap[key] = user
+				continue
+			}
+			// the credentials of a rotation role are written by the rotation
+			if found && password == user.Password && (username == user.Name || isRotationUserOf(username, user.Name)) {
+				continue
+			}
+			if err := s.client.WriteCredentials(path, token, user.Name, user.Password); err != nil {
+				failures = append(failures, fmt.Sprintf("could not write credentials of role %q: %v", user.Name, err))
+				continue
+			}
+			c.logger.Debugf("credentials of role %q have been written to Vault at %q", user.Name, path)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+func (s *vaultStore) writeCredentials(role, username, password string) error {
+	token, err := s.cluster.vaultToken()
+	if err != nil {
+		return err
+	}
+	return s.client.WriteCredentials(s.cluster.vaultCredentialsPath(role), token, username, password)
+}
+
+func (s *vaultStore) deleteCredentials(role string) error {
+	token, err := s.cluster.vaultToken()
+	if err != nil {
+		return err
+	}
+	return s.client.DeleteCredentials(s.cluster.vaultCredentialsPath(role), token)
+}
+
+// awsSecretName returns the name of the secret of AWS Secrets Manager holding the credentials of the role.
+func (c *Cluster) awsSecretName(username string) string {
+	return c.OpConfig.AWSSecretsManagerNameTemplate.Format(
+		"namespace", c.Namespace,
+		"cluster", c.Name,
+		"username", username)
+}
+
+// awsSecretTags returns the tags of the secret of AWS Secrets Manager holding the credentials of the role, telling
+// the cluster and the team it belongs to.
+func (c *Cluster) awsSecretTags(role string) map[string]string {
+	return map[string]string{
+		"cluster":   c.Name,
+		"namespace": c.Namespace,
+		"team":      c.teamName(),
+		"role":      role,
+	}
+}
+
+// awsCredentials returns the credentials of the role for AWS Secrets Manager. The applications reading them run
+// outside of Kubernetes, so the host is the DNS name of the load balancer of the master, if any.
+func (c *Cluster) awsCredentials(role, username, password string) awssecrets.Credentials {
+	host := fmt.Sprintf("%s.%s.svc.cluster.local", c.serviceName(Master), c.Namespace)
+	if c.shouldCreateLoadBalancerForService(Master, &c.Spec) {
+		host = c.masterDNSName()
+	}
+	return awssecrets.Credentials{
+		Engine:   "postgres",
+		Host:     host,
+		Port:     5432,
+		Username: username,
+		Password: password,
+		DBName:   c.roleDatabase(role),
+	}
+}
+
+// syncCredentials writes the credentials of the users to AWS Secrets Manager, unless they are stored there already.
+// The secrets are authoritative, a rotation role found in AWS Secrets Manager is kept as long as its password matches.
+func (s *awsSecretsStore) syncCredentials() error {
+	c := s.cluster
+	var failures []string
+	for _, userMap := range []map[string]spec.PgUser{c.systemUsers, c.pgUsers} {
+		for _, user := range userMap {
+			if !c.storedExternally(user) {
+				continue
+			}
+			name := c.awsSecretName(user.Name)
+			stored, err := s.client.ReadCredentials(name)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("could not read credentials of role %q: %v", user.Name, err))
+				continue
+			}
+			credentials := c.awsCredentials(user.Name, user.Name, user.Password)
+			if stored != nil && isRotationUserOf(stored.Username, user.Name) {
+				credentials.Username = stored.Username
+			}
+			if stored != nil && *stored == credentials {
+				continue
+			}
+			if err := s.client.WriteCredentials(name, credentials, c.awsSecretTags(user.Name)); err != nil {
+				failures = append(failures, fmt.Sprintf("could not write credentials of role %q: %v", user.Name, err))
+				continue
+			}
+			c.logger.Debugf("credentials of role %q have been written to AWS Secrets Manager as %q", user.Name, name)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+func (s *awsSecretsStore) writeCredentials(role, username, password string) error {
+	c := s.cluster
+	return s.client.WriteCredentials(c.awsSecretName(role), c.awsCredentials(role, username, password),
+		c.awsSecretTags(role))
+}
+
+func (s *awsSecretsStore) deleteCredentials(role string) error {
+	return s.client.DeleteCredentials(s.cluster.awsSecretName(role))
+}
+
+// syncStoredCredentials writes the credentials of the users to the credentials store, if any.
+func (c *Cluster) syncStoredCredentials() error {
+	if c.credentialsStore == nil {
+		return nil
+	}
+	c.setProcessName(fmt.Sprintf("syncing credentials in %s", c.credentialsStore))
+	if err := c.credentialsStore.syncCredentials(); err != nil {
+		return fmt.Errorf("could not sync credentials in %s: %v", c.credentialsStore, err)
+	}
+	return nil
+}
+
+// writeStoredCredentials stores the new credentials of the role in the credentials store, if any.
+func (c *Cluster) writeStoredCredentials(role, username, password string) error {
+	if c.credentialsStore == nil {
+		return nil
+	}
+	if err := c.credentialsStore.writeCredentials(role, username, password); err != nil {
+		return fmt.Errorf("could not write credentials of role %q to %s: %v", role, c.credentialsStore, err)
+	}
+	return nil
+}
+
+// deleteStoredCredentials removes the credentials of the role from the credentials store, if any.
+func (c *Cluster) deleteStoredCredentials(role string) error {
+	if c.credentialsStore == nil {
+		return nil
+	}
+	if err := c.credentialsStore.deleteCredentials(role); err != nil {
+		return fmt.Errorf("could not delete credentials of role %q from %s: %v", role, c.credentialsStore, err)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/awssecrets"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+// fakeVaultClient keeps the credentials in memory, keyed by path, and counts the writes.
+type fakeVaultClient struct {
+	credentials map[string][2]string
+	writes      int
+}
+
+func (v *fakeVaultClient) ReadCredentials(path, token string) (string, string, bool, error) {
+	if token != "s.token" {
+		return "", "", false, fmt.Errorf("permission denied")
+	}
+	credentials, ok := v.credentials[path]
+	return credentials[0], credentials[1], ok, nil
+}
+
+func (v *fakeVaultClient) WriteCredentials(path, token, username, password string) error {
+	v.writes++
+	v.credentials[path] = [2]string{username, password}
+	return nil
+}
+
+func (v *fakeVaultClient) DeleteCredentials(path, token string) error {
+	delete(v.credentials, path)
+	return nil
+}
+
+// fakeTokenSecrets holds the Vault token secret of the operator.
+type fakeTokenSecrets struct {
+	v1core.SecretInterface
+}
+
+func (s *fakeTokenSecrets) Secrets(namespace string) v1core.SecretInterface {
+	return s
+}
+
+func (s *fakeTokenSecrets) Get(name string, options metav1.GetOptions) (*v1.Secret, error) {
+	return &v1.Secret{Data: map[string][]byte{"token": []byte("s.token")}}, nil
+}
+
+func TestSyncVaultCredentials(t *testing.T) {
+	testName := "TestSyncVaultCredentials"
+	tests := []struct {
+		about   string
+		replace bool
+		stored  map[string][2]string
+		// expected credentials in Vault and passwords of the users afterwards
+		expected  map[string][2]string
+		passwords map[string]string
+		writes    int
+	}{
+		{
+			about:  "new cluster",
+			stored: map[string][2]string{},
+			expected: map[string][2]string{
+				"postgres/test/acid-test/postgres": {"postgres", "superpass"},
+				"postgres/test/acid-test/app":      {"app", "secret"},
+			},
+			passwords: map[string]string{"app": "secret"},
+			writes:    2,
+		},
+		{
+			about: "changed password",
+			stored: map[string][2]string{
+				"postgres/test/acid-test/postgres": {"postgres", "superpass"},
+				"postgres/test/acid-test/app":      {"app", "old"},
+			},
+			expected: map[string][2]string{
+				"postgres/test/acid-test/postgres": {"postgres", "superpass"},
+				"postgres/test/acid-test/app":      {"app", "secret"},
+			},
+			passwords: map[string]string{"app": "secret"},
+			writes:    1,
+		},
+		{
+			about: "rotation role",
+			stored: map[string][2]string{
+				"postgres/test/acid-test/postgres": {"postgres", "superpass"},
+				"postgres/test/acid-test/app":      {"app_181015", "secret"},
+			},
+			expected: map[string][2]string{
+				"postgres/test/acid-test/postgres": {"postgres", "superpass"},
+				"postgres/test/acid-test/app":      {"app_181015", "secret"},
+			},
+			passwords: map[string]string{"app": "secret"},
+			writes:    0,
+		},
+		{
+			about:   "vault only",
+			replace: true,
+			stored: map[string][2]string{
+				"postgres/test/acid-test/postgres": {"postgres", "superpass"},
+				"postgres/test/acid-test/app":      {"app", "stored"},
+			},
+			expected: map[string][2]string{
+				"postgres/test/acid-test/postgres": {"postgres", "superpass"},
+				"postgres/test/acid-test/app":      {"app", "stored"},
+			},
+			passwords: map[string]string{"app": "stored"},
+			writes:    0,
+		},
+	}
+	for _, tt := range tests {
+		client := &fakeVaultClient{credentials: tt.stored}
+		cluster := New(Config{OpConfig: config.Config{
+			VaultPathTemplate:       "postgres/{namespace}/{cluster}/{username}",
+			VaultReplaceUserSecrets: tt.replace,
+		}}, k8sutil.KubernetesClient{SecretsGetter: &fakeTokenSecrets{}}, spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"},
+			Spec:       spec.PostgresSpec{UserSecrets: map[string]spec.UserSecret{"provisioned": {Name: "external"}}},
+		}, logger)
+		cluster.credentialsStore = &vaultStore{cluster: cluster, client: client}
+		cluster.systemUsers["superuser"] = spec.PgUser{Name: "postgres", Password: "superpass", Origin: spec.RoleOriginSystem}
+		cluster.pgUsers = map[string]spec.PgUser{
+			"app":         {Name: "app", Password: "secret", Origin: spec.RoleOriginManifest},
+			"provisioned": {Name: "provisioned", Password: "external", Origin: spec.RoleOriginManifest},
+			"robot":       {Name: "robot", Password: "infra", Origin: spec.RoleOriginInfrastructure},
+		}
+
+		if err := cluster.credentialsStore.syncCredentials(); err != nil {
+			t.Fatalf("%s %s: could not sync credentials: %v", testName, tt.about, err)
+		}
+		if len(client.credentials) != len(tt.expected) {
+			t.Errorf("%s %s: expected credentials %v, got %v", testName, tt.about, tt.expected, client.credentials)
+		}
+		for path, credentials := range tt.expected {
+			if client.credentials[path] != credentials {
+				t.Errorf("%s %s: expected %v at %q, got %v", testName, tt.about, credentials, path,
+					client.credentials[path])
+			}
+		}
+		for name, password := range tt.passwords {
+			if cluster.pgUsers[name].Password != password {
+				t.Errorf("%s %s: expected password %q of %q, got %q", testName, tt.about, password, name,
+					cluster.pgUsers[name].Password)
+			}
+		}
+		if client.writes != tt.writes {
+			t.Errorf("%s %s: expected %d writes, got %d", testName, tt.about, tt.writes, client.writes)
+		}
+		if secrets := cluster.generateUserSecrets(); tt.replace == (secrets["app"] != nil) {
+			t.Errorf("%s %s: unexpected secret of the manifest user %v", testName, tt.about, secrets["app"])
+		}
+	}
+}
+
+// fakeAWSSecretsClient keeps the credentials and the tags in memory, keyed by the name of the secret.
+type fakeAWSSecretsClient struct {
+	credentials map[string]awssecrets.Credentials
+	tags        map[string]map[string]string
+	writes      int
+}
+
+func (a *fakeAWSSecretsClient) ReadCredentials(name string) (*awssecrets.Credentials, error) {
+	credentials, ok := a.credentials[name]
+	if !ok {
+		return nil, nil
+	}
+	return &credentials, nil
+}
+
+func (a *fakeAWSSecretsClient) WriteCredentials(name string, credentials awssecrets.Credentials, tags map[string]string) error {
+	a.writes++
+	a.credentials[name] = credentials
+	a.tags[name] = tags
+	return nil
+}
+
+func (a *fakeAWSSecretsClient) DeleteCredentials(name string) error {
+	delete(a.credentials, name)
+	return nil
+}
+
+func TestSyncAWSCredentials(t *testing.T) {
+	testName := "TestSyncAWSCredentials"
+	credentials := func(username, password, dbname string) awssecrets.Credentials {
+		return awssecrets.Credentials{Engine: "postgres", Host: "acid-test.test.svc.cluster.local", Port: 5432,
+			Username: username, Password: password, DBName: dbname}
+	}
+	tests := []struct {
+		about    string
+		stored   map[string]awssecrets.Credentials
+		expected map[string]awssecrets.Credentials
+		writes   int
+	}{
+		{
+			about:  "new cluster",
+			stored: map[string]awssecrets.Credentials{},
+			expected: map[string]awssecrets.Credentials{
+				"postgres/test/acid-test/postgres": credentials("postgres", "superpass", "postgres"),
+				"postgres/test/acid-test/app":      credentials("app", "secret", "appdb"),
+			},
+			writes: 2,
+		},
+		{
+			about: "changed password",
+			stored: map[string]awssecrets.Credentials{
+				"postgres/test/acid-test/postgres": credentials("postgres", "superpass", "postgres"),
+				"postgres/test/acid-test/app":      credentials("app", "old", "appdb"),
+			},
+			expected: map[string]awssecrets.Credentials{
+				"postgres/test/acid-test/postgres": credentials("postgres", "superpass", "postgres"),
+				"postgres/test/acid-test/app":      credentials("app", "secret", "appdb"),
+			},
+			writes: 1,
+		},
+		{
+			about: "rotation role",
+			stored: map[string]awssecrets.Credentials{
+				"postgres/test/acid-test/postgres": credentials("postgres", "superpass", "postgres"),
+				"postgres/test/acid-test/app":      credentials("app_181015", "secret", "appdb"),
+			},
+			expected: map[string]awssecrets.Credentials{
+				"postgres/test/acid-test/postgres": credentials("postgres", "superpass", "postgres"),
+				"postgres/test/acid-test/app":      credentials("app_181015", "secret", "appdb"),
+			},
+			writes: 0,
+		},
+	}
+	for _, tt := range tests {
+		client := &fakeAWSSecretsClient{credentials: tt.stored, tags: make(map[string]map[string]string)}
+		cluster := New(Config{OpConfig: config.Config{
+			AWSSecretsManagerNameTemplate: "postgres/{namespace}/{cluster}/{username}",
+		}}, k8sutil.KubernetesClient{}, spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"},
+			Spec: spec.PostgresSpec{
+				TeamID:      "acid",
+				Databases:   map[string]string{"appdb": "app"},
+				UserSecrets: map[string]spec.UserSecret{"provisioned": {Name: "external"}},
+			},
+		}, logger)
+		cluster.credentialsStore = &awsSecretsStore{cluster: cluster, client: client}
+		cluster.systemUsers["superuser"] = spec.PgUser{Name: "postgres", Password: "superpass", Origin: spec.RoleOriginSystem}
+		cluster.pgUsers = map[string]spec.PgUser{
+			"app":         {Name: "app", Password: "secret", Origin: spec.RoleOriginManifest},
+			"provisioned": {Name: "provisioned", Password: "external", Origin: spec.RoleOriginManifest},
+			"robot":       {Name: "robot", Password: "infra", Origin: spec.RoleOriginInfrastructure},
+		}
+
+		if err := cluster.credentialsStore.syncCredentials(); err != nil {
+			t.Fatalf("%s %s: could not sync credentials: %v", testName, tt.about, err)
+		}
+		if len(client.credentials) != len(tt.expected) {
+			t.Errorf("%s %s: expected credentials %v, got %v", testName, tt.about, tt.expected, client.credentials)
+		}
+		for name, credentials := range tt.expected {
+			if client.credentials[name] != credentials {
+				t.Errorf("%s %s: expected %#v in %q, got %#v", testName, tt.about, credentials, name,
+					client.credentials[name])
+			}
+		}
+		if client.writes != tt.writes {
+			t.Errorf("%s %s: expected %d writes, got %d", testName, tt.about, tt.writes, client.writes)
+		}
+		for name, tags := range client.tags {
+			if tags["cluster"] != "acid-test" || tags["namespace"] != "test" || tags["team"] != "acid" {
+				t.Errorf("%s %s: unexpected tags of %q: %v", testName, tt.about, name, tags)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeDriver answers every query with the configured rows and records the executed statements and queries.
+type fakeDriver struct {
+	mu       sync.Mutex
+	rows     [][]driver.Value
+	executed []string
+	queried  []string
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+}
+
+type fakeStmt struct {
+	driver *fakeDriver
+	query  string
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{driver: d}, nil
+}
+
+func (d *fakeDriver) executedStatements() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.executed...)
+}
+
+func (d *fakeDriver) executedQueries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.queried...)
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{driver: c.driver, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.driver.mu.Lock()
+	defer s.driver.mu.Unlock()
+	s.driver.executed = append(s.driver.executed, s.query)
+
+	return driver.RowsAffected(0), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.driver.mu.Lock()
+	defer s.driver.mu.Unlock()
+	s.driver.queried = append(s.driver.queried, s.query)
+
+	return &fakeRows{rows: s.driver.rows}, nil
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	columns := make([]string, len(r.rows[0]))
+	for i := range columns {
+		columns[i] = fmt.Sprintf("column%d", i)
+	}
+	return columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+// openFakeDB registers a fake driver under the given name, which must be unique within the test binary.
+func openFakeDB(t *testing.T, name string, rows ...[]driver.Value) (*sql.DB, *fakeDriver) {
+	d := &fakeDriver{rows: rows}
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("could not open the fake database: %v", err)
+	}
+
+	return db, d
+}
@@ -65,12 +65,15 @@ func (c *Cluster) ExecCommand(podName *spec.NamespacedName, command ...string) (
 		Stderr:             &execErr,
 	})
 
+	// the stream fails on a non-zero exit status, the commands may well write warnings to stderr when they succeed
 	if err != nil {
+		if execErr.Len() > 0 {
+			return "", fmt.Errorf("could not execute: %v, stderr: %v", err, execErr.String())
+		}
 		return "", fmt.Errorf("could not execute: %v", err)
 	}
-
 	if execErr.Len() > 0 {
-		return "", fmt.Errorf("stderr: %v", execErr.String())
+		c.logger.Debugf("command %q wrote to stderr: %v", strings.Join(command, " "), execErr.String())
 	}
 
 	return execOut.String(), nil
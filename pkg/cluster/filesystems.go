@@ -4,40 +4,258 @@ import (
 	"fmt"
 	"strings"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	batchv1 "k8s.io/client-go/pkg/apis/batch/v1"
+
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/filesystems"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/retryutil"
+)
+
+const (
+	// -P disables line wrapping of long device names in GNU df and is understood by BusyBox df as well
+	defaultFilesystemInfoCommand = "df -PT %s"
+	procMountsFile               = "/proc/mounts"
 )
 
-func (c *Cluster) getPostgresFilesystemInfo(podName *spec.NamespacedName) (device, fstype string, err error) {
-	out, err := c.ExecCommand(podName, "bash", "-c", fmt.Sprintf("df -T %s|tail -1", constants.PostgresDataMount))
+// filesystemInfoCommand returns the command that prints the device and the type of the postgres filesystem.
+func (c *Cluster) filesystemInfoCommand() string {
+	if c.OpConfig.FilesystemInfoCommand != "" {
+		return c.OpConfig.FilesystemInfoCommand.Format("mount", dataMountPath(&c.Spec.Volume))
+	}
+	return fmt.Sprintf(defaultFilesystemInfoCommand, dataMountPath(&c.Spec.Volume))
+}
+
+func (c *Cluster) getPostgresFilesystemInfo(commandExecutor func(cmd string) (string, error)) (device, fstype string,
+	err error) {
+	out, err := commandExecutor(c.filesystemInfoCommand())
+	if err == nil {
+		if device, fstype, err = parseDfOutput(out); err == nil {
+			return device, fstype, nil
+		}
+	}
+	// BusyBox df built without the "fancy" features does not know about -T
+	c.logger.Debugf("could not get postgres filesystem info from df, falling back to %s: %v", procMountsFile, err)
+
+	out, err = commandExecutor("cat " + procMountsFile)
 	if err != nil {
 		return "", "", err
 	}
-	fields := strings.Fields(out)
-	if len(fields) < 2 {
+
+	return parseProcMounts(out, dataMountPath(&c.Spec.Volume))
+}
+
+// parseDfOutput extracts the device and the filesystem type from the output of df -T. The positions
+// of the columns are taken from the header, since GNU and BusyBox df differ in their output.
+func parseDfOutput(out string) (device, fstype string, err error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 2 {
+		return "", "", fmt.Errorf("too few lines in the df output")
+	}
+
+	header := strings.Fields(lines[0])
+	typeColumn := -1
+	for i, name := range header {
+		if name == "Type" {
+			typeColumn = i
+			break
+		}
+	}
+	if typeColumn < 0 {
+		return "", "", fmt.Errorf("no filesystem type column in the df output")
+	}
+
+	// GNU df without -P puts long device names on a separate line
+	fields := strings.Fields(strings.Join(lines[1:], " "))
+	if len(fields) <= typeColumn {
 		return "", "", fmt.Errorf("too few fields in the df output")
 	}
 
-	return fields[0], fields[1], nil
+	return fields[0], fields[typeColumn], nil
+}
+
+// parseProcMounts finds the device and the filesystem type of the mount point containing the given path.
+func parseProcMounts(out string, path string) (device, fstype string, err error) {
+	longestMatch := ""
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		mountPoint := fields[1]
+		if mountPoint != path && mountPoint != "/" && !strings.HasPrefix(path, mountPoint+"/") {
+			continue
+		}
+		if len(mountPoint) > len(longestMatch) {
+			longestMatch = mountPoint
+			device, fstype = fields[0], fields[2]
+		}
+	}
+	if longestMatch == "" {
+		return "", "", fmt.Errorf("could not find mount point for %q in %s", path, procMountsFile)
+	}
+
+	return device, fstype, nil
 }
 
 func (c *Cluster) resizePostgresFilesystem(podName *spec.NamespacedName, resizers []filesystems.FilesystemResizer) error {
-	// resize2fs always writes to stderr, and ExecCommand considers a non-empty stderr an error
+	commandExecutor := func(cmd string) (out string, err error) {
+		return c.ExecCommand(podName, "sh", "-c", cmd)
+	}
+	if c.OpConfig.FilesystemResizeMode == config.FilesystemResizeModeJob {
+		commandExecutor = func(cmd string) (out string, err error) {
+			return c.runFilesystemJob(podName, cmd)
+		}
+	}
 	// first, determine the device and the filesystem
-	deviceName, fsType, err := c.getPostgresFilesystemInfo(podName)
+	deviceName, fsType, err := c.getPostgresFilesystemInfo(commandExecutor)
 	if err != nil {
 		return fmt.Errorf("could not get device and type for the postgres filesystem: %v", err)
 	}
+
+	if c.OpConfig.FilesystemResizeCommand != "" {
+		_, err := commandExecutor(c.OpConfig.FilesystemResizeCommand.Format("device", deviceName, "mount",
+			dataMountPath(&c.Spec.Volume)))
+		return err
+	}
+
 	for _, resizer := range resizers {
 		if !resizer.CanResizeFilesystem(fsType) {
 			continue
 		}
-		err := resizer.ResizeFilesystem(deviceName, func(cmd string) (out string, err error) {
-			return c.ExecCommand(podName, "bash", "-c", cmd)
-		})
+		err := resizer.ResizeFilesystem(deviceName, commandExecutor)
 
 		return err
 	}
 	return fmt.Errorf("could not resize filesystem: no compatible resizers for the filesystem of type %q", fsType)
 }
+
+// runFilesystemJob runs the command in a short-lived privileged Job on the node of the pod instead of the Postgres
+// container, which may lack the binaries or the permissions to resize the filesystem. The Job mounts the data volume
+// claim of the pod as well, a ReadWriteOnce volume can be mounted by any number of pods on the same node. The output
+// of the command is returned once the Job has succeeded, the Job is removed either way.
+func (c *Cluster) runFilesystemJob(podName *spec.NamespacedName, command string) (string, error) {
+	pod, err := c.KubeClient.Pods(podName.Namespace).Get(podName.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get pod: %v", err)
+	}
+	if pod.Spec.NodeName == "" {
+		return "", fmt.Errorf("pod %q is not scheduled to a node", podName)
+	}
+
+	job, err := c.KubeClient.Jobs(pod.Namespace).Create(c.generateFilesystemJob(pod, command))
+	if err != nil {
+		return "", fmt.Errorf("could not create filesystem job: %v", err)
+	}
+	c.logger.Debugf("filesystem job %q has been created on the node %q", job.Name, pod.Spec.NodeName)
+	defer func() {
+		background := metav1.DeletePropagationBackground
+		err := c.KubeClient.Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &background})
+		if err != nil {
+			c.logger.Warningf("could not delete filesystem job %q: %v", job.Name, err)
+		}
+	}()
+
+	err = retryutil.Retry(c.OpConfig.ResourceCheckInterval, c.OpConfig.ResourceCheckTimeout,
+		func() (bool, error) {
+			current, err := c.KubeClient.Jobs(job.Namespace).Get(job.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			if current.Status.Failed > 0 {
+				return false, fmt.Errorf("the command has failed")
+			}
+			return current.Status.Succeeded > 0, nil
+		})
+	if err != nil {
+		return "", fmt.Errorf("filesystem job %q has not succeeded: %v", job.Name, err)
+	}
+
+	return c.filesystemJobOutput(job)
+}
+
+// filesystemJobOutput returns the log of the succeeded pod of the Job, the output of the command.
+func (c *Cluster) filesystemJobOutput(job *batchv1.Job) (string, error) {
+	pods, err := c.KubeClient.Pods(job.Namespace).List(metav1.ListOptions{LabelSelector: "job-name=" + job.Name})
+	if err != nil {
+		return "", fmt.Errorf("could not list pods of filesystem job %q: %v", job.Name, err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodSucceeded {
+			continue
+		}
+		out, err := c.KubeClient.Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{}).Do().Raw()
+		if err != nil {
+			return "", fmt.Errorf("could not get log of pod %q: %v", pod.Name, err)
+		}
+		return string(out), nil
+	}
+	return "", fmt.Errorf("no succeeded pod of filesystem job %q", job.Name)
+}
+
+// generateFilesystemJob returns the Job running the command on the node of the pod with the data volume claim of the
+// pod mounted where the Postgres container mounts it. The Job and its pod carry none of the labels of the cluster,
+// so that neither the pod watcher nor the listings of the cluster objects mistake them for Postgres ones; a label of
+// their own names the pod they work for.
+func (c *Cluster) generateFilesystemJob(pod *v1.Pod, command string) *batchv1.Job {
+	image := c.OpConfig.FilesystemResizeJobImage
+	if image == "" {
+		image = c.OpConfig.DockerImage
+	}
+	privileged := true
+	one := int32(1)
+	deadline := int64(c.OpConfig.ResourceCheckTimeout.Seconds())
+	jobLabels := map[string]string{constants.FilesystemJobLabel: pod.Name}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pod.Name + "-filesystem-",
+			Namespace:    pod.Namespace,
+			Labels:       jobLabels,
+		},
+		Spec: batchv1.JobSpec{
+			Parallelism:           &one,
+			Completions:           &one,
+			ActiveDeadlineSeconds: &deadline,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: jobLabels,
+				},
+				Spec: v1.PodSpec{
+					NodeName:           pod.Spec.NodeName,
+					Tolerations:        pod.Spec.Tolerations,
+					ServiceAccountName: c.OpConfig.PodServiceAccountName,
+					RestartPolicy:      v1.RestartPolicyNever,
+					Containers: []v1.Container{
+						{
+							Name:            "filesystem",
+							Image:           image,
+							ImagePullPolicy: v1.PullIfNotPresent,
+							Command:         []string{"sh", "-c", command},
+							SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      constants.DataVolumeName,
+									MountPath: dataMountPath(&c.Spec.Volume),
+								},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: constants.DataVolumeName,
+							VolumeSource: v1.VolumeSource{
+								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+									ClaimName: constants.DataVolumeName + "-" + pod.Name,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
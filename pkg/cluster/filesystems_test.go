@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+func TestFilesystemInfoCommand(t *testing.T) {
+	testName := "TestFilesystemInfoCommand"
+	tests := []struct {
+		subtest string
+		command string
+		result  string
+	}{
+		{
+			subtest: "default df command",
+			command: "",
+			result:  "df -PT /home/postgres/pgdata",
+		},
+		{
+			subtest: "custom command template",
+			command: "busybox df -T {mount}",
+			result:  "busybox df -T /home/postgres/pgdata",
+		},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{}}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+		if err := cluster.OpConfig.FilesystemInfoCommand.Decode(tt.command); err != nil {
+			t.Fatalf("%s %s: could not decode command: %v", testName, tt.subtest, err)
+		}
+		if result := cluster.filesystemInfoCommand(); result != tt.result {
+			t.Errorf("%s %s: expected command %q, got %q", testName, tt.subtest, tt.result, result)
+		}
+	}
+}
+
+func TestParseDfOutput(t *testing.T) {
+	testName := "TestParseDfOutput"
+	tests := []struct {
+		subtest string
+		out     string
+		device  string
+		fstype  string
+		err     bool
+	}{
+		{
+			subtest: "GNU df",
+			out: "Filesystem     Type 1024-blocks    Used Available Capacity Mounted on\n" +
+				"/dev/xvdbb     ext4     5095040 1170976   3907680      24% /home/postgres/pgdata\n",
+			device: "/dev/xvdbb",
+			fstype: "ext4",
+		},
+		{
+			subtest: "GNU df with a wrapped device name",
+			out: "Filesystem                                  Type 1K-blocks    Used Available Use% Mounted on\n" +
+				"/dev/mapper/very-long-volume-group-name-pgdata\n" +
+				"                                            xfs    5095040 1170976   3907680  24% /home/postgres/pgdata\n",
+			device: "/dev/mapper/very-long-volume-group-name-pgdata",
+			fstype: "xfs",
+		},
+		{
+			subtest: "BusyBox df",
+			out: "Filesystem           Type       1024-blocks      Used Available Capacity Mounted on\n" +
+				"/dev/nvme1n1         ext4           5095040   1170976   3907680  24% /home/postgres/pgdata\n",
+			device: "/dev/nvme1n1",
+			fstype: "ext4",
+		},
+		{
+			subtest: "BusyBox df without filesystem types",
+			out: "Filesystem           1024-blocks      Used Available Capacity Mounted on\n" +
+				"/dev/nvme1n1             5095040   1170976   3907680  24% /home/postgres/pgdata\n",
+			err: true,
+		},
+		{
+			subtest: "empty output",
+			out:     "",
+			err:     true,
+		},
+	}
+	for _, tt := range tests {
+		device, fstype, err := parseDfOutput(tt.out)
+		if (err != nil) != tt.err {
+			t.Errorf("%s %s: unexpected error status: %v", testName, tt.subtest, err)
+			continue
+		}
+		if device != tt.device || fstype != tt.fstype {
+			t.Errorf("%s %s: expected device %q and type %q, got %q and %q",
+				testName, tt.subtest, tt.device, tt.fstype, device, fstype)
+		}
+	}
+}
+
+func TestParseProcMounts(t *testing.T) {
+	testName := "TestParseProcMounts"
+	mounts := "overlay / overlay rw,relatime 0 0\n" +
+		"proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0\n" +
+		"/dev/xvdbb /home/postgres/pgdata ext4 rw,relatime,data=ordered 0 0\n" +
+		"/dev/xvdbc /home/postgres/pgdata2 xfs rw,relatime 0 0\n"
+	tests := []struct {
+		subtest string
+		out     string
+		path    string
+		device  string
+		fstype  string
+		err     bool
+	}{
+		{
+			subtest: "exact mount point",
+			out:     mounts,
+			path:    "/home/postgres/pgdata",
+			device:  "/dev/xvdbb",
+			fstype:  "ext4",
+		},
+		{
+			subtest: "path inside the mount point",
+			out:     mounts,
+			path:    "/home/postgres/pgdata/pgroot",
+			device:  "/dev/xvdbb",
+			fstype:  "ext4",
+		},
+		{
+			subtest: "path on the root filesystem",
+			out:     mounts,
+			path:    "/home/postgres",
+			device:  "overlay",
+			fstype:  "overlay",
+		},
+		{
+			subtest: "no mount points",
+			out:     "",
+			path:    "/home/postgres/pgdata",
+			err:     true,
+		},
+	}
+	for _, tt := range tests {
+		device, fstype, err := parseProcMounts(tt.out, tt.path)
+		if (err != nil) != tt.err {
+			t.Errorf("%s %s: unexpected error status: %v", testName, tt.subtest, err)
+			continue
+		}
+		if device != tt.device || fstype != tt.fstype {
+			t.Errorf("%s %s: expected device %q and type %q, got %q and %q",
+				testName, tt.subtest, tt.device, tt.fstype, device, fstype)
+		}
+	}
+}
+
+func TestGenerateFilesystemJob(t *testing.T) {
+	testName := "TestGenerateFilesystemJob"
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "acid-test-1", Namespace: "test"},
+		Spec: v1.PodSpec{
+			NodeName:    "node-1",
+			Tolerations: []v1.Toleration{{Key: "postgres", Operator: v1.TolerationOpExists}},
+		},
+	}
+	tests := []struct {
+		subtest  string
+		jobImage string
+		image    string
+	}{
+		{"docker image by default", "", "spilo:1.4"},
+		{"configured image", "filesystem-tools:1.0", "filesystem-tools:1.0"},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{
+			DockerImage:              "spilo:1.4",
+			FilesystemResizeJobImage: tt.jobImage,
+			Resources:                config.Resources{ClusterNameLabel: "cluster-name"},
+		}}, k8sutil.KubernetesClient{}, spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"}},
+			logger)
+
+		job := cluster.generateFilesystemJob(pod, "resize2fs /dev/xvdb 2>&1")
+		podSpec := job.Spec.Template.Spec
+		if job.GenerateName != "acid-test-1-filesystem-" || job.Namespace != "test" {
+			t.Errorf("%s %s: unexpected metadata %#v", testName, tt.subtest, job.ObjectMeta)
+		}
+		jobLabels := map[string]string{constants.FilesystemJobLabel: "acid-test-1"}
+		if !reflect.DeepEqual(job.Labels, jobLabels) || !reflect.DeepEqual(job.Spec.Template.Labels, jobLabels) {
+			t.Errorf("%s %s: the job and its pod must carry only the label of the job, got %v and %v", testName,
+				tt.subtest, job.Labels, job.Spec.Template.Labels)
+		}
+		if podSpec.NodeName != "node-1" || !reflect.DeepEqual(podSpec.Tolerations, pod.Spec.Tolerations) {
+			t.Errorf("%s %s: the job does not run on the node of the pod", testName, tt.subtest)
+		}
+		container := podSpec.Containers[0]
+		if container.Image != tt.image {
+			t.Errorf("%s %s: expected image %q, got %q", testName, tt.subtest, tt.image, container.Image)
+		}
+		if !reflect.DeepEqual(container.Command, []string{"sh", "-c", "resize2fs /dev/xvdb 2>&1"}) {
+			t.Errorf("%s %s: unexpected command %v", testName, tt.subtest, container.Command)
+		}
+		if container.SecurityContext == nil || container.SecurityContext.Privileged == nil ||
+			!*container.SecurityContext.Privileged {
+			t.Errorf("%s %s: the container of the job is not privileged", testName, tt.subtest)
+		}
+		if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != "/home/postgres/pgdata" {
+			t.Errorf("%s %s: unexpected volume mounts %v", testName, tt.subtest, container.VolumeMounts)
+		}
+		if claim := podSpec.Volumes[0].PersistentVolumeClaim; claim == nil || claim.ClaimName != "pgdata-acid-test-1" {
+			t.Errorf("%s %s: unexpected volumes %v", testName, tt.subtest, podSpec.Volumes)
+		}
+	}
+}
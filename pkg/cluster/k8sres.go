@@ -1,9 +1,15 @@
 package cluster
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"path"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -12,9 +18,12 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/apps/v1beta1"
+	batchv1 "k8s.io/client-go/pkg/apis/batch/v1"
+	batchv2alpha1 "k8s.io/client-go/pkg/apis/batch/v2alpha1"
 	policybeta1 "k8s.io/client-go/pkg/apis/policy/v1beta1"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"k8s.io/apimachinery/pkg/labels"
 )
@@ -24,8 +33,53 @@ const (
 	patroniPGBinariesParameterName   = "bin_dir"
 	patroniPGParametersParameterName = "parameters"
 	localHost                        = "127.0.0.1/32"
+	secretChecksumAnnotationKey      = "zalando-postgres-operator-secret-checksum"
+	resourceHugePages2Mi             = v1.ResourceName("hugepages-2Mi")
+	backupCredentialsVolumeName      = "backup-credentials"
+	backupCredentialsMountPath       = "/home/postgres/etc/backup-credentials"
 )
 
+// workloadProfileSettings describes how a workload profile derives Postgres
+// parameters from the resources of the postgres container.
+type workloadProfileSettings struct {
+	sharedBuffersRatio        float64
+	effectiveCacheSizeRatio   float64
+	workMemDivisor            int64
+	maintenanceWorkMemDivisor int64
+	randomPageCost            string
+	effectiveIOConcurrency    string
+	parallelWorkersPerCPU     float64
+}
+
+var workloadProfiles = map[string]workloadProfileSettings{
+	spec.WorkloadProfileOLTP: {
+		sharedBuffersRatio:        0.25,
+		effectiveCacheSizeRatio:   0.75,
+		workMemDivisor:            256,
+		maintenanceWorkMemDivisor: 16,
+		randomPageCost:            "1.1",
+		effectiveIOConcurrency:    "200",
+	},
+	spec.WorkloadProfileOLAP: {
+		sharedBuffersRatio:        0.25,
+		effectiveCacheSizeRatio:   0.75,
+		workMemDivisor:            32,
+		maintenanceWorkMemDivisor: 8,
+		randomPageCost:            "1.1",
+		effectiveIOConcurrency:    "200",
+		parallelWorkersPerCPU:     0.5,
+	},
+	spec.WorkloadProfileMixed: {
+		sharedBuffersRatio:        0.25,
+		effectiveCacheSizeRatio:   0.75,
+		workMemDivisor:            64,
+		maintenanceWorkMemDivisor: 16,
+		randomPageCost:            "1.1",
+		effectiveIOConcurrency:    "200",
+		parallelWorkersPerCPU:     0.25,
+	},
+}
+
 type pgUser struct {
 	Password string   `json:"password"`
 	Options  []string `json:"options"`
@@ -36,6 +90,9 @@ type patroniDCS struct {
 	LoopWait                 uint32                 `json:"loop_wait,omitempty"`
 	RetryTimeout             uint32                 `json:"retry_timeout,omitempty"`
 	MaximumLagOnFailover     float32                `json:"maximum_lag_on_failover,omitempty"`
+	SynchronousMode          bool                   `json:"synchronous_mode,omitempty"`
+	SynchronousModeStrict    bool                   `json:"synchronous_mode_strict,omitempty"`
+	SynchronousNodeCount     uint32                 `json:"synchronous_node_count,omitempty"`
 	PGBootstrapConfiguration map[string]interface{} `json:"postgresql,omitempty"`
 }
 
@@ -81,6 +138,10 @@ func (c *Cluster) podDisruptionBudgetName() string {
 	return c.OpConfig.PDBNameFormat.Format("cluster", c.Name)
 }
 
+func (c *Cluster) logicalBackupJobName() string {
+	return "logical-backup-" + c.Name
+}
+
 func (c *Cluster) makeDefaultResources() spec.Resources {
 
 	config := c.OpConfig
@@ -91,6 +152,50 @@ func (c *Cluster) makeDefaultResources() spec.Resources {
 	return spec.Resources{defaultRequests, defaultLimits}
 }
 
+// postgresContainerDefaults returns the docker image and the resources of the postgres container used when the
+// manifest does not set them. Unless the operator propagates its defaults, a running cluster keeps the ones it
+// has been created with, so that a change of the operator configuration does not roll all clusters at once.
+func (c *Cluster) postgresContainerDefaults() (string, spec.Resources) {
+	image, resources := c.OpConfig.DockerImage, c.makeDefaultResources()
+	if c.OpConfig.PropagateDefaults {
+		return image, resources
+	}
+	container := c.currentPostgresContainer()
+	if container == nil {
+		return image, resources
+	}
+
+	requests, limits := container.Resources.Requests, container.Resources.Limits
+	resources.ResourceRequest.CPU = quantityOrDefault(requests, v1.ResourceCPU, resources.ResourceRequest.CPU)
+	resources.ResourceRequest.Memory = quantityOrDefault(requests, v1.ResourceMemory, resources.ResourceRequest.Memory)
+	resources.ResourceLimits.CPU = quantityOrDefault(limits, v1.ResourceCPU, resources.ResourceLimits.CPU)
+	resources.ResourceLimits.Memory = quantityOrDefault(limits, v1.ResourceMemory, resources.ResourceLimits.Memory)
+
+	return container.Image, resources
+}
+
+// currentPostgresContainer returns the postgres container of the running statefulset, if there is one.
+func (c *Cluster) currentPostgresContainer() *v1.Container {
+	if c.Statefulset == nil {
+		return nil
+	}
+	containers := c.Statefulset.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name == c.containerName() {
+			return &containers[i]
+		}
+	}
+
+	return nil
+}
+
+func quantityOrDefault(list v1.ResourceList, name v1.ResourceName, defaultValue string) string {
+	if quantity, ok := list[name]; ok {
+		return quantity.String()
+	}
+	return defaultValue
+}
+
 func generateResourceRequirements(resources spec.Resources, defaultResources spec.Resources) (*v1.ResourceRequirements, error) {
 	var err error
 
@@ -109,6 +214,13 @@ func generateResourceRequirements(resources spec.Resources, defaultResources spe
 		return nil, fmt.Errorf("could not fill resource limits: %v", err)
 	}
 
+	// huge pages cannot be overcommitted, so Kubernetes requires their requests to be equal to the limits
+	if hugePages, ok := result.Limits[resourceHugePages2Mi]; ok {
+		result.Requests[resourceHugePages2Mi] = hugePages
+	} else if hugePages, ok := result.Requests[resourceHugePages2Mi]; ok {
+		result.Limits[resourceHugePages2Mi] = hugePages
+	}
+
 	return &result, nil
 }
 
@@ -138,11 +250,18 @@ func fillResourceList(spec spec.ResourceDescription, defaults spec.ResourceDescr
 			return nil, fmt.Errorf("could not parse default memory quantity: %v", err)
 		}
 	}
+	if spec.HugePages2Mi != "" {
+		requests[resourceHugePages2Mi], err = resource.ParseQuantity(spec.HugePages2Mi)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse hugepages-2Mi quantity: %v", err)
+		}
+	}
 
 	return requests, nil
 }
 
-func generateSpiloJSONConfiguration(pg *spec.PostgresqlParam, patroni *spec.Patroni, pamRoleName string, logger *logrus.Entry) string {
+func generateSpiloJSONConfiguration(pg *spec.PostgresqlParam, patroni *spec.Patroni, clientCerts *spec.ClientCertificates,
+	pamRoleName string, logger *logrus.Entry) string {
 	config := spiloConfiguration{}
 
 	config.Bootstrap = pgBootstrap{}
@@ -212,6 +331,10 @@ PatroniInitDBParams:
 			"hostssl   all all all md5",
 		}
 	}
+	// the client certificate rules must come first, since the first matching rule wins
+	if clientCerts != nil {
+		config.Bootstrap.PgHBA = append(clientCertificateHBARules(clientCerts, pg.PgVersion), config.Bootstrap.PgHBA...)
+	}
 
 	if patroni.MaximumLagOnFailover >= 0 {
 		config.Bootstrap.DCS.MaximumLagOnFailover = patroni.MaximumLagOnFailover
@@ -225,6 +348,9 @@ PatroniInitDBParams:
 	if patroni.TTL != 0 {
 		config.Bootstrap.DCS.TTL = patroni.TTL
 	}
+	config.Bootstrap.DCS.SynchronousMode = patroni.SynchronousMode
+	config.Bootstrap.DCS.SynchronousModeStrict = patroni.SynchronousModeStrict
+	config.Bootstrap.DCS.SynchronousNodeCount = patroni.SynchronousNodeCount
 
 	config.PgLocalConfiguration = make(map[string]interface{})
 	config.PgLocalConfiguration[patroniPGBinariesParameterName] = fmt.Sprintf(pgBinariesLocationTemplate, pg.PgVersion)
@@ -260,6 +386,167 @@ PatroniInitDBParams:
 	return string(result)
 }
 
+// generateWorkloadProfileParameters expands the workload profile into Postgres
+// parameters sized after the memory and CPU limits of the postgres container.
+func generateWorkloadProfileParameters(profile string, pgVersion string, resources *v1.ResourceRequirements) map[string]string {
+	settings, ok := workloadProfiles[profile]
+	if !ok {
+		return nil
+	}
+
+	memory := resources.Limits[v1.ResourceMemory]
+	if memory.IsZero() {
+		memory = resources.Requests[v1.ResourceMemory]
+	}
+	cpu := resources.Limits[v1.ResourceCPU]
+	if cpu.IsZero() {
+		cpu = resources.Requests[v1.ResourceCPU]
+	}
+	memoryMB := memory.Value() / (1024 * 1024)
+
+	atLeast := func(value, min int64) int64 {
+		if value < min {
+			return min
+		}
+		return value
+	}
+
+	result := map[string]string{
+		"shared_buffers":           fmt.Sprintf("%dMB", atLeast(int64(float64(memoryMB)*settings.sharedBuffersRatio), 16)),
+		"effective_cache_size":     fmt.Sprintf("%dMB", atLeast(int64(float64(memoryMB)*settings.effectiveCacheSizeRatio), 64)),
+		"work_mem":                 fmt.Sprintf("%dMB", atLeast(memoryMB/settings.workMemDivisor, 4)),
+		"maintenance_work_mem":     fmt.Sprintf("%dMB", atLeast(memoryMB/settings.maintenanceWorkMemDivisor, 64)),
+		"random_page_cost":         settings.randomPageCost,
+		"effective_io_concurrency": settings.effectiveIOConcurrency,
+	}
+
+	// parallel query is only available starting from 9.6
+	if version, err := strconv.ParseFloat(pgVersion, 64); err == nil && version >= 9.6 && settings.parallelWorkersPerCPU > 0 {
+		workers := int64(float64(cpu.MilliValue()) / 1000 * settings.parallelWorkersPerCPU)
+		result["max_parallel_workers_per_gather"] = strconv.FormatInt(atLeast(workers, 1), 10)
+	}
+
+	return result
+}
+
+// applyWorkloadProfile returns the Postgres parameters with the ones derived from the workload profile
+// added. Parameters explicitly set in the manifest always take priority over the profile.
+func applyWorkloadProfile(pg *spec.PostgresqlParam, profile string, resources *v1.ResourceRequirements) *spec.PostgresqlParam {
+	profileParameters := generateWorkloadProfileParameters(profile, pg.PgVersion, resources)
+	if len(profileParameters) == 0 {
+		return pg
+	}
+
+	result := spec.PostgresqlParam{
+		PgVersion:  pg.PgVersion,
+		Parameters: profileParameters,
+	}
+	for param, val := range pg.Parameters {
+		result.Parameters[param] = val
+	}
+
+	return &result
+}
+
+// setDefaultParameter returns the Postgres parameters with the given parameter added, unless
+// it has been already set explicitly.
+func setDefaultParameter(pg *spec.PostgresqlParam, name, value string) *spec.PostgresqlParam {
+	if _, ok := pg.Parameters[name]; ok {
+		return pg
+	}
+
+	result := spec.PostgresqlParam{
+		PgVersion:  pg.PgVersion,
+		Parameters: map[string]string{name: value},
+	}
+	for param, val := range pg.Parameters {
+		result.Parameters[param] = val
+	}
+
+	return &result
+}
+
+// applySuperuserReservedConnections sets superuser_reserved_connections from the manifest or the operator
+// configuration, so that the operator is always able to connect as a superuser when max_connections is exhausted.
+func (c *Cluster) applySuperuserReservedConnections(pg *spec.PostgresqlParam, reserved *uint32) *spec.PostgresqlParam {
+	if reserved != nil {
+		return setDefaultParameter(pg, "superuser_reserved_connections", strconv.FormatUint(uint64(*reserved), 10))
+	}
+	if c.OpConfig.SuperuserReservedConnections == 0 {
+		return pg
+	}
+	if maxConnections, ok := pg.Parameters["max_connections"]; ok {
+		if num, err := strconv.ParseUint(maxConnections, 10, 32); err == nil && uint32(num) <= c.OpConfig.SuperuserReservedConnections {
+			c.logger.Warningf("not setting superuser_reserved_connections to %d: max_connections is only %d",
+				c.OpConfig.SuperuserReservedConnections, num)
+			return pg
+		}
+	}
+
+	return setDefaultParameter(pg, "superuser_reserved_connections",
+		strconv.FormatUint(uint64(c.OpConfig.SuperuserReservedConnections), 10))
+}
+
+// applySynchronousCommit sets synchronous_commit from the manifest. Levels that do not wait for the standby
+// make the synchronous mode pointless for the transactions using them, so such combination is reported.
+func (c *Cluster) applySynchronousCommit(pg *spec.PostgresqlParam, pgSpec *spec.PostgresSpec) *spec.PostgresqlParam {
+	level := pgSpec.SynchronousCommit
+	if level == "" {
+		return pg
+	}
+	if (pgSpec.Patroni.SynchronousMode || pgSpec.Patroni.SynchronousModeStrict) &&
+		(level == spec.SynchronousCommitOff || level == spec.SynchronousCommitLocal) {
+		c.logger.Warningf("synchronous_commit %q does not wait for the synchronous standby enabled by the synchronous mode",
+			level)
+	}
+
+	return setDefaultParameter(pg, "synchronous_commit", level)
+}
+
+// applySSLParameters sets the minimum TLS protocol version and the ciphers from the manifest.
+func applySSLParameters(pg *spec.PostgresqlParam, pgSpec *spec.PostgresSpec) *spec.PostgresqlParam {
+	if pgSpec.SSLMinProtocolVersion != "" {
+		pg = setDefaultParameter(pg, "ssl_min_protocol_version", pgSpec.SSLMinProtocolVersion)
+	}
+	if pgSpec.SSLCiphers != "" {
+		pg = setDefaultParameter(pg, "ssl_ciphers", pgSpec.SSLCiphers)
+	}
+
+	return pg
+}
+
+// applyHugePages sets huge_pages from the manifest. The huge pages used for the shared memory are requested
+// with the hugepages-2Mi resource of the postgres container.
+func applyHugePages(pg *spec.PostgresqlParam, hugePages string) *spec.PostgresqlParam {
+	if hugePages == "" {
+		return pg
+	}
+
+	return setDefaultParameter(pg, "huge_pages", hugePages)
+}
+
+// clientCertificateHBARules returns the pg_hba rules requiring a verified client certificate
+// on top of the password for the given users connecting from the given subnets.
+func clientCertificateHBARules(certs *spec.ClientCertificates, pgVersion string) []string {
+	subnets := certs.Subnets
+	if len(subnets) == 0 {
+		subnets = []string{"all"}
+	}
+	// verify-full, checking the name of the certificate against the user, is only known to Postgres 12 and later
+	clientCert := "clientcert=verify-full"
+	if version, err := strconv.ParseFloat(pgVersion, 64); err == nil && version < 12 {
+		clientCert = "clientcert=1"
+	}
+
+	rules := make([]string, 0, len(certs.Users)*len(subnets))
+	for _, user := range certs.Users {
+		for _, subnet := range subnets {
+			rules = append(rules, fmt.Sprintf("hostssl   all %s %s md5 %s", user, subnet, clientCert))
+		}
+	}
+	return rules
+}
+
 func nodeAffinity(nodeReadinessLabel map[string]string) *v1.Affinity {
 	matchExpressions := make([]v1.NodeSelectorRequirement, 0)
 	if len(nodeReadinessLabel) == 0 {
@@ -305,6 +592,9 @@ func tolerations(tolerationsSpec *[]v1.Toleration, podToleration map[string]stri
 // isBootstrapOnlyParameter checks asgainst special Patroni bootstrap parameters.
 // Those parameters must go to the bootstrap/dcs/postgresql/parameters section.
 // See http://patroni.readthedocs.io/en/latest/dynamic_configuration.html.
+// The reloadable parameters set by the manifest fields, like synchronous_commit, are kept
+// there as well, so that a change made via the Patroni API is applied with a reload and
+// is not shadowed by the local configuration.
 func isBootstrapOnlyParameter(param string) bool {
 	return param == "max_connections" ||
 		param == "max_locks_per_transaction" ||
@@ -312,16 +602,73 @@ func isBootstrapOnlyParameter(param string) bool {
 		param == "max_prepared_transactions" ||
 		param == "wal_level" ||
 		param == "wal_log_hints" ||
-		param == "track_commit_timestamp"
+		param == "track_commit_timestamp" ||
+		param == "synchronous_commit" ||
+		param == "ssl_min_protocol_version" ||
+		param == "ssl_ciphers"
 }
 
-func generateVolumeMounts() []v1.VolumeMount {
-	return []v1.VolumeMount{
+// dataMountPath returns where the data volume is mounted in the containers.
+func dataMountPath(volume *spec.Volume) string {
+	if volume.MountPath != "" {
+		return volume.MountPath
+	}
+	return constants.PostgresDataMount
+}
+
+// dataPath returns the PGROOT of Spilo, the directory of the data volume holding the data directory.
+func dataPath(volume *spec.Volume) string {
+	return path.Join(dataMountPath(volume), constants.PostgresDataRoot)
+}
+
+// dataVolumeSubPath returns the directory of the data volume mounted into the postgres container of the statefulset.
+func dataVolumeSubPath(statefulSet *v1beta1.StatefulSet) string {
+	if len(statefulSet.Spec.Template.Spec.Containers) == 0 {
+		return ""
+	}
+	for _, mount := range statefulSet.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.Name == constants.DataVolumeName {
+			return mount.SubPath
+		}
+	}
+	return ""
+}
+
+func generateVolumeMounts(volume *spec.Volume, tablespaces map[string]spec.Tablespace) []v1.VolumeMount {
+	mounts := []v1.VolumeMount{
 		{
 			Name:      constants.DataVolumeName,
-			MountPath: constants.PostgresDataMount, //TODO: fetch from manifest
+			MountPath: dataMountPath(volume),
+			SubPath:   volume.SubPath,
 		},
 	}
+	for _, name := range tablespaceNames(tablespaces) {
+		mounts = append(mounts, v1.VolumeMount{
+			Name:      tablespaceVolumeName(name),
+			MountPath: tablespaceMountPath(name),
+		})
+	}
+	return mounts
+}
+
+// tablespaceNames returns the names of the tablespaces sorted, so that the volumes keep their order in the statefulset.
+func tablespaceNames(tablespaces map[string]spec.Tablespace) []string {
+	names := make([]string, 0, len(tablespaces))
+	for name := range tablespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tablespaceVolumeName returns the name of the volume of the tablespace. Underscores are not allowed in the names
+// of the Kubernetes objects, hyphens are not allowed in the tablespace names, so the replacement is unambiguous.
+func tablespaceVolumeName(name string) string {
+	return constants.TablespaceVolumePrefix + strings.Replace(name, "_", "-", -1)
+}
+
+func tablespaceMountPath(name string) string {
+	return path.Join(constants.TablespacesMount, name)
 }
 
 func generateSpiloContainer(
@@ -431,7 +778,7 @@ func generatePodTemplate(
 }
 
 // generatePodEnvVars generates environment variables for the Spilo Pod
-func (c *Cluster) generateSpiloPodEnvVars(uid types.UID, spiloConfiguration string, cloneDescription *spec.CloneDescription, customPodEnvVarsList []v1.EnvVar) []v1.EnvVar {
+func (c *Cluster) generateSpiloPodEnvVars(uid types.UID, pgRoot string, spiloConfiguration string, cloneDescription *spec.CloneDescription, standbyDescription *spec.StandbyDescription, backup *spec.Backup, customPodEnvVarsList []v1.EnvVar) []v1.EnvVar {
 	envVars := []v1.EnvVar{
 		{
 			Name:  "SCOPE",
@@ -439,7 +786,7 @@ func (c *Cluster) generateSpiloPodEnvVars(uid types.UID, spiloConfiguration stri
 		},
 		{
 			Name:  "PGROOT",
-			Value: constants.PostgresDataPath,
+			Value: pgRoot,
 		},
 		{
 			Name: "POD_IP",
@@ -497,11 +844,7 @@ func (c *Cluster) generateSpiloPodEnvVars(uid types.UID, spiloConfiguration stri
 	if spiloConfiguration != "" {
 		envVars = append(envVars, v1.EnvVar{Name: "SPILO_CONFIGURATION", Value: spiloConfiguration})
 	}
-	if c.OpConfig.WALES3Bucket != "" {
-		envVars = append(envVars, v1.EnvVar{Name: "WAL_S3_BUCKET", Value: c.OpConfig.WALES3Bucket})
-		envVars = append(envVars, v1.EnvVar{Name: "WAL_BUCKET_SCOPE_SUFFIX", Value: getBucketScopeSuffix(string(uid))})
-		envVars = append(envVars, v1.EnvVar{Name: "WAL_BUCKET_SCOPE_PREFIX", Value: ""})
-	}
+	envVars = append(envVars, c.generateBackupEnvironment(uid, backup)...)
 
 	if c.OpConfig.LogS3Bucket != "" {
 		envVars = append(envVars, v1.EnvVar{Name: "LOG_S3_BUCKET", Value: c.OpConfig.LogS3Bucket})
@@ -519,6 +862,10 @@ func (c *Cluster) generateSpiloPodEnvVars(uid types.UID, spiloConfiguration stri
 		envVars = append(envVars, c.generateCloneEnvironment(cloneDescription)...)
 	}
 
+	if standbyDescription != nil {
+		envVars = append(envVars, c.generateStandbyEnvironment(standbyDescription)...)
+	}
+
 	if len(customPodEnvVarsList) > 0 {
 		envVars = append(envVars, customPodEnvVarsList...)
 	}
@@ -526,6 +873,66 @@ func (c *Cluster) generateSpiloPodEnvVars(uid types.UID, spiloConfiguration stri
 	return envVars
 }
 
+// generateBackupEnvironment configures WAL-E in Spilo. The bucket of the manifest takes priority over the
+// operator-wide one, Spilo archives to s3://{bucket}/spilo/{prefix}{cluster}{suffix}/wal then.
+func (c *Cluster) generateBackupEnvironment(uid types.UID, backup *spec.Backup) []v1.EnvVar {
+	bucket, prefix := c.walLocation(backup)
+	if bucket == "" {
+		return nil
+	}
+
+	result := []v1.EnvVar{
+		{Name: "WAL_S3_BUCKET", Value: bucket},
+		{Name: "WAL_BUCKET_SCOPE_SUFFIX", Value: getBucketScopeSuffix(string(uid))},
+		{Name: "WAL_BUCKET_SCOPE_PREFIX", Value: prefix},
+	}
+	if backup == nil {
+		return result
+	}
+	if backup.Schedule != "" {
+		result = append(result, v1.EnvVar{Name: "BACKUP_SCHEDULE", Value: backup.Schedule})
+	}
+	if backup.Retention != nil {
+		result = append(result, v1.EnvVar{Name: "BACKUP_NUM_TO_RETAIN", Value: strconv.Itoa(int(*backup.Retention))})
+	}
+	// the encryption is set for both WAL-E and WAL-G, whichever Spilo runs, a key without the encryption method
+	// implies KMS
+	if backup.S3ServerSideEncryption != "" || backup.S3KMSKeyID != "" {
+		sse := backup.S3ServerSideEncryption
+		if sse == "" {
+			sse = spec.S3EncryptionKMS
+		}
+		result = append(result,
+			v1.EnvVar{Name: "WALE_S3_SSE", Value: sse},
+			v1.EnvVar{Name: "WALG_S3_SSE", Value: sse})
+	}
+	if backup.S3KMSKeyID != "" {
+		result = append(result, v1.EnvVar{Name: "WALG_S3_SSE_KMS_ID", Value: backup.S3KMSKeyID})
+	}
+	// delta backups are only taken by WAL-G, so Spilo has to use it instead of WAL-E for the base backups
+	if backup.DeltaMaxSteps != nil {
+		result = append(result,
+			v1.EnvVar{Name: "USE_WALG_BACKUP", Value: "true"},
+			v1.EnvVar{Name: "WALG_DELTA_MAX_STEPS", Value: strconv.Itoa(int(*backup.DeltaMaxSteps))})
+	}
+	if backup.DeltaOrigin != "" {
+		result = append(result, v1.EnvVar{Name: "WALG_DELTA_ORIGIN", Value: backup.DeltaOrigin})
+	}
+
+	return result
+}
+
+func (c *Cluster) walLocation(backup *spec.Backup) (bucket, prefix string) {
+	bucket = c.OpConfig.WALES3Bucket
+	if backup != nil {
+		if backup.S3Bucket != "" {
+			bucket = backup.S3Bucket
+		}
+		prefix = backup.S3Prefix
+	}
+	return
+}
+
 // deduplicateEnvVars makes sure there are no duplicate in the target envVar array. While Kubernetes already
 // deduplicates variables defined in a container, it leaves the last definition in the list and this behavior is not
 // well-documented, which means that the behavior can be reversed at some point (it may also start producing an error).
@@ -627,8 +1034,9 @@ func makeResources(cpuRequest, memoryRequest, cpuLimit, memoryLimit string) spec
 func (c *Cluster) generateStatefulSet(spec *spec.PostgresSpec) (*v1beta1.StatefulSet, error) {
 
 	defaultResources := c.makeDefaultResources()
+	defaultDockerImage, defaultPostgresResources := c.postgresContainerDefaults()
 
-	resourceRequirements, err := generateResourceRequirements(spec.Resources, defaultResources)
+	resourceRequirements, err := generateResourceRequirements(spec.Resources, defaultPostgresResources)
 	if err != nil {
 		return nil, fmt.Errorf("could not generate resource requirements: %v", err)
 	}
@@ -650,17 +1058,31 @@ func (c *Cluster) generateStatefulSet(spec *spec.PostgresSpec) (*v1beta1.Statefu
 		}
 	}
 
-	spiloConfiguration := generateSpiloJSONConfiguration(&spec.PostgresqlParam, &spec.Patroni, c.OpConfig.PamRoleName, c.logger)
+	pgParam := applyWorkloadProfile(&spec.PostgresqlParam, spec.WorkloadProfile, resourceRequirements)
+	pgParam = c.applySuperuserReservedConnections(pgParam, spec.SuperuserReservedConnections)
+	pgParam = c.applySynchronousCommit(pgParam, spec)
+	pgParam = applySSLParameters(pgParam, spec)
+	pgParam = applyHugePages(pgParam, spec.HugePages)
+	if spec.ClientCertificates != nil {
+		pgParam = setDefaultParameter(pgParam, "ssl_ca_file", spec.ClientCertificates.CAFile)
+	}
+	// the roles Patroni creates get the same kind of hashes as the ones from the operator
+	if c.OpConfig.PasswordEncryption == config.PasswordEncryptionSCRAMSHA256 {
+		pgParam = setDefaultParameter(pgParam, "password_encryption", config.PasswordEncryptionSCRAMSHA256)
+	}
+	spiloConfiguration := generateSpiloJSONConfiguration(pgParam, &spec.Patroni, spec.ClientCertificates,
+		c.OpConfig.PamRoleName, c.logger)
 
 	// generate environment variables for the spilo container
 	spiloEnvVars := deduplicateEnvVars(
-		c.generateSpiloPodEnvVars(c.Postgresql.GetUID(), spiloConfiguration, &spec.Clone, customPodEnvVarsList),
+		c.generateSpiloPodEnvVars(c.Postgresql.GetUID(), dataPath(&spec.Volume), spiloConfiguration, &spec.Clone, spec.StandbyCluster, spec.Backup,
+			customPodEnvVarsList),
 		c.containerName(), c.logger)
 
 	// pickup the docker image for the spilo container
-	effectiveDockerImage := getEffectiveDockerImage(c.OpConfig.DockerImage, spec.DockerImage)
+	effectiveDockerImage := getEffectiveDockerImage(defaultDockerImage, spec.DockerImage)
 
-	volumeMounts := generateVolumeMounts()
+	volumeMounts := generateVolumeMounts(&spec.Volume, spec.Tablespaces)
 
 	// generate the spilo container
 	spiloContainer := generateSpiloContainer(c.containerName(), &effectiveDockerImage, resourceRequirements, spiloEnvVars, volumeMounts)
@@ -709,9 +1131,32 @@ func (c *Cluster) generateStatefulSet(spec *spec.PostgresSpec) (*v1beta1.Statefu
 	if err != nil {
 		return nil, fmt.Errorf("could not generate pod template: %v", err)
 	}
-	volumeClaimTemplate, err := generatePersistentVolumeClaimTemplate(spec.Volume.Size, spec.Volume.StorageClass)
-	if err != nil {
-		return nil, fmt.Errorf("could not generate volume claim template: %v", err)
+	if spec.Backup != nil && spec.Backup.CredentialsSecret != "" {
+		addBackupCredentials(podTemplate, spec.Backup.CredentialsSecret)
+	}
+	if len(spec.RestartOnSecretChange) > 0 {
+		secrets, err := c.getPodSecrets(spec.RestartOnSecretChange)
+		if err != nil {
+			return nil, err
+		}
+		setSecretChecksumAnnotation(podTemplate, secrets)
+	}
+	var volumeClaimTemplates []v1.PersistentVolumeClaim
+	if spec.Volume.IsEphemeral() {
+		addEphemeralVolumes(podTemplate, spec.Tablespaces)
+	} else {
+		grow, err := c.volumeSizeGrows(spec.Volume)
+		if err != nil {
+			return nil, err
+		}
+		if grow {
+			if err := c.checkVolumeSizeLimit(spec.Volume); err != nil {
+				return nil, err
+			}
+		}
+		if volumeClaimTemplates, err = generateVolumeClaimTemplates(spec); err != nil {
+			return nil, err
+		}
 	}
 
 	numberOfInstances := c.getNumberOfInstances(spec)
@@ -728,13 +1173,119 @@ func (c *Cluster) generateStatefulSet(spec *spec.PostgresSpec) (*v1beta1.Statefu
 			Selector:             c.labelsSelector(),
 			ServiceName:          c.serviceName(Master),
 			Template:             *podTemplate,
-			VolumeClaimTemplates: []v1.PersistentVolumeClaim{*volumeClaimTemplate},
+			VolumeClaimTemplates: volumeClaimTemplates,
+			// pods are only ever recreated by the operator, see planStatefulSetSync
+			UpdateStrategy: v1beta1.StatefulSetUpdateStrategy{Type: v1beta1.OnDeleteStatefulSetStrategyType},
 		},
 	}
 
 	return statefulSet, nil
 }
 
+// generateVolumeClaimTemplates returns the volume claim templates of the data volume and of the tablespaces.
+func generateVolumeClaimTemplates(spec *spec.PostgresSpec) ([]v1.PersistentVolumeClaim, error) {
+	volumeClaimTemplate, err := generatePersistentVolumeClaimTemplate(constants.DataVolumeName, spec.Volume.Size,
+		spec.Volume.StorageClass)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate volume claim template: %v", err)
+	}
+	volumeClaimTemplates := []v1.PersistentVolumeClaim{*volumeClaimTemplate}
+	for _, name := range tablespaceNames(spec.Tablespaces) {
+		tablespace := spec.Tablespaces[name]
+		template, err := generatePersistentVolumeClaimTemplate(tablespaceVolumeName(name), tablespace.Size,
+			tablespace.StorageClass)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate volume claim template of tablespace %q: %v", name, err)
+		}
+		volumeClaimTemplates = append(volumeClaimTemplates, *template)
+	}
+
+	return volumeClaimTemplates, nil
+}
+
+// addEphemeralVolumes backs the data volume and the volumes of the tablespaces by emptyDir volumes of the pods
+// instead of persistent volume claims, the data is gone with the pod.
+func addEphemeralVolumes(template *v1.PodTemplateSpec, tablespaces map[string]spec.Tablespace) {
+	names := []string{constants.DataVolumeName}
+	for _, name := range tablespaceNames(tablespaces) {
+		names = append(names, tablespaceVolumeName(name))
+	}
+	for _, name := range names {
+		template.Spec.Volumes = append(template.Spec.Volumes, v1.Volume{
+			Name:         name,
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		})
+	}
+}
+
+// addBackupCredentials hands the keys of the secret to the postgres container as environment variables, Spilo picks
+// up the credentials of the archive from there. The secret is mounted as well for the credentials that have to be
+// files, like the key of a GCS service account.
+func addBackupCredentials(template *v1.PodTemplateSpec, secretName string) {
+	template.Spec.Volumes = append(template.Spec.Volumes, v1.Volume{
+		Name: backupCredentialsVolumeName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{SecretName: secretName},
+		},
+	})
+
+	container := &template.Spec.Containers[0]
+	container.EnvFrom = append(container.EnvFrom, v1.EnvFromSource{
+		SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: secretName}},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      backupCredentialsVolumeName,
+		MountPath: backupCredentialsMountPath,
+		ReadOnly:  true,
+	})
+}
+
+func (c *Cluster) getPodSecrets(names []string) ([]v1.Secret, error) {
+	secrets := make([]v1.Secret, 0, len(names))
+	for _, name := range names {
+		secret, err := c.KubeClient.Secrets(c.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not read secret %q: %v", name, err)
+		}
+		secrets = append(secrets, *secret)
+	}
+
+	return secrets, nil
+}
+
+// setSecretChecksumAnnotation stamps the checksum of the secrets content on the pod template, so that a change
+// of any of them alters the template annotations and triggers the rolling update of the pods.
+func setSecretChecksumAnnotation(template *v1.PodTemplateSpec, secrets []v1.Secret) {
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[secretChecksumAnnotationKey] = secretsChecksum(secrets)
+}
+
+// secretsChecksum computes a checksum of the secrets data, independent of the order of secrets and keys.
+func secretsChecksum(secrets []v1.Secret) string {
+	sorted := make([]v1.Secret, len(secrets))
+	copy(sorted, secrets)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	hash := sha256.New()
+	for _, secret := range sorted {
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(hash, "%s\x00", secret.Name)
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%s\x00%d\x00", key, len(secret.Data[key]))
+			hash.Write(secret.Data[key])
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 func getEffectiveDockerImage(globalDockerImage, clusterDockerImage string) string {
 	if clusterDockerImage == "" {
 		return globalDockerImage
@@ -834,9 +1385,20 @@ func (c *Cluster) getNumberOfInstances(spec *spec.PostgresSpec) (newcur int32) {
 	return
 }
 
-func generatePersistentVolumeClaimTemplate(volumeSize, volumeStorageClass string) (*v1.PersistentVolumeClaim, error) {
+// volumeClaimStorageClass returns the storage class the claim asks for, either by its annotation or in its spec.
+func volumeClaimStorageClass(pvc *v1.PersistentVolumeClaim) string {
+	if class, ok := pvc.Annotations["volume.beta.kubernetes.io/storage-class"]; ok {
+		return class
+	}
+	if pvc.Spec.StorageClassName != nil {
+		return *pvc.Spec.StorageClassName
+	}
+	return pvc.Annotations["volume.alpha.kubernetes.io/storage-class"]
+}
+
+func generatePersistentVolumeClaimTemplate(volumeName, volumeSize, volumeStorageClass string) (*v1.PersistentVolumeClaim, error) {
 	metadata := metav1.ObjectMeta{
-		Name: constants.DataVolumeName,
+		Name: volumeName,
 	}
 	if volumeStorageClass != "" {
 		// TODO: check if storage class exists
@@ -869,8 +1431,15 @@ func (c *Cluster) generateUserSecrets() (secrets map[string]*v1.Secret) {
 	secrets = make(map[string]*v1.Secret, len(c.pgUsers))
 	namespace := c.Namespace
 	for username, pgUser := range c.pgUsers {
+		// the secrets provisioned outside of the operator are left to their owners
+		if _, ok := c.Spec.UserSecrets[username]; ok && pgUser.Origin == spec.RoleOriginManifest {
+			continue
+		}
+		if c.vaultOnlyUser(pgUser) {
+			continue
+		}
 		//Skip users with no password i.e. human users (they'll be authenticated using pam)
-		secret := c.generateSingleUserSecret(namespace, pgUser)
+		secret := c.generateSingleUserSecret(c.credentialSecretNamespace(username), pgUser)
 		if secret != nil {
 			secrets[username] = secret
 		}
@@ -889,7 +1458,7 @@ func (c *Cluster) generateUserSecrets() (secrets map[string]*v1.Secret) {
 func (c *Cluster) generateSingleUserSecret(namespace string, pgUser spec.PgUser) *v1.Secret {
 	//Skip users with no password i.e. human users (they'll be authenticated using pam)
 	if pgUser.Password == "" {
-		if pgUser.Origin != spec.RoleOriginTeamsAPI {
+		if pgUser.Origin != spec.RoleOriginTeamsAPI && isLoginRole(pgUser.Flags) {
 			c.logger.Warningf("could not generate secret for a non-teamsAPI role %q: role has no password",
 				pgUser.Name)
 		}
@@ -909,9 +1478,58 @@ func (c *Cluster) generateSingleUserSecret(namespace string, pgUser spec.PgUser)
 			"password": []byte(pgUser.Password),
 		},
 	}
+	if pgUser.Origin == spec.RoleOriginManifest {
+		secret.Labels[constants.ManifestRoleLabel] = username
+	}
+	c.applySecretTemplate(&secret, pgUser.Name)
 	return &secret
 }
 
+// roleDatabase returns the database the applications of the role connect to: the first one it owns, if any.
+func (c *Cluster) roleDatabase(role string) string {
+	var owned []string
+	for name, owner := range c.Spec.Databases {
+		if owner == role {
+			owned = append(owned, name)
+		}
+	}
+	if len(owned) == 0 {
+		return "postgres"
+	}
+	sort.Strings(owned)
+	return owned[0]
+}
+
+// applySecretTemplate sets the keys of the secret_data_template of the operator in the secret of the role, formatted
+// from the credentials in the secret, and tells whether any of them has changed. The credentials may belong to a
+// rotation role of the role, the database is the first one the role owns, postgres if none. The credentials are
+// escaped for the user information of a URI, so that the generated passwords cannot break it.
+func (c *Cluster) applySecretTemplate(secret *v1.Secret, role string) bool {
+	if len(c.OpConfig.SecretDataTemplate) == 0 {
+		return false
+	}
+
+	database := c.roleDatabase(role)
+	changed := false
+	for key, template := range c.OpConfig.SecretDataTemplate {
+		value := template.Format(
+			"username", url.User(string(secret.Data["username"])).String(),
+			"password", url.User(string(secret.Data["password"])).String(),
+			"host", fmt.Sprintf("%s.%s.svc.cluster.local", c.serviceName(Master), c.Namespace),
+			"replica_host", fmt.Sprintf("%s.%s.svc.cluster.local", c.serviceName(Replica), c.Namespace),
+			"port", "5432",
+			"database", database,
+			"cluster", c.Name,
+			"namespace", c.Namespace)
+		if string(secret.Data[key]) != value {
+			secret.Data[key] = []byte(value)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
 func (c *Cluster) shouldCreateLoadBalancerForService(role PostgresRole, spec *spec.PostgresSpec) bool {
 
 	switch role {
@@ -1023,6 +1641,9 @@ func (c *Cluster) generateCloneEnvironment(description *spec.CloneDescription) [
 	if description.EndTimestamp == "" {
 		// cloning with basebackup, make a connection string to the cluster to clone from
 		host, port := c.getClusterServiceConnectionParameters(cluster)
+		if namespace := c.cloneSourceNamespace(description); namespace != c.Namespace {
+			host = host + "." + namespace
+		}
 		// TODO: make some/all of those constants
 		result = append(result, v1.EnvVar{Name: "CLONE_METHOD", Value: "CLONE_WITH_BASEBACKUP"})
 		result = append(result, v1.EnvVar{Name: "CLONE_HOST", Value: host})
@@ -1034,8 +1655,7 @@ func (c *Cluster) generateCloneEnvironment(description *spec.CloneDescription) [
 				ValueFrom: &v1.EnvVarSource{
 					SecretKeyRef: &v1.SecretKeySelector{
 						LocalObjectReference: v1.LocalObjectReference{
-							Name: c.credentialSecretNameForCluster(c.OpConfig.ReplicationUsername,
-								description.ClusterName),
+							Name: c.cloneCredentialSecretName(description),
 						},
 						Key: "password",
 					},
@@ -1043,16 +1663,59 @@ func (c *Cluster) generateCloneEnvironment(description *spec.CloneDescription) [
 			})
 	} else {
 		// cloning with S3, find out the bucket to clone
+		bucket := c.OpConfig.WALES3Bucket
+		if description.S3Bucket != "" {
+			bucket = description.S3Bucket
+		}
 		result = append(result, v1.EnvVar{Name: "CLONE_METHOD", Value: "CLONE_WITH_WALE"})
-		result = append(result, v1.EnvVar{Name: "CLONE_WAL_S3_BUCKET", Value: c.OpConfig.WALES3Bucket})
+		result = append(result, v1.EnvVar{Name: "CLONE_WAL_S3_BUCKET", Value: bucket})
 		result = append(result, v1.EnvVar{Name: "CLONE_TARGET_TIME", Value: description.EndTimestamp})
 		result = append(result, v1.EnvVar{Name: "CLONE_WAL_BUCKET_SCOPE_SUFFIX", Value: getBucketScopeSuffix(description.Uid)})
-		result = append(result, v1.EnvVar{Name: "CLONE_WAL_BUCKET_SCOPE_PREFIX", Value: ""})
+		result = append(result, v1.EnvVar{Name: "CLONE_WAL_BUCKET_SCOPE_PREFIX", Value: description.S3Prefix})
 	}
 
 	return result
 }
 
+// cloneSourceNamespace returns the namespace of the cluster to clone with pg_basebackup.
+func (c *Cluster) cloneSourceNamespace(description *spec.CloneDescription) string {
+	if description.Namespace != "" {
+		return description.Namespace
+	}
+	return c.Namespace
+}
+
+// cloneCredentialSecretName returns the secret with the replication credentials of the cluster to clone. Pods only
+// read the secrets of their own namespace, the credentials of a cluster in another one are copied under the name
+// prefixed with its namespace.
+func (c *Cluster) cloneCredentialSecretName(description *spec.CloneDescription) string {
+	name := c.credentialSecretNameForCluster(c.OpConfig.ReplicationUsername, description.ClusterName)
+	if namespace := c.cloneSourceNamespace(description); namespace != c.Namespace {
+		return namespace + "." + name
+	}
+	return name
+}
+
+// generateStandbyEnvironment makes Spilo bootstrap the cluster as a Patroni standby cluster, which replays the WAL
+// either from the archive or by streaming from the given host.
+func (c *Cluster) generateStandbyEnvironment(description *spec.StandbyDescription) []v1.EnvVar {
+	result := make([]v1.EnvVar, 0)
+
+	if description.StandbyHost != "" {
+		result = append(result, v1.EnvVar{Name: "STANDBY_HOST", Value: description.StandbyHost})
+		if description.StandbyPort != "" {
+			result = append(result, v1.EnvVar{Name: "STANDBY_PORT", Value: description.StandbyPort})
+		}
+		return result
+	}
+
+	result = append(result, v1.EnvVar{Name: "STANDBY_METHOD", Value: "STANDBY_WITH_WALE"})
+	result = append(result, v1.EnvVar{Name: "STANDBY_WALE_S3_PREFIX", Value: description.S3WalPath})
+	result = append(result, v1.EnvVar{Name: "STANDBY_WAL_BUCKET_SCOPE_PREFIX", Value: ""})
+
+	return result
+}
+
 func (c *Cluster) generatePodDisruptionBudget() *policybeta1.PodDisruptionBudget {
 	minAvailable := intstr.FromInt(1)
 
@@ -1071,6 +1734,100 @@ func (c *Cluster) generatePodDisruptionBudget() *policybeta1.PodDisruptionBudget
 	}
 }
 
+// generateLogicalBackupJob returns the cron job that dumps the cluster with pg_dump, connecting
+// to the master service as the superuser, and uploads the result to the logical backup bucket.
+func (c *Cluster) generateLogicalBackupJob() *batchv2alpha1.CronJob {
+	schedule := c.Spec.LogicalBackupSchedule
+	if schedule == "" {
+		schedule = c.OpConfig.LogicalBackupSchedule
+	}
+
+	envVars := []v1.EnvVar{
+		{
+			Name:  "SCOPE",
+			Value: c.Name,
+		},
+		{
+			Name:  "POD_NAMESPACE",
+			Value: c.Namespace,
+		},
+		{
+			Name:  "PG_VERSION",
+			Value: c.Spec.PgVersion,
+		},
+		{
+			Name:  "PGHOST",
+			Value: c.serviceName(Master),
+		},
+		{
+			Name:  "PGPORT",
+			Value: "5432",
+		},
+		{
+			Name:  "PGUSER",
+			Value: c.OpConfig.SuperUsername,
+		},
+		{
+			Name: "PGPASSWORD",
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: c.credentialSecretName(c.OpConfig.SuperUsername),
+					},
+					Key: "password",
+				},
+			},
+		},
+		{
+			Name:  "PGSSLMODE",
+			Value: "require",
+		},
+		{
+			Name:  "LOGICAL_BACKUP_S3_BUCKET",
+			Value: c.OpConfig.LogicalBackupS3Bucket,
+		},
+		{
+			Name:  "LOGICAL_BACKUP_S3_BUCKET_SCOPE_SUFFIX",
+			Value: getBucketScopeSuffix(string(c.Postgresql.GetUID())),
+		},
+	}
+
+	podTemplate := v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: c.labelsSet(true),
+		},
+		Spec: v1.PodSpec{
+			ServiceAccountName: c.OpConfig.PodServiceAccountName,
+			RestartPolicy:      v1.RestartPolicyNever,
+			Containers: []v1.Container{
+				{
+					Name:            "logical-backup",
+					Image:           c.OpConfig.LogicalBackupDockerImage,
+					ImagePullPolicy: v1.PullIfNotPresent,
+					Env:             envVars,
+				},
+			},
+		},
+	}
+
+	return &batchv2alpha1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.logicalBackupJobName(),
+			Namespace: c.Namespace,
+			Labels:    c.labelsSet(true),
+		},
+		Spec: batchv2alpha1.CronJobSpec{
+			Schedule:          schedule,
+			ConcurrencyPolicy: batchv2alpha1.ForbidConcurrent,
+			JobTemplate: batchv2alpha1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					Template: podTemplate,
+				},
+			},
+		},
+	}
+}
+
 // getClusterServiceConnectionParameters fetches cluster host name and port
 // TODO: perhaps we need to query the service (i.e. if non-standard port is used?)
 // TODO: handle clusters in different namespaces
@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+const getPgStatsSQL = `SELECT (SELECT count(*) FROM pg_stat_activity WHERE state = 'active'),
+	        COALESCE(sum(xact_commit + xact_rollback), 0), COALESCE(sum(blks_hit), 0), COALESCE(sum(blks_read), 0)
+	 FROM pg_stat_database;`
+
+// pgStats holds the cumulative statistics counters of the cluster at a certain point of time.
+type pgStats struct {
+	activeConnections int64
+	transactions      int64
+	blocksHit         int64
+	blocksRead        int64
+	collectedAt       time.Time
+}
+
+func readPgStats(db *sql.DB, now time.Time) (*pgStats, error) {
+	stats := &pgStats{collectedAt: now}
+	if err := db.QueryRow(getPgStatsSQL).Scan(&stats.activeConnections, &stats.transactions,
+		&stats.blocksHit, &stats.blocksRead); err != nil {
+		return nil, fmt.Errorf("could not query statistics: %v", err)
+	}
+
+	return stats, nil
+}
+
+// computeClusterMetrics derives the metrics from the current statistics. The transaction rate needs
+// the statistics from the previous collection and is zero if there are none or the counters were reset.
+func computeClusterMetrics(prev, cur *pgStats) spec.ClusterMetrics {
+	metrics := spec.ClusterMetrics{
+		ActiveConnections: cur.activeConnections,
+		CollectedAt:       cur.collectedAt,
+	}
+	if total := cur.blocksHit + cur.blocksRead; total > 0 {
+		metrics.CacheHitRatio = float64(cur.blocksHit) / float64(total)
+	}
+	if prev != nil && cur.transactions >= prev.transactions {
+		if elapsed := cur.collectedAt.Sub(prev.collectedAt).Seconds(); elapsed > 0 {
+			metrics.TransactionsPerSecond = float64(cur.transactions-prev.transactions) / elapsed
+		}
+	}
+
+	return metrics
+}
+
+func (c *Cluster) collectMetrics() error {
+	c.setProcessName("collecting metrics")
+
+	if err := c.initReadDbConn(); err != nil {
+		return fmt.Errorf("could not init database connection: %v", err)
+	}
+	defer func() {
+		if err := c.closeReadDbConn(); err != nil {
+			c.logger.Errorf("could not close database connection: %v", err)
+		}
+	}()
+
+	stats, err := readPgStats(c.pgReadDb, time.Now())
+	if err != nil {
+		return err
+	}
+	c.updateMetrics(stats)
+
+	return nil
+}
+
+func (c *Cluster) updateMetrics(stats *pgStats) {
+	metrics := computeClusterMetrics(c.lastPgStats, stats)
+	c.lastPgStats = stats
+
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+	c.metrics = metrics
+}
+
+// GetMetrics returns the metrics collected during the last sync
+func (c *Cluster) GetMetrics() spec.ClusterMetrics {
+	c.metricsMu.RLock()
+	defer c.metricsMu.RUnlock()
+
+	return c.metrics
+}
@@ -0,0 +1,132 @@
+package cluster
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+func TestUpdateMetricsFromStats(t *testing.T) {
+	testName := "TestUpdateMetricsFromStats"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	start := time.Now()
+
+	db, _ := openFakeDB(t, "stats-first", []driver.Value{int64(3), int64(1000), int64(900), int64(100)})
+	stats, err := readPgStats(db, start)
+	if err != nil {
+		t.Fatalf("%s: could not read statistics: %v", testName, err)
+	}
+	cluster.updateMetrics(stats)
+	metrics := cluster.GetMetrics()
+	if metrics.ActiveConnections != 3 {
+		t.Errorf("%s: expected 3 active connections, got %d", testName, metrics.ActiveConnections)
+	}
+	if math.Abs(metrics.CacheHitRatio-0.9) > 1e-9 {
+		t.Errorf("%s: expected cache hit ratio 0.9, got %f", testName, metrics.CacheHitRatio)
+	}
+	if metrics.TransactionsPerSecond != 0 {
+		t.Errorf("%s: expected no transaction rate without previous statistics, got %f", testName, metrics.TransactionsPerSecond)
+	}
+
+	db, _ = openFakeDB(t, "stats-second", []driver.Value{int64(5), int64(1600), int64(1900), int64(100)})
+	if stats, err = readPgStats(db, start.Add(time.Minute)); err != nil {
+		t.Fatalf("%s: could not read statistics: %v", testName, err)
+	}
+	cluster.updateMetrics(stats)
+	metrics = cluster.GetMetrics()
+	if metrics.ActiveConnections != 5 {
+		t.Errorf("%s: expected 5 active connections, got %d", testName, metrics.ActiveConnections)
+	}
+	if math.Abs(metrics.TransactionsPerSecond-10) > 1e-9 {
+		t.Errorf("%s: expected 10 transactions per second, got %f", testName, metrics.TransactionsPerSecond)
+	}
+	if math.Abs(metrics.CacheHitRatio-0.95) > 1e-9 {
+		t.Errorf("%s: expected cache hit ratio 0.95, got %f", testName, metrics.CacheHitRatio)
+	}
+}
+
+func TestComputeClusterMetricsAfterStatsReset(t *testing.T) {
+	testName := "TestComputeClusterMetricsAfterStatsReset"
+	now := time.Now()
+	prev := &pgStats{transactions: 5000, collectedAt: now.Add(-time.Minute)}
+	cur := &pgStats{transactions: 100, collectedAt: now}
+
+	if metrics := computeClusterMetrics(prev, cur); metrics.TransactionsPerSecond != 0 {
+		t.Errorf("%s: expected no transaction rate after the statistics reset, got %f", testName, metrics.TransactionsPerSecond)
+	}
+}
+
+func TestLogicalSlotLag(t *testing.T) {
+	testName := "TestLogicalSlotLag"
+	tests := []struct {
+		about     string
+		threshold string
+		degraded  bool
+	}{
+		{"retained WAL above the threshold", "1Gi", true},
+		{"retained WAL below the threshold", "4Gi", false},
+		{"no threshold configured", "", false},
+	}
+	for i, tt := range tests {
+		events := &fakeEvents{}
+		cluster := New(Config{OpConfig: config.Config{LogicalSlotMaxRetainedWAL: tt.threshold}},
+			k8sutil.KubernetesClient{EventsGetter: events},
+			spec.Postgresql{
+				ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+				Spec:       spec.PostgresSpec{PostgresqlParam: spec.PostgresqlParam{PgVersion: "10"}},
+			}, logger)
+		db, _ := openFakeDB(t, fmt.Sprintf("logical-slots-%d", i),
+			[]driver.Value{"cdc", "orders", false, int64(3 << 30), int64(2 << 30)},
+			[]driver.Value{"audit", "orders", true, int64(1 << 20), int64(0)})
+		cluster.pgDb = db
+
+		if degraded := cluster.checkLogicalSlots(); degraded != tt.degraded {
+			t.Errorf("%s %s: expected degraded to be %t, got %t", testName, tt.about, tt.degraded, degraded)
+		}
+		slots := cluster.GetStatus().LogicalSlots
+		if len(slots) != 2 || slots[0].Name != "cdc" || slots[0].RetainedWALBytes != 3<<30 || slots[0].LagBytes != 2<<30 {
+			t.Errorf("%s %s: expected the retained WAL and the lag of the slots in the status, got %#v",
+				testName, tt.about, slots)
+		}
+		if tt.degraded && (len(events.created) != 1 || !strings.Contains(events.created[0].Message, `"cdc" (3Gi retained)`)) {
+			t.Errorf("%s %s: expected an event naming the lagging slot, got %#v", testName, tt.about, events.created)
+		}
+		if !tt.degraded && len(events.created) != 0 {
+			t.Errorf("%s %s: expected no events, got %#v", testName, tt.about, events.created)
+		}
+	}
+}
+
+func TestBuildReplicationStatus(t *testing.T) {
+	testName := "TestBuildReplicationStatus"
+	now := time.Now()
+	db, _ := openFakeDB(t, "replication-stats",
+		[]driver.Value{"acid-test-1", "streaming", "sync"},
+		[]driver.Value{"acid-test-2", "streaming", "async"})
+	stats, err := readReplicationStats(db)
+	if err != nil {
+		t.Fatalf("%s: could not read replication statistics: %v", testName, err)
+	}
+
+	status := buildReplicationStatus(stats, 4, 2, now)
+	expected := &spec.ReplicationStatus{Healthy: true, ConnectedReplicas: 2, SynchronousStandbys: 1, Timeline: 4,
+		CollectedAt: now}
+	if *status != *expected {
+		t.Errorf("%s: expected %#v, got %#v", testName, expected, status)
+	}
+
+	// a replica that is still catching up does not count as connected
+	stats[1].state = "catchup"
+	if status := buildReplicationStatus(stats, 4, 2, now); status.Healthy || status.ConnectedReplicas != 1 {
+		t.Errorf("%s: expected the replication with a catching up replica to be unhealthy, got %#v", testName, status)
+	}
+}
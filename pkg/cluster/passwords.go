@@ -0,0 +1,404 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+const (
+	// roles of the same name are created each day by the password rotation with the rotation-user mode
+	rotationUserSuffixFormat = "060102"
+	// Postgres truncates longer names, the rotation roles of different roles could clash then
+	maxRoleNameLength = 63
+	getMemberRolesSQL = `SELECT m.rolname FROM pg_auth_members a
+		JOIN pg_roles m ON m.oid = a.member JOIN pg_roles r ON r.oid = a.roleid
+		WHERE r.rolname = $1 ORDER BY 1;`
+)
+
+// rotationUserName returns the name of the role holding the credentials of the role from the day of the rotation on.
+func rotationUserName(name string, now time.Time) string {
+	return name + "_" + now.Format(rotationUserSuffixFormat)
+}
+
+// isRotationUserOf tells whether the role has been created by the rotation of the credentials of the base role.
+func isRotationUserOf(role, base string) bool {
+	if !strings.HasPrefix(role, base+"_") {
+		return false
+	}
+	suffix := strings.TrimPrefix(role, base+"_")
+	if len(suffix) != len(rotationUserSuffixFormat) {
+		return false
+	}
+	_, err := time.Parse(rotationUserSuffixFormat, suffix)
+	return err == nil
+}
+
+// secretHoldsRole tells whether the secret holds the credentials of the role itself or of one of its rotation roles.
+func secretHoldsRole(secret *v1.Secret, name string) bool {
+	username := string(secret.Data["username"])
+	return username == name || isRotationUserOf(username, name)
+}
+
+// keepRotatedPasswords returns the users to sync, with the roles whose secrets hold a rotation role keeping the
+// password they have in the database: the password the operator knows for them belongs to no one.
+func (c *Cluster) keepRotatedPasswords(dbUsers spec.PgUserMap) spec.PgUserMap {
+	if len(c.rotatedSecrets) == 0 {
+		return c.pgUsers
+	}
+	result := make(spec.PgUserMap, len(c.pgUsers))
+	for name, user := range c.pgUsers {
+		if dbUser, ok := dbUsers[name]; ok && c.rotatedSecrets[name] {
+			user.Password = dbUser.Password
+		}
+		result[name] = user
+	}
+	return result
+}
+
+// passwordRotationDue tells whether the password in the secret is older than the rotation interval of the operator.
+// The secrets without the rotation annotation count from their creation.
+func (c *Cluster) passwordRotationDue(secret *v1.Secret, now time.Time) bool {
+	interval := time.Duration(c.OpConfig.PasswordRotationIntervalDays) * 24 * time.Hour
+	if interval == 0 {
+		return false
+	}
+	rotatedAt := secret.CreationTimestamp.Time
+	if value, ok := secret.Annotations[constants.PasswordRotatedAtAnnotation]; ok {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			rotatedAt = t
+		} else {
+			c.logger.Warningf("could not parse the %s annotation of secret %q: %v",
+				constants.PasswordRotatedAtAnnotation, secret.Name, err)
+		}
+	}
+	return now.Sub(rotatedAt) >= interval
+}
+
+// rotatedRoles returns the sorted names of the roles whose passwords are subject to the rotation: the roles defined
+// in the manifest. The system roles are shared with Patroni and the other roles get their passwords from elsewhere.
+func rotatedRoles(users spec.PgUserMap) []string {
+	var result []string
+	for name, user := range users {
+		if user.Origin == spec.RoleOriginManifest && user.Password != "" {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// rotatePasswords gives the roles from the manifest new passwords once their current ones are older than the
+// rotation interval.
+func (c *Cluster) rotatePasswords() error {
+	c.setProcessName("rotating passwords")
+
+	var names []string
+	for _, name := range rotatedRoles(c.pgUsers) {
+		// the passwords from the secrets provisioned outside of the operator are rotated by their owners
+		if _, ok := c.Spec.UserSecrets[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	if err := c.initDbConn(); err != nil {
+		return fmt.Errorf("could not init db connection: %v", err)
+	}
+	defer func() {
+		if err := c.closeDbConn(); err != nil {
+			c.logger.Errorf("could not close db connection: %v", err)
+		}
+	}()
+
+	now := time.Now()
+	var failures []string
+	for _, name := range names {
+		if err := c.rotatePassword(name, now); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// rotatePassword hands out new credentials for the role once the ones in its secret are due, according to the
+// password_rotation_mode of the operator.
+func (c *Cluster) rotatePassword(name string, now time.Time) error {
+	secret, err := c.KubeClient.Secrets(c.credentialSecretNamespace(name)).Get(c.credentialSecretName(name),
+		metav1.GetOptions{})
+	if k8sutil.ResourceNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get secret of role %q: %v", name, err)
+	}
+	if !secretHoldsRole(secret, name) {
+		c.logger.Warningf("secret %q does not contain the role %q", secret.Name, name)
+		return nil
+	}
+	if !c.passwordRotationDue(secret, now) {
+		return nil
+	}
+
+	if c.OpConfig.PasswordRotationMode == config.PasswordRotationModeRotationUser {
+		err = c.rotateUser(c.pgUsers[name], secret, now)
+	} else if err = c.rotatePasswordInPlace(c.pgUsers[name], secret, now); err == nil {
+		user := c.pgUsers[name]
+		user.Password = string(secret.Data["password"])
+		c.pgUsers[name] = user
+	}
+	if err != nil {
+		return err
+	}
+
+	c.logger.Infof("credentials of role %q have been rotated, the secret holds the role %q",
+		name, secret.Data["username"])
+	c.createEvent(v1.EventTypeNormal, eventReasonPasswordRotated,
+		fmt.Sprintf("credentials of role %q have been rotated, the new ones are in secret %q", name, secret.Name))
+
+	return nil
+}
+
+// rotatePasswordInPlace changes the password of the role in the database first and then in its secret. The secret is
+// updated against the version just read, should that fail the role gets its previous password back, so that the
+// secret keeps working for the applications either way.
+func (c *Cluster) rotatePasswordInPlace(user spec.PgUser, secret *v1.Secret, now time.Time) error {
+	oldPassword := string(secret.Data["password"])
+	newPassword := util.RandomPassword(constants.PasswordLength)
+	if err := c.setRolePassword(user, newPassword); err != nil {
+		return fmt.Errorf("could not change password of role %q: %v", user.Name, err)
+	}
+
+	if err := c.updateRotatedSecret(secret, user.Name, user.Name, newPassword, now); err != nil {
+		if revertErr := c.setRolePassword(user, oldPassword); revertErr != nil {
+			return fmt.Errorf("%v, could not restore the previous password: %v", err, revertErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// rotateUser creates a new login role inheriting from the role, named after the role and the day of the rotation,
+// and puts its credentials into the secret. The role the secret held so far keeps working until the next rotation, so
+// that the applications pick up the new credentials without an outage; the rotation roles before it are dropped.
+func (c *Cluster) rotateUser(user spec.PgUser, secret *v1.Secret, now time.Time) error {
+	previous := string(secret.Data["username"])
+	rotationUser := spec.PgUser{
+		Name:     rotationUserName(user.Name, now),
+		Origin:   user.Origin,
+		Password: util.RandomPassword(constants.PasswordLength),
+		Flags:    []string{constants.RoleFlagLogin},
+		MemberOf: []string{user.Name},
+		// the limit applies to each role on its own, the applications connect as the rotation role
+		ConnectionLimit: user.ConnectionLimit,
+	}
+	if len(rotationUser.Name) > maxRoleNameLength {
+		return fmt.Errorf("could not rotate role %q: name of the rotation role %q is longer than %d characters",
+			user.Name, rotationUser.Name, maxRoleNameLength)
+	}
+	if rotationUser.Name == previous {
+		return nil
+	}
+
+	// the rotation role is left over by a rotation whose secret update has failed, it gets the new password instead
+	dbUsers, err := c.readPgUsersFromDatabase([]string{rotationUser.Name})
+	if err != nil {
+		return fmt.Errorf("error getting users from the database: %v", err)
+	}
+	request := spec.PgSyncUserRequest{Kind: spec.PGSyncUserAdd, User: rotationUser}
+	if _, ok := dbUsers[rotationUser.Name]; ok {
+		request.Kind = spec.PGsyncUserAlter
+	}
+	if err := c.userSyncStrategy.ExecuteSyncRequests([]spec.PgSyncUserRequest{request}, c.pgDb); err != nil {
+		return fmt.Errorf("could not create rotation role of role %q: %v", user.Name, err)
+	}
+
+	if err := c.updateRotatedSecret(secret, user.Name, rotationUser.Name, rotationUser.Password, now); err != nil {
+		return err
+	}
+
+	if err := c.dropRotationUsers(user.Name, rotationUser.Name, previous); err != nil {
+		c.logger.Warningf("could not drop old rotation roles of role %q: %v", user.Name, err)
+	}
+	return nil
+}
+
+// updateRotatedSecret puts the new credentials of the role into its secret, the update fails if the secret has
+// changed since it was read.
+func (c *Cluster) updateRotatedSecret(secret *v1.Secret, role, username, password string, now time.Time) error {
+	secret.Data["username"] = []byte(username)
+	secret.Data["password"] = []byte(password)
+	c.applySecretTemplate(secret, role)
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[constants.PasswordRotatedAtAnnotation] = now.UTC().Format(time.RFC3339)
+	if _, err := c.KubeClient.Secrets(secret.Namespace).Update(secret); err != nil {
+		return fmt.Errorf("could not update secret %q: %v", secret.Name, err)
+	}
+	// the secret stays authoritative, the next sync writes the credentials again
+	if err := c.writeStoredCredentials(role, username, password); err != nil {
+		c.logger.Warningf("could not store the rotated credentials: %v", err)
+	}
+	return nil
+}
+
+// getRotationUsers returns the rotation roles of the role in the database.
+// The caller is responsible for opening and closing the database connection.
+func (c *Cluster) getRotationUsers(name string) ([]string, error) {
+	rows, err := c.pgDb.Query(getMemberRolesSQL, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not query members of role %q: %v", name, c.describeStatementError(err))
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return nil, fmt.Errorf("error when processing row: %v", err)
+		}
+		if isRotationUserOf(member, name) {
+			result = append(result, member)
+		}
+	}
+
+	return result, rows.Err()
+}
+
+// dropRotationUsers drops the rotation roles of the role except for the ones given. A rotation role owning objects
+// cannot be dropped and is reported.
+func (c *Cluster) dropRotationUsers(name string, keep ...string) error {
+	members, err := c.getRotationUsers(name)
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool, len(keep))
+	for _, name := range keep {
+		kept[name] = true
+	}
+	var requests []spec.PgSyncUserRequest
+	for _, member := range members {
+		if !kept[member] {
+			requests = append(requests, spec.PgSyncUserRequest{
+				Kind: spec.PGSyncUserDrop,
+				User: spec.PgUser{Name: member, Origin: spec.RoleOriginManifest},
+			})
+		}
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	return c.userSyncStrategy.ExecuteSyncRequests(requests, c.pgDb)
+}
+
+// setRolePassword changes the password of the role in the database.
+// The caller is responsible for opening and closing the database connection.
+func (c *Cluster) setRolePassword(user spec.PgUser, password string) error {
+	request := spec.PgSyncUserRequest{
+		Kind: spec.PGsyncUserAlter,
+		User: spec.PgUser{Name: user.Name, Origin: user.Origin, Password: password},
+	}
+	return c.userSyncStrategy.ExecuteSyncRequests([]spec.PgSyncUserRequest{request}, c.pgDb)
+}
+
+// systemPasswordsRotationRequest returns the value of the annotation of the manifest requesting the rotation of the
+// passwords of the system users, empty if there is none.
+func (c *Cluster) systemPasswordsRotationRequest() string {
+	return c.ObjectMeta.Annotations[constants.RotateSystemPasswordsAnnotation]
+}
+
+// systemRotationUsers returns the keys of the system users in the order of their rotation: the superuser comes last,
+// since the operator connects as the superuser to change the passwords.
+func systemRotationUsers() []string {
+	return []string{constants.ReplicationUserKeyName, constants.SuperuserKeyName}
+}
+
+// rotateSystemPasswords gives the superuser and the replication user new passwords once the manifest requests it
+// with a value of the annotation not handled yet. The passwords change in the database and in the secrets, one user
+// at a time, then the pods are recreated in the order of a rolling update: Patroni reads the passwords from the
+// environment when it starts, so that is what makes it pick up the new ones. The rolling update flag of the
+// statefulset makes the next sync finish the rolling update if it fails here.
+func (c *Cluster) rotateSystemPasswords() error {
+	request := c.systemPasswordsRotationRequest()
+	if request == "" {
+		return nil
+	}
+	c.setProcessName("rotating passwords of the system users")
+
+	secrets := make(map[string]*v1.Secret)
+	for _, key := range systemRotationUsers() {
+		name := c.systemUsers[key].Name
+		secret, err := c.KubeClient.Secrets(c.Namespace).Get(c.credentialSecretName(name), metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("could not get secret of role %q: %v", name, err)
+		}
+		if secret.Annotations[constants.RotateSystemPasswordsAnnotation] != request {
+			secrets[key] = secret
+		}
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	if err := c.initDbConn(); err != nil {
+		return fmt.Errorf("could not init db connection: %v", err)
+	}
+	defer func() {
+		if err := c.closeDbConn(); err != nil {
+			c.logger.Errorf("could not close db connection: %v", err)
+		}
+	}()
+
+	now := time.Now()
+	for _, key := range systemRotationUsers() {
+		secret, ok := secrets[key]
+		if !ok {
+			continue
+		}
+		user := c.systemUsers[key]
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[constants.RotateSystemPasswordsAnnotation] = request
+		if err := c.rotatePasswordInPlace(user, secret, now); err != nil {
+			return err
+		}
+		user.Password = string(secret.Data["password"])
+		c.systemUsers[key] = user
+		c.logger.Infof("password of system role %q has been rotated", user.Name)
+	}
+	c.createEvent(v1.EventTypeNormal, eventReasonPasswordRotated,
+		"passwords of the system users have been rotated, the pods are going to be recreated")
+
+	if err := c.applyRollingUpdateFlagforStatefulSet(true); err != nil {
+		return fmt.Errorf("could not set rolling update flag for the statefulset: %v", err)
+	}
+	if err := c.recreatePods(); err != nil {
+		return fmt.Errorf("could not recreate pods: %v", err)
+	}
+	c.logger.Infof("pods have been recreated with the new passwords of the system users")
+	if err := c.applyRollingUpdateFlagforStatefulSet(false); err != nil {
+		c.logger.Warningf("could not clear rolling update for the statefulset: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+func TestPasswordRotationDue(t *testing.T) {
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	secret := func(created time.Time, annotations map[string]string) *v1.Secret {
+		return &v1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:              "app.acid-test.credentials",
+			CreationTimestamp: metav1.NewTime(created),
+			Annotations:       annotations,
+		}}
+	}
+	rotatedAt := func(t time.Time) map[string]string {
+		return map[string]string{constants.PasswordRotatedAtAnnotation: t.Format(time.RFC3339)}
+	}
+	tests := []struct {
+		about    string
+		interval uint32
+		secret   *v1.Secret
+		due      bool
+	}{
+		{"rotation disabled", 0, secret(now.AddDate(-1, 0, 0), nil), false},
+		{"new secret", 30, secret(now.AddDate(0, 0, -1), nil), false},
+		{"old secret", 30, secret(now.AddDate(0, 0, -30), nil), true},
+		{"rotated recently", 30, secret(now.AddDate(-1, 0, 0), rotatedAt(now.AddDate(0, 0, -29))), false},
+		{"rotated long ago", 30, secret(now.AddDate(-1, 0, 0), rotatedAt(now.AddDate(0, 0, -31))), true},
+		{"invalid annotation", 30,
+			secret(now.AddDate(0, 0, -1), map[string]string{constants.PasswordRotatedAtAnnotation: "yesterday"}), false},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{PasswordRotationIntervalDays: tt.interval}},
+			k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+		if due := cluster.passwordRotationDue(tt.secret, now); due != tt.due {
+			t.Errorf("TestPasswordRotationDue %s: expected due %t, got %t", tt.about, tt.due, due)
+		}
+	}
+}
+
+func TestRotatedRoles(t *testing.T) {
+	users := spec.PgUserMap{
+		"app":      {Name: "app", Password: "secret", Origin: spec.RoleOriginManifest},
+		"admin":    {Name: "admin", Password: "secret", Origin: spec.RoleOriginManifest},
+		"nologin":  {Name: "nologin", Origin: spec.RoleOriginManifest},
+		"robot":    {Name: "robot", Password: "secret", Origin: spec.RoleOriginInfrastructure},
+		"postgres": {Name: "postgres", Password: "secret", Origin: spec.RoleOriginSystem},
+		"jdoe":     {Name: "jdoe", Origin: spec.RoleOriginTeamsAPI},
+	}
+	expected := []string{"admin", "app"}
+	if result := rotatedRoles(users); !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestRotatedRoles: expected %v, got %v", expected, result)
+	}
+}
+
+func TestKeepRotatedPasswords(t *testing.T) {
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+	cluster.pgUsers = spec.PgUserMap{
+		"app":   {Name: "app", Password: "generated", Origin: spec.RoleOriginManifest},
+		"other": {Name: "other", Password: "from secret", Origin: spec.RoleOriginManifest},
+		"new":   {Name: "new", Password: "generated", Origin: spec.RoleOriginManifest},
+	}
+	cluster.rotatedSecrets = map[string]bool{"app": true, "new": true}
+	dbUsers := spec.PgUserMap{
+		"app":   {Name: "app", Password: "md5abcdef"},
+		"other": {Name: "other", Password: "md5123456"},
+	}
+
+	expected := spec.PgUserMap{
+		"app":   {Name: "app", Password: "md5abcdef", Origin: spec.RoleOriginManifest},
+		"other": {Name: "other", Password: "from secret", Origin: spec.RoleOriginManifest},
+		"new":   {Name: "new", Password: "generated", Origin: spec.RoleOriginManifest},
+	}
+	if result := cluster.keepRotatedPasswords(dbUsers); !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestKeepRotatedPasswords: expected %#v, got %#v", expected, result)
+	}
+	if cluster.pgUsers["app"].Password != "generated" {
+		t.Errorf("TestKeepRotatedPasswords: expected the users of the cluster to be left alone")
+	}
+}
+
+func TestRotationUsers(t *testing.T) {
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	if name := rotationUserName("app", now); name != "app_181015" {
+		t.Errorf("TestRotationUsers: expected rotation role %q, got %q", "app_181015", name)
+	}
+
+	tests := []struct {
+		role     string
+		base     string
+		expected bool
+	}{
+		{"app_181015", "app", true},
+		{"app", "app", false},
+		{"app_reader", "app", false},
+		{"app_181315", "app", false},
+		{"app_18101", "app", false},
+		{"app_v2_181015", "app", false},
+		{"app_v2_181015", "app_v2", true},
+	}
+	for _, tt := range tests {
+		if result := isRotationUserOf(tt.role, tt.base); result != tt.expected {
+			t.Errorf("TestRotationUsers %s of %s: expected %t, got %t", tt.role, tt.base, tt.expected, result)
+		}
+		secret := &v1.Secret{Data: map[string][]byte{"username": []byte(tt.role)}}
+		if result := secretHoldsRole(secret, tt.base); result != (tt.expected || tt.role == tt.base) {
+			t.Errorf("TestRotationUsers secret of %s holding %s: got %t", tt.base, tt.role, result)
+		}
+	}
+}
+
+// fakeSystemSecrets holds the secrets of the system users, annotated with the rotation request they have handled.
+type fakeSystemSecrets struct {
+	v1core.SecretInterface
+	handled string
+	gets    int
+}
+
+func (s *fakeSystemSecrets) Secrets(namespace string) v1core.SecretInterface {
+	return s
+}
+
+func (s *fakeSystemSecrets) Get(name string, options metav1.GetOptions) (*v1.Secret, error) {
+	s.gets++
+	return &v1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Annotations: map[string]string{constants.RotateSystemPasswordsAnnotation: s.handled},
+	}}, nil
+}
+
+func TestRotateSystemPasswordsHandled(t *testing.T) {
+	testName := "TestRotateSystemPasswordsHandled"
+	order := systemRotationUsers()
+	if order[len(order)-1] != constants.SuperuserKeyName {
+		t.Errorf("%s: expected the superuser to be rotated last, got %v", testName, order)
+	}
+
+	tests := []struct {
+		about   string
+		request string
+		gets    int
+	}{
+		{"no request", "", 0},
+		{"handled request", "2018-10-15", 2},
+	}
+	for _, tt := range tests {
+		secrets := &fakeSystemSecrets{handled: "2018-10-15"}
+		cluster := New(Config{OpConfig: config.Config{SuperUsername: "postgres", ReplicationUsername: "standby"}},
+			k8sutil.KubernetesClient{SecretsGetter: secrets}, spec.Postgresql{ObjectMeta: metav1.ObjectMeta{
+				Name:        "acid-test",
+				Namespace:   "test",
+				Annotations: map[string]string{constants.RotateSystemPasswordsAnnotation: tt.request},
+			}}, logger)
+		cluster.initSystemUsers()
+		passwords := map[string]string{}
+		for key, user := range cluster.systemUsers {
+			passwords[key] = user.Password
+		}
+
+		// a handled request needs no database connection, which the test does not have
+		if err := cluster.rotateSystemPasswords(); err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, tt.about, err)
+		}
+		if secrets.gets != tt.gets {
+			t.Errorf("%s %s: expected %d secrets read, got %d", testName, tt.about, tt.gets, secrets.gets)
+		}
+		for key, user := range cluster.systemUsers {
+			if user.Password != passwords[key] {
+				t.Errorf("%s %s: password of %q has changed", testName, tt.about, user.Name)
+			}
+		}
+	}
+}
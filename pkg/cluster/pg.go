@@ -4,40 +4,76 @@ import (
 	"database/sql"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/lib/pq"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/retryutil"
 )
 
 const (
+	// the BYPASSRLS attribute is selected as %s, it only exists from PostgreSQL 9.5 on
 	getUserSQL = `SELECT a.rolname, COALESCE(a.rolpassword, ''), a.rolsuper, a.rolinherit,
-	        a.rolcreaterole, a.rolcreatedb, a.rolcanlogin, s.setconfig,
+	        a.rolcreaterole, a.rolcreatedb, a.rolcanlogin, a.rolreplication, %s, a.rolconnlimit, s.setconfig,
 	        ARRAY(SELECT b.rolname
 	              FROM pg_catalog.pg_auth_members m
 	              JOIN pg_catalog.pg_authid b ON (m.roleid = b.oid)
-	             WHERE m.member = a.oid) as memberof
+	             WHERE m.member = a.oid) as memberof,
+	        ARRAY(SELECT b.rolname
+	              FROM pg_catalog.pg_auth_members m
+	              JOIN pg_catalog.pg_authid b ON (m.roleid = b.oid)
+	             WHERE m.member = a.oid AND m.admin_option) as adminof
 	 FROM pg_catalog.pg_authid a LEFT JOIN pg_db_role_setting s ON (a.oid = s.setrole AND s.setdatabase = 0::oid)
 	 WHERE a.rolname = ANY($1)
 	 ORDER BY 1;`
 
+	getServerVersionNumSQL = `SHOW server_version_num;`
+	isInRecoverySQL        = `SELECT pg_is_in_recovery();`
+	bypassRLSVersionNum    = 90500
+
 	getDatabasesSQL       = `SELECT datname, pg_get_userbyid(datdba) AS owner FROM pg_database;`
 	createDatabaseSQL     = `CREATE DATABASE "%s" OWNER "%s";`
 	alterDatabaseOwnerSQL = `ALTER DATABASE "%s" OWNER TO "%s";`
 )
 
+// name of the database/sql driver used to connect to Postgres, replaced in tests
+var pgDriverName = "postgres"
+
 func (c *Cluster) pgConnectionString() string {
+	return c.pgServiceConnectionString(Master)
+}
+
+func (c *Cluster) pgServiceConnectionString(role PostgresRole) string {
+	return c.pgDatabaseConnectionString(role, "postgres")
+}
+
+func (c *Cluster) pgDatabaseConnectionString(role PostgresRole, dbname string) string {
 	password := c.systemUsers[constants.SuperuserKeyName].Password
 
-	return fmt.Sprintf("host='%s' dbname=postgres sslmode=require user='%s' password='%s' connect_timeout='%d'",
-		fmt.Sprintf("%s.%s.svc.cluster.local", c.Name, c.Namespace),
+	// the connection is not kept open between statements, so a SET statement_timeout would not survive;
+	// lib/pq passes unknown parameters of the connection string to the server as session settings instead.
+	return fmt.Sprintf("host='%s' dbname='%s' sslmode=require user='%s' password='%s' connect_timeout='%d' statement_timeout='%d'",
+		fmt.Sprintf("%s.%s.svc.cluster.local", c.serviceName(role), c.Namespace),
+		dbname,
 		c.systemUsers[constants.SuperuserKeyName].Name,
 		strings.Replace(password, "$", "\\$", -1),
-		constants.PostgresConnectTimeout/time.Second)
+		constants.PostgresConnectTimeout/time.Second,
+		c.OpConfig.PgStatementTimeout/time.Millisecond)
+}
+
+// describeStatementError points out statements cancelled due to the statement timeout, so that they are
+// not confused with errors in the statement itself.
+func (c *Cluster) describeStatementError(err error) error {
+	if util.IsStatementTimeout(err) {
+		return fmt.Errorf("statement timeout of %v exceeded: %v", c.OpConfig.PgStatementTimeout, err)
+	}
+	return err
 }
 
 func (c *Cluster) databaseAccessDisabled() bool {
@@ -48,6 +84,12 @@ func (c *Cluster) databaseAccessDisabled() bool {
 	return !c.OpConfig.EnableDBAccess
 }
 
+// isStandbyCluster tells whether the cluster replays the WAL of another one. Its databases are read-only and get
+// the roles and the databases from the source, so the operator leaves them alone.
+func (c *Cluster) isStandbyCluster() bool {
+	return c.Spec.StandbyCluster != nil
+}
+
 func (c *Cluster) initDbConn() error {
 	c.setProcessName("initializing db connection")
 	if c.pgDb != nil {
@@ -58,28 +100,9 @@ func (c *Cluster) initDbConn() error {
 	connstring := c.pgConnectionString()
 
 	finalerr := retryutil.Retry(constants.PostgresConnectTimeout, constants.PostgresConnectRetryTimeout,
-		func() (bool, error) {
-			var err error
-			conn, err = sql.Open("postgres", connstring)
-			if err == nil {
-				err = conn.Ping()
-			}
-
-			if err == nil {
-				return true, nil
-			}
-
-			if _, ok := err.(*net.OpError); ok {
-				c.logger.Errorf("could not connect to PostgreSQL database: %v", err)
-				return false, nil
-			}
-
-			if err2 := conn.Close(); err2 != nil {
-				c.logger.Errorf("error when closing PostgreSQL connection after another error: %v", err)
-				return false, err2
-			}
-
-			return false, err
+		func() (done bool, err error) {
+			conn, done, err = c.tryDbConn(connstring)
+			return
 		})
 
 	if finalerr != nil {
@@ -94,6 +117,137 @@ func (c *Cluster) initDbConn() error {
 	return nil
 }
 
+// tryDbConn makes a single attempt to connect to the database. Errors that may go away, i.e. network errors or
+// landing on a replica, are logged and reported as not done, so that the caller retries.
+func (c *Cluster) tryDbConn(connstring string) (*sql.DB, bool, error) {
+	conn, err := sql.Open(pgDriverName, connstring)
+	if err == nil {
+		err = conn.Ping()
+	}
+	if err == nil {
+		var inRecovery bool
+		if inRecovery, err = c.sessionInRecovery(conn); err == nil && inRecovery {
+			// the service may still point to the former master after a failover, the next attempt re-resolves it
+			c.logger.Warningf("connected to a replica instead of the master, retrying")
+			if err := conn.Close(); err != nil {
+				c.logger.Errorf("could not close the connection to the replica: %v", err)
+			}
+			return nil, false, nil
+		}
+	}
+
+	if err == nil {
+		return conn, true, nil
+	}
+
+	if _, ok := err.(*net.OpError); ok {
+		c.logger.Errorf("could not connect to PostgreSQL database: %v", err)
+		return nil, false, nil
+	}
+
+	if err2 := conn.Close(); err2 != nil {
+		c.logger.Errorf("error when closing PostgreSQL connection after another error: %v", err)
+		return nil, false, err2
+	}
+
+	return nil, false, err
+}
+
+// sessionInRecovery tells whether the connection landed on a server in recovery, i.e. a replica, that cannot
+// serve the read-write session the operator needs. lib/pq does not implement the target_session_attrs
+// parameter of libpq, so the operator checks it on its own.
+func (c *Cluster) sessionInRecovery(conn *sql.DB) (bool, error) {
+	if c.OpConfig.PgTargetSessionAttrs != config.TargetSessionAttrsReadWrite {
+		return false, nil
+	}
+
+	var inRecovery bool
+	if err := conn.QueryRow(isInRecoverySQL).Scan(&inRecovery); err != nil {
+		return false, fmt.Errorf("could not check whether the server is in recovery: %v", err)
+	}
+
+	return inRecovery, nil
+}
+
+// initReadDbConn opens the connection for the read-only queries. With replicaReads it goes to the replica service
+// and falls back to the master connection when no replica accepts it; the statements changing anything must
+// always use the master connection of initDbConn.
+func (c *Cluster) initReadDbConn() error {
+	c.setProcessName("initializing read-only db connection")
+	if c.pgReadDb != nil {
+		return nil
+	}
+
+	if c.Spec.ReplicaReads {
+		conn, err := c.openReplicaDbConn()
+		if err == nil {
+			c.pgReadDb = conn
+			return nil
+		}
+		c.logger.Warningf("could not connect to a replica, running the read-only queries on the master: %v", err)
+	}
+	if err := c.initDbConn(); err != nil {
+		return err
+	}
+	c.pgReadDb = c.pgDb
+
+	return nil
+}
+
+// openDatabaseConn opens a connection to the given database on the master, for the statements that only apply to the
+// database they run in. The caller is responsible for closing it.
+func (c *Cluster) openDatabaseConn(dbname string) (*sql.DB, error) {
+	var conn *sql.DB
+	connstring := c.pgDatabaseConnectionString(Master, dbname)
+	err := retryutil.Retry(constants.PostgresConnectTimeout, constants.PostgresConnectRetryTimeout,
+		func() (done bool, err error) {
+			conn, done, err = c.tryDbConn(connstring)
+			return
+		})
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to database %q: %v", dbname, err)
+	}
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(-1)
+
+	return conn, nil
+}
+
+// openReplicaDbConn makes a single attempt only, a missing replica should not delay the fallback to the master.
+func (c *Cluster) openReplicaDbConn() (*sql.DB, error) {
+	conn, err := sql.Open(pgDriverName, c.pgServiceConnectionString(Replica))
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		if err2 := conn.Close(); err2 != nil {
+			c.logger.Errorf("could not close the connection to the replica: %v", err2)
+		}
+		return nil, err
+	}
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(-1)
+
+	return conn, nil
+}
+
+func (c *Cluster) closeReadDbConn() error {
+	if c.pgReadDb == nil {
+		c.logger.Warning("attempted to close an empty read-only db connection object")
+		return nil
+	}
+	if c.pgReadDb == c.pgDb {
+		c.pgReadDb = nil
+		return c.closeDbConn()
+	}
+
+	c.logger.Debug("closing read-only database connection")
+	err := c.pgReadDb.Close()
+	c.pgReadDb = nil
+
+	return err
+}
+
 func (c *Cluster) closeDbConn() (err error) {
 	c.setProcessName("closing db connection")
 	if c.pgDb != nil {
@@ -111,10 +265,16 @@ func (c *Cluster) closeDbConn() (err error) {
 
 func (c *Cluster) readPgUsersFromDatabase(userNames []string) (users spec.PgUserMap, err error) {
 	c.setProcessName("reading users from the db")
-	var rows *sql.Rows
+	var (
+		rows       *sql.Rows
+		versionNum int
+	)
 	users = make(spec.PgUserMap)
-	if rows, err = c.pgDb.Query(getUserSQL, pq.Array(userNames)); err != nil {
-		return nil, fmt.Errorf("error when querying users: %v", err)
+	if err = c.pgDb.QueryRow(getServerVersionNumSQL).Scan(&versionNum); err != nil {
+		return nil, fmt.Errorf("could not query server version: %v", c.describeStatementError(err))
+	}
+	if rows, err = c.pgDb.Query(userQuery(versionNum), pq.Array(userNames)); err != nil {
+		return nil, fmt.Errorf("error when querying users: %v", c.describeStatementError(err))
 	}
 	defer func() {
 		if err2 := rows.Close(); err2 != nil {
@@ -126,14 +286,17 @@ func (c *Cluster) readPgUsersFromDatabase(userNames []string) (users spec.PgUser
 		var (
 			rolname, rolpassword                                          string
 			rolsuper, rolinherit, rolcreaterole, rolcreatedb, rolcanlogin bool
-			roloptions, memberof                                          []string
+			rolreplication, rolbypassrls                                  bool
+			rolconnlimit                                                  int
+			roloptions, memberof, adminof                                 []string
 		)
-		err := rows.Scan(&rolname, &rolpassword, &rolsuper, &rolinherit,
-			&rolcreaterole, &rolcreatedb, &rolcanlogin, pq.Array(&roloptions), pq.Array(&memberof))
+		err := rows.Scan(&rolname, &rolpassword, &rolsuper, &rolinherit, &rolcreaterole, &rolcreatedb, &rolcanlogin,
+			&rolreplication, &rolbypassrls, &rolconnlimit, pq.Array(&roloptions), pq.Array(&memberof),
+			pq.Array(&adminof))
 		if err != nil {
 			return nil, fmt.Errorf("error when processing user rows: %v", err)
 		}
-		flags := makeUserFlags(rolsuper, rolinherit, rolcreaterole, rolcreatedb, rolcanlogin)
+		flags := makeUserFlags(rolsuper, rolinherit, rolcreaterole, rolcreatedb, rolcanlogin, rolreplication, rolbypassrls)
 		// XXX: the code assumes the password we get from pg_authid is always MD5
 		parameters := make(map[string]string)
 		for _, option := range roloptions {
@@ -145,12 +308,48 @@ func (c *Cluster) readPgUsersFromDatabase(userNames []string) (users spec.PgUser
 			parameters[fields[0]] = fields[1]
 		}
 
-		users[rolname] = spec.PgUser{Name: rolname, Password: rolpassword, Flags: flags, MemberOf: memberof, Parameters: parameters}
+		users[rolname] = spec.PgUser{Name: rolname, Password: rolpassword, Flags: flags, MemberOf: memberof,
+			AdminOf: adminof, Parameters: parameters, ConnectionLimit: &rolconnlimit}
 	}
 
 	return users, nil
 }
 
+// userQuery returns the query reading the roles from a server of the given server_version_num, the roles of the
+// servers older than 9.5 cannot bypass the row level security.
+func userQuery(versionNum int) string {
+	if versionNum < bypassRLSVersionNum {
+		return fmt.Sprintf(getUserSQL, "false")
+	}
+	return fmt.Sprintf(getUserSQL, "a.rolbypassrls")
+}
+
+// getRunningPgVersion returns the major version of the running postgres server, i.e. 9.6 or 10.
+// The caller is responsible for opening and closing the read-only database connection.
+func (c *Cluster) getRunningPgVersion() (string, error) {
+	var versionNum string
+
+	if err := c.pgReadDb.QueryRow(getServerVersionNumSQL).Scan(&versionNum); err != nil {
+		return "", fmt.Errorf("could not query server version: %v", c.describeStatementError(err))
+	}
+
+	return pgMajorVersionFromVersionNum(versionNum)
+}
+
+// pgMajorVersionFromVersionNum converts server_version_num into the major version string used in the manifest.
+// Starting from PostgreSQL 10 the major version consists of the first component only.
+func pgMajorVersionFromVersionNum(versionNum string) (string, error) {
+	num, err := strconv.Atoi(versionNum)
+	if err != nil {
+		return "", fmt.Errorf("could not parse server version number %q: %v", versionNum, err)
+	}
+	if num >= 100000 {
+		return strconv.Itoa(num / 10000), nil
+	}
+
+	return fmt.Sprintf("%d.%d", num/10000, (num/100)%100), nil
+}
+
 // getDatabases returns the map of current databases with owners
 // The caller is responsible for opening and closing the database connection
 func (c *Cluster) getDatabases() (map[string]string, error) {
@@ -161,7 +360,7 @@ func (c *Cluster) getDatabases() (map[string]string, error) {
 	dbs := make(map[string]string)
 
 	if rows, err = c.pgDb.Query(getDatabasesSQL); err != nil {
-		return nil, fmt.Errorf("could not query database: %v", err)
+		return nil, fmt.Errorf("could not query database: %v", c.describeStatementError(err))
 	}
 
 	defer func() {
@@ -192,7 +391,7 @@ func (c *Cluster) executeCreateDatabase(datname, owner string) error {
 	c.logger.Infof("creating database %q with owner %q", datname, owner)
 
 	if _, err := c.pgDb.Exec(fmt.Sprintf(createDatabaseSQL, datname, owner)); err != nil {
-		return fmt.Errorf("could not execute create database: %v", err)
+		return fmt.Errorf("could not execute create database: %v", c.describeStatementError(err))
 	}
 	return nil
 }
@@ -205,7 +404,7 @@ func (c *Cluster) executeAlterDatabaseOwner(datname string, owner string) error
 	}
 	c.logger.Infof("changing database %q owner to %q", datname, owner)
 	if _, err := c.pgDb.Exec(fmt.Sprintf(alterDatabaseOwnerSQL, datname, owner)); err != nil {
-		return fmt.Errorf("could not execute alter database owner: %v", err)
+		return fmt.Errorf("could not execute alter database owner: %v", c.describeStatementError(err))
 	}
 	return nil
 }
@@ -223,12 +422,16 @@ func (c *Cluster) databaseNameOwnerValid(datname, owner string) bool {
 	return true
 }
 
-func makeUserFlags(rolsuper, rolinherit, rolcreaterole, rolcreatedb, rolcanlogin bool) (result []string) {
+func makeUserFlags(rolsuper, rolinherit, rolcreaterole, rolcreatedb, rolcanlogin, rolreplication,
+	rolbypassrls bool) (result []string) {
 	if rolsuper {
 		result = append(result, constants.RoleFlagSuperuser)
 	}
+	// NOINHERIT is reported as well, otherwise the flag requested in the manifest is never found on the role
 	if rolinherit {
 		result = append(result, constants.RoleFlagInherit)
+	} else {
+		result = append(result, constants.RoleFlagNoInherit)
 	}
 	if rolcreaterole {
 		result = append(result, constants.RoleFlagCreateRole)
@@ -239,6 +442,12 @@ func makeUserFlags(rolsuper, rolinherit, rolcreaterole, rolcreatedb, rolcanlogin
 	if rolcanlogin {
 		result = append(result, constants.RoleFlagLogin)
 	}
+	if rolreplication {
+		result = append(result, constants.RoleFlagReplication)
+	}
+	if rolbypassrls {
+		result = append(result, constants.RoleFlagByPassRLS)
+	}
 
 	return result
 }
@@ -3,12 +3,33 @@ package cluster
 import (
 	"fmt"
 	"math/rand"
+	"sync"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/pkg/api/v1"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
 	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/patroni"
+)
+
+const (
+	podReasonCrashLoopBackOff      = "CrashLoopBackOff"
+	eventReasonDCSQuorumLost       = "DCSQuorumLost"
+	eventReasonChecksumFailed      = "ChecksumFailure"
+	eventReasonSlotLagging         = "LogicalSlotLagging"
+	eventReasonWALVerifyFailed     = "WALVerifyFailure"
+	eventReasonRestoreVerifyFailed = "RestoreVerifyFailure"
+	eventReasonVolumesDeleted      = "VolumesDeleted"
+	eventReasonVolumesRetained     = "VolumesRetained"
+	eventReasonVolumeShrinkRefused = "VolumeShrinkRefused"
+	eventReasonPasswordRotated     = "PasswordRotated"
+	eventReasonInvalidRoleEntries  = "InvalidRoleEntries"
+	// the leader lock expires after the ttl of Patroni, 30 seconds unless the manifest says otherwise
+	defaultPatroniTTL = 30 * time.Second
 )
 
 func (c *Cluster) listPods() ([]v1.Pod, error) {
@@ -199,7 +220,7 @@ func (c *Cluster) MigrateMasterPod(podName spec.NamespacedName) error {
 		return nil
 	}
 
-	if role := PostgresRole(oldMaster.Labels[c.OpConfig.PodRoleLabel]); role != Master {
+	if role := PostgresRole(oldMaster.Labels[c.podRoleLabel()]); role != Master {
 		c.logger.Warningf("pod %q is not a master", podName)
 		return nil
 	}
@@ -257,7 +278,7 @@ func (c *Cluster) MigrateReplicaPod(podName spec.NamespacedName, fromNodeName st
 		return nil
 	}
 
-	if role := PostgresRole(replicaPod.Labels[c.OpConfig.PodRoleLabel]); role != Replica {
+	if role := PostgresRole(replicaPod.Labels[c.podRoleLabel()]); role != Replica {
 		return fmt.Errorf("pod %q is not a replica", podName)
 	}
 
@@ -305,25 +326,31 @@ func (c *Cluster) recreatePods() error {
 	c.logger.Infof("there are %d pods in the cluster to recreate", len(pods.Items))
 
 	var (
-		masterPod, newMasterPod, newPod *v1.Pod
+		masterPod, newMasterPod *v1.Pod
 	)
-	replicas := make([]spec.NamespacedName, 0)
+	replicaPods := make([]spec.NamespacedName, 0)
 	for i, pod := range pods.Items {
-		role := PostgresRole(pod.Labels[c.OpConfig.PodRoleLabel])
+		role := PostgresRole(pod.Labels[c.podRoleLabel()])
 
 		if role == Master {
 			masterPod = &pods.Items[i]
 			continue
 		}
+		replicaPods = append(replicaPods, util.NameFromMeta(pod.ObjectMeta))
+	}
 
-		podName := util.NameFromMeta(pods.Items[i].ObjectMeta)
-		if newPod, err = c.recreatePod(podName); err != nil {
-			return fmt.Errorf("could not recreate replica pod %q: %v", util.NameFromMeta(pod.ObjectMeta), err)
+	replicas := make([]spec.NamespacedName, 0)
+	for _, batch := range podBatches(replicaPods, c.maxUnavailablePods(len(pods.Items))) {
+		newPods, err := c.recreatePodBatch(batch)
+		if err != nil {
+			return err
 		}
-		if newRole := PostgresRole(newPod.Labels[c.OpConfig.PodRoleLabel]); newRole == Replica {
-			replicas = append(replicas, util.NameFromMeta(pod.ObjectMeta))
-		} else if newRole == Master {
-			newMasterPod = newPod
+		for i, newPod := range newPods {
+			if newRole := PostgresRole(newPod.Labels[c.podRoleLabel()]); newRole == Replica {
+				replicas = append(replicas, batch[i])
+			} else if newRole == Master {
+				newMasterPod = newPod
+			}
 		}
 	}
 
@@ -346,6 +373,64 @@ func (c *Cluster) recreatePods() error {
 	return nil
 }
 
+// recreatePodBatch recreates the replica pods concurrently and waits for all of them to come back.
+func (c *Cluster) recreatePodBatch(podNames []spec.NamespacedName) ([]*v1.Pod, error) {
+	var wg sync.WaitGroup
+	newPods := make([]*v1.Pod, len(podNames))
+	errors := make([]error, len(podNames))
+
+	for i := range podNames {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			newPods[i], errors[i] = c.recreatePod(podNames[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errors {
+		if err != nil {
+			return nil, fmt.Errorf("could not recreate replica pod %q: %v", podNames[i], err)
+		}
+	}
+
+	return newPods, nil
+}
+
+// maxUnavailablePods returns how many of the cluster pods may be recreated at the same time, the manifest
+// value takes precedence over the operator one. Percentages are rounded down, but at least one pod is recreated.
+func (c *Cluster) maxUnavailablePods(total int) int {
+	value := intstr.Parse(c.OpConfig.PodMaxUnavailable)
+	if c.Spec.MaxUnavailable != nil {
+		value = *c.Spec.MaxUnavailable
+	}
+	maxUnavailable, err := intstr.GetValueFromIntOrPercent(&value, total, false)
+	if err != nil {
+		c.logger.Warningf("could not get the number of pods to recreate at once, recreating them one by one: %v", err)
+		return 1
+	}
+	if maxUnavailable < 1 {
+		return 1
+	}
+
+	return maxUnavailable
+}
+
+// podBatches splits the pods into consecutive batches of at most size pods.
+func podBatches(pods []spec.NamespacedName, size int) [][]spec.NamespacedName {
+	batches := make([][]spec.NamespacedName, 0)
+	for len(pods) > 0 {
+		n := size
+		if n > len(pods) {
+			n = len(pods)
+		}
+		batches = append(batches, pods[:n])
+		pods = pods[n:]
+	}
+
+	return batches
+}
+
 func (c *Cluster) podIsEndOfLife(pod *v1.Pod) (bool, error) {
 	node, err := c.KubeClient.Nodes().Get(pod.Spec.NodeName, metav1.GetOptions{})
 	if err != nil {
@@ -354,3 +439,174 @@ func (c *Cluster) podIsEndOfLife(pod *v1.Pod) (bool, error) {
 	return node.Spec.Unschedulable || !util.MapContains(node.Labels, c.OpConfig.NodeReadinessLabel), nil
 
 }
+
+// isPodCrashLooping checks whether any container of the pod is waiting to be restarted after repeated crashes.
+func isPodCrashLooping(pod *v1.Pod) bool {
+	if pod == nil {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == podReasonCrashLoopBackOff {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Cluster) hasCrashLoopingPods() bool {
+	c.podHealthMu.Lock()
+	defer c.podHealthMu.Unlock()
+
+	return len(c.crashLoopingPods) > 0
+}
+
+// dcsQuorumLost checks whether the cluster has no master because Patroni cannot reach the DCS. Patroni demotes
+// the master on purpose in that case to avoid a split-brain, and neither a failover nor recreating the pods can
+// bring it back, so the operator must leave the pods alone until the DCS recovers.
+func (c *Cluster) dcsQuorumLost(pods []v1.Pod) bool {
+	if c.Spec.Patroni.DCSQuorumLossAction == spec.DCSQuorumLossActionIgnore {
+		return false
+	}
+	for _, pod := range pods {
+		if PostgresRole(pod.Labels[c.podRoleLabel()]) == Master {
+			return false
+		}
+	}
+	ttl := defaultPatroniTTL
+	if c.Spec.Patroni.TTL > 0 {
+		ttl = time.Duration(c.Spec.Patroni.TTL) * time.Second
+	}
+	now := time.Now()
+	for i, pod := range pods {
+		status, err := c.patroni.GetMemberStatus(&pods[i])
+		if patroni.IsDCSError(err) {
+			c.logger.Debugf("patroni in the pod %q cannot reach the DCS: %v", util.NameFromMeta(pod.ObjectMeta), err)
+			return true
+		}
+		if err == nil && status.DCSUnreachable(now, ttl) {
+			c.logger.Debugf("patroni in the pod %q has not reached the DCS since %v", util.NameFromMeta(pod.ObjectMeta),
+				time.Unix(status.DCSLastSeen, 0))
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDCSQuorum lists the pods and reports the DCS quorum loss if there is one.
+func (c *Cluster) checkDCSQuorum() bool {
+	pods, err := c.listPods()
+	if err != nil {
+		c.logger.Warningf("could not check the DCS quorum: %v", err)
+		return false
+	}
+	if !c.dcsQuorumLost(pods) {
+		return false
+	}
+	c.reportDCSQuorumLoss()
+
+	return true
+}
+
+func (c *Cluster) reportDCSQuorumLoss() {
+	message := "the cluster has no master since Patroni cannot reach the DCS, changes disrupting the pods are postponed"
+	c.logger.Warning(message)
+	c.createWarningEvent(eventReasonDCSQuorumLost, message)
+}
+
+// createWarningEvent reports a problem of the cluster as an event of its postgresql object.
+func (c *Cluster) createWarningEvent(reason, message string) {
+	c.createEvent(v1.EventTypeWarning, reason, message)
+}
+
+// createEvent records an event of the given type on the postgresql object of the cluster.
+func (c *Cluster) createEvent(eventType, reason, message string) {
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: c.Name + "-",
+			Namespace:    c.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: constants.CRDGroup + "/" + constants.CRDApiVersion,
+			Kind:       constants.CRDKind,
+			Namespace:  c.Namespace,
+			Name:       c.Name,
+			UID:        c.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: "postgres-operator"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := c.KubeClient.Events(c.Namespace).Create(event); err != nil {
+		c.logger.Warningf("could not create the %s event: %v", reason, err)
+	}
+}
+
+// trackPodHealth records whether the pod of the event is crash looping and tells whether that has changed.
+func (c *Cluster) trackPodHealth(event spec.PodEvent) bool {
+	c.podHealthMu.Lock()
+	defer c.podHealthMu.Unlock()
+
+	crashLooping := event.EventType != spec.EventDelete && isPodCrashLooping(event.CurPod)
+	if crashLooping == c.crashLoopingPods[event.PodName] {
+		return false
+	}
+	if crashLooping {
+		c.logger.Warningf("pod %q is in the %s state", event.PodName, podReasonCrashLoopBackOff)
+		c.crashLoopingPods[event.PodName] = true
+	} else {
+		delete(c.crashLoopingPods, event.PodName)
+	}
+	return true
+}
+
+// statusAfterPodHealthChange returns the cluster status resulting from the crash looping pods. Only running clusters
+// are degraded and only the degraded ones are restored, other statuses are owned by the operation (create, update or
+// sync) currently in progress. The caller must hold the cluster mutex, which guards the status.
+func (c *Cluster) statusAfterPodHealthChange() (status spec.ClusterPhase, changed bool) {
+	crashLooping := c.hasCrashLoopingPods()
+	if crashLooping && c.Status.Phase == spec.ClusterStatusRunning {
+		return spec.ClusterStatusDegraded, true
+	}
+	if !crashLooping && c.Status.Phase == spec.ClusterStatusDegraded {
+		return spec.ClusterStatusRunning, true
+	}
+
+	return c.Status.Phase, false
+}
+
+// scheduleStatusUpdate updates the cluster status after a change of the pod health once the cluster mutex is free.
+// The pod events keep being dispatched meanwhile, since the operation holding the mutex may wait for them; a single
+// update is pending at a time, it takes all the changes recorded until it runs into account.
+func (c *Cluster) scheduleStatusUpdate() {
+	c.podHealthMu.Lock()
+	if c.statusPending {
+		c.podHealthMu.Unlock()
+		return
+	}
+	c.statusPending = true
+	c.podHealthMu.Unlock()
+
+	go func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.podHealthMu.Lock()
+		c.statusPending = false
+		c.podHealthMu.Unlock()
+
+		if c.podEventsQueue.IsClosed() {
+			return
+		}
+		if status, changed := c.statusAfterPodHealthChange(); changed {
+			c.logger.Infof("setting cluster status to %q after the change of the pod health", status)
+			c.setStatus(status)
+		}
+	}()
+}
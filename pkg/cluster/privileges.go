@@ -0,0 +1,187 @@
+package cluster
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+const (
+	// the default privileges of the owner in all schemas, as opposed to the ones limited to a single schema
+	getDefaultPrivilegesSQL = `SELECT d.defaclobjtype::text, pg_get_userbyid(a.grantee), a.privilege_type
+		FROM pg_default_acl d, aclexplode(d.defaclacl) a
+		WHERE d.defaclrole = (SELECT oid FROM pg_roles WHERE rolname = $1) AND d.defaclnamespace = 0;`
+	grantDefaultPrivilegesSQL  = `ALTER DEFAULT PRIVILEGES FOR ROLE "%s" GRANT %s ON %s TO "%s";`
+	revokeDefaultPrivilegesSQL = `ALTER DEFAULT PRIVILEGES FOR ROLE "%s" REVOKE %s ON %s FROM "%s";`
+
+	defaultACLTables    = "r"
+	defaultACLSequences = "S"
+)
+
+// defaultACL holds the default privileges by the type of the objects, the grantee and the privilege.
+type defaultACL map[string]map[string]map[string]bool
+
+func (acl defaultACL) add(objectType, grantee string, privileges ...string) {
+	if acl[objectType] == nil {
+		acl[objectType] = make(map[string]map[string]bool)
+	}
+	if acl[objectType][grantee] == nil {
+		acl[objectType][grantee] = make(map[string]bool)
+	}
+	for _, privilege := range privileges {
+		acl[objectType][grantee][privilege] = true
+	}
+}
+
+// defaultACLObjects maps the types of the objects in pg_default_acl to their names in ALTER DEFAULT PRIVILEGES.
+var defaultACLObjects = map[string]string{defaultACLTables: "TABLES", defaultACLSequences: "SEQUENCES"}
+
+// desiredDefaultACL returns the default privileges of the readers and the writers of a database: the readers may
+// read the tables and the sequences, the writers may change the rows of the tables and advance the sequences as well.
+func desiredDefaultACL(privileges spec.DefaultPrivileges) defaultACL {
+	acl := make(defaultACL)
+	for _, reader := range privileges.Readers {
+		acl.add(defaultACLTables, reader, "SELECT")
+		acl.add(defaultACLSequences, reader, "USAGE", "SELECT")
+	}
+	for _, writer := range privileges.Writers {
+		acl.add(defaultACLTables, writer, "SELECT", "INSERT", "UPDATE", "DELETE")
+		acl.add(defaultACLSequences, writer, "USAGE", "SELECT", "UPDATE")
+	}
+	return acl
+}
+
+// defaultPrivilegesStatements returns the statements turning the current default privileges of the owner into the
+// desired ones. Privileges missing for the desired grantees are granted, the ones of the managed roles beyond the
+// desired ones are revoked; the privileges of other roles, i.e. granted by hand, are left alone.
+func defaultPrivilegesStatements(owner string, desired, current defaultACL, managed map[string]bool) []string {
+	var result []string
+	for _, objectType := range []string{defaultACLSequences, defaultACLTables} {
+		grantees := make(map[string]bool)
+		for grantee := range desired[objectType] {
+			grantees[grantee] = true
+		}
+		for grantee := range current[objectType] {
+			if managed[grantee] && grantee != owner {
+				grantees[grantee] = true
+			}
+		}
+		names := make([]string, 0, len(grantees))
+		for grantee := range grantees {
+			names = append(names, grantee)
+		}
+		sort.Strings(names)
+
+		for _, grantee := range names {
+			var grant, revoke []string
+			for privilege := range desired[objectType][grantee] {
+				if !current[objectType][grantee][privilege] {
+					grant = append(grant, privilege)
+				}
+			}
+			for privilege := range current[objectType][grantee] {
+				if !desired[objectType][grantee][privilege] {
+					revoke = append(revoke, privilege)
+				}
+			}
+			sort.Strings(grant)
+			sort.Strings(revoke)
+			if len(grant) > 0 {
+				result = append(result, fmt.Sprintf(grantDefaultPrivilegesSQL, owner, strings.Join(grant, ", "),
+					defaultACLObjects[objectType], grantee))
+			}
+			if len(revoke) > 0 {
+				result = append(result, fmt.Sprintf(revokeDefaultPrivilegesSQL, owner, strings.Join(revoke, ", "),
+					defaultACLObjects[objectType], grantee))
+			}
+		}
+	}
+	return result
+}
+
+// getDefaultPrivileges returns the default privileges the owner has set in all schemas of the database.
+func getDefaultPrivileges(db *sql.DB, owner string) (defaultACL, error) {
+	rows, err := db.Query(getDefaultPrivilegesSQL, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acl := make(defaultACL)
+	for rows.Next() {
+		var objectType, grantee, privilege string
+		if err := rows.Scan(&objectType, &grantee, &privilege); err != nil {
+			return nil, fmt.Errorf("error when processing row: %v", err)
+		}
+		acl.add(objectType, grantee, privilege)
+	}
+
+	return acl, rows.Err()
+}
+
+// syncDefaultPrivileges makes the roles of the manifest get access to the tables and the sequences the owners of the
+// databases create from now on. Every database of the manifest is reconciled, the ones without default privileges
+// against empty ones, so that the privileges removed from the manifest are revoked. ALTER DEFAULT PRIVILEGES applies
+// to the database it runs in, so each database gets a connection of its own.
+func (c *Cluster) syncDefaultPrivileges() error {
+	c.setProcessName("syncing default privileges")
+
+	// only the privileges of the roles of the manifest are granted or revoked
+	if len(c.Spec.Users) == 0 {
+		return nil
+	}
+	managed := make(map[string]bool, len(c.Spec.Users))
+	for name := range c.Spec.Users {
+		managed[name] = true
+	}
+
+	for datname := range c.Spec.DefaultPrivileges {
+		if _, ok := c.Spec.Databases[datname]; !ok {
+			c.logger.Warningf("skipping default privileges of database %q, which is not in the databases section", datname)
+		}
+	}
+
+	datnames := make([]string, 0, len(c.Spec.Databases))
+	for datname := range c.Spec.Databases {
+		datnames = append(datnames, datname)
+	}
+	sort.Strings(datnames)
+
+	for _, datname := range datnames {
+		if err := c.syncDatabaseDefaultPrivileges(datname, c.Spec.Databases[datname],
+			desiredDefaultACL(c.Spec.DefaultPrivileges[datname]), managed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cluster) syncDatabaseDefaultPrivileges(datname, owner string, desired defaultACL, managed map[string]bool) error {
+	db, err := c.openDatabaseConn(datname)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			c.logger.Errorf("could not close connection to database %q: %v", datname, err)
+		}
+	}()
+
+	current, err := getDefaultPrivileges(db, owner)
+	if err != nil {
+		return fmt.Errorf("could not get default privileges of database %q: %v", datname, c.describeStatementError(err))
+	}
+	for _, statement := range defaultPrivilegesStatements(owner, desired, current, managed) {
+		c.logger.Infof("executing %q in database %q", statement, datname)
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("could not alter default privileges in database %q: %v", datname,
+				c.describeStatementError(err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+func TestDefaultPrivilegesStatements(t *testing.T) {
+	testName := "TestDefaultPrivilegesStatements"
+	privileges := spec.DefaultPrivileges{Readers: []string{"readonly"}, Writers: []string{"readwrite"}}
+	managed := map[string]bool{"app": true, "readonly": true, "readwrite": true, "former": true}
+	current := func(grants ...[3]string) defaultACL {
+		acl := make(defaultACL)
+		for _, grant := range grants {
+			acl.add(grant[0], grant[1], grant[2])
+		}
+		return acl
+	}
+	granted := current(
+		[3]string{"r", "readonly", "SELECT"},
+		[3]string{"S", "readonly", "USAGE"}, [3]string{"S", "readonly", "SELECT"},
+		[3]string{"r", "readwrite", "SELECT"}, [3]string{"r", "readwrite", "INSERT"},
+		[3]string{"r", "readwrite", "UPDATE"}, [3]string{"r", "readwrite", "DELETE"},
+		[3]string{"S", "readwrite", "USAGE"}, [3]string{"S", "readwrite", "SELECT"}, [3]string{"S", "readwrite", "UPDATE"},
+	)
+
+	tests := []struct {
+		about    string
+		current  defaultACL
+		expected []string
+	}{
+		{
+			about:   "new database",
+			current: current(),
+			expected: []string{
+				`ALTER DEFAULT PRIVILEGES FOR ROLE "app" GRANT SELECT, USAGE ON SEQUENCES TO "readonly";`,
+				`ALTER DEFAULT PRIVILEGES FOR ROLE "app" GRANT SELECT, UPDATE, USAGE ON SEQUENCES TO "readwrite";`,
+				`ALTER DEFAULT PRIVILEGES FOR ROLE "app" GRANT SELECT ON TABLES TO "readonly";`,
+				`ALTER DEFAULT PRIVILEGES FOR ROLE "app" GRANT DELETE, INSERT, SELECT, UPDATE ON TABLES TO "readwrite";`,
+			},
+		},
+		{
+			about:    "granted already",
+			current:  granted,
+			expected: nil,
+		},
+		{
+			about: "removed reader and privileges of other roles",
+			current: func() defaultACL {
+				acl := current([3]string{"r", "former", "SELECT"}, [3]string{"r", "auditor", "SELECT"},
+					[3]string{"r", "app", "TRUNCATE"}, [3]string{"r", "readonly", "INSERT"})
+				for objectType, grantees := range granted {
+					for grantee, privileges := range grantees {
+						for privilege := range privileges {
+							acl.add(objectType, grantee, privilege)
+						}
+					}
+				}
+				return acl
+			}(),
+			expected: []string{
+				`ALTER DEFAULT PRIVILEGES FOR ROLE "app" REVOKE SELECT ON TABLES FROM "former";`,
+				`ALTER DEFAULT PRIVILEGES FOR ROLE "app" REVOKE INSERT ON TABLES FROM "readonly";`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		result := defaultPrivilegesStatements("app", desiredDefaultACL(privileges), tt.current, managed)
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%s %s: expected %v, got %v", testName, tt.about, tt.expected, result)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+)
+
+// reads the cumulative CPU time in nanoseconds, the memory usage and the inactive page cache of the container cgroup
+const containerUsageCommand = "cat /sys/fs/cgroup/cpuacct/cpuacct.usage /sys/fs/cgroup/memory/memory.usage_in_bytes && " +
+	"grep -w total_inactive_file /sys/fs/cgroup/memory/memory.stat"
+
+const (
+	recommendationHeadroom    = 1.25 // the recommended requests leave 25% on top of the peak usage
+	recommendationLowerRatio  = 0.6  // requests are only reported as too high below 60% of them
+	recommendationCPUStep     = 10   // millicores
+	recommendationMemoryStep  = 1 << 20
+	recommendationMemoryUnits = "Mi"
+)
+
+// containerUsage is a sample of the postgres container resource usage.
+type containerUsage struct {
+	cpuTime     int64 // cumulative, in nanoseconds
+	memory      int64 // working set in bytes, i.e. without the inactive page cache
+	collectedAt time.Time
+}
+
+// resourcePeaks holds the highest usage observed on any pod since the operator started.
+type resourcePeaks struct {
+	cpuMillis int64
+	memory    int64
+}
+
+// parseContainerUsage parses the output of the containerUsageCommand.
+func parseContainerUsage(out string, now time.Time) (containerUsage, error) {
+	usage := containerUsage{collectedAt: now}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		return usage, fmt.Errorf("expected 3 lines of cgroup statistics, got %d", len(lines))
+	}
+
+	var (
+		err          error
+		inactiveFile int64
+	)
+	if usage.cpuTime, err = strconv.ParseInt(strings.TrimSpace(lines[0]), 10, 64); err != nil {
+		return usage, fmt.Errorf("could not parse CPU usage: %v", err)
+	}
+	if usage.memory, err = strconv.ParseInt(strings.TrimSpace(lines[1]), 10, 64); err != nil {
+		return usage, fmt.Errorf("could not parse memory usage: %v", err)
+	}
+	fields := strings.Fields(lines[2])
+	if len(fields) != 2 {
+		return usage, fmt.Errorf("could not parse the inactive page cache %q", lines[2])
+	}
+	if inactiveFile, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return usage, fmt.Errorf("could not parse the inactive page cache: %v", err)
+	}
+	if inactiveFile < usage.memory {
+		usage.memory -= inactiveFile
+	} else {
+		usage.memory = 0
+	}
+
+	return usage, nil
+}
+
+// observeResourceUsage samples the resource usage of the pods and updates the peaks. The CPU usage is the rate
+// between two consecutive samples of the same pod, so it is only known from the second sample on.
+func (c *Cluster) observeResourceUsage(pods []v1.Pod, now time.Time,
+	commandExecutor func(podName spec.NamespacedName, cmd string) (string, error)) error {
+	for _, pod := range pods {
+		podName := util.NameFromMeta(pod.ObjectMeta)
+		out, err := commandExecutor(podName, containerUsageCommand)
+		if err != nil {
+			return fmt.Errorf("could not get the resource usage of the pod %q: %v", podName, err)
+		}
+		usage, err := parseContainerUsage(out, now)
+		if err != nil {
+			return fmt.Errorf("could not parse the resource usage of the pod %q: %v", podName, err)
+		}
+
+		if usage.memory > c.usagePeaks.memory {
+			c.usagePeaks.memory = usage.memory
+		}
+		// the counter starts over when the container restarts
+		if prev, ok := c.lastUsage[pod.Name]; ok && usage.cpuTime >= prev.cpuTime {
+			if elapsed := usage.collectedAt.Sub(prev.collectedAt); elapsed > 0 {
+				cpuMillis := (usage.cpuTime - prev.cpuTime) * 1000 / elapsed.Nanoseconds()
+				if cpuMillis > c.usagePeaks.cpuMillis {
+					c.usagePeaks.cpuMillis = cpuMillis
+				}
+			}
+		}
+		c.lastUsage[pod.Name] = usage
+	}
+
+	return nil
+}
+
+// computeResourceRecommendation suggests requests fitting the peak usage with some headroom, and tells which of
+// the configured requests are notably too high or too low. It never changes the cluster.
+func computeResourceRecommendation(requests v1.ResourceList, peaks resourcePeaks, now time.Time) *spec.ResourceRecommendation {
+	recommendation := &spec.ResourceRecommendation{ComputedAt: now, Reasons: make([]string, 0)}
+
+	if peaks.cpuMillis > 0 {
+		cpu := roundUp(int64(math.Ceil(float64(peaks.cpuMillis)*recommendationHeadroom)), recommendationCPUStep)
+		recommended := resource.MustParse(fmt.Sprintf("%dm", cpu))
+		recommendation.PeakCPU = fmt.Sprintf("%dm", peaks.cpuMillis)
+		recommendation.CPURequest = recommended.String()
+		if request, ok := requests[v1.ResourceCPU]; ok {
+			recommendation.Reasons = append(recommendation.Reasons,
+				compareRequest("cpu", request, recommended, peaks.cpuMillis > request.MilliValue())...)
+		}
+	}
+	if peaks.memory > 0 {
+		memory := roundUp(int64(math.Ceil(float64(peaks.memory)*recommendationHeadroom)), recommendationMemoryStep)
+		recommended := resource.MustParse(fmt.Sprintf("%d%s", memory/recommendationMemoryStep, recommendationMemoryUnits))
+		recommendation.PeakMemory = resource.NewQuantity(peaks.memory, resource.BinarySI).String()
+		recommendation.MemoryRequest = recommended.String()
+		if request, ok := requests[v1.ResourceMemory]; ok {
+			recommendation.Reasons = append(recommendation.Reasons,
+				compareRequest("memory", request, recommended, peaks.memory > request.Value())...)
+		}
+	}
+
+	return recommendation
+}
+
+func compareRequest(name string, request, recommended resource.Quantity, exceeded bool) []string {
+	if exceeded {
+		return []string{fmt.Sprintf("%s requests could be raised from %s to %s", name, request.String(), recommended.String())}
+	}
+	if float64(recommended.MilliValue()) < float64(request.MilliValue())*recommendationLowerRatio {
+		return []string{fmt.Sprintf("%s requests could be lowered from %s to %s", name, request.String(), recommended.String())}
+	}
+	return nil
+}
+
+func roundUp(value, step int64) int64 {
+	if value <= 0 {
+		return step
+	}
+	return (value + step - 1) / step * step
+}
+
+// updateResourceRecommendation samples the usage of the cluster pods and refreshes the recommendation.
+func (c *Cluster) updateResourceRecommendation() error {
+	c.setProcessName("computing resource recommendations")
+
+	pods, err := c.listPods()
+	if err != nil {
+		return fmt.Errorf("could not list pods: %v", err)
+	}
+	commandExecutor := func(podName spec.NamespacedName, cmd string) (string, error) {
+		return c.ExecCommand(&podName, "sh", "-c", cmd)
+	}
+	if err := c.observeResourceUsage(pods, time.Now(), commandExecutor); err != nil {
+		return err
+	}
+
+	return c.setResourceRecommendation(time.Now())
+}
+
+func (c *Cluster) setResourceRecommendation(now time.Time) error {
+	_, defaults := c.postgresContainerDefaults()
+	requests, err := fillResourceList(c.Spec.Resources.ResourceRequest, defaults.ResourceRequest)
+	if err != nil {
+		return fmt.Errorf("could not get the resource requests: %v", err)
+	}
+	recommendation := computeResourceRecommendation(requests, c.usagePeaks, now)
+
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+	c.recommendation = recommendation
+
+	return nil
+}
+
+// GetResourceRecommendation returns the advisory resource recommendation, nil if none has been computed yet
+func (c *Cluster) GetResourceRecommendation() *spec.ResourceRecommendation {
+	c.metricsMu.RLock()
+	defer c.metricsMu.RUnlock()
+
+	return c.recommendation
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+// fakeCgroupUsage returns the output of the container usage command for the given samples, keyed by pod name.
+func fakeCgroupUsage(samples map[string][3]int64) func(podName spec.NamespacedName, cmd string) (string, error) {
+	return func(podName spec.NamespacedName, cmd string) (string, error) {
+		sample, ok := samples[podName.Name]
+		if !ok {
+			return "", fmt.Errorf("no such pod")
+		}
+		return fmt.Sprintf("%d\n%d\ntotal_inactive_file %d\n", sample[0], sample[1], sample[2]), nil
+	}
+}
+
+func TestResourceRecommendationFromUsage(t *testing.T) {
+	testName := "TestResourceRecommendationFromUsage"
+	cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{
+		Spec: spec.PostgresSpec{Resources: spec.Resources{
+			ResourceRequest: spec.ResourceDescription{CPU: "1", Memory: "1Gi"},
+			ResourceLimits:  spec.ResourceDescription{CPU: "2", Memory: "2Gi"},
+		}},
+	}, logger)
+	pods := []v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "acid-test-0", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "acid-test-1", Namespace: "default"}},
+	}
+	start := time.Now()
+
+	// CPU time in nanoseconds, memory usage and inactive page cache in bytes
+	if err := cluster.observeResourceUsage(pods, start, fakeCgroupUsage(map[string][3]int64{
+		"acid-test-0": {10e9, 300 << 20, 100 << 20},
+		"acid-test-1": {5e9, 150 << 20, 50 << 20},
+	})); err != nil {
+		t.Fatalf("%s: could not observe the resource usage: %v", testName, err)
+	}
+	if err := cluster.observeResourceUsage(pods, start.Add(time.Minute), fakeCgroupUsage(map[string][3]int64{
+		"acid-test-0": {16e9, 300 << 20, 100 << 20},
+		"acid-test-1": {5.6e9, 150 << 20, 50 << 20},
+	})); err != nil {
+		t.Fatalf("%s: could not observe the resource usage: %v", testName, err)
+	}
+	if err := cluster.setResourceRecommendation(start.Add(time.Minute)); err != nil {
+		t.Fatalf("%s: could not compute the recommendation: %v", testName, err)
+	}
+
+	expected := &spec.ResourceRecommendation{
+		CPURequest:    "130m",
+		MemoryRequest: "250Mi",
+		PeakCPU:       "100m",
+		PeakMemory:    "200Mi",
+		Reasons: []string{
+			"cpu requests could be lowered from 1 to 130m",
+			"memory requests could be lowered from 1Gi to 250Mi",
+		},
+		ComputedAt: start.Add(time.Minute),
+	}
+	if recommendation := cluster.GetStatus().ResourceRecommendation; !reflect.DeepEqual(recommendation, expected) {
+		t.Errorf("%s: expected the recommendation %#v in the status, got %#v", testName, expected, recommendation)
+	}
+}
+
+func TestComputeResourceRecommendation(t *testing.T) {
+	testName := "TestComputeResourceRecommendation"
+	now := time.Now()
+	requests := v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("100m"),
+		v1.ResourceMemory: resource.MustParse("512Mi"),
+	}
+	tests := []struct {
+		about   string
+		peaks   resourcePeaks
+		reasons []string
+	}{
+		{"usage above the requests", resourcePeaks{cpuMillis: 150, memory: 600 << 20}, []string{
+			"cpu requests could be raised from 100m to 190m",
+			"memory requests could be raised from 512Mi to 750Mi",
+		}},
+		{"usage matching the requests", resourcePeaks{cpuMillis: 70, memory: 300 << 20}, []string{}},
+		{"no CPU usage observed yet", resourcePeaks{memory: 100 << 20}, []string{
+			"memory requests could be lowered from 512Mi to 125Mi",
+		}},
+	}
+	for _, tt := range tests {
+		recommendation := computeResourceRecommendation(requests, tt.peaks, now)
+		if !reflect.DeepEqual(recommendation.Reasons, tt.reasons) {
+			t.Errorf("%s %s: expected reasons %v, got %v", testName, tt.about, tt.reasons, recommendation.Reasons)
+		}
+	}
+}
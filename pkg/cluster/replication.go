@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+const getReplicationStatsSQL = `SELECT COALESCE(application_name, ''), COALESCE(state, ''), COALESCE(sync_state, '')
+	 FROM pg_stat_replication;`
+
+// replicationStat is a single walsender of the master as reported by pg_stat_replication.
+type replicationStat struct {
+	applicationName string
+	state           string
+	syncState       string
+}
+
+func readReplicationStats(db *sql.DB) ([]replicationStat, error) {
+	rows, err := db.Query(getReplicationStatsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("could not query replication statistics: %v", err)
+	}
+	defer rows.Close()
+
+	stats := make([]replicationStat, 0)
+	for rows.Next() {
+		var stat replicationStat
+		if err := rows.Scan(&stat.applicationName, &stat.state, &stat.syncState); err != nil {
+			return nil, fmt.Errorf("error when processing replication statistics: %v", err)
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats, rows.Err()
+}
+
+// buildReplicationStatus counts the replicas streaming from the master and the synchronous standbys among them.
+// The replication is healthy when every replica of the cluster is streaming.
+func buildReplicationStatus(stats []replicationStat, timeline int, replicas int32, now time.Time) *spec.ReplicationStatus {
+	status := &spec.ReplicationStatus{Timeline: timeline, CollectedAt: now}
+	for _, stat := range stats {
+		if stat.state != "streaming" {
+			continue
+		}
+		status.ConnectedReplicas++
+		// quorum is reported starting from Postgres 10 for the ANY form of synchronous_standby_names
+		if stat.syncState == "sync" || stat.syncState == "quorum" {
+			status.SynchronousStandbys++
+		}
+	}
+	status.Healthy = int32(status.ConnectedReplicas) >= replicas
+
+	return status
+}
+
+func (c *Cluster) updateReplicationStatus() error {
+	c.setProcessName("updating replication status")
+
+	masterPods, err := c.getRolePods(Master)
+	if err != nil {
+		return fmt.Errorf("could not get master pod: %v", err)
+	}
+	if len(masterPods) == 0 {
+		return fmt.Errorf("no master pod")
+	}
+	member, err := c.patroni.GetMemberStatus(&masterPods[0])
+	if err != nil {
+		return fmt.Errorf("could not get the patroni status of the master: %v", err)
+	}
+
+	if err := c.initDbConn(); err != nil {
+		return fmt.Errorf("could not init database connection: %v", err)
+	}
+	defer func() {
+		if err := c.closeDbConn(); err != nil {
+			c.logger.Errorf("could not close database connection: %v", err)
+		}
+	}()
+
+	stats, err := readReplicationStats(c.pgDb)
+	if err != nil {
+		return err
+	}
+	status := buildReplicationStatus(stats, member.Timeline, c.getNumberOfInstances(&c.Spec)-1, time.Now())
+
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+	c.replication = status
+	// written to the status of the manifest by the sync
+	c.Status.Replication = status
+
+	return nil
+}
+
+// GetReplicationStatus returns the replication summary collected during the last sync
+func (c *Cluster) GetReplicationStatus() *spec.ReplicationStatus {
+	c.metricsMu.RLock()
+	defer c.metricsMu.RUnlock()
+
+	return c.replication
+}
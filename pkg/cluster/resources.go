@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 
@@ -9,6 +10,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/apps/v1beta1"
+	batchv2alpha1 "k8s.io/client-go/pkg/apis/batch/v2alpha1"
 	policybeta1 "k8s.io/client-go/pkg/apis/policy/v1beta1"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/util"
@@ -18,7 +20,8 @@ import (
 )
 
 const (
-	RollingUpdateStatefulsetAnnotationKey = "zalando-postgres-operator-rolling-update-required"
+	RollingUpdateStatefulsetAnnotationKey  = "zalando-postgres-operator-rolling-update-required"
+	CloneRolesSyncStatefulsetAnnotationKey = "zalando-postgres-operator-clone-roles-sync-required"
 )
 
 func (c *Cluster) listResources() error {
@@ -26,6 +29,10 @@ func (c *Cluster) listResources() error {
 		c.logger.Infof("found pod disruption budget: %q (uid: %q)", util.NameFromMeta(c.PodDisruptionBudget.ObjectMeta), c.PodDisruptionBudget.UID)
 	}
 
+	if c.LogicalBackupJob != nil {
+		c.logger.Infof("found logical backup job: %q (uid: %q)", util.NameFromMeta(c.LogicalBackupJob.ObjectMeta), c.LogicalBackupJob.UID)
+	}
+
 	if c.Statefulset != nil {
 		c.logger.Infof("found statefulset: %q (uid: %q)", util.NameFromMeta(c.Statefulset.ObjectMeta), c.Statefulset.UID)
 	}
@@ -64,11 +71,15 @@ func (c *Cluster) listResources() error {
 }
 
 func (c *Cluster) createStatefulSet() (*v1beta1.StatefulSet, error) {
+	defer c.startSpan("createStatefulSet")()
 	c.setProcessName("creating statefulset")
 	statefulSetSpec, err := c.generateStatefulSet(&c.Spec)
 	if err != nil {
 		return nil, fmt.Errorf("could not generate statefulset: %v", err)
 	}
+	if c.Spec.Clone.ClusterName != "" {
+		c.setCloneRolesSyncFlagForStatefulSet(statefulSetSpec, true)
+	}
 	statefulSet, err := c.KubeClient.StatefulSets(statefulSetSpec.Namespace).Create(statefulSetSpec)
 	if err != nil {
 		return nil, err
@@ -176,6 +187,45 @@ func (c *Cluster) getRollingUpdateFlagFromStatefulSet(sset *v1beta1.StatefulSet,
 	return flag
 }
 
+// setCloneRolesSyncFlagForStatefulSet marks whether the roles still have to be synced against the restored database
+// of a cloned cluster. Keeping the flag in the statefulset makes the sync survive operator restarts.
+func (c *Cluster) setCloneRolesSyncFlagForStatefulSet(sset *v1beta1.StatefulSet, val bool) {
+	anno := sset.GetAnnotations()
+	if anno == nil {
+		anno = make(map[string]string)
+	}
+	anno[CloneRolesSyncStatefulsetAnnotationKey] = strconv.FormatBool(val)
+	sset.SetAnnotations(anno)
+}
+
+// applyCloneRolesSyncFlagForStatefulSet sets the clone roles sync flag for the cluster's StatefulSet
+// and applies that setting to the actual running cluster.
+func (c *Cluster) applyCloneRolesSyncFlagForStatefulSet(val bool) error {
+	c.setCloneRolesSyncFlagForStatefulSet(c.Statefulset, val)
+	sset, err := c.updateStatefulSetAnnotations(c.Statefulset.GetAnnotations())
+	if err != nil {
+		return err
+	}
+	c.Statefulset = sset
+	return nil
+}
+
+// getCloneRolesSyncFlagFromStatefulSet returns the value of the clone roles sync flag from the passed
+// StatefulSet, which is false for the clusters that were not cloned or for malformed values.
+func (c *Cluster) getCloneRolesSyncFlagFromStatefulSet(sset *v1beta1.StatefulSet) bool {
+	stringFlag, exists := sset.GetAnnotations()[CloneRolesSyncStatefulsetAnnotationKey]
+	if !exists {
+		return false
+	}
+	flag, err := strconv.ParseBool(stringFlag)
+	if err != nil {
+		c.logger.Warnf("error when parsing %q annotation for the statefulset %q: expected boolean value, got %q",
+			CloneRolesSyncStatefulsetAnnotationKey, util.NameFromMeta(sset.ObjectMeta), stringFlag)
+		return false
+	}
+	return flag
+}
+
 // mergeRollingUpdateFlagUsingCache return the value of the rollingUpdate flag from the passed
 // statefulset, however, the value can be cleared if there is a cached flag in the cluster that
 // is set to false (the disrepancy could be a result of a failed StatefulSet update).s
@@ -222,6 +272,7 @@ func (c *Cluster) updateStatefulSetAnnotations(annotations map[string]string) (*
 
 }
 func (c *Cluster) updateStatefulSet(newStatefulSet *v1beta1.StatefulSet) error {
+	defer c.startSpan("updateStatefulSet")()
 	c.setProcessName("updating statefulset")
 	if c.Statefulset == nil {
 		return fmt.Errorf("there is no statefulset in the cluster")
@@ -263,6 +314,7 @@ func (c *Cluster) updateStatefulSet(newStatefulSet *v1beta1.StatefulSet) error {
 
 // replaceStatefulSet deletes an old StatefulSet and creates the new using spec in the PostgreSQL CRD.
 func (c *Cluster) replaceStatefulSet(newStatefulSet *v1beta1.StatefulSet) error {
+	defer c.startSpan("replaceStatefulSet")()
 	c.setProcessName("replacing statefulset")
 	if c.Statefulset == nil {
 		return fmt.Errorf("there is no statefulset in the cluster")
@@ -309,7 +361,61 @@ func (c *Cluster) replaceStatefulSet(newStatefulSet *v1beta1.StatefulSet) error
 	return nil
 }
 
+// scaleDownStatefulSet stops the pods before the statefulset is deleted, so that Patroni shuts down every member
+// cleanly instead of failing over to the pods that are still running.
+func (c *Cluster) scaleDownStatefulSet() error {
+	defer c.startSpan("scaleDownStatefulSet")()
+	c.setProcessName("scaling down statefulset")
+	if c.Statefulset == nil {
+		return fmt.Errorf("there is no statefulset in the cluster")
+	}
+	statefulSetName := util.NameFromMeta(c.Statefulset.ObjectMeta)
+
+	if c.OpConfig.DeletePauseFailovers {
+		if err := c.pauseFailovers(); err != nil {
+			c.logger.Warningf("could not pause failovers: %v", err)
+		}
+	}
+
+	c.logger.Debugf("scaling statefulset %q to zero", statefulSetName)
+	_, err := c.KubeClient.StatefulSets(c.Statefulset.Namespace).Patch(
+		c.Statefulset.Name,
+		types.MergePatchType,
+		[]byte(`{"spec":{"replicas":0}}`), "")
+	if err != nil {
+		return fmt.Errorf("could not scale statefulset %q to zero: %v", statefulSetName, err)
+	}
+
+	err = retryutil.Retry(c.OpConfig.ResourceCheckInterval, c.OpConfig.ResourceCheckTimeout,
+		func() (bool, error) {
+			pods, err := c.listPods()
+			if err != nil {
+				return false, err
+			}
+			return len(pods) == 0, nil
+		})
+	if err != nil {
+		return fmt.Errorf("could not wait for the pods to shut down: %v", err)
+	}
+	c.logger.Infof("statefulset %q has been scaled down", statefulSetName)
+
+	return nil
+}
+
+func (c *Cluster) pauseFailovers() error {
+	masterPods, err := c.getRolePods(Master)
+	if err != nil {
+		return err
+	}
+	if len(masterPods) == 0 {
+		return fmt.Errorf("no master pod to reach Patroni")
+	}
+
+	return c.patroni.Pause(&masterPods[0])
+}
+
 func (c *Cluster) deleteStatefulSet() error {
+	defer c.startSpan("deleteStatefulSet")()
 	c.setProcessName("deleting statefulset")
 	c.logger.Debugln("deleting statefulset")
 	if c.Statefulset == nil {
@@ -327,14 +433,73 @@ func (c *Cluster) deleteStatefulSet() error {
 		return fmt.Errorf("could not delete pods: %v", err)
 	}
 
-	if err := c.deletePersistenVolumeClaims(); err != nil {
-		return fmt.Errorf("could not delete PersistentVolumeClaims: %v", err)
+	return nil
+}
+
+// checkBasebackupCloneSource makes sure the cluster to clone with pg_basebackup is running and its replication
+// credentials are there, otherwise the pods of the clone would never finish the bootstrap. The credentials of a
+// cluster in another namespace are copied into the namespace of the clone.
+func (c *Cluster) checkBasebackupCloneSource() error {
+	namespace := c.cloneSourceNamespace(&c.Spec.Clone)
+	host, _ := c.getClusterServiceConnectionParameters(c.Spec.Clone.ClusterName)
+	if _, err := c.KubeClient.Services(namespace).Get(host, metav1.GetOptions{}); err != nil {
+		if k8sutil.ResourceAccessForbidden(err) {
+			return fmt.Errorf("operator is not allowed to get the services in the namespace %q: %v", namespace, err)
+		}
+		return fmt.Errorf("could not get the service %q of the running cluster: %v", host, err)
+	}
+	secretName := c.credentialSecretNameForCluster(c.OpConfig.ReplicationUsername, c.Spec.Clone.ClusterName)
+	secret, err := c.KubeClient.Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		if k8sutil.ResourceAccessForbidden(err) {
+			return fmt.Errorf("operator is not allowed to get the secrets in the namespace %q: %v", namespace, err)
+		}
+		return fmt.Errorf("could not get the replication credentials %q: %v", secretName, err)
+	}
+	if namespace == c.Namespace {
+		return nil
+	}
+
+	credentials := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.cloneCredentialSecretName(&c.Spec.Clone),
+			Namespace: c.Namespace,
+			Labels:    c.labelsSet(true),
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	}
+	if _, err := c.KubeClient.Secrets(c.Namespace).Create(credentials); err != nil {
+		if !k8sutil.ResourceAlreadyExists(err) {
+			return fmt.Errorf("could not copy the replication credentials %q: %v", secretName, err)
+		}
+		if _, err := c.KubeClient.Secrets(c.Namespace).Update(credentials); err != nil {
+			return fmt.Errorf("could not update the copy of the replication credentials %q: %v", secretName, err)
+		}
+	}
+	c.logger.Infof("replication credentials of the cluster %q have been copied from the namespace %q",
+		c.Spec.Clone.ClusterName, namespace)
+
+	return nil
+}
+
+// deleteCloneCredentials removes the copy of the replication credentials of a cluster cloned from another namespace.
+// The replication user of the copy keeps it out of the secrets deleted along with the cluster.
+func (c *Cluster) deleteCloneCredentials() error {
+	clone := &c.Spec.Clone
+	if clone.ClusterName == "" || clone.EndTimestamp != "" || c.cloneSourceNamespace(clone) == c.Namespace {
+		return nil
+	}
+	name := c.cloneCredentialSecretName(clone)
+	if err := c.KubeClient.Secrets(c.Namespace).Delete(name, c.deleteOptions); err != nil && !k8sutil.ResourceNotFound(err) {
+		return fmt.Errorf("could not delete secret %q: %v", name, err)
 	}
 
 	return nil
 }
 
 func (c *Cluster) createService(role PostgresRole) (*v1.Service, error) {
+	defer c.startSpan("createService")()
 	c.setProcessName("creating %v service", role)
 
 	serviceSpec := c.generateService(role, &c.Spec)
@@ -348,6 +513,7 @@ func (c *Cluster) createService(role PostgresRole) (*v1.Service, error) {
 }
 
 func (c *Cluster) updateService(role PostgresRole, newService *v1.Service) error {
+	defer c.startSpan("updateService")()
 	c.setProcessName("updating %v service", role)
 
 	if c.Services[role] == nil {
@@ -415,6 +581,20 @@ func (c *Cluster) updateService(role PostgresRole, newService *v1.Service) error
 		}
 	}
 
+	// a merge patch would keep the labels removed from the selector, so those need to be nulled explicitly
+	if !reflect.DeepEqual(c.Services[role].Spec.Selector, newService.Spec.Selector) {
+		selectorPatchData, err := selectorPatch(c.Services[role].Spec.Selector, newService.Spec.Selector)
+		if err != nil {
+			return fmt.Errorf("could not form patch for the service %q selector: %v", serviceName, err)
+		}
+		if _, err = c.KubeClient.Services(serviceName.Namespace).Patch(
+			serviceName.Name,
+			types.MergePatchType,
+			selectorPatchData, ""); err != nil {
+			return fmt.Errorf("could not patch the selector of the service %q: %v", serviceName, err)
+		}
+	}
+
 	patchData, err := specPatch(newService.Spec)
 	if err != nil {
 		return fmt.Errorf("could not form patch for the service %q: %v", serviceName, err)
@@ -434,6 +614,7 @@ func (c *Cluster) updateService(role PostgresRole, newService *v1.Service) error
 }
 
 func (c *Cluster) deleteService(role PostgresRole) error {
+	defer c.startSpan("deleteService")()
 	c.logger.Debugf("deleting service %s", role)
 
 	service := c.Services[role]
@@ -568,6 +749,55 @@ func (c *Cluster) deletePodDisruptionBudget() error {
 	return nil
 }
 
+func (c *Cluster) createLogicalBackupJob() (*batchv2alpha1.CronJob, error) {
+	jobSpec := c.generateLogicalBackupJob()
+	job, err := c.KubeClient.
+		CronJobs(jobSpec.Namespace).
+		Create(jobSpec)
+	if err != nil {
+		return nil, err
+	}
+	c.LogicalBackupJob = job
+
+	return job, nil
+}
+
+func (c *Cluster) updateLogicalBackupJob(newJob *batchv2alpha1.CronJob) error {
+	if c.LogicalBackupJob == nil {
+		return fmt.Errorf("there is no logical backup job in the cluster")
+	}
+
+	// the update is rejected unless it is based on the current version of the object
+	newJob.ResourceVersion = c.LogicalBackupJob.ResourceVersion
+	job, err := c.KubeClient.
+		CronJobs(newJob.Namespace).
+		Update(newJob)
+	if err != nil {
+		return fmt.Errorf("could not update logical backup job: %v", err)
+	}
+	c.LogicalBackupJob = job
+
+	return nil
+}
+
+func (c *Cluster) deleteLogicalBackupJob() error {
+	c.logger.Debug("deleting logical backup job")
+	if c.LogicalBackupJob == nil {
+		return fmt.Errorf("there is no logical backup job in the cluster")
+	}
+
+	err := c.KubeClient.
+		CronJobs(c.LogicalBackupJob.Namespace).
+		Delete(c.LogicalBackupJob.Name, c.deleteOptions)
+	if err != nil && !k8sutil.ResourceNotFound(err) {
+		return fmt.Errorf("could not delete logical backup job: %v", err)
+	}
+	c.logger.Infof("logical backup job %q has been deleted", util.NameFromMeta(c.LogicalBackupJob.ObjectMeta))
+	c.LogicalBackupJob = nil
+
+	return nil
+}
+
 func (c *Cluster) deleteEndpoint(role PostgresRole) error {
 	c.setProcessName("deleting endpoint")
 	c.logger.Debugln("deleting endpoint")
@@ -599,11 +829,6 @@ func (c *Cluster) deleteSecret(secret *v1.Secret) error {
 	return err
 }
 
-func (c *Cluster) createRoles() (err error) {
-	// TODO: figure out what to do with duplicate names (humans and robots) among pgUsers
-	return c.syncRoles()
-}
-
 // GetServiceMaster returns cluster's kubernetes master Service
 func (c *Cluster) GetServiceMaster() *v1.Service {
 	return c.Services[Master]
@@ -634,6 +859,11 @@ func (c *Cluster) GetPodDisruptionBudget() *policybeta1.PodDisruptionBudget {
 	return c.PodDisruptionBudget
 }
 
+// GetLogicalBackupJob returns cluster's kubernetes CronJob running the logical backups
+func (c *Cluster) GetLogicalBackupJob() *batchv2alpha1.CronJob {
+	return c.LogicalBackupJob
+}
+
 func (c *Cluster) createDatabases() error {
 	c.setProcessName("creating databases")
 
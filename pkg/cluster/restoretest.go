@@ -0,0 +1,222 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+const (
+	// the manifest of the throwaway cluster points to the cluster whose backups it verifies with that label
+	restoreTestLabel = "restore-test-of"
+	// the throwaway cluster archives its own WAL under that prefix, away from the clusters of the bucket
+	restoreTestS3Prefix = "restore-test/"
+	// the smoke test succeeds once the restored cluster has finished the recovery and accepts writes
+	restoreTestQuery = "SELECT NOT pg_is_in_recovery()"
+)
+
+// restoreTestClusterName returns the name of the throwaway cluster the backups of the cluster are restored into.
+func (c *Cluster) restoreTestClusterName() string {
+	return c.Name + spec.RestoreTestClusterSuffix
+}
+
+// restoreVerifyDue tells whether the manifest asks for the test restores and the last one is long enough ago.
+func (c *Cluster) restoreVerifyDue(now time.Time) bool {
+	if c.Spec.Backup == nil || !c.Spec.Backup.VerifyRestore {
+		return false
+	}
+	last := c.Status.LastRestoreVerifyTime
+	return last == nil || now.Sub(last.Time) >= c.OpConfig.RestoreVerifyInterval
+}
+
+// restoreVerifyFailed tells whether the last test restore has failed, as long as the test restores are enabled.
+func (c *Cluster) restoreVerifyFailed() bool {
+	return c.Spec.Backup != nil && c.Spec.Backup.VerifyRestore &&
+		c.Status.RestoreVerified != nil && !*c.Status.RestoreVerified
+}
+
+// syncRestoreVerification drives the test restore from one sync to the next: it creates the throwaway cluster from
+// the latest backup when the interval has passed, runs the smoke test once that cluster is running and removes it
+// again. The result goes to the status of the cluster and the returned flag tells whether the status has changed.
+func (c *Cluster) syncRestoreVerification() (bool, error) {
+	c.setProcessName("verifying the restore of the backups")
+
+	test, err := c.getRestoreTestCluster()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if test == nil {
+		if !c.restoreVerifyDue(now) {
+			return false, nil
+		}
+		if bucket, _ := c.WALLocation(); bucket == "" {
+			return false, fmt.Errorf("no WAL bucket is configured for the cluster")
+		}
+		return false, c.createRestoreTestCluster(now)
+	}
+
+	done, failure := restoreTestResult(test, now, c.OpConfig.RestoreVerifyTimeout)
+	if !done {
+		c.logger.Debugf("restore test cluster %q is in the %q phase", test.Name, test.Status.Phase)
+		return false, nil
+	}
+	if failure == "" {
+		failure = c.runRestoreSmokeTest(test)
+	}
+
+	verified := failure == ""
+	verifiedAt := metav1.NewTime(now)
+	c.Status.RestoreVerified = &verified
+	c.Status.LastRestoreVerifyTime = &verifiedAt
+	if verified {
+		c.logger.Infof("the latest backup of the cluster has been restored successfully")
+	} else {
+		message := fmt.Sprintf("the latest backup could not be restored: %s", failure)
+		c.logger.Warning(message)
+		c.createWarningEvent(eventReasonRestoreVerifyFailed, message)
+	}
+
+	if err := c.deleteRestoreTestCluster(); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// restoreTestResult tells whether the throwaway cluster is ready for the smoke test or has failed to come up, in which
+// case the reason of the failure is returned as well.
+func restoreTestResult(test *spec.Postgresql, now time.Time, timeout time.Duration) (done bool, failure string) {
+	switch test.Status.Phase {
+	case spec.ClusterStatusRunning:
+		return true, ""
+	case spec.ClusterStatusInvalid:
+		return true, fmt.Sprintf("the manifest of the restore test cluster is invalid: %v", test.Error)
+	case spec.ClusterStatusAddFailed, spec.ClusterStatusCloneFailed:
+		return true, fmt.Sprintf("the restore test cluster has failed to start: %s", test.Status.Phase)
+	}
+	if now.Sub(test.CreationTimestamp.Time) >= timeout {
+		return true, fmt.Sprintf("the restore test cluster is not running after %v", timeout)
+	}
+	return false, ""
+}
+
+// runRestoreSmokeTest queries the restored database on the first pod of the throwaway cluster and returns the reason
+// of the failure, if any.
+func (c *Cluster) runRestoreSmokeTest(test *spec.Postgresql) string {
+	podName := spec.NamespacedName{Namespace: test.Namespace, Name: test.Name + "-0"}
+	cmd := fmt.Sprintf("psql -U %s -d postgres -tAc %q", c.OpConfig.SuperUsername, restoreTestQuery)
+	out, err := c.ExecCommand(&podName, "sh", "-c", cmd)
+	if err != nil {
+		return fmt.Sprintf("could not query the restored database: %v", err)
+	}
+	if result := strings.TrimSpace(out); result != "t" {
+		return fmt.Sprintf("unexpected result of the smoke test: %q", result)
+	}
+	return ""
+}
+
+// generateRestoreTestCluster returns the manifest of a single pod cluster cloned from the archive of the cluster up to
+// the current time, so that the recovery covers the latest base backup together with the WAL archived since.
+func (c *Cluster) generateRestoreTestCluster(now time.Time) *spec.Postgresql {
+	bucket, prefix := c.WALLocation()
+	noFinalBackup := false
+
+	return &spec.Postgresql{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       constants.CRDKind,
+			APIVersion: constants.CRDGroup + "/" + constants.CRDApiVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.restoreTestClusterName(),
+			Namespace: c.Namespace,
+			Labels:    map[string]string{restoreTestLabel: c.Name},
+		},
+		Spec: spec.PostgresSpec{
+			PostgresqlParam:   c.Spec.PostgresqlParam,
+			Volume:            spec.Volume{Size: c.Spec.Volume.Size, StorageClass: c.Spec.Volume.StorageClass},
+			Resources:         c.Spec.Resources,
+			TeamID:            c.Spec.TeamID,
+			DockerImage:       c.Spec.DockerImage,
+			NumberOfInstances: 1,
+			Clone: spec.CloneDescription{
+				ClusterName:  c.Name,
+				Uid:          string(c.UID),
+				EndTimestamp: now.Format(time.RFC3339),
+				S3Bucket:     bucket,
+				S3Prefix:     prefix,
+			},
+			Backup:              &spec.Backup{S3Bucket: bucket, S3Prefix: restoreTestS3Prefix},
+			FinalBackupOnDelete: &noFinalBackup,
+		},
+	}
+}
+
+func (c *Cluster) createRestoreTestCluster(now time.Time) error {
+	pg := c.generateRestoreTestCluster(now)
+	body, err := json.Marshal(pg)
+	if err != nil {
+		return fmt.Errorf("could not marshal restore test cluster manifest: %v", err)
+	}
+
+	_, err = c.KubeClient.CRDREST.Post().
+		Namespace(pg.Namespace).
+		Resource(constants.CRDResource).
+		Body(body).
+		DoRaw()
+	if err != nil {
+		return fmt.Errorf("could not create restore test cluster manifest: %v", err)
+	}
+	c.logger.Infof("restore test cluster %q has been created", pg.Name)
+
+	return nil
+}
+
+// getRestoreTestCluster returns the manifest of the throwaway cluster, nil when no test restore is running.
+func (c *Cluster) getRestoreTestCluster() (*spec.Postgresql, error) {
+	b, err := c.KubeClient.CRDREST.Get().
+		Namespace(c.Namespace).
+		Resource(constants.CRDResource).
+		Name(c.restoreTestClusterName()).
+		DoRaw()
+	if k8sutil.ResourceNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get restore test cluster manifest: %v", err)
+	}
+
+	var pg spec.Postgresql
+	if err := json.Unmarshal(b, &pg); err != nil {
+		return nil, fmt.Errorf("could not unmarshal restore test cluster manifest: %v", err)
+	}
+	// a cluster of the same name not created by the operator is never touched
+	if pg.Labels[restoreTestLabel] != c.Name {
+		return nil, fmt.Errorf("cluster %q is not a restore test cluster of %q", pg.Name, c.Name)
+	}
+
+	return &pg, nil
+}
+
+// deleteRestoreTestCluster removes the manifest of the throwaway cluster, the operator deletes the cluster itself.
+func (c *Cluster) deleteRestoreTestCluster() error {
+	name := c.restoreTestClusterName()
+	err := c.KubeClient.CRDREST.Delete().
+		Namespace(c.Namespace).
+		Resource(constants.CRDResource).
+		Name(name).
+		Do().
+		Error()
+	if err != nil && !k8sutil.ResourceNotFound(err) {
+		return fmt.Errorf("could not delete restore test cluster manifest %q: %v", name, err)
+	}
+
+	return nil
+}
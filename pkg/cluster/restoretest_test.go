@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+func TestRestoreVerifyDue(t *testing.T) {
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	hourAgo, weekAgo := metav1.NewTime(now.Add(-time.Hour)), metav1.NewTime(now.Add(-7*24*time.Hour))
+	verified, failed := true, false
+	tests := []struct {
+		about      string
+		backup     *spec.Backup
+		lastVerify *metav1.Time
+		verified   *bool
+		due        bool
+		degraded   bool
+	}{
+		{"no backup section", nil, nil, nil, false, false},
+		{"verification disabled", &spec.Backup{}, nil, &failed, false, false},
+		{"never verified", &spec.Backup{VerifyRestore: true}, nil, nil, true, false},
+		{"verified recently", &spec.Backup{VerifyRestore: true}, &hourAgo, &verified, false, false},
+		{"failed a week ago", &spec.Backup{VerifyRestore: true}, &weekAgo, &failed, true, true},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{OpConfig: config.Config{RestoreVerifyInterval: 7 * 24 * time.Hour}},
+			k8sutil.KubernetesClient{}, spec.Postgresql{Spec: spec.PostgresSpec{Backup: tt.backup}}, logger)
+		cluster.Status.LastRestoreVerifyTime = tt.lastVerify
+		cluster.Status.RestoreVerified = tt.verified
+		if due := cluster.restoreVerifyDue(now); due != tt.due {
+			t.Errorf("TestRestoreVerifyDue %s: expected due %t, got %t", tt.about, tt.due, due)
+		}
+		if degraded := cluster.restoreVerifyFailed(); degraded != tt.degraded {
+			t.Errorf("TestRestoreVerifyDue %s: expected failed %t, got %t", tt.about, tt.degraded, degraded)
+		}
+	}
+}
+
+func TestRestoreTestResult(t *testing.T) {
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		about   string
+		phase   spec.ClusterPhase
+		created time.Time
+		done    bool
+		failure string
+	}{
+		{"still creating", spec.ClusterStatusCreating, now.Add(-time.Hour), false, ""},
+		{"running", spec.ClusterStatusRunning, now.Add(-time.Hour), true, ""},
+		{"clone failed", spec.ClusterStatusCloneFailed, now.Add(-time.Hour), true, "failed to start"},
+		{"invalid manifest", spec.ClusterStatusInvalid, now.Add(-time.Minute), true, "invalid"},
+		{"timed out", spec.ClusterStatusCreating, now.Add(-3 * time.Hour), true, "not running after 2h0m0s"},
+	}
+	for _, tt := range tests {
+		test := &spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(tt.created)},
+			Status:     spec.PostgresStatus{Phase: tt.phase},
+		}
+		done, failure := restoreTestResult(test, now, 2*time.Hour)
+		if done != tt.done {
+			t.Errorf("TestRestoreTestResult %s: expected done %t, got %t", tt.about, tt.done, done)
+		}
+		if (tt.failure == "") != (failure == "") || !strings.Contains(failure, tt.failure) {
+			t.Errorf("TestRestoreTestResult %s: expected failure containing %q, got %q", tt.about, tt.failure, failure)
+		}
+	}
+}
+
+func TestGenerateRestoreTestCluster(t *testing.T) {
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	enabled := true
+	cluster := New(Config{OpConfig: config.Config{WALES3Bucket: "operator-bucket"}}, k8sutil.KubernetesClient{},
+		spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test", UID: types.UID("1234")},
+			Spec: spec.PostgresSpec{
+				TeamID:              "acid",
+				NumberOfInstances:   3,
+				Volume:              spec.Volume{Size: "10Gi", AutoGrow: &spec.VolumeAutoGrow{}},
+				Backup:              &spec.Backup{S3Prefix: "team/", VerifyRestore: true},
+				FinalBackupOnDelete: &enabled,
+			},
+		}, logger)
+
+	pg := cluster.generateRestoreTestCluster(now)
+	if pg.Name != "acid-test-restore-test" || pg.Namespace != "test" || pg.Labels[restoreTestLabel] != "acid-test" {
+		t.Errorf("TestGenerateRestoreTestCluster: unexpected metadata %#v", pg.ObjectMeta)
+	}
+	if pg.Spec.NumberOfInstances != 1 || pg.Spec.TeamID != "acid" || pg.Spec.Volume.Size != "10Gi" ||
+		pg.Spec.Volume.AutoGrow != nil {
+		t.Errorf("TestGenerateRestoreTestCluster: unexpected spec %#v", pg.Spec)
+	}
+	expectedClone := spec.CloneDescription{
+		ClusterName:  "acid-test",
+		Uid:          "1234",
+		EndTimestamp: "2018-10-15T12:00:00Z",
+		S3Bucket:     "operator-bucket",
+		S3Prefix:     "team/",
+	}
+	if pg.Spec.Clone != expectedClone {
+		t.Errorf("TestGenerateRestoreTestCluster: expected clone %#v, got %#v", expectedClone, pg.Spec.Clone)
+	}
+	if pg.Spec.Backup.VerifyRestore || pg.Spec.Backup.S3Prefix != restoreTestS3Prefix {
+		t.Errorf("TestGenerateRestoreTestCluster: unexpected backup section %#v", pg.Spec.Backup)
+	}
+	if pg.Spec.FinalBackupOnDelete == nil || *pg.Spec.FinalBackupOnDelete {
+		t.Errorf("TestGenerateRestoreTestCluster: the final backup of the throwaway cluster is not disabled")
+	}
+}
@@ -0,0 +1,110 @@
+package cluster
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+// the retained WAL is counted from the restart_lsn, the lag from the position confirmed by the consumer
+const getLogicalSlotsSQL = `SELECT slot_name, COALESCE(database, ''), active,
+	        COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), restart_lsn), 0)::bigint,
+	        COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn), 0)::bigint
+	 FROM pg_replication_slots
+	 WHERE slot_type = 'logical'
+	 ORDER BY slot_name;`
+
+// the WAL functions are called xlog/location before Postgres 10
+var getLogicalSlotsSQL96 = strings.NewReplacer(
+	"pg_wal_lsn_diff", "pg_xlog_location_diff",
+	"pg_current_wal_lsn", "pg_current_xlog_location").Replace(getLogicalSlotsSQL)
+
+func readLogicalSlots(db *sql.DB, pgVersion string) ([]spec.LogicalSlot, error) {
+	query := getLogicalSlotsSQL
+	if version, err := strconv.ParseFloat(pgVersion, 64); err == nil && version < 10 {
+		query = getLogicalSlotsSQL96
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("could not query logical replication slots: %v", err)
+	}
+	defer rows.Close()
+
+	slots := make([]spec.LogicalSlot, 0)
+	for rows.Next() {
+		var slot spec.LogicalSlot
+		if err := rows.Scan(&slot.Name, &slot.Database, &slot.Active, &slot.RetainedWALBytes, &slot.LagBytes); err != nil {
+			return nil, fmt.Errorf("error when processing logical replication slots: %v", err)
+		}
+		slots = append(slots, slot)
+	}
+
+	return slots, rows.Err()
+}
+
+// checkLogicalSlots reports the WAL retained by the logical replication slots, which is never removed as long
+// as the consumer does not confirm it. It returns true when a slot retains more WAL than the configured
+// threshold, since the volume fills up eventually then.
+func (c *Cluster) checkLogicalSlots() bool {
+	c.setProcessName("checking logical replication slots")
+
+	if err := c.initDbConn(); err != nil {
+		c.logger.Warningf("could not init database connection to check logical replication slots: %v", err)
+		return false
+	}
+	defer func() {
+		if err := c.closeDbConn(); err != nil {
+			c.logger.Errorf("could not close database connection: %v", err)
+		}
+	}()
+
+	slots, err := readLogicalSlots(c.pgDb, c.Spec.PgVersion)
+	if err != nil {
+		c.logger.Warningf("could not check logical replication slots: %v", err)
+		return false
+	}
+
+	c.metricsMu.Lock()
+	c.logicalSlots = slots
+	c.metricsMu.Unlock()
+
+	if c.OpConfig.LogicalSlotMaxRetainedWAL == "" {
+		return false
+	}
+	threshold, err := resource.ParseQuantity(c.OpConfig.LogicalSlotMaxRetainedWAL)
+	if err != nil {
+		c.logger.Warningf("could not parse the logical slot retained WAL threshold %q: %v",
+			c.OpConfig.LogicalSlotMaxRetainedWAL, err)
+		return false
+	}
+
+	lagging := make([]string, 0)
+	for _, slot := range slots {
+		if slot.RetainedWALBytes > threshold.Value() {
+			lagging = append(lagging, fmt.Sprintf("%q (%s retained)", slot.Name,
+				resource.NewQuantity(slot.RetainedWALBytes, resource.BinarySI).String()))
+		}
+	}
+	if len(lagging) == 0 {
+		return false
+	}
+	message := fmt.Sprintf("logical replication slots retain more WAL than %s: %s",
+		c.OpConfig.LogicalSlotMaxRetainedWAL, strings.Join(lagging, ", "))
+	c.logger.Warning(message)
+	c.createWarningEvent(eventReasonSlotLagging, message)
+
+	return true
+}
+
+// GetLogicalSlots returns the logical replication slots found during the last sync
+func (c *Cluster) GetLogicalSlots() []spec.LogicalSlot {
+	c.metricsMu.RLock()
+	defer c.metricsMu.RUnlock()
+
+	return c.logicalSlots
+}
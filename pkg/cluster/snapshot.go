@@ -0,0 +1,309 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/retryutil"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/volumes"
+)
+
+// the snapshots of all volumes taken at once share the value of that label, the value sorts by the time they were taken
+const (
+	volumeSnapshotSetLabel  = "volume-snapshot-set"
+	volumeSnapshotSetFormat = "20060102-150405"
+)
+
+// syncVolumeSnapshots snapshots the data volumes when the interval of the manifest has passed since the last set of
+// snapshots and removes the sets beyond the retention. It is called from the sync, so the interval is only as
+// precise as the resync period of the operator.
+func (c *Cluster) syncVolumeSnapshots() error {
+	c.setProcessName("syncing volume snapshots")
+
+	config := c.Spec.Backup.VolumeSnapshots
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil {
+		return fmt.Errorf("could not parse volume snapshot interval: %v", err)
+	}
+	snapshots, err := c.listVolumeSnapshots()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if volumeSnapshotDue(snapshots, interval, now) {
+		taken, err := c.takeVolumeSnapshots(now)
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, taken...)
+	}
+
+	if config.Retention == nil {
+		return nil
+	}
+	for _, snapshot := range expiredVolumeSnapshots(snapshots, int(*config.Retention)) {
+		if err := c.deleteVolumeSnapshot(snapshot.Name); err != nil {
+			return err
+		}
+		c.logger.Infof("volume snapshot %q beyond the retention has been deleted", snapshot.Name)
+	}
+
+	return nil
+}
+
+func (c *Cluster) takeVolumeSnapshots(now time.Time) ([]spec.VolumeSnapshot, error) {
+	pvcs, err := c.listPersistentVolumeClaims()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []spec.VolumeSnapshot
+	set := now.UTC().Format(volumeSnapshotSetFormat)
+	for _, pvc := range pvcs {
+		snapshot := c.generateVolumeSnapshot(pvc.Name, set)
+		if err := c.createVolumeSnapshot(snapshot); err != nil {
+			return nil, err
+		}
+		c.logger.Infof("volume snapshot %q of the persistent volume claim %q has been created", snapshot.Name, pvc.Name)
+		result = append(result, *snapshot)
+	}
+
+	return result, nil
+}
+
+func (c *Cluster) generateVolumeSnapshot(pvcName, set string) *spec.VolumeSnapshot {
+	labels := c.labelsSet(true)
+	labels[volumeSnapshotSetLabel] = set
+
+	return &spec.VolumeSnapshot{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "VolumeSnapshot",
+			APIVersion: fmt.Sprintf("%s/%s", constants.VolumeSnapshotGroup, constants.VolumeSnapshotApiVersion),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", pvcName, set),
+			Namespace: c.Namespace,
+			Labels:    labels,
+		},
+		Spec: spec.VolumeSnapshotSpec{
+			Source:                  &spec.VolumeSnapshotSource{Kind: "PersistentVolumeClaim", Name: pvcName},
+			VolumeSnapshotClassName: c.volumeSnapshotClassName(),
+		},
+	}
+}
+
+// volumeSnapshotClassName returns the class of the snapshots given in the manifest, the empty string makes the
+// snapshotter fall back to the default class.
+func (c *Cluster) volumeSnapshotClassName() string {
+	if c.Spec.Backup == nil || c.Spec.Backup.VolumeSnapshots == nil {
+		return ""
+	}
+	return c.Spec.Backup.VolumeSnapshots.SnapshotClassName
+}
+
+func (c *Cluster) createVolumeSnapshot(snapshot *spec.VolumeSnapshot) error {
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("could not marshal volume snapshot: %v", err)
+	}
+	_, err = c.KubeClient.SnapshotREST.Post().
+		Namespace(c.Namespace).
+		Resource(constants.VolumeSnapshotResource).
+		Body(body).
+		DoRaw()
+	if err != nil {
+		return fmt.Errorf("could not create volume snapshot %q: %v", snapshot.Name, err)
+	}
+	return nil
+}
+
+func (c *Cluster) getVolumeSnapshot(name string) (*spec.VolumeSnapshot, error) {
+	b, err := c.KubeClient.SnapshotREST.Get().
+		Namespace(c.Namespace).
+		Resource(constants.VolumeSnapshotResource).
+		Name(name).
+		DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("could not get volume snapshot %q: %v", name, err)
+	}
+
+	var snapshot spec.VolumeSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return nil, fmt.Errorf("could not unmarshal volume snapshot %q: %v", name, err)
+	}
+	return &snapshot, nil
+}
+
+func (c *Cluster) listVolumeSnapshots() ([]spec.VolumeSnapshot, error) {
+	options := metav1.ListOptions{
+		LabelSelector: c.labelsSet(false).String(),
+	}
+	b, err := c.KubeClient.SnapshotREST.Get().
+		Namespace(c.Namespace).
+		Resource(constants.VolumeSnapshotResource).
+		VersionedParams(&options, metav1.ParameterCodec).
+		DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("could not list volume snapshots: %v", err)
+	}
+
+	var list spec.VolumeSnapshotList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("could not unmarshal list of volume snapshots: %v", err)
+	}
+	return list.Items, nil
+}
+
+func (c *Cluster) deleteVolumeSnapshot(name string) error {
+	_, err := c.KubeClient.SnapshotREST.Delete().
+		Namespace(c.Namespace).
+		Resource(constants.VolumeSnapshotResource).
+		Name(name).
+		DoRaw()
+	if err != nil {
+		return fmt.Errorf("could not delete volume snapshot %q: %v", name, err)
+	}
+	return nil
+}
+
+// volumeSnapshotDue tells whether no set of snapshots has been taken within the interval.
+func volumeSnapshotDue(snapshots []spec.VolumeSnapshot, interval time.Duration, now time.Time) bool {
+	sets := volumeSnapshotSets(snapshots)
+	if len(sets) == 0 {
+		return true
+	}
+	last, err := time.Parse(volumeSnapshotSetFormat, sets[0])
+	if err != nil {
+		return true
+	}
+	return now.Sub(last) >= interval
+}
+
+// expiredVolumeSnapshots returns the snapshots of all but the newest sets given by the retention.
+func expiredVolumeSnapshots(snapshots []spec.VolumeSnapshot, retention int) []spec.VolumeSnapshot {
+	sets := volumeSnapshotSets(snapshots)
+	if len(sets) <= retention {
+		return nil
+	}
+
+	expired := make(map[string]bool)
+	for _, set := range sets[retention:] {
+		expired[set] = true
+	}
+	var result []spec.VolumeSnapshot
+	for _, snapshot := range snapshots {
+		if expired[snapshot.Labels[volumeSnapshotSetLabel]] {
+			result = append(result, snapshot)
+		}
+	}
+	return result
+}
+
+// volumeSnapshotSets returns the sets of the snapshots, the newest first. Snapshots not taken by the operator are
+// ignored.
+func volumeSnapshotSets(snapshots []spec.VolumeSnapshot) []string {
+	seen := make(map[string]bool)
+	var sets []string
+	for _, snapshot := range snapshots {
+		set := snapshot.Labels[volumeSnapshotSetLabel]
+		if set == "" || seen[set] {
+			continue
+		}
+		seen[set] = true
+		sets = append(sets, set)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sets)))
+	return sets
+}
+
+// generateResizeSnapshot returns the snapshot of the claim taken before its resize. It belongs to no set, so that the
+// retention of the periodic snapshots leaves it alone.
+func (c *Cluster) generateResizeSnapshot(pvcName string, now time.Time) *spec.VolumeSnapshot {
+	snapshot := c.generateVolumeSnapshot(pvcName, now.UTC().Format(volumeSnapshotSetFormat))
+	snapshot.Name = fmt.Sprintf("%s-resize-%s", pvcName, now.UTC().Format(volumeSnapshotSetFormat))
+	delete(snapshot.Labels, volumeSnapshotSetLabel)
+	return snapshot
+}
+
+// snapshotVolumeClaimsBeforeResize snapshots the claims with the CSI snapshotter and waits until the snapshotter has
+// cut all of them, since the claims must not grow before.
+func (c *Cluster) snapshotVolumeClaimsBeforeResize(pvcNames []string) error {
+	now := time.Now()
+	snapshots := make([]*spec.VolumeResizeSnapshot, 0, len(pvcNames))
+	for _, pvcName := range pvcNames {
+		snapshot := c.generateResizeSnapshot(pvcName, now)
+		if err := c.createVolumeSnapshot(snapshot); err != nil {
+			return err
+		}
+		c.logger.Infof("volume snapshot %q of the persistent volume claim %q has been created before the resize",
+			snapshot.Name, pvcName)
+		snapshots = append(snapshots, &spec.VolumeResizeSnapshot{
+			Volume:   pvcName,
+			Snapshot: snapshot.Name,
+			Time:     metav1.NewTime(now),
+		})
+	}
+
+	for _, snapshot := range snapshots {
+		name := snapshot.Snapshot
+		err := retryutil.Retry(c.OpConfig.ResourceCheckInterval, c.OpConfig.ResourceCheckTimeout,
+			func() (bool, error) {
+				current, err := c.getVolumeSnapshot(name)
+				if err != nil {
+					return false, err
+				}
+				return current.Status.CreationTime != nil, nil
+			})
+		if err != nil {
+			return fmt.Errorf("volume snapshot %q has not been taken: %v", name, err)
+		}
+	}
+	c.recordResizeSnapshots(snapshots)
+
+	return nil
+}
+
+// snapshotVolumeBeforeResize snapshots the volume at its provider. A provider unable to snapshot its volumes blocks
+// the resize, the snapshot is the way back if the resize corrupts the filesystem.
+func (c *Cluster) snapshotVolumeBeforeResize(r volumeResize) (*spec.VolumeResizeSnapshot, error) {
+	snapshotter, ok := r.resizer.(volumes.VolumeSnapshotter)
+	if !ok {
+		return nil, fmt.Errorf("volume provider of the persistent volume %q does not support snapshots", r.pv.Name)
+	}
+	volumeID, err := r.resizer.GetProviderVolumeID(r.pv)
+	if err != nil {
+		return nil, err
+	}
+	description := fmt.Sprintf("%s/%s: %s before the resize", c.Namespace, c.Name, r.pv.Name)
+	snapshotID, err := snapshotter.SnapshotVolume(volumeID, description)
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Infof("volume %q of the persistent volume %q has been snapshotted to %q before the resize",
+		volumeID, r.pv.Name, snapshotID)
+
+	return &spec.VolumeResizeSnapshot{Volume: r.pv.Name, Snapshot: snapshotID, Time: metav1.Now()}, nil
+}
+
+// recordResizeSnapshots replaces the snapshots in the status of the cluster with the ones taken before the latest
+// resize, the sync writes them to the manifest.
+func (c *Cluster) recordResizeSnapshots(snapshots []*spec.VolumeResizeSnapshot) {
+	var taken []spec.VolumeResizeSnapshot
+	for _, snapshot := range snapshots {
+		if snapshot != nil {
+			taken = append(taken, *snapshot)
+		}
+	}
+	if len(taken) == 0 {
+		return
+	}
+	sort.Slice(taken, func(i, j int) bool { return taken[i].Volume < taken[j].Volume })
+	c.Status.ResizeSnapshots = taken
+}
@@ -0,0 +1,221 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/volumes"
+)
+
+func volumeSnapshot(name, set string) spec.VolumeSnapshot {
+	snapshot := spec.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+	if set != "" {
+		snapshot.Labels[volumeSnapshotSetLabel] = set
+	}
+	return snapshot
+}
+
+func TestVolumeSnapshotDue(t *testing.T) {
+	testName := "TestVolumeSnapshotDue"
+	now := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		subtest   string
+		snapshots []spec.VolumeSnapshot
+		due       bool
+	}{
+		{
+			subtest: "no snapshots yet",
+			due:     true,
+		},
+		{
+			subtest:   "only snapshots not taken by the operator",
+			snapshots: []spec.VolumeSnapshot{volumeSnapshot("manual", "")},
+			due:       true,
+		},
+		{
+			subtest: "last set within the interval",
+			snapshots: []spec.VolumeSnapshot{
+				volumeSnapshot("pgdata-acid-test-0-20181014-000000", "20181014-000000"),
+				volumeSnapshot("pgdata-acid-test-0-20181015-000000", "20181015-000000"),
+			},
+		},
+		{
+			subtest: "last set older than the interval",
+			snapshots: []spec.VolumeSnapshot{
+				volumeSnapshot("pgdata-acid-test-0-20181014-000000", "20181014-000000"),
+				volumeSnapshot("pgdata-acid-test-0-20181014-110000", "20181014-110000"),
+			},
+			due: true,
+		},
+	}
+	for _, tt := range tests {
+		if due := volumeSnapshotDue(tt.snapshots, 24*time.Hour, now); due != tt.due {
+			t.Errorf("%s %s: expected due %t, got %t", testName, tt.subtest, tt.due, due)
+		}
+	}
+}
+
+func TestExpiredVolumeSnapshots(t *testing.T) {
+	testName := "TestExpiredVolumeSnapshots"
+	snapshots := []spec.VolumeSnapshot{
+		volumeSnapshot("pgdata-acid-test-0-20181013-000000", "20181013-000000"),
+		volumeSnapshot("pgdata-acid-test-1-20181013-000000", "20181013-000000"),
+		volumeSnapshot("pgdata-acid-test-0-20181015-000000", "20181015-000000"),
+		volumeSnapshot("pgdata-acid-test-1-20181015-000000", "20181015-000000"),
+		volumeSnapshot("pgdata-acid-test-0-20181014-000000", "20181014-000000"),
+		volumeSnapshot("pgdata-acid-test-1-20181014-000000", "20181014-000000"),
+		volumeSnapshot("manual", ""),
+	}
+	tests := []struct {
+		subtest   string
+		retention int
+		expired   []string
+	}{
+		{
+			subtest:   "all sets within the retention",
+			retention: 3,
+		},
+		{
+			subtest:   "oldest set expired",
+			retention: 2,
+			expired:   []string{"pgdata-acid-test-0-20181013-000000", "pgdata-acid-test-1-20181013-000000"},
+		},
+		{
+			subtest:   "only the newest set kept",
+			retention: 1,
+			expired: []string{"pgdata-acid-test-0-20181013-000000", "pgdata-acid-test-1-20181013-000000",
+				"pgdata-acid-test-0-20181014-000000", "pgdata-acid-test-1-20181014-000000"},
+		},
+	}
+	for _, tt := range tests {
+		var expired []string
+		for _, snapshot := range expiredVolumeSnapshots(snapshots, tt.retention) {
+			expired = append(expired, snapshot.Name)
+		}
+		if !reflect.DeepEqual(expired, tt.expired) {
+			t.Errorf("%s %s: expected the expired snapshots %v, got %v", testName, tt.subtest, tt.expired, expired)
+		}
+	}
+}
+
+func TestGenerateVolumeSnapshot(t *testing.T) {
+	cluster := New(
+		Config{OpConfig: config.Config{Resources: config.Resources{ClusterNameLabel: "cluster-name"}}},
+		k8sutil.KubernetesClient{},
+		spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+			Spec: spec.PostgresSpec{
+				TeamID: "acid",
+				Backup: &spec.Backup{VolumeSnapshots: &spec.VolumeSnapshots{Interval: "24h", SnapshotClassName: "csi-aws-ebs"}},
+			},
+		}, logger)
+
+	snapshot := cluster.generateVolumeSnapshot("pgdata-acid-test-0", "20181015-120000")
+	if snapshot.Name != "pgdata-acid-test-0-20181015-120000" {
+		t.Errorf("TestGenerateVolumeSnapshot: unexpected name %q", snapshot.Name)
+	}
+	expectedLabels := map[string]string{"cluster-name": "acid-test", "team": "acid", volumeSnapshotSetLabel: "20181015-120000"}
+	if !reflect.DeepEqual(snapshot.Labels, expectedLabels) {
+		t.Errorf("TestGenerateVolumeSnapshot: expected labels %v, got %v", expectedLabels, snapshot.Labels)
+	}
+	expectedSpec := spec.VolumeSnapshotSpec{
+		Source:                  &spec.VolumeSnapshotSource{Kind: "PersistentVolumeClaim", Name: "pgdata-acid-test-0"},
+		VolumeSnapshotClassName: "csi-aws-ebs",
+	}
+	if !reflect.DeepEqual(snapshot.Spec, expectedSpec) {
+		t.Errorf("TestGenerateVolumeSnapshot: expected spec %+v, got %+v", expectedSpec, snapshot.Spec)
+	}
+}
+
+func TestGenerateResizeSnapshot(t *testing.T) {
+	cluster := New(
+		Config{OpConfig: config.Config{Resources: config.Resources{ClusterNameLabel: "cluster-name"}}},
+		k8sutil.KubernetesClient{},
+		spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"},
+			Spec:       spec.PostgresSpec{TeamID: "acid"},
+		}, logger)
+
+	snapshot := cluster.generateResizeSnapshot("pgdata-acid-test-0", time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC))
+	if snapshot.Name != "pgdata-acid-test-0-resize-20181015-120000" {
+		t.Errorf("TestGenerateResizeSnapshot: unexpected name %q", snapshot.Name)
+	}
+	expectedLabels := map[string]string{"cluster-name": "acid-test", "team": "acid"}
+	if !reflect.DeepEqual(snapshot.Labels, expectedLabels) {
+		t.Errorf("TestGenerateResizeSnapshot: expected labels %v, got %v", expectedLabels, snapshot.Labels)
+	}
+	if snapshot.Spec.VolumeSnapshotClassName != "" {
+		t.Errorf("TestGenerateResizeSnapshot: expected the default snapshot class, got %q",
+			snapshot.Spec.VolumeSnapshotClassName)
+	}
+}
+
+type fakeVolumeResizer struct {
+	volumes.VolumeResizer
+}
+
+func (r *fakeVolumeResizer) GetProviderVolumeID(pv *v1.PersistentVolume) (string, error) {
+	return "vol-" + pv.Name, nil
+}
+
+type fakeVolumeSnapshotter struct {
+	fakeVolumeResizer
+	snapshotted []string
+}
+
+func (r *fakeVolumeSnapshotter) SnapshotVolume(volumeID, description string) (string, error) {
+	r.snapshotted = append(r.snapshotted, volumeID)
+	return "snap-" + volumeID, nil
+}
+
+func TestSnapshotVolumeBeforeResize(t *testing.T) {
+	testName := "TestSnapshotVolumeBeforeResize"
+	cluster := New(Config{OpConfig: config.Config{SnapshotVolumesBeforeResize: true}}, k8sutil.KubernetesClient{},
+		spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "default"}}, logger)
+
+	snapshotter := &fakeVolumeSnapshotter{}
+	tests := []struct {
+		subtest  string
+		resizer  volumes.VolumeResizer
+		snapshot string
+		err      bool
+	}{
+		{"provider with snapshots", snapshotter, "snap-vol-pv-1", false},
+		{"provider without snapshots", &fakeVolumeResizer{}, "", true},
+	}
+	var taken []*spec.VolumeResizeSnapshot
+	for _, tt := range tests {
+		pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}
+		snapshot, err := cluster.snapshotVolumeBeforeResize(volumeResize{pv: pv, resizer: tt.resizer})
+		if (err != nil) != tt.err {
+			t.Errorf("%s %s: expected error %t, got %v", testName, tt.subtest, tt.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if snapshot.Volume != "pv-1" || snapshot.Snapshot != tt.snapshot {
+			t.Errorf("%s %s: unexpected snapshot %+v", testName, tt.subtest, snapshot)
+		}
+		taken = append(taken, snapshot)
+	}
+	if !reflect.DeepEqual(snapshotter.snapshotted, []string{"vol-pv-1"}) {
+		t.Errorf("%s: unexpected snapshotted volumes %v", testName, snapshotter.snapshotted)
+	}
+
+	cluster.recordResizeSnapshots(append(taken, nil))
+	if len(cluster.Status.ResizeSnapshots) != 1 || cluster.Status.ResizeSnapshots[0].Snapshot != "snap-vol-pv-1" {
+		t.Errorf("%s: unexpected snapshots in the status %+v", testName, cluster.Status.ResizeSnapshots)
+	}
+	cluster.recordResizeSnapshots(nil)
+	if len(cluster.Status.ResizeSnapshots) != 1 {
+		t.Errorf("%s: the snapshots of the last resize are not kept in the status", testName)
+	}
+}
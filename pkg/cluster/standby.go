@@ -0,0 +1,46 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/util/retryutil"
+)
+
+// the role Patroni reports for the promoted leader, as opposed to the standby_leader of a standby cluster
+const patroniMasterRole = "master"
+
+// promoteStandbyCluster turns the standby cluster into a regular one after the standby section has been removed
+// from the manifest. Patroni promotes the standby leader once the standby configuration is gone from the DCS, the
+// pods are only rolled afterwards, when they no longer get the standby environment.
+func (c *Cluster) promoteStandbyCluster() error {
+	c.setProcessName("promoting the standby cluster")
+
+	masterPods, err := c.getRolePods(Master)
+	if err != nil {
+		return fmt.Errorf("could not get master pod: %v", err)
+	}
+	if len(masterPods) == 0 {
+		return fmt.Errorf("no standby leader is running in the cluster")
+	}
+	leader := &masterPods[0]
+
+	if err := c.patroni.PromoteStandbyCluster(leader); err != nil {
+		return fmt.Errorf("could not remove the standby configuration: %v", err)
+	}
+
+	err = retryutil.Retry(c.OpConfig.ResourceCheckInterval, c.OpConfig.ResourceCheckTimeout,
+		func() (bool, error) {
+			status, err := c.patroni.GetMemberStatus(leader)
+			if err != nil {
+				c.logger.Debugf("could not get the status of the standby leader: %v", err)
+				return false, nil
+			}
+			return status.Role == patroniMasterRole, nil
+		})
+	if err != nil {
+		return fmt.Errorf("standby leader %q has not been promoted: %v", leader.Name, err)
+	}
+	c.logger.Infof("standby leader %q has been promoted", leader.Name)
+
+	return nil
+}
@@ -3,14 +3,23 @@ package cluster
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/pkg/api/v1"
 	policybeta1 "k8s.io/client-go/pkg/apis/policy/v1beta1"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
 	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/retryutil"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/users"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/volumes"
 )
 
@@ -19,15 +28,22 @@ import (
 func (c *Cluster) Sync(newSpec *spec.Postgresql) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	defer c.startSpan("Sync")()
 
 	c.setSpec(newSpec)
 
+	degraded, quorumLost, cloneFailed, backupProbed, replicationUpdated := false, false, false, false, false
 	defer func() {
 		if err != nil {
 			c.logger.Warningf("error while syncing cluster state: %v", err)
-			c.setStatus(spec.ClusterStatusSyncFailed)
-		} else if c.Status != spec.ClusterStatusRunning {
-			c.setStatus(spec.ClusterStatusRunning)
+		}
+		status := syncResultStatus(err, quorumLost, degraded || c.hasCrashLoopingPods())
+		if err != nil && cloneFailed {
+			status = spec.ClusterStatusCloneFailed
+		}
+		if status != spec.ClusterStatusRunning || c.Status.Phase != spec.ClusterStatusRunning || backupProbed ||
+			replicationUpdated {
+			c.setStatus(status)
 		}
 	}()
 
@@ -44,12 +60,27 @@ func (c *Cluster) Sync(newSpec *spec.Postgresql) (err error) {
 		return
 	}
 
+	c.logger.Debugf("detecting pod role label")
+	if pods, err := c.listPods(); err != nil {
+		c.logger.Warningf("could not detect the pod role label: %v", err)
+	} else {
+		c.updatePodRoleLabel(pods)
+		quorumLost = c.dcsQuorumLost(pods)
+	}
+
 	c.logger.Debugf("syncing services")
 	if err = c.syncServices(); err != nil {
 		err = fmt.Errorf("could not sync services: %v", err)
 		return
 	}
 
+	// the remaining steps may resize volumes, recreate pods or fail over, none of which should happen
+	// while Patroni keeps the cluster without a master on purpose.
+	if quorumLost {
+		c.reportDCSQuorumLoss()
+		return
+	}
+
 	// potentially enlarge volumes before changing the statefulset. By doing that
 	// in this order we make sure the operator is not stuck waiting for a pod that
 	// cannot start because it ran out of disk space.
@@ -66,21 +97,115 @@ func (c *Cluster) Sync(newSpec *spec.Postgresql) (err error) {
 	if err = c.syncStatefulSet(); err != nil {
 		if !k8sutil.ResourceAlreadyExists(err) {
 			err = fmt.Errorf("could not sync statefulsets: %v", err)
+			// the statefulset of a failed clone is re-created by the sync, which retries the clone
+			cloneFailed = c.handleFailedClone()
 			return
 		}
 	}
 
-	// create database objects unless we are running without pods or disabled that feature explicitely
-	if !(c.databaseAccessDisabled() || c.getNumberOfInstances(&newSpec.Spec) <= 0) {
-		c.logger.Debugf("syncing roles")
-		if err = c.syncRoles(); err != nil {
-			err = fmt.Errorf("could not sync roles: %v", err)
+	if err = c.syncVolumeShrink(); err != nil {
+		err = fmt.Errorf("could not shrink volumes: %v", err)
+		return
+	}
+
+	// create database objects unless we are running without pods or disabled that feature explicitely, the standby
+	// cluster does not accept writes and the checks below rely on functions unavailable during the recovery
+	if !(c.isStandbyCluster() || c.databaseAccessDisabled() || c.getNumberOfInstances(&newSpec.Spec) <= 0) {
+		if err = c.syncDatabaseObjects(); err != nil {
 			return
 		}
-		c.logger.Debugf("syncing databases")
-		if err = c.syncDatabases(); err != nil {
-			err = fmt.Errorf("could not sync databases: %v", err)
-			return
+		if c.OpConfig.RemovedRoleAction != config.RemovedRoleActionIgnore {
+			c.logger.Debugf("deprovisioning roles removed from the manifest")
+			if removed, err := c.removedSecretRoles(); err != nil {
+				c.logger.Warningf("could not find the roles removed from the manifest: %v", err)
+			} else if len(removed) > 0 {
+				if err := c.deprovisionRoles(removed); err != nil {
+					c.logger.Warningf("could not deprovision roles: %v", err)
+				}
+			}
+		}
+		if c.OpConfig.PasswordRotationIntervalDays > 0 {
+			c.logger.Debugf("rotating passwords")
+			if err := c.rotatePasswords(); err != nil {
+				c.logger.Warningf("could not rotate passwords: %v", err)
+			}
+		}
+		if c.systemPasswordsRotationRequest() != "" {
+			c.logger.Debugf("rotating passwords of the system users")
+			if err := c.rotateSystemPasswords(); err != nil {
+				c.logger.Warningf("could not rotate passwords of the system users: %v", err)
+			}
+		}
+		c.logger.Debugf("checking postgres version")
+		degraded = c.checkPgVersion()
+		if c.Spec.CheckDataChecksums {
+			c.logger.Debugf("checking data checksum failures")
+			degraded = c.checkDataChecksums() || degraded
+		}
+		c.logger.Debugf("checking logical replication slots")
+		degraded = c.checkLogicalSlots() || degraded
+		c.logger.Debugf("collecting metrics")
+		if err := c.collectMetrics(); err != nil {
+			c.logger.Warningf("could not collect metrics: %v", err)
+		}
+		if err := c.updateReplicationStatus(); err != nil {
+			c.logger.Warningf("could not update replication status: %v", err)
+		} else {
+			replicationUpdated = true
+		}
+		c.logger.Debugf("probing backups")
+		if err := c.updateBackupStatus(); err != nil {
+			c.logger.Warningf("could not probe backups: %v", err)
+		} else {
+			backupProbed = true
+		}
+	}
+
+	if c.getNumberOfInstances(&newSpec.Spec) > 0 && c.backupPruneDue(time.Now()) {
+		c.logger.Debugf("removing base backups beyond the retention")
+		if err := c.pruneBackups(); err != nil {
+			c.logger.Warningf("could not remove old base backups: %v", err)
+		}
+	}
+
+	if c.getNumberOfInstances(&newSpec.Spec) > 0 && c.walVerifyDue(time.Now()) {
+		c.logger.Debugf("verifying the WAL archive")
+		if err := c.verifyWALArchive(); err != nil {
+			c.logger.Warningf("could not verify the WAL archive: %v", err)
+		} else {
+			backupProbed = true
+		}
+	}
+	degraded = c.walVerifyFailed() || degraded
+
+	if c.Spec.Backup != nil && c.Spec.Backup.VerifyRestore && !c.isStandbyCluster() {
+		c.logger.Debugf("verifying the restore of the backups")
+		if changed, err := c.syncRestoreVerification(); err != nil {
+			c.logger.Warningf("could not verify the restore of the backups: %v", err)
+		} else if changed {
+			backupProbed = true
+		}
+	}
+	degraded = c.restoreVerifyFailed() || degraded
+
+	if c.Spec.Backup != nil && c.Spec.Backup.VolumeSnapshots != nil && c.getNumberOfInstances(&newSpec.Spec) > 0 {
+		c.logger.Debugf("syncing volume snapshots")
+		if err := c.syncVolumeSnapshots(); err != nil {
+			c.logger.Warningf("could not sync volume snapshots: %v", err)
+		}
+	}
+
+	if c.Spec.Volume.AutoGrow != nil && c.getNumberOfInstances(&newSpec.Spec) > 0 {
+		c.logger.Debugf("checking volume usage")
+		if err := c.autoGrowVolumes(); err != nil {
+			c.logger.Warningf("could not grow volumes automatically: %v", err)
+		}
+	}
+
+	if c.OpConfig.ResourceRecommendations {
+		c.logger.Debugf("computing resource recommendations")
+		if err := c.updateResourceRecommendation(); err != nil {
+			c.logger.Warningf("could not compute resource recommendations: %v", err)
 		}
 	}
 
@@ -90,10 +215,30 @@ func (c *Cluster) Sync(newSpec *spec.Postgresql) (err error) {
 		return
 	}
 
+	c.logger.Debug("syncing logical backup job")
+	if err = c.syncLogicalBackupJob(); err != nil {
+		err = fmt.Errorf("could not sync logical backup job: %v", err)
+		return
+	}
+
 	return
 }
 
+// syncResultStatus returns the status of the cluster after the sync.
+func syncResultStatus(err error, quorumLost, degraded bool) spec.ClusterPhase {
+	switch {
+	case err != nil:
+		return spec.ClusterStatusSyncFailed
+	case quorumLost:
+		return spec.ClusterStatusDCSQuorumLost
+	case degraded:
+		return spec.ClusterStatusDegraded
+	}
+	return spec.ClusterStatusRunning
+}
+
 func (c *Cluster) syncServices() error {
+	defer c.startSpan("syncServices")()
 	for _, role := range []PostgresRole{Master, Replica} {
 		c.logger.Debugf("syncing %s service", role)
 
@@ -225,7 +370,52 @@ func (c *Cluster) syncPodDisruptionBudget(isUpdate bool) error {
 	return nil
 }
 
+// syncLogicalBackupJob makes the cron job follow the manifest: it is created or updated when
+// the logical backup is enabled and removed once it is disabled.
+func (c *Cluster) syncLogicalBackupJob() error {
+	job, err := c.KubeClient.CronJobs(c.Namespace).Get(c.logicalBackupJobName(), metav1.GetOptions{})
+	if err != nil && !k8sutil.ResourceNotFound(err) {
+		return fmt.Errorf("could not get logical backup job: %v", err)
+	}
+	if err == nil {
+		c.LogicalBackupJob = job
+	} else {
+		c.LogicalBackupJob = nil
+	}
+
+	if !c.Spec.EnableLogicalBackup {
+		if c.LogicalBackupJob == nil {
+			return nil
+		}
+		return c.deleteLogicalBackupJob()
+	}
+
+	newJob := c.generateLogicalBackupJob()
+	if c.LogicalBackupJob == nil {
+		c.logger.Infof("could not find the cluster's logical backup job")
+		if job, err = c.createLogicalBackupJob(); err != nil {
+			return fmt.Errorf("could not create logical backup job: %v", err)
+		}
+		c.logger.Infof("created missing logical backup job %q", util.NameFromMeta(job.ObjectMeta))
+		return nil
+	}
+
+	match, reason := compareLogicalBackupJob(c.LogicalBackupJob, newJob)
+	if match {
+		return nil
+	}
+	c.logger.Infof("logical backup job %q is not in the desired state and needs to be updated: %s",
+		util.NameFromMeta(c.LogicalBackupJob.ObjectMeta), reason)
+	if err := c.updateLogicalBackupJob(newJob); err != nil {
+		return err
+	}
+	c.logger.Infof("logical backup job %q has been updated", util.NameFromMeta(newJob.ObjectMeta))
+
+	return nil
+}
+
 func (c *Cluster) syncStatefulSet() error {
+	defer c.startSpan("syncStatefulSet")()
 	var (
 		podsRollingUpdateRequired bool
 	)
@@ -269,20 +459,27 @@ func (c *Cluster) syncStatefulSet() error {
 			return fmt.Errorf("could not generate statefulset: %v", err)
 		}
 		c.setRollingUpdateFlagForStatefulSet(desiredSS, podsRollingUpdateRequired)
+		if flag, exists := sset.Annotations[CloneRolesSyncStatefulsetAnnotationKey]; exists {
+			desiredSS.Annotations[CloneRolesSyncStatefulsetAnnotationKey] = flag
+		}
 
 		cmp := c.compareStatefulSetWith(desiredSS)
+		if cmp.rejectReason != "" {
+			return fmt.Errorf("could not apply the statefulset changes: %s", cmp.rejectReason)
+		}
+		plan := planStatefulSetSync(cmp, podsRollingUpdateRequired)
 		if !cmp.match {
-			if cmp.rollingUpdate && !podsRollingUpdateRequired {
+			if plan.recreatePods && !podsRollingUpdateRequired {
 				podsRollingUpdateRequired = true
 				c.setRollingUpdateFlagForStatefulSet(desiredSS, podsRollingUpdateRequired)
 			}
 			c.logStatefulSetChanges(c.Statefulset, desiredSS, false, cmp.reasons)
 
-			if !cmp.replace {
+			if plan.update {
 				if err := c.updateStatefulSet(desiredSS); err != nil {
 					return fmt.Errorf("could not update statefulset: %v", err)
 				}
-			} else {
+			} else if plan.replace {
 				if err := c.replaceStatefulSet(desiredSS); err != nil {
 					return fmt.Errorf("could not replace statefulset: %v", err)
 				}
@@ -312,12 +509,41 @@ func (c *Cluster) syncStatefulSet() error {
 	return nil
 }
 
+type statefulSetSyncPlan struct {
+	update       bool
+	replace      bool
+	recreatePods bool
+}
+
+// planStatefulSetSync decides how the differences between the running and the desired statefulset are applied.
+// The replace orphans the running pods, which are adopted by the new statefulset, and since the statefulset uses
+// the OnDelete update strategy, the statefulset controller never rolls them on its own. Therefore, a change that
+// requires both a replace and a rolling update results in the replace followed by a single recreation of the pods
+// by the operator. The recreation is also done when the rolling update flag left by a previous, interrupted sync
+// is set, even if the statefulsets match.
+func planStatefulSetSync(cmp *compareStatefulsetResult, podsRollingUpdateRequired bool) statefulSetSyncPlan {
+	plan := statefulSetSyncPlan{recreatePods: podsRollingUpdateRequired}
+	if cmp.match {
+		return plan
+	}
+	if cmp.replace {
+		plan.replace = true
+	} else {
+		plan.update = true
+	}
+	if cmp.rollingUpdate {
+		plan.recreatePods = true
+	}
+
+	return plan
+}
+
 // checkAndSetGlobalPostgreSQLConfiguration checks whether cluster-wide API parameters
 // (like max_connections) has changed and if necessary sets it via the Patroni API
 func (c *Cluster) checkAndSetGlobalPostgreSQLConfiguration() error {
 	// we need to extract those options from the cluster manifest.
 	optionsToSet := make(map[string]string)
-	pgOptions := c.Spec.Parameters
+	pgOptions := applySSLParameters(c.applySynchronousCommit(&c.Spec.PostgresqlParam, &c.Spec), &c.Spec).Parameters
 
 	for k, v := range pgOptions {
 		if isBootstrapOnlyParameter(k) {
@@ -350,8 +576,10 @@ func (c *Cluster) checkAndSetGlobalPostgreSQLConfiguration() error {
 }
 
 func (c *Cluster) syncSecrets() error {
+	defer c.startSpan("syncSecrets")()
 	c.setProcessName("syncing secrets")
 	secrets := c.generateUserSecrets()
+	c.rotatedSecrets = make(map[string]bool)
 
 	for secretUsername, secretSpec := range secrets {
 		secret, err := c.KubeClient.Secrets(secretSpec.Namespace).Create(secretSpec)
@@ -361,7 +589,7 @@ func (c *Cluster) syncSecrets() error {
 			if err2 != nil {
 				return fmt.Errorf("could not get current secret: %v", err2)
 			}
-			if secretUsername != string(curSecret.Data["username"]) {
+			if !secretHoldsRole(curSecret, secretUsername) {
 				c.logger.Warningf("secret %q does not contain the role %q", secretSpec.Name, secretUsername)
 				continue
 			}
@@ -382,10 +610,21 @@ func (c *Cluster) syncSecrets() error {
 				if _, err := c.KubeClient.Secrets(secretSpec.Namespace).Update(secretSpec); err != nil {
 					return fmt.Errorf("could not update infrastructure role secret for role %q: %v", secretUsername, err)
 				}
+			} else if string(curSecret.Data["username"]) != pwdUser.Name {
+				// the password is the one of the rotation role, the role itself keeps the password it has
+				c.rotatedSecrets[pwdUser.Name] = true
 			} else {
 				// for non-infrastructure role - update the role with the password from the secret
 				pwdUser.Password = string(curSecret.Data["password"])
 				userMap[secretUsername] = pwdUser
+				// the secrets created before the manifest roles were labeled get the label as well
+				labeled := setManifestRoleLabel(curSecret, secretSpec)
+				if c.applySecretTemplate(curSecret, pwdUser.Name) || labeled {
+					c.logger.Debugf("updating the templated keys and the labels of the secret %q", secretSpec.Name)
+					if _, err := c.KubeClient.Secrets(secretSpec.Namespace).Update(curSecret); err != nil {
+						return fmt.Errorf("could not update secret for role %q: %v", secretUsername, err)
+					}
+				}
 			}
 
 			continue
@@ -398,10 +637,88 @@ func (c *Cluster) syncSecrets() error {
 		}
 	}
 
+	return c.syncStoredCredentials()
+}
+
+// databaseObjectStep is a single step of creating or syncing the objects inside the database.
+type databaseObjectStep struct {
+	name string
+	sync func() error
+}
+
+// databaseObjectSteps returns the steps of the database objects sync in their dependency order. The roles come first,
+// since the databases are owned by them, followed by the tablespaces the databases may be placed in; anything working
+// inside the databases, like schemas, grants and default privileges, must follow the databases.
+func (c *Cluster) databaseObjectSteps() []databaseObjectStep {
+	syncRoles := c.syncRoles
+	if c.Statefulset != nil && c.getCloneRolesSyncFlagFromStatefulSet(c.Statefulset) {
+		syncRoles = c.syncRolesAfterClone
+	}
+
+	return []databaseObjectStep{
+		{name: "roles", sync: syncRoles},
+		{name: "tablespaces", sync: c.syncTablespaces},
+		{name: "databases", sync: c.syncDatabases},
+		{name: "default privileges", sync: c.syncDefaultPrivileges},
+	}
+}
+
+// syncDatabaseObjects creates or syncs the database objects both for the new and the existing clusters,
+// stopping at the first failed step, since the following ones depend on it. The failures to sync some of the
+// users are collected instead, the objects of the other users can still be synced.
+func (c *Cluster) syncDatabaseObjects() error {
+	var failures []string
+	for _, step := range c.databaseObjectSteps() {
+		c.logger.Debugf("syncing %s", step.name)
+		err := step.sync()
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(users.SyncErrors); ok {
+			failures = append(failures, fmt.Sprintf("could not sync %s: %v", step.name, err))
+			continue
+		}
+		return fmt.Errorf("could not sync %s: %v", step.name, err)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+
 	return nil
 }
 
+// syncRolesAfterClone syncs the roles against the restored database of a freshly cloned cluster, creating the roles
+// missing in the restore and leaving the unknown ones alone. The database may not accept connections or writes
+// right away, so the sync is retried with a new connection every time. Once it succeeds, the flag in the statefulset
+// is cleared and subsequent syncs fall back to the regular role sync.
+func (c *Cluster) syncRolesAfterClone() error {
+	var syncErr error
+	err := retryutil.Retry(constants.PostgresConnectTimeout, c.OpConfig.ResourceCheckTimeout,
+		func() (bool, error) {
+			if syncErr = c.syncRoles(); syncErr != nil {
+				// the failures of single users are not cured by retrying, the database accepts the other roles
+				if _, ok := syncErr.(users.SyncErrors); ok {
+					return true, nil
+				}
+				c.logger.Warningf("could not sync roles of the cloned cluster, retrying: %v", syncErr)
+				return false, nil
+			}
+			return true, nil
+		})
+	if err != nil {
+		return fmt.Errorf("could not sync roles after cloning the cluster: %v", err)
+	}
+	c.logger.Infof("roles have been synced against the restored database")
+
+	if err := c.applyCloneRolesSyncFlagForStatefulSet(false); err != nil {
+		c.logger.Warningf("could not clear the clone roles sync flag for the statefulset: %v", err)
+	}
+
+	return syncErr
+}
+
 func (c *Cluster) syncRoles() error {
+	defer c.startSpan("syncRoles")()
 	c.setProcessName("syncing roles")
 
 	var (
@@ -428,26 +745,208 @@ func (c *Cluster) syncRoles() error {
 		return fmt.Errorf("error getting users from the database: %v", err)
 	}
 
-	pgSyncRequests := c.userSyncStrategy.ProduceSyncRequests(dbUsers, c.pgUsers)
+	pgSyncRequests := c.userSyncStrategy.ProduceSyncRequests(dbUsers, c.keepRotatedPasswords(dbUsers))
 	if err = c.userSyncStrategy.ExecuteSyncRequests(pgSyncRequests, c.pgDb); err != nil {
+		if _, ok := err.(users.SyncErrors); ok {
+			return err
+		}
 		return fmt.Errorf("error executing sync statements: %v", err)
 	}
 
 	return nil
 }
 
+// removedManifestRoles returns the sorted names of the roles removed from the users section of the manifest. Roles still
+// defined elsewhere, i.e. by the teams API or the infrastructure roles, and the protected and system roles are left out.
+func (c *Cluster) removedManifestRoles(oldUsers, newUsers map[string]spec.UserFlags) []string {
+	var result []string
+	for name := range oldUsers {
+		if _, ok := newUsers[name]; ok {
+			continue
+		}
+		if _, ok := c.pgUsers[name]; ok || c.isProtectedUsername(name) || c.isSystemUsername(name) {
+			continue
+		}
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// setManifestRoleLabel copies the manifest role label of the generated secret to the current one, if it is missing
+// there, and tells whether it did.
+func setManifestRoleLabel(curSecret, newSecret *v1.Secret) bool {
+	role, ok := newSecret.Labels[constants.ManifestRoleLabel]
+	if !ok || curSecret.Labels[constants.ManifestRoleLabel] == role {
+		return false
+	}
+	if curSecret.Labels == nil {
+		curSecret.Labels = make(map[string]string)
+	}
+	curSecret.Labels[constants.ManifestRoleLabel] = role
+	return true
+}
+
+// removedSecretRoles returns the roles removed from the manifest that still have a secret created by the operator.
+// Unlike the comparison of the old and the new manifest, this catches the users removed while the operator was not
+// running and the roles that could not be deprovisioned before, since their secrets are only deleted along with them.
+func (c *Cluster) removedSecretRoles() ([]string, error) {
+	namespace := c.Namespace
+	if c.OpConfig.EnableCrossNamespaceSecret {
+		namespace = v1.NamespaceAll
+	}
+	selector := labels.Set{c.OpConfig.ClusterNameLabel: c.Name}.AsSelector()
+	requirement, err := labels.NewRequirement(constants.ManifestRoleLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := c.KubeClient.Secrets(namespace).List(metav1.ListOptions{
+		LabelSelector: selector.Add(*requirement).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list the secrets of the manifest roles: %v", err)
+	}
+
+	secretRoles := make(map[string]spec.UserFlags)
+	for _, secret := range secrets.Items {
+		role := secret.Labels[constants.ManifestRoleLabel]
+		// the secrets of the clusters of the same name in other namespaces
+		if secret.Namespace != c.credentialSecretNamespace(role) {
+			continue
+		}
+		secretRoles[role] = nil
+	}
+
+	return c.removedManifestRoles(secretRoles, c.Spec.Users), nil
+}
+
+// deprovisionRoles drops or locks the roles according to the removed_role_action of the operator and deletes their
+// secrets. A role that cannot be dropped, i.e. since it still owns objects, keeps its secret and is reported.
+func (c *Cluster) deprovisionRoles(names []string) error {
+	c.setProcessName("deprovisioning roles")
+
+	var request spec.PgSyncUserRequest
+	outcome := ""
+	switch c.OpConfig.RemovedRoleAction {
+	case config.RemovedRoleActionDrop:
+		request.Kind, outcome = spec.PGSyncUserDrop, "dropped"
+	case config.RemovedRoleActionLock:
+		request.Kind, outcome = spec.PGSyncUserLock, "locked"
+	default:
+		c.logger.Debugf("leaving the roles %s removed from the manifest alone", strings.Join(names, ", "))
+		return nil
+	}
+
+	if err := c.initDbConn(); err != nil {
+		return fmt.Errorf("could not init db connection: %v", err)
+	}
+	defer func() {
+		if err := c.closeDbConn(); err != nil {
+			c.logger.Errorf("could not close db connection: %v", err)
+		}
+	}()
+	dbUsers, err := c.readPgUsersFromDatabase(names)
+	if err != nil {
+		return fmt.Errorf("error getting users from the database: %v", err)
+	}
+
+	var failures []string
+	for _, name := range names {
+		if _, ok := dbUsers[name]; ok {
+			// the rotation roles hold the credentials of the role for the applications
+			rotationUsers, err := c.getRotationUsers(name)
+			if err != nil {
+				failures = append(failures, err.Error())
+				continue
+			}
+			var requests []spec.PgSyncUserRequest
+			for _, role := range append(rotationUsers, name) {
+				request.User = spec.PgUser{Name: role, Origin: spec.RoleOriginManifest}
+				requests = append(requests, request)
+			}
+			if err := c.userSyncStrategy.ExecuteSyncRequests(requests, c.pgDb); err != nil {
+				failures = append(failures, err.Error())
+				continue
+			}
+			c.logger.Infof("role %q removed from the manifest has been %s", name, outcome)
+		}
+		if err := c.deleteRoleSecret(name); err != nil {
+			failures = append(failures, err.Error())
+		}
+		if err := c.deleteStoredCredentials(name); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// deleteRoleSecret deletes the secret holding the credentials of the role, if any. A secret of the same name holding
+// another role is left alone, like in syncSecrets.
+func (c *Cluster) deleteRoleSecret(name string) error {
+	secret, err := c.KubeClient.Secrets(c.credentialSecretNamespace(name)).Get(c.credentialSecretName(name),
+		metav1.GetOptions{})
+	if k8sutil.ResourceNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get secret of role %q: %v", name, err)
+	}
+	if !secretHoldsRole(secret, name) {
+		c.logger.Warningf("secret %q does not contain the role %q", secret.Name, name)
+		return nil
+	}
+	if err := c.deleteSecret(secret); err != nil {
+		return fmt.Errorf("could not delete secret of role %q: %v", name, err)
+	}
+	return nil
+}
+
 // syncVolumes reads all persistent volumes and checks that their size matches the one declared in the statefulset.
 func (c *Cluster) syncVolumes() error {
+	defer c.startSpan("syncVolumes")()
 	c.setProcessName("syncing volumes")
 
+	// the emptyDir volumes of the ephemeral clusters go away with their pods, there is nothing to reconcile
+	if c.Spec.Volume.IsEphemeral() {
+		return nil
+	}
+	if shrinking, err := c.checkVolumeShrink(); err != nil || shrinking {
+		return err
+	}
+	if c.OpConfig.VolumeResizeMode == config.VolumeResizeModePVC {
+		return c.syncVolumeClaims()
+	}
+
+	resizers, err := volumes.NewVolumeResizers(&c.OpConfig)
+	if err != nil {
+		return fmt.Errorf("could not sync volumes: %v", err)
+	}
+	if c.OpConfig.EnableVolumeTagging {
+		if err := c.tagVolumes(resizers); err != nil {
+			c.logger.Warningf("could not tag volumes: %v", err)
+		}
+	}
+
 	act, err := c.volumesNeedResizing(c.Spec.Volume)
 	if err != nil {
 		return fmt.Errorf("could not compare size of the volumes: %v", err)
 	}
-	if !act {
+	// the type, the iops and the throughput are compared with the ones of the volumes by the resizers themselves,
+	// which leave the volumes already matching the manifest alone
+	modifyProperties := hasVolumeProperties(c.Spec.Volume)
+	if !act && !modifyProperties {
 		return nil
 	}
-	if err := c.resizeVolumes(c.Spec.Volume, []volumes.VolumeResizer{&volumes.EBSVolumeResizer{AWSRegion: c.OpConfig.AWSRegion}}); err != nil {
+	if act {
+		if err := c.checkVolumeSizeLimit(c.Spec.Volume); err != nil {
+			return fmt.Errorf("refusing to resize volumes: %v", err)
+		}
+	}
+	if err := c.resizeVolumes(c.Spec.Volume, resizers, modifyProperties); err != nil {
 		return fmt.Errorf("could not sync volumes: %v", err)
 	}
 
@@ -456,6 +955,48 @@ func (c *Cluster) syncVolumes() error {
 	return nil
 }
 
+// checkPgVersion compares the major version of the running postgres with the one requested in the manifest.
+// It returns true when the mismatch should result in the degraded cluster status.
+func (c *Cluster) checkPgVersion() bool {
+	c.setProcessName("checking postgres version")
+
+	if err := c.initReadDbConn(); err != nil {
+		c.logger.Warningf("could not init database connection to check postgres version: %v", err)
+		return false
+	}
+	defer func() {
+		if err := c.closeReadDbConn(); err != nil {
+			c.logger.Errorf("could not close database connection: %v", err)
+		}
+	}()
+
+	detectedVersion, err := c.getRunningPgVersion()
+	if err != nil {
+		c.logger.Warningf("could not detect running postgres version: %v", err)
+		return false
+	}
+
+	degrade, err := c.comparePgVersion(detectedVersion)
+	if err != nil {
+		c.logger.Warningf("%v", err)
+	}
+
+	return degrade
+}
+
+// comparePgVersion returns an error describing the mismatch between the running and the requested
+// postgres major version, together with the flag whether the cluster should be marked as degraded.
+func (c *Cluster) comparePgVersion(detectedVersion string) (degrade bool, err error) {
+	if c.Spec.PgVersion == "" || c.Spec.PgVersion == detectedVersion {
+		return false, nil
+	}
+
+	err = fmt.Errorf("running postgres major version %q does not match the version %q requested in the manifest",
+		detectedVersion, c.Spec.PgVersion)
+
+	return c.OpConfig.PgVersionMismatchAction == config.PgVersionMismatchActionDegrade, err
+}
+
 func (c *Cluster) samePDBWith(pdb *policybeta1.PodDisruptionBudget) (match bool, reason string) {
 	match = reflect.DeepEqual(pdb.Spec, c.PodDisruptionBudget.Spec)
 	if !match {
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+)
+
+const (
+	getTablespacesSQL   = `SELECT spcname FROM pg_tablespace;`
+	createTablespaceSQL = `CREATE TABLESPACE "%s" LOCATION '%s';`
+	// Postgres refuses the mount point itself as the location, since it is owned by root and holds lost+found. The
+	// directory is only created once the volume is mounted, a pod not restarted with the volume yet would otherwise
+	// get the tablespace on its root filesystem.
+	tablespaceDirectoryCommand = "mountpoint -q %[2]s && " +
+		"mkdir -p %[1]s && chown postgres:postgres %[1]s && chmod 700 %[1]s"
+)
+
+// tablespaceLocation returns the directory of the tablespace on its volume.
+func tablespaceLocation(name string) string {
+	return path.Join(tablespaceMountPath(name), "data")
+}
+
+// tablespacesToCreate returns the sorted names of the tablespaces of the manifest missing in the database.
+func tablespacesToCreate(tablespaces map[string]spec.Tablespace, current map[string]bool) []string {
+	var result []string
+	for name := range tablespaces {
+		if !current[name] {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// getTablespaces returns the names of the tablespaces in the database.
+// The caller is responsible for opening and closing the database connection.
+func (c *Cluster) getTablespaces() (map[string]bool, error) {
+	rows, err := c.pgDb.Query(getTablespacesSQL)
+	if err != nil {
+		return nil, fmt.Errorf("could not query tablespaces: %v", c.describeStatementError(err))
+	}
+	defer rows.Close()
+
+	result := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error when processing row: %v", err)
+		}
+		result[name] = true
+	}
+
+	return result, rows.Err()
+}
+
+// prepareTablespaceDirectory creates the directory of the tablespace on every pod. The replicas replay the creation
+// of the tablespace and fail unless the directory exists on their volumes as well.
+func (c *Cluster) prepareTablespaceDirectory(name string) error {
+	pods, err := c.listPods()
+	if err != nil {
+		return err
+	}
+	command := fmt.Sprintf(tablespaceDirectoryCommand, tablespaceLocation(name), tablespaceMountPath(name))
+	for _, pod := range pods {
+		podName := util.NameFromMeta(pod.ObjectMeta)
+		if _, err := c.ExecCommand(&podName, "sh", "-c", command); err != nil {
+			return fmt.Errorf("could not create directory of tablespace %q on pod %q, its volume may not be mounted yet: %v",
+				name, podName, err)
+		}
+	}
+
+	return nil
+}
+
+// syncTablespaces creates the tablespaces of the manifest missing in the database. The tablespaces removed from the
+// manifest are left alone, since the objects in them would have to move first.
+func (c *Cluster) syncTablespaces() error {
+	c.setProcessName("syncing tablespaces")
+
+	if len(c.Spec.Tablespaces) == 0 {
+		return nil
+	}
+
+	if err := c.initDbConn(); err != nil {
+		return fmt.Errorf("could not init database connection")
+	}
+	defer func() {
+		if err := c.closeDbConn(); err != nil {
+			c.logger.Errorf("could not close database connection: %v", err)
+		}
+	}()
+
+	current, err := c.getTablespaces()
+	if err != nil {
+		return fmt.Errorf("could not get current tablespaces: %v", err)
+	}
+
+	for _, name := range tablespacesToCreate(c.Spec.Tablespaces, current) {
+		if err := c.prepareTablespaceDirectory(name); err != nil {
+			return err
+		}
+		c.logger.Infof("creating tablespace %q", name)
+		if _, err := c.pgDb.Exec(fmt.Sprintf(createTablespaceSQL, name, tablespaceLocation(name))); err != nil {
+			return fmt.Errorf("could not create tablespace %q: %v", name, c.describeStatementError(err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+func TestTablespacesToCreate(t *testing.T) {
+	testName := "TestTablespacesToCreate"
+	tablespaces := map[string]spec.Tablespace{"fast": {Size: "5Gi"}, "archive": {Size: "100Gi"}}
+	tests := []struct {
+		subtest  string
+		current  map[string]bool
+		expected []string
+	}{
+		{"fresh cluster", map[string]bool{"pg_default": true, "pg_global": true}, []string{"archive", "fast"}},
+		{"one tablespace exists", map[string]bool{"pg_default": true, "fast": true}, []string{"archive"}},
+		{"all tablespaces exist", map[string]bool{"archive": true, "fast": true}, nil},
+	}
+	for _, tt := range tests {
+		if result := tablespacesToCreate(tablespaces, tt.current); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%s %s: expected %v, got %v", testName, tt.subtest, tt.expected, result)
+		}
+	}
+	if location := tablespaceLocation("archive"); location != "/home/postgres/tablespaces/archive/data" {
+		t.Errorf("%s: unexpected location %q", testName, location)
+	}
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
 	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/retryutil"
@@ -76,6 +77,89 @@ func (c *Cluster) isSystemUsername(username string) bool {
 	return (username == c.OpConfig.SuperUsername || username == c.OpConfig.ReplicationUsername)
 }
 
+// reservedRoleReason tells why the operator does not manage the role, empty if it does.
+func (c *Cluster) reservedRoleReason(role string) string {
+	if c.isProtectedUsername(role) {
+		return "is a protected role"
+	}
+	if c.isSystemUsername(role) {
+		return "is a system role"
+	}
+	return ""
+}
+
+// validateProtectedRoles returns an error for every entry of the manifest naming a protected or system role in the
+// sections the operator creates, alters or drops roles from. The users are initialized without those entries.
+func (c *Cluster) validateProtectedRoles() []error {
+	var errs []error
+	sections := []struct {
+		name  string
+		roles []string
+	}{
+		{name: "users"}, {name: "userSecrets"}, {name: "userMemberships"}, {name: "userConnectionLimits"},
+	}
+	for role := range c.Spec.Users {
+		sections[0].roles = append(sections[0].roles, role)
+	}
+	for role := range c.Spec.UserSecrets {
+		sections[1].roles = append(sections[1].roles, role)
+	}
+	for role := range c.Spec.UserMemberships {
+		sections[2].roles = append(sections[2].roles, role)
+	}
+	for role := range c.Spec.UserConnectionLimits {
+		sections[3].roles = append(sections[3].roles, role)
+	}
+	for _, section := range sections {
+		sort.Strings(section.roles)
+		for _, role := range section.roles {
+			if reason := c.reservedRoleReason(role); reason != "" {
+				errs = append(errs, fmt.Errorf("invalid manifest entry %s.%s: role %q %s",
+					section.name, role, role, reason))
+			}
+		}
+	}
+
+	// the roles the users are granted
+	for _, username := range sections[0].roles {
+		_, inRoles := splitUserFlags(c.Spec.Users[username])
+		for _, role := range inRoles {
+			if reason := c.reservedRoleReason(role); reason != "" {
+				errs = append(errs, fmt.Errorf("invalid manifest entry users.%s: %s%s: role %q %s",
+					username, constants.RoleMembershipPrefix, role, role, reason))
+			}
+		}
+	}
+	for _, username := range sections[2].roles {
+		for _, role := range c.Spec.UserMemberships[username] {
+			if reason := c.reservedRoleReason(role); reason != "" {
+				errs = append(errs, fmt.Errorf("invalid manifest entry userMemberships.%s: role %q %s",
+					username, role, reason))
+			}
+		}
+	}
+	return errs
+}
+
+// reportInvalidRoleEntries records the skipped entries of the manifest in the status of the cluster and emits a
+// warning event whenever they change, so that they are visible without blocking the rest of the sync. It tells
+// whether the status has changed.
+func (c *Cluster) reportInvalidRoleEntries(errs []error) bool {
+	var entries []string
+	for _, err := range errs {
+		c.logger.Warningf("skipping %v", err)
+		entries = append(entries, err.Error())
+	}
+	if strings.Join(entries, "\n") == strings.Join(c.Status.InvalidRoleEntries, "\n") {
+		return false
+	}
+	c.Status.InvalidRoleEntries = entries
+	if len(entries) > 0 {
+		c.createWarningEvent(eventReasonInvalidRoleEntries, "skipping "+strings.Join(entries, "; "))
+	}
+	return true
+}
+
 func isValidFlag(flag string) bool {
 	for _, validFlag := range []string{constants.RoleFlagSuperuser, constants.RoleFlagLogin, constants.RoleFlagCreateDB,
 		constants.RoleFlagInherit, constants.RoleFlagReplication, constants.RoleFlagByPassRLS} {
@@ -93,6 +177,90 @@ func invertFlag(flag string) string {
 	return "NO" + flag
 }
 
+// isLoginRole tells whether the normalized flags describe a role that can log in.
+func isLoginRole(flags []string) bool {
+	for _, flag := range flags {
+		if flag == constants.RoleFlagLogin {
+			return true
+		}
+	}
+	return false
+}
+
+// splitUserFlags separates the entries of a manifest user naming the roles it is a member of from its flags.
+func splitUserFlags(userFlags []string) (flags, memberOf []string) {
+	for _, entry := range userFlags {
+		if strings.HasPrefix(strings.ToLower(entry), constants.RoleMembershipPrefix) {
+			memberOf = append(memberOf, entry[len(constants.RoleMembershipPrefix):])
+		} else {
+			flags = append(flags, entry)
+		}
+	}
+	return flags, memberOf
+}
+
+// manifestMemberships returns the roles the manifest grants to the user, from its entry and from the userMemberships
+// section. The roles of the users section have to be NOLOGIN roles, the other ones have to exist in the database.
+// System and protected roles are never granted.
+func (c *Cluster) manifestMemberships(username string, inRoles []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	for _, role := range append(append([]string{}, inRoles...), c.Spec.UserMemberships[username]...) {
+		// the protected and system roles are reported as invalid entries of the manifest instead
+		if seen[role] || c.reservedRoleReason(role) != "" {
+			continue
+		}
+		seen[role] = true
+		if !isValidUsername(role) || role == username {
+			return nil, fmt.Errorf("role %q cannot be granted to user %q", role, username)
+		}
+		if userFlags, ok := c.Spec.Users[role]; ok {
+			flags, _ := splitUserFlags(userFlags)
+			normalized, err := normalizeUserFlags(flags)
+			if err != nil {
+				return nil, fmt.Errorf("invalid flags for user %q: %v", role, err)
+			}
+			if isLoginRole(normalized) {
+				return nil, fmt.Errorf("role %q granted to user %q is not a NOLOGIN role", role, username)
+			}
+		}
+		result = append(result, role)
+	}
+	return result, nil
+}
+
+// CheckDeclaredInfrastructureRole normalizes the flags of an infrastructure role declared by an infrastructurerole
+// object and rejects the privileges such a role must not get: the SUPERUSER, REPLICATION and BYPASSRLS flags and the
+// memberships in protected or system roles. Only the infrastructure roles secret can grant those.
+func CheckDeclaredInfrastructureRole(role *spec.PgUser, opConfig *config.Config) error {
+	if !isValidUsername(role.Name) {
+		return fmt.Errorf("invalid role name %q", role.Name)
+	}
+	flags, err := normalizeUserFlags(role.Flags)
+	if err != nil {
+		return fmt.Errorf("invalid flags of role %q: %v", role.Name, err)
+	}
+	for _, flag := range flags {
+		if flag == constants.RoleFlagSuperuser || flag == constants.RoleFlagReplication ||
+			flag == constants.RoleFlagByPassRLS {
+			return fmt.Errorf("role %q cannot have the %s flag", role.Name, flag)
+		}
+	}
+	for _, roles := range [][]string{role.MemberOf, role.AdminOf} {
+		for _, member := range roles {
+			reserved := member == opConfig.SuperUsername || member == opConfig.ReplicationUsername
+			for _, protected := range opConfig.ProtectedRoles {
+				reserved = reserved || member == protected
+			}
+			if !isValidUsername(member) || reserved {
+				return fmt.Errorf("role %q cannot be granted to role %q", member, role.Name)
+			}
+		}
+	}
+	role.Flags = flags
+	return nil
+}
+
 func normalizeUserFlags(userFlags []string) ([]string, error) {
 	uniqueFlags := make(map[string]bool)
 	addLogin := true
@@ -142,6 +310,20 @@ func specPatch(spec interface{}) ([]byte, error) {
 	}{spec})
 }
 
+// selectorPatch produces a JSON MergePatch turning the current selector into the new one.
+func selectorPatch(cur, new map[string]string) ([]byte, error) {
+	selector := make(map[string]interface{})
+	for k := range cur {
+		selector[k] = nil
+	}
+	for k, v := range new {
+		selector[k] = v
+	}
+	return json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"selector": selector},
+	})
+}
+
 // metaAnnotationsPatch produces a JSON of the object metadata that has only the annotation
 // field in order to use it in a MergePatch. Note that we don't patch the complete metadata, since
 // it contains the current revision of the object that could be outdated at the time we patch.
@@ -236,7 +418,7 @@ func (c *Cluster) waitForPodLabel(podEvents chan spec.PodEvent, stopChan chan st
 	for {
 		select {
 		case podEvent := <-podEvents:
-			podRole := PostgresRole(podEvent.CurPod.Labels[c.OpConfig.PodRoleLabel])
+			podRole := PostgresRole(podEvent.CurPod.Labels[c.podRoleLabel()])
 
 			if role == nil {
 				if podRole == Master || podRole == Replica {
@@ -298,12 +480,12 @@ func (c *Cluster) _waitPodLabelsReady(anyReplica bool) error {
 	}
 	masterListOption := metav1.ListOptions{
 		LabelSelector: labels.Merge(ls, labels.Set{
-			c.OpConfig.PodRoleLabel: string(Master),
+			c.podRoleLabel(): string(Master),
 		}).String(),
 	}
 	replicaListOption := metav1.ListOptions{
 		LabelSelector: labels.Merge(ls, labels.Set{
-			c.OpConfig.PodRoleLabel: string(Replica),
+			c.podRoleLabel(): string(Replica),
 		}).String(),
 	}
 	podsNumber = 1
@@ -393,9 +575,56 @@ func (c *Cluster) labelsSelector() *metav1.LabelSelector {
 	return &metav1.LabelSelector{c.labelsSet(false), nil}
 }
 
+// podRoleLabel returns the label Patroni marks the roles of the pods with. It is the configured one,
+// unless the running pods turned out to use one of the fallback labels instead.
+func (c *Cluster) podRoleLabel() string {
+	c.roleLabelMu.RLock()
+	defer c.roleLabelMu.RUnlock()
+
+	if c.roleLabel != "" {
+		return c.roleLabel
+	}
+	return c.OpConfig.PodRoleLabel
+}
+
+// RoleLabel returns the label the pods of the cluster mark their roles with.
+func (c *Cluster) RoleLabel() string {
+	return c.podRoleLabel()
+}
+
+// updatePodRoleLabel detects the role label used by the running pods, since the label scheme differs between
+// Patroni versions. The current label is kept if none of the pods has a role yet.
+func (c *Cluster) updatePodRoleLabel(pods []v1.Pod) {
+	candidates := append([]string{c.OpConfig.PodRoleLabel}, c.OpConfig.PodRoleLabelFallbacks...)
+	label := detectPodRoleLabel(pods, candidates)
+	if label == "" {
+		return
+	}
+
+	c.roleLabelMu.Lock()
+	defer c.roleLabelMu.Unlock()
+	if label != c.OpConfig.PodRoleLabel && label != c.roleLabel {
+		c.logger.Warningf("pods are labeled with the %q role label instead of %q, using it for the selectors",
+			label, c.OpConfig.PodRoleLabel)
+	}
+	c.roleLabel = label
+}
+
+// detectPodRoleLabel returns the first of the candidate labels that marks any of the pods as a master or a replica.
+func detectPodRoleLabel(pods []v1.Pod, candidates []string) string {
+	for _, label := range candidates {
+		for _, pod := range pods {
+			if role := PostgresRole(pod.Labels[label]); role == Master || role == Replica {
+				return label
+			}
+		}
+	}
+	return ""
+}
+
 func (c *Cluster) roleLabelsSet(role PostgresRole) labels.Set {
 	lbls := c.labelsSet(false)
-	lbls[c.OpConfig.PodRoleLabel] = string(role)
+	lbls[c.podRoleLabel()] = string(role)
 	return lbls
 }
 
@@ -414,7 +643,33 @@ func (c *Cluster) replicaDNSName() string {
 }
 
 func (c *Cluster) credentialSecretName(username string) string {
-	return c.credentialSecretNameForCluster(username, c.Name)
+	_, name := c.crossNamespaceUser(username)
+	return c.credentialSecretNameForCluster(name, c.Name)
+}
+
+// credentialSecretNamespace returns the namespace the secret of the user goes to.
+func (c *Cluster) credentialSecretNamespace(username string) string {
+	namespace, _ := c.crossNamespaceUser(username)
+	return namespace
+}
+
+// crossNamespaceUser splits the name of a manifest user of the form namespace.user into the namespace of its secret
+// and the name the secret is named after, once the cross namespace secrets are enabled. Other users keep their secrets
+// next to the cluster. The roles removed from the manifest are no longer known and count as manifest users.
+func (c *Cluster) crossNamespaceUser(username string) (namespace, name string) {
+	if !c.OpConfig.EnableCrossNamespaceSecret || !strings.Contains(username, ".") {
+		return c.Namespace, username
+	}
+	if user, ok := c.pgUsers[username]; ok && user.Origin != spec.RoleOriginManifest {
+		return c.Namespace, username
+	}
+	for _, user := range c.systemUsers {
+		if user.Name == username {
+			return c.Namespace, username
+		}
+	}
+	parts := strings.SplitN(username, ".", 2)
+	return parts[0], parts[1]
 }
 
 func (c *Cluster) credentialSecretNameForCluster(username string, clusterName string) string {
@@ -428,6 +683,23 @@ func (c *Cluster) credentialSecretNameForCluster(username string, clusterName st
 		"tprgroup", constants.CRDGroup)
 }
 
+// getUserSecretPassword reads the password of a manifest user from the secret provisioned outside of the operator.
+func (c *Cluster) getUserSecretPassword(ref spec.UserSecret) (string, error) {
+	key := ref.PasswordKey
+	if key == "" {
+		key = "password"
+	}
+	secret, err := c.KubeClient.Secrets(c.Namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get secret %q: %v", ref.Name, err)
+	}
+	password := string(secret.Data[key])
+	if password == "" {
+		return "", fmt.Errorf("secret %q has no %q key", ref.Name, key)
+	}
+	return password, nil
+}
+
 func masterCandidate(replicas []spec.NamespacedName) spec.NamespacedName {
 	return replicas[rand.Intn(len(replicas))]
 }
@@ -2,20 +2,31 @@ package cluster
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/pkg/api/v1"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
 	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/filesystems"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/retryutil"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/volumes"
 )
 
+// -P keeps the usage in a fixed column, also for long device names
+const volumeUsageCommand = "df -P %s"
+
 func (c *Cluster) listPersistentVolumeClaims() ([]v1.PersistentVolumeClaim, error) {
 	ns := c.Namespace
 	listOptions := metav1.ListOptions{
@@ -50,6 +61,65 @@ func (c *Cluster) deletePersistenVolumeClaims() error {
 	return nil
 }
 
+// shouldDeleteVolumes tells whether the volumes are deleted along with the cluster. The manifest overrides the operator
+// configuration.
+func (c *Cluster) shouldDeleteVolumes() bool {
+	if c.Spec.DeleteVolumesOnDelete != nil {
+		return *c.Spec.DeleteVolumesOnDelete
+	}
+	return c.OpConfig.DeleteVolumesOnDelete
+}
+
+// removeVolumes deletes or retains the persistent volume claims of the deleted cluster. A deleted claim leaves its
+// volume to the reclaim policy of the volume, so that the volumes of storage classes retaining them keep the data. The
+// volumes of retained claims are set to be retained, so that they survive the claims being deleted by hand later on.
+// The outcome is recorded as an event of the cluster.
+func (c *Cluster) removeVolumes() error {
+	pvcs, err := c.listPersistentVolumeClaims()
+	if err != nil {
+		return err
+	}
+	if len(pvcs) == 0 {
+		c.logger.Debugln("no PVCs to remove")
+		return nil
+	}
+
+	names := make([]string, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		names = append(names, pvc.Name)
+	}
+	if !c.shouldDeleteVolumes() {
+		for _, pvc := range pvcs {
+			if pvc.Spec.VolumeName == "" {
+				continue
+			}
+			if err := c.setVolumeReclaimPolicy(pvc.Spec.VolumeName, v1.PersistentVolumeReclaimRetain); err != nil {
+				return err
+			}
+		}
+		message := fmt.Sprintf("persistent volume claims %s have been retained", strings.Join(names, ", "))
+		c.logger.Info(message)
+		c.createEvent(v1.EventTypeNormal, eventReasonVolumesRetained, message)
+		return nil
+	}
+	if err := c.deletePersistenVolumeClaims(); err != nil {
+		return err
+	}
+	c.createEvent(v1.EventTypeNormal, eventReasonVolumesDeleted,
+		fmt.Sprintf("persistent volume claims %s have been deleted, their volumes follow their reclaim policy",
+			strings.Join(names, ", ")))
+
+	return nil
+}
+
+func (c *Cluster) setVolumeReclaimPolicy(name string, policy v1.PersistentVolumeReclaimPolicy) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"persistentVolumeReclaimPolicy":%q}}`, policy))
+	if _, err := c.KubeClient.PersistentVolumes().Patch(name, types.MergePatchType, patch); err != nil {
+		return fmt.Errorf("could not set the reclaim policy of persistent volume %q: %v", name, err)
+	}
+	return nil
+}
+
 func (c *Cluster) listPersistentVolumes() ([]*v1.PersistentVolume, error) {
 	result := make([]*v1.PersistentVolume, 0)
 
@@ -66,29 +136,88 @@ func (c *Cluster) listPersistentVolumes() ([]*v1.PersistentVolume, error) {
 	lastPodIndex := len(pods) - 1
 
 	for _, pvc := range pvcs {
-		lastDash := strings.LastIndex(pvc.Name, "-")
-		if lastDash > 0 && lastDash < len(pvc.Name)-1 {
-			pvcNumber, err := strconv.Atoi(pvc.Name[lastDash+1:])
-			if err != nil {
-				return nil, fmt.Errorf("could not convert last part of the persistent volume claim name %q to a number", pvc.Name)
-			}
-			if pvcNumber > lastPodIndex {
-				c.logger.Debugf("skipping persistent volume %q corresponding to a non-running pods", pvc.Name)
-				continue
-			}
+		// the size of the manifest only applies to the data volumes, not to the volumes of the tablespaces
+		pvcNumber, ok := c.dataVolumeClaimIndex(pvc.Name)
+		if !ok {
+			continue
+		}
+		if pvcNumber > lastPodIndex {
+			c.logger.Debugf("skipping persistent volume %q corresponding to a non-running pods", pvc.Name)
+			continue
+		}
+		if pvc.Spec.VolumeName == "" {
+			c.logger.Debugf("skipping persistent volume claim %q not bound to a volume", pvc.Name)
+			continue
 		}
 		pv, err := c.KubeClient.PersistentVolumes().Get(pvc.Spec.VolumeName, metav1.GetOptions{})
 		if err != nil {
 			return nil, fmt.Errorf("could not get PersistentVolume: %v", err)
 		}
+		if err := c.checkVolumeOwnership(&pvc, pv); err != nil {
+			c.logger.Warningf("skipping persistent volume %q: %v", pv.Name, err)
+			continue
+		}
 		result = append(result, pv)
 	}
 
 	return result, nil
 }
 
-// resizeVolumes resize persistent volumes compatible with the given resizer interface
-func (c *Cluster) resizeVolumes(newVolume spec.Volume, resizers []volumes.VolumeResizer) error {
+func isDataVolumeClaim(pvc *v1.PersistentVolumeClaim) bool {
+	return strings.HasPrefix(pvc.Name, constants.DataVolumeName+"-")
+}
+
+// dataVolumeClaimIndex returns the index of the pod the data volume claim of the cluster belongs to. The claims of the
+// tablespaces and the ones of other clusters whose names start with the name of this one, i.e. acid-test-2 for
+// acid-test, do not qualify.
+func (c *Cluster) dataVolumeClaimIndex(name string) (int, bool) {
+	prefix := fmt.Sprintf("%s-%s-", constants.DataVolumeName, c.Name)
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	suffix := name[len(prefix):]
+	index, err := strconv.Atoi(suffix)
+	if err != nil || index < 0 || strconv.Itoa(index) != suffix {
+		return 0, false
+	}
+	return index, true
+}
+
+// isOwnDataVolumeClaim tells whether the claim is a data volume claim of the cluster by its name and its labels.
+func (c *Cluster) isOwnDataVolumeClaim(pvc *v1.PersistentVolumeClaim) bool {
+	_, ok := c.dataVolumeClaimIndex(pvc.Name)
+	return ok && pvc.Labels[c.OpConfig.ClusterNameLabel] == c.Name
+}
+
+// checkVolumeOwnership verifies that the claim belongs to the cluster, that the persistent volume is bound to that very
+// claim and that the volume is not labeled with another cluster, before the volume at the provider is touched.
+func (c *Cluster) checkVolumeOwnership(pvc *v1.PersistentVolumeClaim, pv *v1.PersistentVolume) error {
+	if !c.isOwnDataVolumeClaim(pvc) {
+		return fmt.Errorf("persistent volume claim %q is not a data volume claim of the cluster", pvc.Name)
+	}
+	ref := pv.Spec.ClaimRef
+	if ref == nil || ref.Namespace != pvc.Namespace || ref.Name != pvc.Name || ref.UID != pvc.UID {
+		return fmt.Errorf("persistent volume is not bound to the persistent volume claim %q", pvc.Name)
+	}
+	if name, ok := pv.Labels[c.OpConfig.ClusterNameLabel]; ok && name != c.Name {
+		return fmt.Errorf("persistent volume is labeled as a volume of the cluster %q", name)
+	}
+	return nil
+}
+
+// volumeResize is the resize of a single persistent volume with the resizer of its provider.
+type volumeResize struct {
+	pv         *v1.PersistentVolume
+	resizer    volumes.VolumeResizer
+	volumeSize int64
+	grow       bool
+}
+
+// resizeVolumes resize persistent volumes compatible with the given resizer interface. With modifyProperties, the
+// type, the iops and the throughput of the manifest are applied as well by the resizers supporting them. The volumes
+// and their filesystems are resized concurrently by at most volume_resize_workers workers, the errors of all of them
+// are returned together.
+func (c *Cluster) resizeVolumes(newVolume spec.Volume, resizers []volumes.VolumeResizer, modifyProperties bool) error {
 	c.setProcessName("resizing volumes")
 
 	totalCompatible := 0
@@ -101,12 +230,15 @@ func (c *Cluster) resizeVolumes(newVolume spec.Volume, resizers []volumes.Volume
 		return fmt.Errorf("could not list persistent volumes: %v", err)
 	}
 
+	// the resizers are connected upfront, so that the workers share their connections
+	var resizes []volumeResize
 	for _, pv := range pvs {
 		volumeSize := quantityToGigabyte(pv.Spec.Capacity[v1.ResourceStorage])
-		if volumeSize >= newSize {
-			if volumeSize > newSize {
-				c.logger.Warningf("cannot shrink persistent volume")
-			}
+		if volumeSize > newSize {
+			c.logger.Warningf("cannot shrink persistent volume")
+		}
+		grow := volumeSize < newSize
+		if !grow && !modifyProperties {
 			continue
 		}
 		for _, resizer := range resizers {
@@ -119,40 +251,519 @@ func (c *Cluster) resizeVolumes(newVolume spec.Volume, resizers []volumes.Volume
 				if err != nil {
 					return fmt.Errorf("could not connect to the volume provider: %v", err)
 				}
-				defer func() {
+				defer func(resizer volumes.VolumeResizer) {
+					if err := resizer.DisconnectFromProvider(); err != nil {
+						c.logger.Errorf("%v", err)
+					}
+				}(resizer)
+			}
+			resizes = append(resizes, volumeResize{pv: pv, resizer: resizer, volumeSize: volumeSize, grow: grow})
+		}
+	}
+	if len(pvs) > 0 && totalCompatible == 0 {
+		return fmt.Errorf("could not resize volumes: persistent volumes are not compatible with existing resizing providers")
+	}
+
+	var wg sync.WaitGroup
+	errors := make([]error, len(resizes))
+	snapshots := make([]*spec.VolumeResizeSnapshot, len(resizes))
+	workers := make(chan struct{}, c.volumeResizeWorkers())
+	for i := range resizes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			if c.OpConfig.SnapshotVolumesBeforeResize {
+				snapshot, err := c.snapshotVolumeBeforeResize(resizes[i])
+				if err != nil {
+					errors[i] = err
+					return
+				}
+				snapshots[i] = snapshot
+			}
+			errors[i] = c.resizeVolume(resizes[i], newVolume, newSize, newQuantity, modifyProperties)
+		}(i)
+	}
+	wg.Wait()
+	c.recordResizeSnapshots(snapshots)
+
+	var failures []string
+	for i, err := range errors {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", resizes[i].pv.Name, err))
+		}
+	}
+	if len(failures) < len(resizes) {
+		c.lastVolumeResize = time.Now()
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("could not resize %d of %d volumes: %s", len(failures), len(resizes), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// resizeVolume resizes or modifies a single volume at the provider and grows the filesystem on it.
+func (c *Cluster) resizeVolume(r volumeResize, newVolume spec.Volume, newSize int64, newQuantity resource.Quantity,
+	modifyProperties bool) error {
+	pv := r.pv
+	volumeID, err := r.resizer.GetProviderVolumeID(pv)
+	if err != nil {
+		return err
+	}
+	if modifier, ok := r.resizer.(volumes.VolumeModifier); ok && modifyProperties {
+		modification := volumes.VolumeModification{
+			Size:       r.volumeSize,
+			Iops:       newVolume.Iops,
+			Throughput: newVolume.Throughput,
+			VolumeType: newVolume.VolumeType,
+		}
+		if r.grow {
+			modification.Size = newSize
+		}
+		c.logger.Debugf("modifying persistent volume %q to %d", pv.Name, modification.Size)
+		if err := modifier.ModifyVolume(volumeID, modification); err != nil {
+			return fmt.Errorf("could not modify volume %q: %v", volumeID, err)
+		}
+		if err := c.annotateVolumeType(pv, newVolume.VolumeType); err != nil {
+			return err
+		}
+	} else if r.grow {
+		c.logger.Debugf("updating persistent volume %q to %d", pv.Name, newSize)
+		if err := r.resizer.ResizeVolume(volumeID, newSize); err != nil {
+			return fmt.Errorf("could not resize volume %q: %v", volumeID, err)
+		}
+	} else {
+		c.logger.Warningf("volume provider of the persistent volume %q does not support volume types, iops or throughput", pv.Name)
+	}
+	if !r.grow {
+		return nil
+	}
+	c.logger.Debugf("resizing the filesystem on the volume %q", pv.Name)
+	podName := getPodNameFromPersistentVolume(pv)
+	fsResizers := []filesystems.FilesystemResizer{
+		&filesystems.Ext234Resize{},
+		&filesystems.XFSResize{MountPoint: dataMountPath(&c.Spec.Volume)},
+	}
+	if err := c.resizePostgresFilesystem(podName, fsResizers); err != nil {
+		return fmt.Errorf("could not resize the filesystem on pod %q: %v", podName, err)
+	}
+	c.logger.Debugf("filesystem resize successful on volume %q", pv.Name)
+	pv.Spec.Capacity[v1.ResourceStorage] = newQuantity
+	c.logger.Debugf("updating persistent volume definition for volume %q", pv.Name)
+	if _, err := c.KubeClient.PersistentVolumes().Update(pv); err != nil {
+		return fmt.Errorf("could not update persistent volume: %q", err)
+	}
+	c.logger.Debugf("successfully updated persistent volume %q", pv.Name)
+
+	return nil
+}
+
+// volumeResizeWorkers returns how many volumes are resized at the same time, at least one.
+func (c *Cluster) volumeResizeWorkers() int {
+	if c.OpConfig.VolumeResizeWorkers < 1 {
+		return 1
+	}
+	return int(c.OpConfig.VolumeResizeWorkers)
+}
+
+// maxVolumeSize returns the size the volumes of the cluster must not grow beyond, taken from the manifest
+// or, if not set there, from the operator configuration. The empty string means there is no limit.
+func (c *Cluster) maxVolumeSize(volume spec.Volume) string {
+	if volume.MaxSize != "" {
+		return volume.MaxSize
+	}
+	return c.OpConfig.MaxVolumeSize
+}
+
+// checkVolumeSizeLimit returns an error if the volume size exceeds the maximum allowed size.
+func (c *Cluster) checkVolumeSizeLimit(volume spec.Volume) error {
+	maxSize := c.maxVolumeSize(volume)
+	if maxSize == "" {
+		return nil
+	}
+	maxQuantity, err := resource.ParseQuantity(maxSize)
+	if err != nil {
+		return fmt.Errorf("could not parse maximum volume size: %v", err)
+	}
+	quantity, err := resource.ParseQuantity(volume.Size)
+	if err != nil {
+		return fmt.Errorf("could not parse volume size: %v", err)
+	}
+	if quantity.Cmp(maxQuantity) > 0 {
+		return fmt.Errorf("volume size %s exceeds the maximum allowed size %s", volume.Size, maxSize)
+	}
+
+	return nil
+}
+
+// volumeSizeGrows tells whether the volume asks for more storage than the data volume claim template of the current
+// statefulset. The limit is only enforced on the growth, so that the clusters already larger than a newly configured
+// limit can still be synced. A cluster without a statefulset grows from nothing.
+func (c *Cluster) volumeSizeGrows(volume spec.Volume) (bool, error) {
+	if c.Statefulset == nil {
+		return true, nil
+	}
+	newSize, err := resource.ParseQuantity(volume.Size)
+	if err != nil {
+		return false, fmt.Errorf("could not parse volume size: %v", err)
+	}
+	for _, template := range c.Statefulset.Spec.VolumeClaimTemplates {
+		if template.Name != constants.DataVolumeName {
+			continue
+		}
+		currentSize, ok := template.Spec.Resources.Requests[v1.ResourceStorage]
+		return !ok || newSize.Cmp(currentSize) > 0, nil
+	}
+	return true, nil
+}
+
+// autoGrowVolumes checks the disk usage on the master and, when it crosses the configured threshold, grows the volumes
+// by the configured increment. The new size is written back to the manifest, so that it is not reverted on the next sync.
+func (c *Cluster) autoGrowVolumes() error {
+	c.setProcessName("checking volume usage")
+
+	masterPods, err := c.getRolePods(Master)
+	if err != nil {
+		return fmt.Errorf("could not get master pod: %v", err)
+	}
+	if len(masterPods) == 0 {
+		return fmt.Errorf("no master pod is running in the cluster")
+	}
+	podName := util.NameFromMeta(masterPods[0].ObjectMeta)
+	commandExecutor := func(cmd string) (string, error) {
+		return c.ExecCommand(&podName, "sh", "-c", cmd)
+	}
+
+	newSize, grow, err := c.autoGrowVolumeSize(time.Now(), commandExecutor)
+	if err != nil || !grow {
+		return err
+	}
+
+	newVolume := c.Spec.Volume
+	newVolume.Size = newSize
+	if err := c.checkVolumeSizeLimit(newVolume); err != nil {
+		return err
+	}
+	c.logger.Infof("growing volumes from %s to %s", c.Spec.Volume.Size, newSize)
+	if err := c.patchVolumeSize(newSize); err != nil {
+		return err
+	}
+	// the controller reads the spec concurrently while reporting and taking backups
+	c.specMu.Lock()
+	c.Spec.Volume.Size = newSize
+	c.specMu.Unlock()
+
+	return c.syncVolumes()
+}
+
+// autoGrowVolumeSize returns the size the volumes should be grown to, based on the disk usage reported by df.
+func (c *Cluster) autoGrowVolumeSize(now time.Time, commandExecutor func(cmd string) (string, error)) (string, bool, error) {
+	autoGrow := c.Spec.Volume.AutoGrow
+	if autoGrow == nil {
+		return "", false, nil
+	}
+	if !c.lastVolumeResize.IsZero() && now.Sub(c.lastVolumeResize) < constants.EBSVolumeModificationCooldown {
+		c.logger.Debugf("volumes were resized at %s, skipping automatic growth", c.lastVolumeResize.Format(time.RFC3339))
+		return "", false, nil
+	}
+
+	out, err := commandExecutor(fmt.Sprintf(volumeUsageCommand, dataMountPath(&c.Spec.Volume)))
+	if err != nil {
+		return "", false, fmt.Errorf("could not get volume usage: %v", err)
+	}
+	usedPercent, err := parseDfUsage(out)
+	if err != nil {
+		return "", false, fmt.Errorf("could not parse volume usage: %v", err)
+	}
+	if usedPercent < autoGrow.UsageThreshold {
+		return "", false, nil
+	}
+
+	newSize, grow, err := nextAutoGrowSize(c.Spec.Volume.Size, autoGrow.Increment, autoGrow.MaxSize, c.maxVolumeSize(c.Spec.Volume))
+	if err != nil {
+		return "", false, err
+	}
+	if !grow {
+		c.logger.Warningf("volume usage is at %d%%, but the volumes have reached their maximum size %s", usedPercent, c.Spec.Volume.Size)
+	}
+
+	return newSize, grow, nil
+}
+
+// nextAutoGrowSize adds the increment to the size, capping the result at the smallest of the non-empty limits.
+func nextAutoGrowSize(size, increment string, limits ...string) (string, bool, error) {
+	current, err := resource.ParseQuantity(size)
+	if err != nil {
+		return "", false, fmt.Errorf("could not parse volume size: %v", err)
+	}
+	next, err := resource.ParseQuantity(increment)
+	if err != nil {
+		return "", false, fmt.Errorf("could not parse volume auto grow increment: %v", err)
+	}
+	next.Add(current)
+	for _, limit := range limits {
+		if limit == "" {
+			continue
+		}
+		maxSize, err := resource.ParseQuantity(limit)
+		if err != nil {
+			return "", false, fmt.Errorf("could not parse maximum volume size: %v", err)
+		}
+		if next.Cmp(maxSize) > 0 {
+			next = maxSize
+		}
+	}
+	if next.Cmp(current) <= 0 {
+		return "", false, nil
+	}
+
+	return next.String(), true, nil
+}
+
+// parseDfUsage extracts the percentage of used space from the output of df -P.
+func parseDfUsage(out string) (int, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("too few lines in the df output")
+	}
+
+	usageColumn := -1
+	for i, name := range strings.Fields(lines[0]) {
+		if name == "Capacity" || name == "Use%" {
+			usageColumn = i
+			break
+		}
+	}
+	if usageColumn < 0 {
+		return 0, fmt.Errorf("no usage column in the df output")
+	}
+
+	fields := strings.Fields(strings.Join(lines[1:], " "))
+	if len(fields) <= usageColumn {
+		return 0, fmt.Errorf("too few fields in the df output")
+	}
+	usedPercent, err := strconv.Atoi(strings.TrimSuffix(fields[usageColumn], "%"))
+	if err != nil {
+		return 0, fmt.Errorf("could not parse usage %q: %v", fields[usageColumn], err)
+	}
+
+	return usedPercent, nil
+}
+
+func (c *Cluster) patchVolumeSize(size string) error {
+	request := []byte(fmt.Sprintf(`{"spec": {"volume": {"size": %q}}}`, size))
+
+	_, err := c.KubeClient.CRDREST.Patch(types.MergePatchType).
+		Namespace(c.Namespace).
+		Resource(constants.CRDResource).
+		Name(c.Name).
+		Body(request).
+		DoRaw()
+	if err != nil {
+		return fmt.Errorf("could not update the volume size in the manifest: %v", err)
+	}
+
+	return nil
+}
+
+// modifyVolumes applies the size along with the type, the iops and the throughput of the manifest to the volumes of the
+// running pods.
+func (c *Cluster) modifyVolumes() error {
+	c.setProcessName("modifying volumes")
+
+	if c.Spec.Volume.IsEphemeral() {
+		return nil
+	}
+	if shrinking, err := c.checkVolumeShrink(); err != nil || shrinking {
+		return err
+	}
+	if c.OpConfig.VolumeResizeMode == config.VolumeResizeModePVC {
+		c.logger.Warningf("volume type, iops and throughput are only applied in the %q volume resize mode",
+			config.VolumeResizeModeProvider)
+		return c.syncVolumeClaims()
+	}
+	grow, err := c.volumesNeedResizing(c.Spec.Volume)
+	if err != nil {
+		return fmt.Errorf("could not compare size of the volumes: %v", err)
+	}
+	// the volumes larger than the manifest have been dealt with above, the sizes left differing grow
+	if grow {
+		if err := c.checkVolumeSizeLimit(c.Spec.Volume); err != nil {
+			return fmt.Errorf("refusing to resize volumes: %v", err)
+		}
+	}
+	resizers, err := volumes.NewVolumeResizers(&c.OpConfig)
+	if err != nil {
+		return err
+	}
+
+	return c.resizeVolumes(c.Spec.Volume, resizers, true)
+}
+
+// sameVolumeProperties tells whether both volume specifications ask for the same type, iops and throughput.
+func sameVolumeProperties(old, new spec.Volume) bool {
+	return old.VolumeType == new.VolumeType &&
+		reflect.DeepEqual(old.Iops, new.Iops) && reflect.DeepEqual(old.Throughput, new.Throughput)
+}
+
+// hasVolumeProperties tells whether the volume specification asks for a type, iops or throughput.
+func hasVolumeProperties(volume spec.Volume) bool {
+	return volume.VolumeType != "" || volume.Iops != nil || volume.Throughput != nil
+}
+
+// volumeTags returns the tags identifying the cluster of the volumes to the cost allocation and cleanup tooling.
+func (c *Cluster) volumeTags() map[string]string {
+	return map[string]string{
+		c.OpConfig.ClusterNameLabel: c.Name,
+		"namespace":                 c.Namespace,
+		"team":                      c.Spec.TeamID,
+	}
+}
+
+// formatVolumeTags returns the tags as sorted key=value pairs, the value of the annotation of the tagged volumes.
+func formatVolumeTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// tagVolumes tags the provider volumes of the running pods with the metadata of the cluster. Volumes whose annotation
+// shows the current tags are skipped, so that the provider API is only called for new volumes or changed metadata.
+func (c *Cluster) tagVolumes(resizers []volumes.VolumeResizer) error {
+	c.setProcessName("tagging volumes")
+
+	tags := c.volumeTags()
+	annotation := formatVolumeTags(tags)
+	pvs, err := c.listPersistentVolumes()
+	if err != nil {
+		return fmt.Errorf("could not list persistent volumes: %v", err)
+	}
+
+	for _, pv := range pvs {
+		if pv.Annotations[constants.VolumeTagsAnnotation] == annotation {
+			continue
+		}
+		for _, resizer := range resizers {
+			tagger, ok := resizer.(volumes.VolumeTagger)
+			if !ok || !resizer.VolumeBelongsToProvider(pv) {
+				continue
+			}
+			if !resizer.IsConnectedToProvider() {
+				if err := resizer.ConnectToProvider(); err != nil {
+					return fmt.Errorf("could not connect to the volume provider: %v", err)
+				}
+				defer func(resizer volumes.VolumeResizer) {
 					if err := resizer.DisconnectFromProvider(); err != nil {
 						c.logger.Errorf("%v", err)
 					}
-				}()
+				}(resizer)
 			}
-			awsVolumeID, err := resizer.GetProviderVolumeID(pv)
+			volumeID, err := resizer.GetProviderVolumeID(pv)
 			if err != nil {
 				return err
 			}
-			c.logger.Debugf("updating persistent volume %q to %d", pv.Name, newSize)
-			if err := resizer.ResizeVolume(awsVolumeID, newSize); err != nil {
-				return fmt.Errorf("could not resize EBS volume %q: %v", awsVolumeID, err)
+			if err := tagger.TagVolume(volumeID, tags); err != nil {
+				return err
 			}
-			c.logger.Debugf("resizing the filesystem on the volume %q", pv.Name)
-			podName := getPodNameFromPersistentVolume(pv)
-			if err := c.resizePostgresFilesystem(podName, []filesystems.FilesystemResizer{&filesystems.Ext234Resize{}}); err != nil {
-				return fmt.Errorf("could not resize the filesystem on pod %q: %v", podName, err)
+			if pv.Annotations == nil {
+				pv.Annotations = make(map[string]string)
 			}
-			c.logger.Debugf("filesystem resize successful on volume %q", pv.Name)
-			pv.Spec.Capacity[v1.ResourceStorage] = newQuantity
-			c.logger.Debugf("updating persistent volume definition for volume %q", pv.Name)
+			pv.Annotations[constants.VolumeTagsAnnotation] = annotation
 			if _, err := c.KubeClient.PersistentVolumes().Update(pv); err != nil {
-				return fmt.Errorf("could not update persistent volume: %q", err)
+				return fmt.Errorf("could not update the tags annotation of persistent volume %q: %v", pv.Name, err)
 			}
-			c.logger.Debugf("successfully updated persistent volume %q", pv.Name)
+			c.logger.Debugf("volume %q of persistent volume %q has been tagged", volumeID, pv.Name)
+			break
 		}
 	}
-	if len(pvs) > 0 && totalCompatible == 0 {
-		return fmt.Errorf("could not resize EBS volumes: persistent volumes are not compatible with existing resizing providers")
+
+	return nil
+}
+
+// annotateVolumeType records the type the persistent volume has been migrated to in its annotations, so that
+// the definition of the volume does not keep the type of its storage class.
+func (c *Cluster) annotateVolumeType(pv *v1.PersistentVolume, volumeType string) error {
+	if volumeType == "" || pv.Annotations[constants.VolumeTypeAnnotation] == volumeType {
+		return nil
+	}
+	if pv.Annotations == nil {
+		pv.Annotations = make(map[string]string)
+	}
+	pv.Annotations[constants.VolumeTypeAnnotation] = volumeType
+	updated, err := c.KubeClient.PersistentVolumes().Update(pv)
+	if err != nil {
+		return fmt.Errorf("could not update the type annotation of persistent volume %q: %v", pv.Name, err)
+	}
+	*pv = *updated
+
+	return nil
+}
+
+// syncVolumeClaims grows the storage requests of the persistent volume claims to the size of the manifest. The
+// storage class must allow the volume expansion, the volumes and their filesystems are then resized by Kubernetes.
+func (c *Cluster) syncVolumeClaims() error {
+	c.setProcessName("syncing persistent volume claims")
+
+	newQuantity, err := resource.ParseQuantity(c.Spec.Volume.Size)
+	if err != nil {
+		return fmt.Errorf("could not parse volume size: %v", err)
+	}
+	pvcs, err := c.listPersistentVolumeClaims()
+	if err != nil {
+		return err
 	}
+	ownClaims := make([]v1.PersistentVolumeClaim, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		if c.isOwnDataVolumeClaim(&pvc) {
+			ownClaims = append(ownClaims, pvc)
+		}
+	}
+	toResize := volumeClaimsToResize(ownClaims, newQuantity)
+	if len(toResize) == 0 {
+		return nil
+	}
+	if err := c.checkVolumeSizeLimit(c.Spec.Volume); err != nil {
+		return fmt.Errorf("refusing to resize volumes: %v", err)
+	}
+	if c.OpConfig.SnapshotVolumesBeforeResize {
+		if err := c.snapshotVolumeClaimsBeforeResize(toResize); err != nil {
+			return fmt.Errorf("refusing to resize volumes: %v", err)
+		}
+	}
+
+	request := []byte(fmt.Sprintf(`{"spec": {"resources": {"requests": {"storage": %q}}}}`, newQuantity.String()))
+	for _, name := range toResize {
+		c.logger.Debugf("updating persistent volume claim %q to %s", name, newQuantity.String())
+		if _, err := c.KubeClient.PersistentVolumeClaims(c.Namespace).Patch(name, types.MergePatchType, request); err != nil {
+			return fmt.Errorf("could not update persistent volume claim %q: %v", name, err)
+		}
+		c.lastVolumeResize = time.Now()
+	}
+	c.logger.Infof("persistent volume claims have been resized to %s", newQuantity.String())
+
 	return nil
 }
 
+// volumeClaimsToResize returns the names of the claims requesting less storage than the given quantity. Claims asking
+// for more are left alone, since the volumes cannot shrink.
+func volumeClaimsToResize(pvcs []v1.PersistentVolumeClaim, quantity resource.Quantity) []string {
+	var result []string
+	for _, pvc := range pvcs {
+		if !isDataVolumeClaim(&pvc) {
+			continue
+		}
+		if current, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok && current.Cmp(quantity) >= 0 {
+			continue
+		}
+		result = append(result, pvc.Name)
+	}
+	return result
+}
+
 func (c *Cluster) volumesNeedResizing(newVolume spec.Volume) (bool, error) {
 	vols, manifestSize, err := c.listVolumesWithManifestSize(newVolume)
 	if err != nil {
@@ -180,6 +791,163 @@ func (c *Cluster) listVolumesWithManifestSize(newVolume spec.Volume) ([]*v1.Pers
 	return vols, manifestSize, nil
 }
 
+// volumeClaimShrinks tells whether the new claim requests less storage than the current one.
+func volumeClaimShrinks(current, new *v1.PersistentVolumeClaim) bool {
+	currentSize, ok := current.Spec.Resources.Requests[v1.ResourceStorage]
+	if !ok {
+		return false
+	}
+	newSize, ok := new.Spec.Resources.Requests[v1.ResourceStorage]
+	return ok && newSize.Cmp(currentSize) < 0
+}
+
+// forceVolumeShrink tells whether the annotation of the manifest asks for the volumes to be shrunk.
+func (c *Cluster) forceVolumeShrink() bool {
+	return c.ObjectMeta.Annotations[constants.ForceVolumeShrinkAnnotation] == "true"
+}
+
+// volumesToShrink returns the data volumes of the running pods that are larger than the size of the manifest.
+func (c *Cluster) volumesToShrink(newVolume spec.Volume) ([]*v1.PersistentVolume, error) {
+	vols, manifestSize, err := c.listVolumesWithManifestSize(newVolume)
+	if err != nil {
+		return nil, err
+	}
+	var result []*v1.PersistentVolume
+	for _, pv := range vols {
+		if quantityToGigabyte(pv.Spec.Capacity[v1.ResourceStorage]) > manifestSize {
+			result = append(result, pv)
+		}
+	}
+	return result, nil
+}
+
+// checkVolumeShrink refuses the volume size of the manifest below the size of the volumes, since neither EBS nor the
+// other providers can shrink volumes, and reports it as an event. When the annotation forces the shrink, it tells
+// that the volumes are left to syncVolumeShrink, which recreates them once the statefulset has the smaller volume
+// claim template.
+func (c *Cluster) checkVolumeShrink() (bool, error) {
+	pvs, err := c.volumesToShrink(c.Spec.Volume)
+	if err != nil {
+		return false, fmt.Errorf("could not compare size of the volumes: %v", err)
+	}
+	if len(pvs) == 0 {
+		return false, nil
+	}
+	if c.forceVolumeShrink() {
+		c.logger.Infof("%d volumes are larger than %s and are going to be recreated", len(pvs), c.Spec.Volume.Size)
+		return true, nil
+	}
+
+	names := make([]string, 0, len(pvs))
+	for _, pv := range pvs {
+		names = append(names, pv.Name)
+	}
+	message := fmt.Sprintf("volume size %s is smaller than the size of the persistent volumes %s: volumes cannot shrink, "+
+		"set the %q annotation to \"true\" to recreate them", c.Spec.Volume.Size, strings.Join(names, ", "),
+		constants.ForceVolumeShrinkAnnotation)
+	c.createWarningEvent(eventReasonVolumeShrinkRefused, message)
+	return false, fmt.Errorf("%s", message)
+}
+
+// syncVolumeShrink recreates the volumes larger than the manifest when the annotation forces the shrink. The pods
+// are recreated one at a time together with their volume claim, the replicas first and the master after a
+// switchover, and every recreated member copies the data from the master again. The statefulset must already have
+// the smaller volume claim template.
+func (c *Cluster) syncVolumeShrink() error {
+	if c.Spec.Volume.IsEphemeral() || !c.forceVolumeShrink() {
+		return nil
+	}
+	c.setProcessName("shrinking volumes")
+
+	pvs, err := c.volumesToShrink(c.Spec.Volume)
+	if err != nil || len(pvs) == 0 {
+		return err
+	}
+	if c.getNumberOfInstances(&c.Spec) < 2 {
+		return fmt.Errorf("shrinking the volumes requires at least 2 instances, the data of a single one would be lost")
+	}
+
+	masterPods, err := c.getRolePods(Master)
+	if err != nil {
+		return fmt.Errorf("could not get master pod: %v", err)
+	}
+	if len(masterPods) == 0 {
+		return fmt.Errorf("no master pod is running in the cluster")
+	}
+	master := util.NameFromMeta(masterPods[0].ObjectMeta)
+
+	var masterClaim string
+	for _, pv := range pvs {
+		podName := getPodNameFromPersistentVolume(pv)
+		if *podName == master {
+			masterClaim = pv.Spec.ClaimRef.Name
+			continue
+		}
+		if err := c.recreatePodVolume(*podName, pv.Spec.ClaimRef.Name); err != nil {
+			return err
+		}
+	}
+	if masterClaim != "" {
+		replicas, err := c.getRolePods(Replica)
+		if err != nil {
+			return fmt.Errorf("could not get replica pods: %v", err)
+		}
+		if len(replicas) == 0 {
+			return fmt.Errorf("no replica to switch over to before recreating the volume of the master")
+		}
+		candidates := make([]spec.NamespacedName, 0, len(replicas))
+		for _, replica := range replicas {
+			candidates = append(candidates, util.NameFromMeta(replica.ObjectMeta))
+		}
+		if err := c.Switchover(&masterPods[0], masterCandidate(candidates)); err != nil {
+			return fmt.Errorf("could not switch over before recreating the volume of the master: %v", err)
+		}
+		if err := c.recreatePodVolume(master, masterClaim); err != nil {
+			return err
+		}
+	}
+	c.logger.Infof("volumes have been shrunk to %s", c.Spec.Volume.Size)
+
+	return nil
+}
+
+// recreatePodVolume deletes the volume claim of the pod and recreates the pod. The claim stays terminating as long as
+// the pod uses it, so the pod is deleted and the claim is waited for before the pod is recreated once more; the
+// statefulset then creates a new claim of the size of its template together with the pod, instead of starting the
+// pod on the terminating claim.
+func (c *Cluster) recreatePodVolume(podName spec.NamespacedName, claimName string) error {
+	c.logger.Infof("recreating pod %q with a new volume", podName)
+	if err := c.KubeClient.PersistentVolumeClaims(podName.Namespace).Delete(claimName, c.deleteOptions); err != nil {
+		return fmt.Errorf("could not delete persistent volume claim %q: %v", claimName, err)
+	}
+	if err := c.deletePod(podName); err != nil {
+		return fmt.Errorf("could not delete pod %q: %v", podName, err)
+	}
+	if err := c.waitForVolumeClaimDeletion(podName.Namespace, claimName); err != nil {
+		return err
+	}
+	if _, err := c.recreatePod(podName); err != nil {
+		return fmt.Errorf("could not recreate pod %q: %v", podName, err)
+	}
+	return nil
+}
+
+// waitForVolumeClaimDeletion waits until the deleted volume claim is gone from the API server.
+func (c *Cluster) waitForVolumeClaimDeletion(namespace, claimName string) error {
+	err := retryutil.Retry(c.OpConfig.ResourceCheckInterval, c.OpConfig.ResourceCheckTimeout,
+		func() (bool, error) {
+			_, err := c.KubeClient.PersistentVolumeClaims(namespace).Get(claimName, metav1.GetOptions{})
+			if k8sutil.ResourceNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+	if err != nil {
+		return fmt.Errorf("persistent volume claim %q has not been deleted: %v", claimName, err)
+	}
+	return nil
+}
+
 // getPodNameFromPersistentVolume returns a pod name that it extracts from the volume claim ref.
 func getPodNameFromPersistentVolume(pv *v1.PersistentVolume) *spec.NamespacedName {
 	namespace := pv.Spec.ClaimRef.Namespace
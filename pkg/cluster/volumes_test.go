@@ -0,0 +1,443 @@
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/apps/v1beta1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
+)
+
+func TestCheckVolumeSizeLimit(t *testing.T) {
+	testName := "TestCheckVolumeSizeLimit"
+	tests := []struct {
+		subtest       string
+		operatorLimit string
+		volume        spec.Volume
+		rejected      bool
+	}{
+		{
+			subtest: "no limit configured",
+			volume:  spec.Volume{Size: "1Ti"},
+		},
+		{
+			subtest:       "resize within the operator limit",
+			operatorLimit: "100Gi",
+			volume:        spec.Volume{Size: "50Gi"},
+		},
+		{
+			subtest:       "resize up to the operator limit",
+			operatorLimit: "100Gi",
+			volume:        spec.Volume{Size: "100Gi"},
+		},
+		{
+			subtest:       "resize beyond the operator limit",
+			operatorLimit: "100Gi",
+			volume:        spec.Volume{Size: "101Gi"},
+			rejected:      true,
+		},
+		{
+			subtest:       "cluster limit overrides the operator limit",
+			operatorLimit: "100Gi",
+			volume:        spec.Volume{Size: "200Gi", MaxSize: "500Gi"},
+		},
+		{
+			subtest:       "resize beyond the cluster limit",
+			operatorLimit: "1Ti",
+			volume:        spec.Volume{Size: "200Gi", MaxSize: "100Gi"},
+			rejected:      true,
+		},
+	}
+	for _, tt := range tests {
+		cluster := New(
+			Config{OpConfig: config.Config{Resources: config.Resources{MaxVolumeSize: tt.operatorLimit}}},
+			k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+		err := cluster.checkVolumeSizeLimit(tt.volume)
+		if tt.rejected && err == nil {
+			t.Errorf("%s %s: expected volume size %s to be rejected", testName, tt.subtest, tt.volume.Size)
+		} else if !tt.rejected && err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, tt.subtest, err)
+		}
+	}
+}
+
+func fakeDf(usedPercent int) func(cmd string) (string, error) {
+	return func(cmd string) (string, error) {
+		return fmt.Sprintf("Filesystem     1024-blocks     Used Available Capacity Mounted on\n"+
+			"/dev/xvdb         10255636  9000000   1255636      %d%% /home/postgres/pgdata\n", usedPercent), nil
+	}
+}
+
+func TestAutoGrowVolumeSize(t *testing.T) {
+	testName := "TestAutoGrowVolumeSize"
+	now := time.Now()
+	autoGrow := &spec.VolumeAutoGrow{UsageThreshold: 80, Increment: "5Gi", MaxSize: "20Gi"}
+	tests := []struct {
+		subtest          string
+		volume           spec.Volume
+		operatorLimit    string
+		lastVolumeResize time.Time
+		usedPercent      int
+		size             string
+		grow             bool
+	}{
+		{
+			subtest:     "usage below the threshold",
+			volume:      spec.Volume{Size: "10Gi", AutoGrow: autoGrow},
+			usedPercent: 50,
+		},
+		{
+			subtest:     "usage above the threshold",
+			volume:      spec.Volume{Size: "10Gi", AutoGrow: autoGrow},
+			usedPercent: 90,
+			size:        "15Gi",
+			grow:        true,
+		},
+		{
+			subtest:     "growth capped at the auto grow maximum size",
+			volume:      spec.Volume{Size: "18Gi", AutoGrow: autoGrow},
+			usedPercent: 90,
+			size:        "20Gi",
+			grow:        true,
+		},
+		{
+			subtest:       "growth capped at the maximum volume size",
+			volume:        spec.Volume{Size: "10Gi", AutoGrow: autoGrow},
+			operatorLimit: "12Gi",
+			usedPercent:   90,
+			size:          "12Gi",
+			grow:          true,
+		},
+		{
+			subtest:     "volume at the maximum size",
+			volume:      spec.Volume{Size: "20Gi", AutoGrow: autoGrow},
+			usedPercent: 95,
+		},
+		{
+			subtest:          "volume resized recently",
+			volume:           spec.Volume{Size: "10Gi", AutoGrow: autoGrow},
+			lastVolumeResize: now.Add(-time.Hour),
+			usedPercent:      90,
+		},
+		{
+			subtest:     "auto grow disabled",
+			volume:      spec.Volume{Size: "10Gi"},
+			usedPercent: 99,
+		},
+	}
+	for _, tt := range tests {
+		cluster := New(
+			Config{OpConfig: config.Config{Resources: config.Resources{MaxVolumeSize: tt.operatorLimit}}},
+			k8sutil.KubernetesClient{}, spec.Postgresql{Spec: spec.PostgresSpec{Volume: tt.volume}}, logger)
+		cluster.lastVolumeResize = tt.lastVolumeResize
+		size, grow, err := cluster.autoGrowVolumeSize(now, fakeDf(tt.usedPercent))
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, tt.subtest, err)
+			continue
+		}
+		if grow != tt.grow {
+			t.Errorf("%s %s: expected grow to be %t, got %t", testName, tt.subtest, tt.grow, grow)
+		}
+		if size != tt.size {
+			t.Errorf("%s %s: expected size %q, got %q", testName, tt.subtest, tt.size, size)
+		}
+	}
+}
+
+func TestParseDfUsage(t *testing.T) {
+	testName := "TestParseDfUsage"
+	tests := []struct {
+		subtest string
+		out     string
+		usage   int
+		err     bool
+	}{
+		{
+			subtest: "POSIX output",
+			out: "Filesystem     1024-blocks    Used Available Capacity Mounted on\n" +
+				"/dev/xvdb         10255636 5127818   5127818      50% /home/postgres/pgdata\n",
+			usage: 50,
+		},
+		{
+			subtest: "GNU output",
+			out: "Filesystem     1K-blocks    Used Available Use% Mounted on\n" +
+				"/dev/xvdb       10255636 9230072   1025564  91% /home/postgres/pgdata\n",
+			usage: 91,
+		},
+		{
+			subtest: "no usage column",
+			out: "Filesystem     Type\n" +
+				"/dev/xvdb      ext4\n",
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		usage, err := parseDfUsage(tt.out)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s %s: expected an error", testName, tt.subtest)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", testName, tt.subtest, err)
+		} else if usage != tt.usage {
+			t.Errorf("%s %s: expected usage %d, got %d", testName, tt.subtest, tt.usage, usage)
+		}
+	}
+}
+
+func volumeClaim(name, size string) v1.PersistentVolumeClaim {
+	pvc := v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if size != "" {
+		pvc.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse(size)}
+	}
+	return pvc
+}
+
+func TestVolumeClaimsToResize(t *testing.T) {
+	testName := "TestVolumeClaimsToResize"
+	tests := []struct {
+		subtest  string
+		pvcs     []v1.PersistentVolumeClaim
+		size     string
+		expected []string
+	}{
+		{
+			subtest: "all claims of the manifest size",
+			pvcs:    []v1.PersistentVolumeClaim{volumeClaim("pgdata-acid-test-0", "10Gi"), volumeClaim("pgdata-acid-test-1", "10Gi")},
+			size:    "10Gi",
+		},
+		{
+			subtest:  "claims to grow",
+			pvcs:     []v1.PersistentVolumeClaim{volumeClaim("pgdata-acid-test-0", "10Gi"), volumeClaim("pgdata-acid-test-1", "5Gi")},
+			size:     "10Gi",
+			expected: []string{"pgdata-acid-test-1"},
+		},
+		{
+			subtest: "claims larger than the manifest are not shrunk",
+			pvcs:    []v1.PersistentVolumeClaim{volumeClaim("pgdata-acid-test-0", "20Gi")},
+			size:    "10Gi",
+		},
+		{
+			subtest:  "claim without a storage request",
+			pvcs:     []v1.PersistentVolumeClaim{volumeClaim("pgdata-acid-test-0", "")},
+			size:     "10Gi",
+			expected: []string{"pgdata-acid-test-0"},
+		},
+		{
+			subtest:  "volumes of the tablespaces are left alone",
+			pvcs:     []v1.PersistentVolumeClaim{volumeClaim("pgdata-acid-test-0", "5Gi"), volumeClaim("tablespace-archive-acid-test-0", "5Gi")},
+			size:     "10Gi",
+			expected: []string{"pgdata-acid-test-0"},
+		},
+	}
+	for _, tt := range tests {
+		result := volumeClaimsToResize(tt.pvcs, resource.MustParse(tt.size))
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%s %s: expected %v, got %v", testName, tt.subtest, tt.expected, result)
+		}
+	}
+}
+
+func TestVolumeClaimShrinks(t *testing.T) {
+	testName := "TestVolumeClaimShrinks"
+	tests := []struct {
+		subtest string
+		current string
+		new     string
+		shrinks bool
+	}{
+		{"same size", "10Gi", "10Gi", false},
+		{"larger size", "10Gi", "20Gi", false},
+		{"smaller size", "10Gi", "5Gi", true},
+		{"smaller size in other units", "1Gi", "1000Mi", true},
+		{"no current request", "", "5Gi", false},
+	}
+	for _, tt := range tests {
+		current, new := volumeClaim("pgdata", tt.current), volumeClaim("pgdata", tt.new)
+		if shrinks := volumeClaimShrinks(&current, &new); shrinks != tt.shrinks {
+			t.Errorf("%s %s: expected %t, got %t", testName, tt.subtest, tt.shrinks, shrinks)
+		}
+	}
+}
+
+func TestVolumeSizeGrows(t *testing.T) {
+	testName := "TestVolumeSizeGrows"
+	tests := []struct {
+		subtest   string
+		templates []v1.PersistentVolumeClaim
+		size      string
+		grows     bool
+	}{
+		{"no statefulset", nil, "10Gi", true},
+		{"same size", []v1.PersistentVolumeClaim{volumeClaim("pgdata", "10Gi")}, "10Gi", false},
+		{"smaller size", []v1.PersistentVolumeClaim{volumeClaim("pgdata", "10Gi")}, "5Gi", false},
+		{"larger size", []v1.PersistentVolumeClaim{volumeClaim("pgdata", "10Gi")}, "20Gi", true},
+		{"only the data volume counts",
+			[]v1.PersistentVolumeClaim{volumeClaim("tablespace", "5Gi"), volumeClaim("pgdata", "20Gi")}, "10Gi", false},
+	}
+	for _, tt := range tests {
+		cluster := New(Config{}, k8sutil.KubernetesClient{}, spec.Postgresql{}, logger)
+		if tt.templates != nil {
+			cluster.Statefulset = &v1beta1.StatefulSet{Spec: v1beta1.StatefulSetSpec{VolumeClaimTemplates: tt.templates}}
+		}
+		grows, err := cluster.volumeSizeGrows(spec.Volume{Size: tt.size})
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error: %v", testName, tt.subtest, err)
+		}
+		if grows != tt.grows {
+			t.Errorf("%s %s: expected %t, got %t", testName, tt.subtest, tt.grows, grows)
+		}
+	}
+}
+
+func TestSameVolumeProperties(t *testing.T) {
+	testName := "TestSameVolumeProperties"
+	iops, moreIops, throughput := int64(3000), int64(6000), int64(125)
+	tests := []struct {
+		subtest string
+		old     spec.Volume
+		new     spec.Volume
+		same    bool
+	}{
+		{
+			subtest: "only the size changed",
+			old:     spec.Volume{Size: "10Gi", Iops: &iops},
+			new:     spec.Volume{Size: "20Gi", Iops: &iops},
+			same:    true,
+		},
+		{
+			subtest: "iops changed",
+			old:     spec.Volume{Size: "10Gi", Iops: &iops},
+			new:     spec.Volume{Size: "10Gi", Iops: &moreIops},
+		},
+		{
+			subtest: "throughput added",
+			old:     spec.Volume{Size: "10Gi"},
+			new:     spec.Volume{Size: "10Gi", Throughput: &throughput},
+		},
+		{
+			subtest: "type changed",
+			old:     spec.Volume{Size: "10Gi", VolumeType: "gp2"},
+			new:     spec.Volume{Size: "10Gi", VolumeType: "gp3"},
+		},
+	}
+	for _, tt := range tests {
+		if same := sameVolumeProperties(tt.old, tt.new); same != tt.same {
+			t.Errorf("%s %s: expected %t, got %t", testName, tt.subtest, tt.same, same)
+		}
+	}
+}
+
+func TestVolumeTags(t *testing.T) {
+	cluster := New(Config{OpConfig: config.Config{Resources: config.Resources{ClusterNameLabel: "cluster-name"}}},
+		k8sutil.KubernetesClient{}, spec.Postgresql{
+			ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"},
+			Spec:       spec.PostgresSpec{TeamID: "acid"},
+		}, logger)
+
+	expected := "cluster-name=acid-test,namespace=test,team=acid"
+	if result := formatVolumeTags(cluster.volumeTags()); result != expected {
+		t.Errorf("TestVolumeTags: expected %q, got %q", expected, result)
+	}
+}
+
+func TestVolumeResizeWorkers(t *testing.T) {
+	for _, tt := range []struct {
+		configured uint32
+		expected   int
+	}{{0, 1}, {1, 1}, {4, 4}} {
+		cluster := New(Config{OpConfig: config.Config{VolumeResizeWorkers: tt.configured}}, k8sutil.KubernetesClient{},
+			spec.Postgresql{}, logger)
+		if workers := cluster.volumeResizeWorkers(); workers != tt.expected {
+			t.Errorf("TestVolumeResizeWorkers: expected %d workers for %d configured, got %d", tt.expected, tt.configured, workers)
+		}
+	}
+}
+
+func TestDataVolumeClaimIndex(t *testing.T) {
+	testName := "TestDataVolumeClaimIndex"
+	cluster := New(Config{}, k8sutil.KubernetesClient{},
+		spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"}}, logger)
+	tests := []struct {
+		name  string
+		index int
+		ok    bool
+	}{
+		{"pgdata-acid-test-0", 0, true},
+		{"pgdata-acid-test-12", 12, true},
+		{"pgdata-acid-test-2-0", 0, false},
+		{"pgdata-acid-test-01", 0, false},
+		{"pgdata-acid-test-", 0, false},
+		{"pgdata-acid-0", 0, false},
+		{"tablespace-archive-acid-test-0", 0, false},
+	}
+	for _, tt := range tests {
+		index, ok := cluster.dataVolumeClaimIndex(tt.name)
+		if index != tt.index || ok != tt.ok {
+			t.Errorf("%s %s: expected %d, %t, got %d, %t", testName, tt.name, tt.index, tt.ok, index, ok)
+		}
+	}
+}
+
+func TestCheckVolumeOwnership(t *testing.T) {
+	testName := "TestCheckVolumeOwnership"
+	cluster := New(Config{OpConfig: config.Config{Resources: config.Resources{ClusterNameLabel: "cluster-name"}}},
+		k8sutil.KubernetesClient{}, spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Name: "acid-test", Namespace: "test"}},
+		logger)
+
+	claim := func(name, clusterName string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test",
+			UID:       types.UID("uid-" + name),
+			Labels:    map[string]string{"cluster-name": clusterName},
+		}}
+	}
+	volume := func(namespace, claimName, clusterName string) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1", Labels: map[string]string{}}}
+		if claimName != "" {
+			pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: namespace, Name: claimName, UID: types.UID("uid-" + claimName)}
+		}
+		if clusterName != "" {
+			pv.Labels["cluster-name"] = clusterName
+		}
+		return pv
+	}
+	tests := []struct {
+		subtest string
+		pvc     *v1.PersistentVolumeClaim
+		pv      *v1.PersistentVolume
+		err     bool
+	}{
+		{"own volume", claim("pgdata-acid-test-0", "acid-test"), volume("test", "pgdata-acid-test-0", ""), false},
+		{"own labeled volume", claim("pgdata-acid-test-0", "acid-test"),
+			volume("test", "pgdata-acid-test-0", "acid-test"), false},
+		{"claim of a cluster with a longer name", claim("pgdata-acid-test-2-0", "acid-test-2"),
+			volume("test", "pgdata-acid-test-2-0", ""), true},
+		{"claim labeled with another cluster", claim("pgdata-acid-test-0", "acid-other"),
+			volume("test", "pgdata-acid-test-0", ""), true},
+		{"volume bound to another claim", claim("pgdata-acid-test-0", "acid-test"),
+			volume("test", "pgdata-acid-test-1", ""), true},
+		{"volume bound in another namespace", claim("pgdata-acid-test-0", "acid-test"),
+			volume("other", "pgdata-acid-test-0", ""), true},
+		{"unbound volume", claim("pgdata-acid-test-0", "acid-test"), volume("test", "", ""), true},
+		{"volume labeled with another cluster", claim("pgdata-acid-test-0", "acid-test"),
+			volume("test", "pgdata-acid-test-0", "acid-other"), true},
+	}
+	for _, tt := range tests {
+		if err := cluster.checkVolumeOwnership(tt.pvc, tt.pv); (err != nil) != tt.err {
+			t.Errorf("%s %s: expected error %t, got %v", testName, tt.subtest, tt.err, err)
+		}
+	}
+}
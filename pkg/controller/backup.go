@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/cluster"
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+)
+
+func (c *Controller) runBackupInformer(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	c.backupInformer.Run(stopCh)
+}
+
+func (c *Controller) backupListFunc(options metav1.ListOptions) (runtime.Object, error) {
+	var list spec.PostgresBackupList
+
+	if err := c.listCRDObjects(constants.BackupCRDResource, c.opConfig.WatchedNamespace, options, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+func (c *Controller) backupWatchFunc(options metav1.ListOptions) (watch.Interface, error) {
+	return c.watchCRDObjects(constants.BackupCRDResource, c.opConfig.WatchedNamespace, options, func() runtime.Object {
+		return &spec.PostgresBackup{}
+	})
+}
+
+// listCRDObjects reads the objects of one of the operator's resources other than postgresqls in the namespace into
+// the list.
+func (c *Controller) listCRDObjects(resource, namespace string, options metav1.ListOptions, list runtime.Object) error {
+	b, err := c.KubeClient.CRDREST.
+		Get().
+		Namespace(namespace).
+		Resource(resource).
+		VersionedParams(&options, metav1.ParameterCodec).
+		DoRaw()
+	if err != nil {
+		return fmt.Errorf("could not get the list of %s CRD objects: %v", resource, err)
+	}
+	if err = json.Unmarshal(b, list); err != nil {
+		return fmt.Errorf("could not unmarshal list of %s: %v", resource, err)
+	}
+
+	return nil
+}
+
+func (c *Controller) watchCRDObjects(resource, namespace string, options metav1.ListOptions,
+	newObject func() runtime.Object) (watch.Interface, error) {
+	options.Watch = true
+	r, err := c.KubeClient.CRDREST.
+		Get().
+		Namespace(namespace).
+		Resource(resource).
+		VersionedParams(&options, metav1.ParameterCodec).
+		FieldsSelectorParam(nil).
+		Stream()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.NewStreamWatcher(&crdObjectDecoder{
+		dec:       json.NewDecoder(r),
+		close:     r.Close,
+		newObject: newObject,
+	}), nil
+}
+
+// crdObjectDecoder is the crdDecoder for the resources that don't need the special handling of postgresqls.
+type crdObjectDecoder struct {
+	dec       *json.Decoder
+	close     func() error
+	newObject func() runtime.Object
+}
+
+func (d *crdObjectDecoder) Close() {
+	d.close()
+}
+
+func (d *crdObjectDecoder) Decode() (action watch.EventType, object runtime.Object, err error) {
+	e := struct {
+		Type   watch.EventType
+		Object runtime.Object
+	}{Object: d.newObject()}
+	if err := d.dec.Decode(&e); err != nil {
+		return watch.Error, nil, err
+	}
+
+	return e.Type, e.Object, nil
+}
+
+// postgresBackupAdd starts the backups the operator has not picked up yet. A backup found running is left over
+// from the previous operator instance, which can't report its result anymore.
+func (c *Controller) postgresBackupAdd(obj interface{}) {
+	backup, ok := obj.(*spec.PostgresBackup)
+	if !ok {
+		c.logger.Errorf("could not cast to postgresbackup spec")
+		return
+	}
+
+	switch backup.Status.Phase {
+	case spec.BackupPhaseNew:
+		go c.runBackup(backup.Clone())
+	case spec.BackupPhaseRunning:
+		c.setBackupStatus(backup, spec.PostgresBackupStatus{
+			Phase:          spec.BackupPhaseFailed,
+			Message:        "interrupted by the operator restart",
+			StartTime:      backup.Status.StartTime,
+			CompletionTime: backupTime(),
+		})
+	}
+}
+
+// runBackup takes the backup and records the phases in the status of the backup object.
+func (c *Controller) runBackup(backup *spec.PostgresBackup) {
+	lg := c.logger.WithField("backup", util.NameFromMeta(backup.ObjectMeta))
+
+	status := spec.PostgresBackupStatus{Phase: spec.BackupPhaseRunning, StartTime: backupTime()}
+	cl, err := c.backupCluster(backup)
+	var pg *spec.Postgresql
+	if err == nil {
+		// the cluster may be synced while the backup runs, so its spec is only read through the locked accessors
+		pg, err = cl.GetSpec()
+	}
+	if err == nil {
+		status.ClusterUID = string(pg.GetUID())
+		status.S3Bucket, status.S3Prefix = cl.WALLocation()
+		c.setBackupStatus(backup, status)
+		lg.Infof("backup of the cluster %q started", backup.Spec.ClusterName)
+		err = cl.TriggerBackup()
+	}
+
+	status.CompletionTime = backupTime()
+	if err != nil {
+		status.Phase, status.Message = spec.BackupPhaseFailed, err.Error()
+		lg.Errorf("backup failed: %v", err)
+	} else {
+		status.Phase = spec.BackupPhaseCompleted
+		lg.Infof("backup has been completed")
+	}
+	c.setBackupStatus(backup, status)
+}
+
+func (c *Controller) backupCluster(backup *spec.PostgresBackup) (*cluster.Cluster, error) {
+	if err := backup.Validate(); err != nil {
+		return nil, err
+	}
+
+	clusterName := spec.NamespacedName{Namespace: backup.Namespace, Name: backup.Spec.ClusterName}
+	c.clustersMu.RLock()
+	cl, ok := c.clusters[clusterName]
+	c.clustersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("could not find cluster %q", clusterName)
+	}
+
+	return cl, nil
+}
+
+func (c *Controller) setBackupStatus(backup *spec.PostgresBackup, status spec.PostgresBackupStatus) {
+	b, err := json.Marshal(status)
+	if err != nil {
+		c.logger.Errorf("could not marshal backup status: %v", err)
+		return
+	}
+	request := []byte(fmt.Sprintf(`{"status": %s}`, string(b)))
+
+	_, err = c.KubeClient.CRDREST.Patch(types.MergePatchType).
+		Namespace(backup.Namespace).
+		Resource(constants.BackupCRDResource).
+		Name(backup.Name).
+		Body(request).
+		DoRaw()
+	if err != nil {
+		c.logger.Warningf("could not set %q status for the backup %q: %v",
+			status.Phase, util.NameFromMeta(backup.ObjectMeta), err)
+	}
+}
+
+func backupTime() *metav1.Time {
+	t := metav1.Now()
+	return &t
+}
@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/cluster"
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+func TestBackupCluster(t *testing.T) {
+	c := NewController(&spec.ControllerConfig{})
+	cl := &cluster.Cluster{}
+	c.clusters[spec.NamespacedName{Namespace: "default", Name: "acid-test"}] = cl
+
+	tests := []struct {
+		name        string
+		namespace   string
+		clusterName string
+		err         string
+	}{
+		{"cluster in the namespace of the backup", "default", "acid-test", ""},
+		{"missing cluster name", "default", "", "clusterName of the backup must not be empty"},
+		{"cluster in another namespace", "test", "acid-test", `could not find cluster "test/acid-test"`},
+	}
+	for _, tt := range tests {
+		backup := &spec.PostgresBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: tt.namespace},
+			Spec:       spec.PostgresBackupSpec{ClusterName: tt.clusterName},
+		}
+		result, err := c.backupCluster(backup)
+		if tt.err == "" {
+			if err != nil || result != cl {
+				t.Errorf("%s: expected the cluster, got %v, error: %v", tt.name, result, err)
+			}
+		} else if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/ringlog"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/tracing"
 )
 
 // Controller represents operator controller
@@ -30,6 +31,7 @@ type Controller struct {
 	logger     *logrus.Entry
 	KubeClient k8sutil.KubernetesClient
 	apiserver  *apiserver.Server
+	tracer     *tracing.Tracer
 
 	stopCh chan struct{}
 
@@ -43,10 +45,17 @@ type Controller struct {
 	teamClusters     map[string][]spec.NamespacedName
 
 	postgresqlInformer cache.SharedIndexInformer
+	backupInformer     cache.SharedIndexInformer
+	restoreInformer    cache.SharedIndexInformer
 	podInformer        cache.SharedIndexInformer
 	nodesInformer      cache.SharedIndexInformer
 	podCh              chan spec.PodEvent
 
+	// the roles of the infrastructure role objects, the ones of the secret are kept in the controller config
+	infrastructureRoleInformer cache.SharedIndexInformer
+	infrastructureRolesMu      sync.RWMutex
+	crdInfrastructureRoles     map[string]spec.PgUser
+
 	clusterEventQueues  []*cache.FIFO // [workerID]Queue
 	lastClusterSyncTime int64
 
@@ -174,7 +183,17 @@ func (c *Controller) initController() {
 		c.logger.Logger.Level = logrus.DebugLevel
 	}
 
-	if err := c.createCRD(); err != nil {
+	if err := c.createCRD(constants.CRDResource, constants.CRDKind, constants.CRDShort); err != nil {
+		c.logger.Fatalf("could not register CustomResourceDefinition: %v", err)
+	}
+	if err := c.createCRD(constants.BackupCRDResource, constants.BackupCRDKind, constants.BackupCRDShort); err != nil {
+		c.logger.Fatalf("could not register CustomResourceDefinition: %v", err)
+	}
+	if err := c.createCRD(constants.RestoreCRDResource, constants.RestoreCRDKind, constants.RestoreCRDShort); err != nil {
+		c.logger.Fatalf("could not register CustomResourceDefinition: %v", err)
+	}
+	if err := c.createCRD(constants.InfrastructureRoleCRDResource, constants.InfrastructureRoleCRDKind,
+		constants.InfrastructureRoleCRDShort); err != nil {
 		c.logger.Fatalf("could not register CustomResourceDefinition: %v", err)
 	}
 
@@ -197,6 +216,10 @@ func (c *Controller) initController() {
 		})
 	}
 
+	if c.opConfig.OTLPTracesEndpoint != "" {
+		c.tracer = tracing.NewTracer(tracing.NewOTLPExporter(c.opConfig.OTLPTracesEndpoint, c.logger))
+	}
+
 	c.apiserver = apiserver.New(c, c.opConfig.APIPort, c.logger.Logger)
 }
 
@@ -217,6 +240,50 @@ func (c *Controller) initSharedInformers() {
 		DeleteFunc: c.postgresqlDelete,
 	})
 
+	// On-demand backups, only the new ones are of interest
+	c.backupInformer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc:  c.backupListFunc,
+			WatchFunc: c.backupWatchFunc,
+		},
+		&spec.PostgresBackup{},
+		constants.QueueResyncPeriodTPR,
+		cache.Indexers{})
+
+	c.backupInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.postgresBackupAdd,
+	})
+
+	// Restores, only the new ones are of interest
+	c.restoreInformer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc:  c.restoreListFunc,
+			WatchFunc: c.restoreWatchFunc,
+		},
+		&spec.PostgresRestore{},
+		constants.QueueResyncPeriodTPR,
+		cache.Indexers{})
+
+	c.restoreInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.postgresRestoreAdd,
+	})
+
+	// Infrastructure roles, every change is applied to all the clusters
+	c.infrastructureRoleInformer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc:  c.infrastructureRoleListFunc,
+			WatchFunc: c.infrastructureRoleWatchFunc,
+		},
+		&spec.InfrastructureRole{},
+		constants.QueueResyncPeriodTPR,
+		cache.Indexers{})
+
+	c.infrastructureRoleInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.infrastructureRoleChanged,
+		UpdateFunc: c.infrastructureRoleUpdate,
+		DeleteFunc: c.infrastructureRoleChanged,
+	})
+
 	// Pods
 	podLw := &cache.ListWatch{
 		ListFunc:  c.podListFunc,
@@ -258,9 +325,12 @@ func (c *Controller) initSharedInformers() {
 func (c *Controller) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 	c.initController()
 
-	wg.Add(5)
+	wg.Add(8)
 	go c.runPodInformer(stopCh, wg)
 	go c.runPostgresqlInformer(stopCh, wg)
+	go c.runBackupInformer(stopCh, wg)
+	go c.runRestoreInformer(stopCh, wg)
+	go c.runInfrastructureRoleInformer(stopCh, wg)
 	go c.clusterResync(stopCh, wg)
 	go c.apiserver.Run(stopCh, wg)
 	go c.kubeNodesInformer(stopCh, wg)
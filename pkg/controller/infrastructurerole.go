@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/cluster"
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+)
+
+func (c *Controller) runInfrastructureRoleInformer(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	c.infrastructureRoleInformer.Run(stopCh)
+}
+
+func (c *Controller) infrastructureRoleListFunc(options metav1.ListOptions) (runtime.Object, error) {
+	var list spec.InfrastructureRoleList
+
+	if err := c.listCRDObjects(constants.InfrastructureRoleCRDResource, spec.GetOperatorNamespace(), options,
+		&list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+func (c *Controller) infrastructureRoleWatchFunc(options metav1.ListOptions) (watch.Interface, error) {
+	// only the objects in the namespace of the operator are trusted, the roles are created on every cluster
+	return c.watchCRDObjects(constants.InfrastructureRoleCRDResource, spec.GetOperatorNamespace(), options,
+		func() runtime.Object {
+			return &spec.InfrastructureRole{}
+		})
+}
+
+// infrastructureRoleChanged is the handler of all the events of the infrastructure roles, the roles are read anew
+// from the informer on every one of them.
+func (c *Controller) infrastructureRoleChanged(obj interface{}) {
+	c.syncInfrastructureRoles()
+}
+
+func (c *Controller) infrastructureRoleUpdate(prev, cur interface{}) {
+	c.syncInfrastructureRoles()
+}
+
+// syncInfrastructureRoles reads the roles of the infrastructure role objects and, when they differ from the ones
+// known so far, hands them to the clusters and syncs those, so that the roles are created or altered right away.
+func (c *Controller) syncInfrastructureRoles() {
+	var objects []*spec.InfrastructureRole
+	for _, obj := range c.infrastructureRoleInformer.GetStore().List() {
+		role, ok := obj.(*spec.InfrastructureRole)
+		if !ok {
+			c.logger.Errorf("could not cast to infrastructurerole spec")
+			continue
+		}
+		objects = append(objects, role)
+	}
+	// the first object of a role name wins, in the order of the namespaced names
+	sort.Slice(objects, func(i, j int) bool {
+		return util.NameFromMeta(objects[i].ObjectMeta).String() < util.NameFromMeta(objects[j].ObjectMeta).String()
+	})
+
+	roles := make(map[string]spec.PgUser)
+	for _, role := range objects {
+		user, err := c.readInfrastructureRole(role)
+		if err != nil {
+			c.logger.Warningf("infrastructure role %q is ignored: %v", util.NameFromMeta(role.ObjectMeta), err)
+			continue
+		}
+		if _, ok := roles[user.Name]; ok {
+			c.logger.Warningf("infrastructure role %q is declared more than once, the object %q is ignored",
+				user.Name, util.NameFromMeta(role.ObjectMeta))
+			continue
+		}
+		roles[user.Name] = *user
+	}
+
+	c.infrastructureRolesMu.Lock()
+	changed := !reflect.DeepEqual(roles, c.crdInfrastructureRoles)
+	c.crdInfrastructureRoles = roles
+	c.infrastructureRolesMu.Unlock()
+	if !changed {
+		return
+	}
+
+	c.logger.Infof("infrastructure roles have changed, syncing the clusters")
+	c.clustersMu.RLock()
+	for _, cl := range c.clusters {
+		cl.SetInfrastructureRoles(c.infrastructureRoles())
+	}
+	c.clustersMu.RUnlock()
+	for _, obj := range c.postgresqlInformer.GetStore().List() {
+		pg, ok := obj.(*spec.Postgresql)
+		if !ok || pg.Error != nil {
+			continue
+		}
+		c.queueClusterEvent(nil, pg, spec.EventSync)
+	}
+}
+
+// readInfrastructureRole turns the object into the role to create, with the password from its secret.
+func (c *Controller) readInfrastructureRole(role *spec.InfrastructureRole) (*spec.PgUser, error) {
+	if err := role.Validate(); err != nil {
+		return nil, err
+	}
+	secret, err := c.KubeClient.Secrets(role.Namespace).Get(role.Spec.PasswordSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get secret %q: %v", role.Spec.PasswordSecret.Name, err)
+	}
+
+	user, err := infrastructureRoleUser(role, secret.Data)
+	if err != nil {
+		return nil, err
+	}
+	if err := cluster.CheckDeclaredInfrastructureRole(user, c.opConfig); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func infrastructureRoleUser(role *spec.InfrastructureRole, secretData map[string][]byte) (*spec.PgUser, error) {
+	key := role.Spec.PasswordSecret.PasswordKey
+	if key == "" {
+		key = "password"
+	}
+	password := string(secretData[key])
+	if password == "" {
+		return nil, fmt.Errorf("secret %q has no %q key", role.Spec.PasswordSecret.Name, key)
+	}
+
+	return &spec.PgUser{
+		Origin:     spec.RoleOriginInfrastructure,
+		Name:       role.RoleName(),
+		Password:   password,
+		Flags:      role.Spec.Flags,
+		MemberOf:   role.Spec.InRoles,
+		AdminOf:    role.Spec.AdminInRoles,
+		Parameters: role.Spec.Parameters,
+	}, nil
+}
+
+// infrastructureRoles returns the roles of the infrastructure roles secret together with the ones of the
+// infrastructure role objects, the roles of the secret take precedence.
+func (c *Controller) infrastructureRoles() map[string]spec.PgUser {
+	c.infrastructureRolesMu.RLock()
+	defer c.infrastructureRolesMu.RUnlock()
+
+	result := make(map[string]spec.PgUser)
+	for name, role := range c.crdInfrastructureRoles {
+		result[name] = role
+	}
+	for name, role := range c.config.InfrastructureRoles {
+		if _, ok := result[name]; ok {
+			c.logger.Warningf("infrastructure role %q of the object is ignored, the secret defines it", name)
+		}
+		result[name] = role
+	}
+
+	return result
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+func TestInfrastructureRoleUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       spec.InfrastructureRoleSpec
+		secretData map[string][]byte
+		result     *spec.PgUser
+		err        string
+	}{
+		{
+			name: "role named after the object",
+			spec: spec.InfrastructureRoleSpec{PasswordSecret: spec.UserSecret{Name: "monitoring"},
+				Flags: []string{"LOGIN"}, InRoles: []string{"pg_monitor"}},
+			secretData: map[string][]byte{"password": []byte("secret")},
+			result: &spec.PgUser{Origin: spec.RoleOriginInfrastructure, Name: "monitoring", Password: "secret",
+				Flags: []string{"LOGIN"}, MemberOf: []string{"pg_monitor"}},
+		},
+		{
+			name: "role name and password key",
+			spec: spec.InfrastructureRoleSpec{RoleName: "robot_zmon",
+				PasswordSecret: spec.UserSecret{Name: "monitoring", PasswordKey: "zmon"}},
+			secretData: map[string][]byte{"zmon": []byte("secret")},
+			result:     &spec.PgUser{Origin: spec.RoleOriginInfrastructure, Name: "robot_zmon", Password: "secret"},
+		},
+		{
+			name:       "missing password",
+			spec:       spec.InfrastructureRoleSpec{PasswordSecret: spec.UserSecret{Name: "monitoring"}},
+			secretData: map[string][]byte{"zmon": []byte("secret")},
+			err:        `secret "monitoring" has no "password" key`,
+		},
+	}
+	for _, tt := range tests {
+		role := &spec.InfrastructureRole{ObjectMeta: metav1.ObjectMeta{Name: "monitoring", Namespace: "default"},
+			Spec: tt.spec}
+		result, err := infrastructureRoleUser(role, tt.secretData)
+		if tt.err == "" {
+			if err != nil || !reflect.DeepEqual(result, tt.result) {
+				t.Errorf("%s: expected %#v, got %#v, error: %v", tt.name, tt.result, result, err)
+			}
+		} else if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestInfrastructureRoles(t *testing.T) {
+	c := NewController(&spec.ControllerConfig{})
+	c.config.InfrastructureRoles = map[string]spec.PgUser{
+		"robot_zmon": {Origin: spec.RoleOriginInfrastructure, Name: "robot_zmon", Password: "from secret"},
+	}
+	c.crdInfrastructureRoles = map[string]spec.PgUser{
+		"robot_zmon": {Origin: spec.RoleOriginInfrastructure, Name: "robot_zmon", Password: "from object"},
+		"backup":     {Origin: spec.RoleOriginInfrastructure, Name: "backup", Password: "backup"},
+	}
+
+	expected := map[string]spec.PgUser{
+		"robot_zmon": {Origin: spec.RoleOriginInfrastructure, Name: "robot_zmon", Password: "from secret"},
+		"backup":     {Origin: spec.RoleOriginInfrastructure, Name: "backup", Password: "backup"},
+	}
+	if result := c.infrastructureRoles(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, got %#v", expected, result)
+	}
+}
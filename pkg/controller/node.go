@@ -99,7 +99,18 @@ func (c *Controller) moveMasterPodsOffNode(node *v1.Node) {
 	for _, pod := range nodePods {
 		podName := util.NameFromMeta(pod.ObjectMeta)
 
-		role, ok := pod.Labels[c.opConfig.PodRoleLabel]
+		clusterName := c.podClusterName(pod)
+
+		c.clustersMu.RLock()
+		cl, known := c.clusters[clusterName]
+		c.clustersMu.RUnlock()
+
+		// the pods of a cluster may carry one of the fallback role labels instead of the configured one
+		roleLabel := c.opConfig.PodRoleLabel
+		if known {
+			roleLabel = cl.RoleLabel()
+		}
+		role, ok := pod.Labels[roleLabel]
 		if !ok || cluster.PostgresRole(role) != cluster.Master {
 			if !ok {
 				c.logger.Warningf("could not move pod %q: pod has no role", podName)
@@ -107,12 +118,7 @@ func (c *Controller) moveMasterPodsOffNode(node *v1.Node) {
 			continue
 		}
 
-		clusterName := c.podClusterName(pod)
-
-		c.clustersMu.RLock()
-		cl, ok := c.clusters[clusterName]
-		c.clustersMu.RUnlock()
-		if !ok {
+		if !known {
 			c.logger.Warningf("could not move pod %q: pod does not belong to a known cluster", podName)
 			continue
 		}
@@ -234,7 +234,15 @@ func (c *Controller) processEvent(event spec.ClusterEvent) {
 		teamName := strings.ToLower(cl.Spec.TeamID)
 
 		c.curWorkerCluster.Store(event.WorkerID, cl)
-		cl.Delete()
+		if err := cl.Delete(); err != nil {
+			// the cluster keeps its resources, so it stays known to the operator until a later attempt succeeds
+			cl.Error = fmt.Errorf("could not delete cluster: %v", err)
+			lg.Errorf("%v, retrying in %v", cl.Error, c.opConfig.ResyncPeriod)
+			time.AfterFunc(c.opConfig.ResyncPeriod, func() {
+				c.queueClusterEvent(event.OldSpec, nil, spec.EventDelete)
+			})
+			return
+		}
 
 		func() {
 			defer c.clustersMu.Unlock()
@@ -442,12 +450,27 @@ func (c *Controller) postgresqlUpdate(prev, cur interface{}) {
 		c.logger.Errorf("could not cast to postgresql spec")
 	}
 	if reflect.DeepEqual(pgOld.Spec, pgNew.Spec) {
+		// the annotations requesting an action are handled by the sync, which should not wait for the resync period
+		if actionAnnotationsChanged(pgOld, pgNew) {
+			c.queueClusterEvent(nil, pgNew, spec.EventSync)
+		}
 		return
 	}
 
 	c.queueClusterEvent(pgOld, pgNew, spec.EventUpdate)
 }
 
+// actionAnnotationsChanged tells whether any of the annotations of the manifest asking the operator for an action
+// has been set, changed or removed.
+func actionAnnotationsChanged(pgOld, pgNew *spec.Postgresql) bool {
+	for _, annotation := range []string{constants.RotateSystemPasswordsAnnotation, constants.ForceVolumeShrinkAnnotation} {
+		if pgOld.Annotations[annotation] != pgNew.Annotations[annotation] {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Controller) postgresqlDelete(obj interface{}) {
 	pg, ok := obj.(*spec.Postgresql)
 	if !ok {
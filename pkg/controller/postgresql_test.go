@@ -2,6 +2,8 @@ package controller
 
 import (
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"reflect"
 	"testing"
 )
@@ -41,3 +43,27 @@ func TestMergeDeprecatedPostgreSQLSpecParameters(t *testing.T) {
 		}
 	}
 }
+
+func TestActionAnnotationsChanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     map[string]string
+		new     map[string]string
+		changed bool
+	}{
+		{"no annotations", nil, nil, false},
+		{"other annotation changed", nil, map[string]string{"team": "acid"}, false},
+		{"rotation requested", nil, map[string]string{constants.RotateSystemPasswordsAnnotation: "2018-10-15"}, true},
+		{"rotation requested again",
+			map[string]string{constants.RotateSystemPasswordsAnnotation: "2018-10-15"},
+			map[string]string{constants.RotateSystemPasswordsAnnotation: "2018-10-16"}, true},
+		{"shrink forced", nil, map[string]string{constants.ForceVolumeShrinkAnnotation: "true"}, true},
+	}
+	for _, tt := range tests {
+		pgOld := &spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Annotations: tt.old}}
+		pgNew := &spec.Postgresql{ObjectMeta: metav1.ObjectMeta{Annotations: tt.new}}
+		if changed := actionAnnotationsChanged(pgOld, pgNew); changed != tt.changed {
+			t.Errorf("%s: expected %t, got %t", tt.name, tt.changed, changed)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+)
+
+func (c *Controller) runRestoreInformer(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	c.restoreInformer.Run(stopCh)
+}
+
+func (c *Controller) restoreListFunc(options metav1.ListOptions) (runtime.Object, error) {
+	var list spec.PostgresRestoreList
+
+	if err := c.listCRDObjects(constants.RestoreCRDResource, c.opConfig.WatchedNamespace, options, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+func (c *Controller) restoreWatchFunc(options metav1.ListOptions) (watch.Interface, error) {
+	return c.watchCRDObjects(constants.RestoreCRDResource, c.opConfig.WatchedNamespace, options, func() runtime.Object {
+		return &spec.PostgresRestore{}
+	})
+}
+
+// postgresRestoreAdd creates the cluster of a restore the operator has not processed yet. From then on, the
+// cluster is handled like any other one and the restore object can be removed.
+func (c *Controller) postgresRestoreAdd(obj interface{}) {
+	restore, ok := obj.(*spec.PostgresRestore)
+	if !ok {
+		c.logger.Errorf("could not cast to postgresrestore spec")
+		return
+	}
+	if restore.Status.Phase != spec.RestorePhaseNew {
+		return
+	}
+
+	lg := c.logger.WithField("restore", util.NameFromMeta(restore.ObjectMeta))
+	status := spec.PostgresRestoreStatus{Phase: spec.RestorePhaseCreated}
+	if err := c.createRestoredCluster(restore.Clone()); err != nil {
+		status = spec.PostgresRestoreStatus{Phase: spec.RestorePhaseFailed, Message: err.Error()}
+		lg.Errorf("could not restore cluster %q: %v", restore.Spec.ClusterName, err)
+	} else {
+		lg.Infof("cluster %q has been created from the restore", restore.Spec.ClusterName)
+	}
+	c.setRestoreStatus(restore, status)
+}
+
+func (c *Controller) createRestoredCluster(restore *spec.PostgresRestore) error {
+	if err := restore.Validate(); err != nil {
+		return err
+	}
+
+	var backup *spec.PostgresBackup
+	if restore.Spec.BackupName != "" {
+		var err error
+		if backup, err = c.getBackup(restore.Namespace, restore.Spec.BackupName); err != nil {
+			return err
+		}
+	}
+
+	pg, err := restoredCluster(restore, backup, time.Now())
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(pg)
+	if err != nil {
+		return fmt.Errorf("could not marshal cluster manifest: %v", err)
+	}
+
+	_, err = c.KubeClient.CRDREST.Post().
+		Namespace(pg.Namespace).
+		Resource(constants.CRDResource).
+		Body(body).
+		DoRaw()
+	if err != nil {
+		return fmt.Errorf("could not create cluster manifest: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Controller) getBackup(namespace, name string) (*spec.PostgresBackup, error) {
+	var backup spec.PostgresBackup
+
+	b, err := c.KubeClient.CRDREST.
+		Get().
+		Namespace(namespace).
+		Resource(constants.BackupCRDResource).
+		Name(name).
+		DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("could not get backup %q: %v", name, err)
+	}
+	if err = json.Unmarshal(b, &backup); err != nil {
+		return nil, fmt.Errorf("could not unmarshal backup %q: %v", name, err)
+	}
+
+	return &backup, nil
+}
+
+// restoredCluster returns the manifest of the cluster cloned from the WAL archive the backup or the source point to.
+func restoredCluster(restore *spec.PostgresRestore, backup *spec.PostgresBackup, now time.Time) (*spec.Postgresql, error) {
+	if backup != nil && (backup.Status.Phase != spec.BackupPhaseCompleted || backup.Status.ClusterUID == "") {
+		return nil, fmt.Errorf("backup %q has not been completed", backup.Name)
+	}
+
+	pg := &spec.Postgresql{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       constants.CRDKind,
+			APIVersion: constants.CRDGroup + "/" + constants.CRDApiVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restore.Spec.ClusterName,
+			Namespace: restore.Namespace,
+		},
+		Spec: restore.Spec.Cluster,
+	}
+	pg.Spec.Clone = restore.CloneDescription(backup, now)
+
+	return pg, nil
+}
+
+func (c *Controller) setRestoreStatus(restore *spec.PostgresRestore, status spec.PostgresRestoreStatus) {
+	b, err := json.Marshal(status)
+	if err != nil {
+		c.logger.Errorf("could not marshal restore status: %v", err)
+		return
+	}
+	request := []byte(fmt.Sprintf(`{"status": %s}`, string(b)))
+
+	_, err = c.KubeClient.CRDREST.Patch(types.MergePatchType).
+		Namespace(restore.Namespace).
+		Resource(constants.RestoreCRDResource).
+		Name(restore.Name).
+		Body(request).
+		DoRaw()
+	if err != nil {
+		c.logger.Warningf("could not set %q status for the restore %q: %v",
+			status.Phase, util.NameFromMeta(restore.ObjectMeta), err)
+	}
+}
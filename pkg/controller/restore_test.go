@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+)
+
+func TestRestoredCluster(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	completed := metav1.NewTime(time.Date(2018, 5, 1, 10, 30, 0, 0, time.UTC))
+	backup := &spec.PostgresBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default"},
+		Spec:       spec.PostgresBackupSpec{ClusterName: "acid-batman"},
+		Status: spec.PostgresBackupStatus{
+			Phase:          spec.BackupPhaseCompleted,
+			CompletionTime: &completed,
+			ClusterUID:     "efd12e58-5786-11e8-b5a7-06148230260c",
+			S3Bucket:       "team-bucket",
+		},
+	}
+	running := backup.Clone()
+	running.Status.Phase = spec.BackupPhaseRunning
+
+	tests := []struct {
+		name    string
+		restore spec.PostgresRestoreSpec
+		backup  *spec.PostgresBackup
+		clone   spec.CloneDescription
+		err     string
+	}{
+		{
+			name:    "backup restored as of its completion",
+			restore: spec.PostgresRestoreSpec{BackupName: "nightly"},
+			backup:  backup,
+			clone: spec.CloneDescription{ClusterName: "acid-batman", Uid: "efd12e58-5786-11e8-b5a7-06148230260c",
+				EndTimestamp: "2018-05-01T10:30:00Z", S3Bucket: "team-bucket"},
+		},
+		{
+			name:    "backup restored to the requested time",
+			restore: spec.PostgresRestoreSpec{BackupName: "nightly", Timestamp: "2018-05-01T11:00:00Z"},
+			backup:  backup,
+			clone: spec.CloneDescription{ClusterName: "acid-batman", Uid: "efd12e58-5786-11e8-b5a7-06148230260c",
+				EndTimestamp: "2018-05-01T11:00:00Z", S3Bucket: "team-bucket"},
+		},
+		{
+			name: "WAL archive restored to the latest state",
+			restore: spec.PostgresRestoreSpec{Source: &spec.RestoreSource{ClusterName: "acid-robin", UID: "uid",
+				S3Prefix: "team/"}},
+			clone: spec.CloneDescription{ClusterName: "acid-robin", Uid: "uid", EndTimestamp: "2018-05-01T12:00:00Z",
+				S3Prefix: "team/"},
+		},
+		{
+			name:    "backup in progress",
+			restore: spec.PostgresRestoreSpec{BackupName: "nightly"},
+			backup:  running,
+			err:     `backup "nightly" has not been completed`,
+		},
+	}
+	for _, tt := range tests {
+		tt.restore.ClusterName = "acid-restored"
+		tt.restore.Cluster = spec.PostgresSpec{TeamID: "acid", NumberOfInstances: 2}
+		restore := &spec.PostgresRestore{
+			ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "default"},
+			Spec:       tt.restore,
+		}
+
+		pg, err := restoredCluster(restore, tt.backup, now)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if pg.Name != "acid-restored" || pg.Namespace != "default" || pg.Spec.NumberOfInstances != 2 {
+			t.Errorf("%s: unexpected cluster %s/%s with %d instances", tt.name, pg.Namespace, pg.Name,
+				pg.Spec.NumberOfInstances)
+		}
+		if !reflect.DeepEqual(pg.Spec.Clone, tt.clone) {
+			t.Errorf("%s: expected clone description %#v, got %#v", tt.name, tt.clone, pg.Spec.Clone)
+		}
+	}
+}
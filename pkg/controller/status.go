@@ -235,3 +235,16 @@ func (c *Controller) ClusterHistory(team, namespace, name string) ([]*spec.Diff,
 
 	return res, nil
 }
+
+// ClusterMetrics returns the metrics of every cluster collected during its last sync
+func (c *Controller) ClusterMetrics() map[spec.NamespacedName]spec.ClusterMetrics {
+	m := make(map[spec.NamespacedName]spec.ClusterMetrics)
+
+	c.clustersMu.RLock()
+	defer c.clustersMu.RUnlock()
+	for name, cluster := range c.clusters {
+		m[name] = cluster.GetMetrics()
+	}
+
+	return m
+}
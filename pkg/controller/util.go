@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
 
 	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
@@ -17,16 +18,12 @@ import (
 )
 
 func (c *Controller) makeClusterConfig() cluster.Config {
-	infrastructureRoles := make(map[string]spec.PgUser)
-	for k, v := range c.config.InfrastructureRoles {
-		infrastructureRoles[k] = v
-	}
-
 	return cluster.Config{
 		RestConfig:          c.config.RestConfig,
 		OpConfig:            config.Copy(c.opConfig),
-		InfrastructureRoles: infrastructureRoles,
+		InfrastructureRoles: c.infrastructureRoles(),
 		PodServiceAccount:   c.PodServiceAccount,
+		Tracer:              c.tracer,
 	}
 }
 
@@ -47,20 +44,21 @@ func (c *Controller) clusterWorkerID(clusterName spec.NamespacedName) uint32 {
 	return c.clusterWorkers[clusterName]
 }
 
-func (c *Controller) createCRD() error {
+// createCRD registers a resource of the operator's API group and waits until it can be used.
+func (c *Controller) createCRD(plural, kind, shortName string) error {
 	crd := &apiextv1beta1.CustomResourceDefinition{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: constants.CRDResource + "." + constants.CRDGroup,
+			Name: plural + "." + constants.CRDGroup,
 		},
 		Spec: apiextv1beta1.CustomResourceDefinitionSpec{
 			Group:   constants.CRDGroup,
 			Version: constants.CRDApiVersion,
 			Names: apiextv1beta1.CustomResourceDefinitionNames{
-				Plural:     constants.CRDResource,
-				Singular:   constants.CRDKind,
-				ShortNames: []string{constants.CRDShort},
-				Kind:       constants.CRDKind,
-				ListKind:   constants.CRDKind + "List",
+				Plural:     plural,
+				Singular:   kind,
+				ShortNames: []string{shortName},
+				Kind:       kind,
+				ListKind:   kind + "List",
 			},
 			Scope: apiextv1beta1.NamespaceScoped,
 		},
@@ -106,6 +104,41 @@ func readDecodedRole(s string) (*spec.PgUser, error) {
 	return &result, nil
 }
 
+// infrastructureRoleJSON is the description of a single role in the JSON infrastructure roles secret format.
+type infrastructureRoleJSON struct {
+	Password   string            `json:"password"`
+	Flags      []string          `json:"user_flags"`
+	MemberOf   []string          `json:"inrole"`
+	Parameters map[string]string `json:"db_parameters"`
+	AdminOf    []string          `json:"admin_inrole"`
+}
+
+// readInfrastructureRolesJSON parses the secret data where every key is a role name
+// and the value is a JSON description of that role, including its password.
+func readInfrastructureRolesJSON(secretData map[string][]byte) (map[string]spec.PgUser, error) {
+	result := make(map[string]spec.PgUser)
+	for role, data := range secretData {
+		var roleDescr infrastructureRoleJSON
+		if err := json.Unmarshal(data, &roleDescr); err != nil {
+			return nil, fmt.Errorf("could not decode role description for the key %q: %v", role, err)
+		}
+		if roleDescr.Password == "" {
+			return nil, fmt.Errorf("role description for the key %q has no password", role)
+		}
+		result[role] = spec.PgUser{
+			Origin:     spec.RoleOriginInfrastructure,
+			Name:       role,
+			Password:   roleDescr.Password,
+			Flags:      roleDescr.Flags,
+			MemberOf:   roleDescr.MemberOf,
+			Parameters: roleDescr.Parameters,
+			AdminOf:    roleDescr.AdminOf,
+		}
+	}
+
+	return result, nil
+}
+
 func (c *Controller) getInfrastructureRoles(rolesSecret *spec.NamespacedName) (result map[string]spec.PgUser, err error) {
 	if *rolesSecret == (spec.NamespacedName{}) {
 		// we don't have infrastructure roles defined, bail out
@@ -120,6 +153,10 @@ func (c *Controller) getInfrastructureRoles(rolesSecret *spec.NamespacedName) (r
 		return nil, fmt.Errorf("could not get infrastructure roles secret: %v", err)
 	}
 
+	if c.opConfig.InfrastructureRolesFormat == config.InfrastructureRolesSecretFormatJSON {
+		return readInfrastructureRolesJSON(infraRolesSecret.Data)
+	}
+
 	secretData := infraRolesSecret.Data
 	result = make(map[string]spec.PgUser)
 Users:
@@ -164,7 +201,7 @@ Users:
 		// we have a configmap with username - json description, let's read and decode it
 		for role, s := range infraRolesMap.Data {
 			if roleDescr, err := readDecodedRole(s); err != nil {
-				return nil, fmt.Errorf("could not decode role description: %v", err)
+				return nil, fmt.Errorf("could not decode role description for the key %q: %v", role, err)
 			} else {
 				// check if we have a a password in a configmap
 				c.logger.Debugf("found role description for role %q: %+v", role, roleDescr)
@@ -3,6 +3,7 @@ package controller
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	b64 "encoding/base64"
@@ -11,11 +12,14 @@ import (
 	"k8s.io/client-go/pkg/api/v1"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
 	"github.com/zalando-incubator/postgres-operator/pkg/util/k8sutil"
 )
 
 const (
-	testInfrastructureRolesSecretName = "infrastructureroles-test"
+	testInfrastructureRolesSecretName          = "infrastructureroles-test"
+	testInfrastructureRolesJSONSecretName      = "infrastructureroles-json-test"
+	testInfrastructureRolesMalformedSecretName = "infrastructureroles-malformed-test"
 )
 
 type mockSecret struct {
@@ -27,7 +31,21 @@ type mockConfigMap struct {
 }
 
 func (c *mockSecret) Get(name string, options metav1.GetOptions) (*v1.Secret, error) {
-	if name != testInfrastructureRolesSecretName {
+	switch name {
+	case testInfrastructureRolesJSONSecretName:
+		secret := &v1.Secret{}
+		secret.Data = map[string][]byte{
+			"jsonrole": []byte(`{"password": "jsonpassword", "inrole": ["testinrole"], "user_flags": ["createdb"]}`),
+		}
+		return secret, nil
+	case testInfrastructureRolesMalformedSecretName:
+		secret := &v1.Secret{}
+		secret.Data = map[string][]byte{
+			"brokenrole": []byte(`{"password": "brokenpassword"`),
+		}
+		return secret, nil
+	case testInfrastructureRolesSecretName:
+	default:
 		return nil, fmt.Errorf("NotFound")
 	}
 	secret := &v1.Secret{}
@@ -43,6 +61,13 @@ func (c *mockSecret) Get(name string, options metav1.GetOptions) (*v1.Secret, er
 }
 
 func (c *mockConfigMap) Get(name string, options metav1.GetOptions) (*v1.ConfigMap, error) {
+	if name == testInfrastructureRolesMalformedSecretName {
+		configmap := &v1.ConfigMap{}
+		configmap.Data = map[string]string{
+			"brokenrole": "inrole: [unterminated",
+		}
+		return configmap, nil
+	}
 	if name != testInfrastructureRolesSecretName {
 		return nil, fmt.Errorf("NotFound")
 	}
@@ -186,3 +211,57 @@ func TestGetInfrastructureRoles(t *testing.T) {
 		}
 	}
 }
+
+func TestGetInfrastructureRolesFormats(t *testing.T) {
+	var testTable = []struct {
+		format        string
+		secretName    string
+		expectedRoles map[string]spec.PgUser
+		expectedError error
+	}{
+		{
+			config.InfrastructureRolesSecretFormatJSON,
+			testInfrastructureRolesJSONSecretName,
+			map[string]spec.PgUser{
+				"jsonrole": {
+					Name:     "jsonrole",
+					Origin:   spec.RoleOriginInfrastructure,
+					Password: "jsonpassword",
+					Flags:    []string{"createdb"},
+					MemberOf: []string{"testinrole"},
+				},
+			},
+			nil,
+		},
+		{
+			config.InfrastructureRolesSecretFormatJSON,
+			testInfrastructureRolesMalformedSecretName,
+			nil,
+			fmt.Errorf(`could not decode role description for the key "brokenrole"`),
+		},
+		{
+			config.InfrastructureRolesSecretFormatKeys,
+			testInfrastructureRolesMalformedSecretName,
+			nil,
+			fmt.Errorf(`could not decode role description for the key "brokenrole"`),
+		},
+	}
+	for _, test := range testTable {
+		controller := newMockController()
+		controller.opConfig.InfrastructureRolesFormat = test.format
+		secretName := spec.NamespacedName{Namespace: v1.NamespaceDefault, Name: test.secretName}
+		roles, err := controller.getInfrastructureRoles(&secretName)
+		if test.expectedError != nil {
+			if err == nil || !strings.HasPrefix(err.Error(), test.expectedError.Error()) {
+				t.Errorf("%s format: expected error starting with '%v', got '%v'", test.format, test.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s format: unexpected error: %v", test.format, err)
+		}
+		if !reflect.DeepEqual(roles, test.expectedRoles) {
+			t.Errorf("%s format: expected roles output %v does not match the actual %v", test.format, test.expectedRoles, roles)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package spec
+
+import (
+	"fmt"
+
+	"github.com/mohae/deepcopy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InfrastructureRole declares a role the operator creates on every cluster it manages, like the roles of the
+// infrastructure roles secret.
+type InfrastructureRole struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec InfrastructureRoleSpec `json:"spec"`
+}
+
+// InfrastructureRoleSpec describes the role, its password is kept in a secret in the namespace of the object.
+type InfrastructureRoleSpec struct {
+	// name of the role, the name of the object when empty
+	RoleName       string            `json:"roleName,omitempty"`
+	PasswordSecret UserSecret        `json:"passwordSecret"`
+	Flags          []string          `json:"flags,omitempty"`
+	InRoles        []string          `json:"inRoles,omitempty"`
+	AdminInRoles   []string          `json:"adminInRoles,omitempty"`
+	Parameters     map[string]string `json:"parameters,omitempty"`
+}
+
+// InfrastructureRoleList is the list of infrastructure roles.
+type InfrastructureRoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []InfrastructureRole `json:"items"`
+}
+
+// Clone makes a deepcopy of the infrastructure role, so that the object kept by the informer is not shared.
+func (r *InfrastructureRole) Clone() *InfrastructureRole {
+	if r == nil {
+		return nil
+	}
+	return deepcopy.Copy(r).(*InfrastructureRole)
+}
+
+// RoleName returns the name of the role in the databases.
+func (r *InfrastructureRole) RoleName() string {
+	if r.Spec.RoleName != "" {
+		return r.Spec.RoleName
+	}
+	return r.ObjectMeta.Name
+}
+
+// Validate checks the parts of the spec the operator relies on.
+func (r *InfrastructureRole) Validate() error {
+	if r.Spec.PasswordSecret.Name == "" {
+		return fmt.Errorf("passwordSecret of the infrastructure role must name a secret")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package spec
+
+import (
+	"fmt"
+
+	"github.com/mohae/deepcopy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupPhase describes where an on-demand backup is in its life cycle.
+type BackupPhase string
+
+// possible values for the backup phase, the empty one is a backup not picked up by the operator yet
+const (
+	BackupPhaseNew       BackupPhase = ""
+	BackupPhaseRunning   BackupPhase = "Running"
+	BackupPhaseCompleted BackupPhase = "Completed"
+	BackupPhaseFailed    BackupPhase = "Failed"
+)
+
+// PostgresBackup requests an immediate base backup of the cluster named in the spec.
+type PostgresBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   PostgresBackupSpec   `json:"spec"`
+	Status PostgresBackupStatus `json:"status,omitempty"`
+}
+
+// PostgresBackupSpec names the cluster to back up, it must live in the namespace of the backup object.
+type PostgresBackupSpec struct {
+	ClusterName string `json:"clusterName"`
+}
+
+// PostgresBackupStatus is the progress of the backup as reported by the operator.
+type PostgresBackupStatus struct {
+	Phase          BackupPhase  `json:"phase,omitempty"`
+	Message        string       `json:"message,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// the WAL archive holding the backup, so that it can be restored after the cluster is gone
+	ClusterUID string `json:"clusterUID,omitempty"`
+	S3Bucket   string `json:"s3Bucket,omitempty"`
+	S3Prefix   string `json:"s3Prefix,omitempty"`
+}
+
+// PostgresBackupList is the list of on-demand backups.
+type PostgresBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []PostgresBackup `json:"items"`
+}
+
+// Clone makes a deepcopy of the backup, so that the object kept by the informer is not shared.
+func (b *PostgresBackup) Clone() *PostgresBackup {
+	if b == nil {
+		return nil
+	}
+	return deepcopy.Copy(b).(*PostgresBackup)
+}
+
+// Validate checks the parts of the spec the operator relies on.
+func (b *PostgresBackup) Validate() error {
+	if b.Spec.ClusterName == "" {
+		return fmt.Errorf("clusterName of the backup must not be empty")
+	}
+	return nil
+}
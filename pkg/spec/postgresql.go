@@ -4,11 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/mohae/deepcopy"
+	"net"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/pkg/api/v1"
 )
 
@@ -22,10 +27,67 @@ type MaintenanceWindow struct {
 
 // Volume describes a single volume in the manifest.
 type Volume struct {
+	Size         string          `json:"size"`
+	StorageClass string          `json:"storageClass"`
+	MaxSize      string          `json:"maxSize,omitempty"`
+	AutoGrow     *VolumeAutoGrow `json:"autoGrow,omitempty"`
+	// provisioned IOPS and throughput in MiB/s of the volumes, i.e. of EBS gp3 volumes
+	Iops       *int64 `json:"iops,omitempty"`
+	Throughput *int64 `json:"throughput,omitempty"`
+	// EBS volume type the volumes are migrated to, i.e. gp3
+	VolumeType string `json:"type,omitempty"`
+	// persistent volume claims by default, emptyDir volumes for the throwaway clusters
+	Mode string `json:"mode,omitempty"`
+	// where the data volume is mounted and the directory of the volume mounted there, for images that keep
+	// the data in a different location
+	MountPath string `json:"mountPath,omitempty"`
+	SubPath   string `json:"subPath,omitempty"`
+}
+
+// IsEphemeral tells whether the data of the cluster lives in emptyDir volumes of the pods.
+func (v *Volume) IsEphemeral() bool {
+	return v.Mode == VolumeModeEphemeral
+}
+
+// Tablespace describes the volume of a tablespace in the manifest.
+type Tablespace struct {
 	Size         string `json:"size"`
-	StorageClass string `json:"storageClass"`
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// TeamMemberPrivileges are the flags and the roles the members of the team get in addition to the LOGIN flag and the
+// membership in the role of pam_role_name.
+type TeamMemberPrivileges struct {
+	Flags   []string `json:"flags,omitempty"`
+	InRoles []string `json:"inRoles,omitempty"`
+}
+
+// DefaultPrivileges names the roles that get access to the tables and the sequences the owner of a database creates.
+type DefaultPrivileges struct {
+	Readers []string `json:"readers,omitempty"`
+	Writers []string `json:"writers,omitempty"`
 }
 
+// UserSecret references an existing secret in the namespace of the cluster holding the password of a manifest user.
+type UserSecret struct {
+	Name string `json:"name"`
+	// key of the password in the secret, password by default
+	PasswordKey string `json:"passwordKey,omitempty"`
+}
+
+// VolumeAutoGrow describes when and by how much the operator grows the volumes on its own.
+type VolumeAutoGrow struct {
+	UsageThreshold int    `json:"usageThreshold"`
+	Increment      string `json:"increment"`
+	MaxSize        string `json:"maxSize,omitempty"`
+}
+
+// possible values for the volume mode, the persistent one is the default
+const (
+	VolumeModePersistent = "persistent"
+	VolumeModeEphemeral  = "ephemeral"
+)
+
 // PostgresqlParam describes PostgreSQL version and pairs of configuration parameter name - values.
 type PostgresqlParam struct {
 	PgVersion  string            `json:"version"`
@@ -34,8 +96,9 @@ type PostgresqlParam struct {
 
 // ResourceDescription describes CPU and memory resources defined for a cluster.
 type ResourceDescription struct {
-	CPU    string `json:"cpu"`
-	Memory string `json:"memory"`
+	CPU          string `json:"cpu"`
+	Memory       string `json:"memory"`
+	HugePages2Mi string `json:"hugepages-2Mi,omitempty"`
 }
 
 // Resources describes requests and limits for the cluster resouces.
@@ -46,12 +109,24 @@ type Resources struct {
 
 // Patroni contains Patroni-specific configuration
 type Patroni struct {
-	InitDB               map[string]string `json:"initdb"`
-	PgHba                []string          `json:"pg_hba"`
-	TTL                  uint32            `json:"ttl"`
-	LoopWait             uint32            `json:"loop_wait"`
-	RetryTimeout         uint32            `json:"retry_timeout"`
-	MaximumLagOnFailover float32           `json:"maximum_lag_on_failover"` // float32 because https://github.com/kubernetes/kubernetes/issues/30213
+	InitDB                map[string]string `json:"initdb"`
+	PgHba                 []string          `json:"pg_hba"`
+	TTL                   uint32            `json:"ttl"`
+	LoopWait              uint32            `json:"loop_wait"`
+	RetryTimeout          uint32            `json:"retry_timeout"`
+	MaximumLagOnFailover  float32           `json:"maximum_lag_on_failover"` // float32 because https://github.com/kubernetes/kubernetes/issues/30213
+	SynchronousMode       bool              `json:"synchronous_mode,omitempty"`
+	SynchronousModeStrict bool              `json:"synchronous_mode_strict,omitempty"`
+	SynchronousNodeCount  uint32            `json:"synchronous_node_count,omitempty"`
+	DCSQuorumLossAction   string            `json:"dcs_quorum_loss_action,omitempty"`
+}
+
+// StandbyDescription describes where a standby cluster replays the WAL from: the WAL archive of the source cluster
+// in S3, or the source cluster itself over streaming replication.
+type StandbyDescription struct {
+	S3WalPath   string `json:"s3_wal_path,omitempty"`
+	StandbyHost string `json:"standby_host,omitempty"`
+	StandbyPort string `json:"standby_port,omitempty"`
 }
 
 // CloneDescription describes which cluster the new should clone and up to which point in time
@@ -59,6 +134,53 @@ type CloneDescription struct {
 	ClusterName  string `json:"cluster,omitempty"`
 	Uid          string `json:"uid,omitempty"`
 	EndTimestamp string `json:"timestamp,omitempty"`
+	// namespace of the cluster to clone with pg_basebackup, the one of the clone when empty
+	Namespace string `json:"namespace,omitempty"`
+	// location of the WAL archive when it differs from the operator-wide bucket, only used with the timestamp
+	S3Bucket string `json:"s3_bucket,omitempty"`
+	S3Prefix string `json:"s3_prefix,omitempty"`
+}
+
+// S3 server-side encryption methods of the archive
+const (
+	S3EncryptionAES256 = "AES256"
+	S3EncryptionKMS    = "aws:kms"
+)
+
+// Backup describes where and how often the cluster is backed up with WAL-E, overriding the operator-wide bucket
+type Backup struct {
+	S3Bucket string `json:"s3Bucket,omitempty"`
+	S3Prefix string `json:"s3Prefix,omitempty"`
+	// cron schedule of the base backups
+	Schedule string `json:"schedule,omitempty"`
+	// number of base backups to keep
+	Retention *int32 `json:"retention,omitempty"`
+	// base backups taken within that many days are kept as well
+	RetentionDays *int32 `json:"retentionDays,omitempty"`
+	// secret in the namespace of the cluster holding the credentials of the archive instead of the pod's IAM role
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	// server-side encryption of the archive, AES256 or aws:kms with the given or the default key
+	S3ServerSideEncryption string `json:"s3ServerSideEncryption,omitempty"`
+	S3KMSKeyID             string `json:"s3KMSKeyID,omitempty"`
+	// number of delta backups WAL-G takes between two full base backups, 0 for full backups only
+	DeltaMaxSteps *int32 `json:"deltaMaxSteps,omitempty"`
+	// base of the delta backups, LATEST or LATEST_FULL
+	DeltaOrigin string `json:"deltaOrigin,omitempty"`
+	// check regularly with wal-g wal-verify that the archive is restorable
+	VerifyWAL bool `json:"verifyWAL,omitempty"`
+	// restore the latest backup into a throwaway cluster regularly and check that it answers queries
+	VerifyRestore bool `json:"verifyRestore,omitempty"`
+	// snapshots of the data volumes taken by the operator in addition to the archive
+	VolumeSnapshots *VolumeSnapshots `json:"volumeSnapshots,omitempty"`
+}
+
+// VolumeSnapshots defines how often the data volumes of the cluster are snapshotted and how many snapshots are kept.
+type VolumeSnapshots struct {
+	// Go duration between two snapshots, i.e. 24h
+	Interval string `json:"interval"`
+	// number of snapshots to keep per volume
+	Retention         *int32 `json:"retention,omitempty"`
+	SnapshotClassName string `json:"snapshotClassName,omitempty"`
 }
 
 // Sidecar defines a container to be run in the same pod as the Postgres container.
@@ -72,27 +194,103 @@ type Sidecar struct {
 
 type UserFlags []string
 
-// PostgresStatus contains status of the PostgreSQL cluster (running, creation failed etc.)
-type PostgresStatus string
+// ClusterPhase is the state of the PostgreSQL cluster (running, creation failed etc.)
+type ClusterPhase string
 
 // possible values for PostgreSQL cluster statuses
 const (
-	ClusterStatusUnknown      PostgresStatus = ""
-	ClusterStatusCreating     PostgresStatus = "Creating"
-	ClusterStatusUpdating     PostgresStatus = "Updating"
-	ClusterStatusUpdateFailed PostgresStatus = "UpdateFailed"
-	ClusterStatusSyncFailed   PostgresStatus = "SyncFailed"
-	ClusterStatusAddFailed    PostgresStatus = "CreateFailed"
-	ClusterStatusRunning      PostgresStatus = "Running"
-	ClusterStatusInvalid      PostgresStatus = "Invalid"
+	ClusterStatusUnknown      ClusterPhase = ""
+	ClusterStatusCreating     ClusterPhase = "Creating"
+	ClusterStatusUpdating     ClusterPhase = "Updating"
+	ClusterStatusUpdateFailed ClusterPhase = "UpdateFailed"
+	ClusterStatusSyncFailed   ClusterPhase = "SyncFailed"
+	ClusterStatusAddFailed    ClusterPhase = "CreateFailed"
+	ClusterStatusRunning      ClusterPhase = "Running"
+	ClusterStatusInvalid      ClusterPhase = "Invalid"
+	ClusterStatusDegraded     ClusterPhase = "Degraded"
+	// Patroni cannot reach the DCS and has demoted the master, the operator holds off any disruptive changes
+	ClusterStatusDCSQuorumLost ClusterPhase = "DCSQuorumLost"
+	// the restore of a clone has not completed, its partial resources are removed before the next attempt
+	ClusterStatusCloneFailed ClusterPhase = "CloneFailed"
+)
+
+// PostgresStatus contains the state of the PostgreSQL cluster along with the health of its backups.
+type PostgresStatus struct {
+	Phase ClusterPhase `json:"phase,omitempty"`
+	// time of the newest base backup in the archive
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+	// seconds since the master has archived the last WAL segment
+	WALArchiveLagSeconds *int64 `json:"walArchiveLagSeconds,omitempty"`
+	// result of the last verification of the archive, when enabled in the backup section
+	WALVerified       *bool        `json:"walVerified,omitempty"`
+	LastWALVerifyTime *metav1.Time `json:"lastWALVerifyTime,omitempty"`
+	// result of the last test restore of the latest backup, when enabled in the backup section
+	RestoreVerified       *bool        `json:"restoreVerified,omitempty"`
+	LastRestoreVerifyTime *metav1.Time `json:"lastRestoreVerifyTime,omitempty"`
+	// snapshots of the volumes taken before their last resize, when enabled in the operator configuration
+	ResizeSnapshots []VolumeResizeSnapshot `json:"resizeSnapshots,omitempty"`
+	// streaming replication from the master as of the last sync
+	Replication *ReplicationStatus `json:"replication,omitempty"`
+	// entries of the manifest naming protected or system roles, skipped when the roles are synced
+	InvalidRoleEntries []string `json:"invalidRoleEntries,omitempty"`
+}
+
+// VolumeResizeSnapshot is the snapshot a volume can be restored from if its resize goes wrong.
+type VolumeResizeSnapshot struct {
+	// persistent volume in the provider resize mode, persistent volume claim in the pvc one
+	Volume string `json:"volume"`
+	// id of the snapshot at the provider or name of the VolumeSnapshot resource
+	Snapshot string      `json:"snapshot"`
+	Time     metav1.Time `json:"time"`
+}
+
+// possible bases of the delta backups, the previous backup of any kind or the previous full one
+const (
+	DeltaOriginLatest     = "LATEST"
+	DeltaOriginLatestFull = "LATEST_FULL"
+)
+
+// possible values for the cluster workload profile
+const (
+	WorkloadProfileOLTP  = "oltp"
+	WorkloadProfileOLAP  = "olap"
+	WorkloadProfileMixed = "mixed"
+)
+
+// possible values for the action taken when the DCS quorum is lost
+const (
+	DCSQuorumLossActionProtect = "protect"
+	DCSQuorumLossActionIgnore  = "ignore"
+)
+
+// possible values for the huge_pages setting
+const (
+	HugePagesTry = "try"
+	HugePagesOn  = "on"
+	HugePagesOff = "off"
+)
+
+// possible values for the synchronous_commit level
+const (
+	SynchronousCommitOn          = "on"
+	SynchronousCommitOff         = "off"
+	SynchronousCommitLocal       = "local"
+	SynchronousCommitRemoteWrite = "remote_write"
+	SynchronousCommitRemoteApply = "remote_apply"
 )
 
 const (
 	serviceNameMaxLength   = 63
 	clusterNameMaxLength   = serviceNameMaxLength - len("-repl")
 	serviceNameRegexString = `^[a-z]([-a-z0-9]*[a-z0-9])?$`
+	namespaceRegexString   = `^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`
+	// the first Postgres version to support ssl_min_protocol_version
+	sslMinProtocolVersionPgVersion = 12
 )
 
+// RestoreTestClusterSuffix is appended to the name of a cluster to name the throwaway cluster of its test restores.
+const RestoreTestClusterSuffix = "-restore-test"
+
 // Postgresql defines PostgreSQL Custom Resource Definition Object.
 type Postgresql struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -134,6 +332,55 @@ type PostgresSpec struct {
 	Databases          map[string]string    `json:"databases,omitempty"`
 	Tolerations        []v1.Toleration      `json:"tolerations,omitempty"`
 	Sidecars           []Sidecar            `json:"sidecars,omitempty"`
+	WorkloadProfile    string               `json:"workloadProfile,omitempty"`
+	// number of connection slots reserved for superusers, including the one used by the operator
+	SuperuserReservedConnections *uint32             `json:"superuserReservedConnections,omitempty"`
+	ClientCertificates           *ClientCertificates `json:"clientCertificates,omitempty"`
+	// secrets used by the pods, i.e. for the TLS certificates, whose changes should restart the pods
+	RestartOnSecretChange []string `json:"restartOnSecretChange,omitempty"`
+	// number or percentage of pods the rolling update may take down at the same time, the master is always alone
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// synchronous_commit level for the whole cluster, unless the parameter is set explicitly
+	SynchronousCommit string `json:"synchronousCommit,omitempty"`
+	// oldest TLS protocol version and the ciphers accepted for the client connections
+	SSLMinProtocolVersion string `json:"sslMinProtocolVersion,omitempty"`
+	SSLCiphers            string `json:"sslCiphers,omitempty"`
+	// huge_pages setting, the huge pages themselves are requested in the resources
+	HugePages string `json:"hugePages,omitempty"`
+	// check the data checksum failures counted by Postgres on every sync
+	CheckDataChecksums bool `json:"checkDataChecksums,omitempty"`
+	// run the read-only monitoring queries of the operator against a replica
+	ReplicaReads bool    `json:"replicaReads,omitempty"`
+	Backup       *Backup `json:"backup,omitempty"`
+	// dump the cluster with pg_dump to S3 on the schedule, the operator-wide one when empty
+	EnableLogicalBackup   bool   `json:"enableLogicalBackup,omitempty"`
+	LogicalBackupSchedule string `json:"logicalBackupSchedule,omitempty"`
+	// the cluster has no master of its own and continuously replays the WAL of another one
+	StandbyCluster *StandbyDescription `json:"standbyCluster,omitempty"`
+	// overrides the final_backup_on_delete of the operator
+	FinalBackupOnDelete *bool `json:"finalBackupOnDelete,omitempty"`
+	// overrides the delete_volumes_on_delete of the operator
+	DeleteVolumesOnDelete *bool `json:"deleteVolumesOnDelete,omitempty"`
+	// tablespaces with a volume of their own, created in Postgres once the pods are ready
+	Tablespaces map[string]Tablespace `json:"tablespaces,omitempty"`
+	// secrets provisioned outside of the operator the passwords of the users come from
+	UserSecrets map[string]UserSecret `json:"userSecrets,omitempty"`
+	// group roles of the users section the users are granted
+	UserMemberships map[string][]string `json:"userMemberships,omitempty"`
+	// maximum numbers of concurrent connections of the users, unlimited when missing
+	UserConnectionLimits map[string]int `json:"userConnectionLimits,omitempty"`
+	// roles granted access to the objects the owners of the databases create, by database
+	DefaultPrivileges map[string]DefaultPrivileges `json:"defaultPrivileges,omitempty"`
+	// privileges of the team members, the ones of the operator configuration when missing
+	TeamMemberPrivileges *TeamMemberPrivileges `json:"teamMemberPrivileges,omitempty"`
+}
+
+// ClientCertificates describes the connections that, in addition to the password, must present
+// a client certificate signed by the CA. No users or subnets mean all of them.
+type ClientCertificates struct {
+	CAFile  string   `json:"caFile"`
+	Users   []string `json:"users,omitempty"`
+	Subnets []string `json:"subnets,omitempty"`
 }
 
 // PostgresqlList defines a list of PostgreSQL clusters.
@@ -147,6 +394,11 @@ type PostgresqlList struct {
 var (
 	weekdays         = map[string]int{"Sun": 0, "Mon": 1, "Tue": 2, "Wed": 3, "Thu": 4, "Fri": 5, "Sat": 6}
 	serviceNameRegex = regexp.MustCompile(serviceNameRegexString)
+	namespaceRegex   = regexp.MustCompile(namespaceRegexString)
+	// TLS protocol versions accepted by ssl_min_protocol_version
+	sslProtocolVersions = []string{"TLSv1", "TLSv1.1", "TLSv1.2", "TLSv1.3"}
+	// tablespace names end up in the names of the volumes, hence no upper case letters
+	tablespaceNameRegex = regexp.MustCompile("^[a-z][a-z0-9_]*$")
 )
 
 // Clone makes a deepcopy of the Postgresql structure. The Error field is nulled-out,
@@ -281,11 +533,417 @@ func validateCloneClusterDescription(clone *CloneDescription) error {
 			return fmt.Errorf("clone cluster name must be no longer than %d characters", serviceNameMaxLength)
 		}
 	}
+	if clone.Namespace != "" && !namespaceRegex.MatchString(clone.Namespace) {
+		return fmt.Errorf("clone namespace %q must confirm to DNS-1123, regex used for validation is %q",
+			clone.Namespace, namespaceRegexString)
+	}
+	// the point-in-time recovery stops at the timestamp, which has to be archived already
+	if clone.EndTimestamp != "" {
+		endTimestamp, err := time.Parse(time.RFC3339, clone.EndTimestamp)
+		if err != nil {
+			return fmt.Errorf("clone timestamp %q must be in the RFC 3339 format with a timezone", clone.EndTimestamp)
+		}
+		if !endTimestamp.Before(time.Now()) {
+			return fmt.Errorf("clone timestamp %q must be in the past", clone.EndTimestamp)
+		}
+	}
+	return nil
+}
+
+func validateStandbyCluster(spec *PostgresSpec) error {
+	standby := spec.StandbyCluster
+	if standby == nil {
+		return nil
+	}
+	if (standby.S3WalPath == "") == (standby.StandbyHost == "") {
+		return fmt.Errorf("standby cluster must set exactly one of s3_wal_path and standby_host")
+	}
+	if standby.S3WalPath != "" && !strings.HasPrefix(standby.S3WalPath, "s3://") {
+		return fmt.Errorf("standby s3_wal_path %q must be an s3:// URL", standby.S3WalPath)
+	}
+	if standby.StandbyPort != "" {
+		if standby.StandbyHost == "" {
+			return fmt.Errorf("standby_port requires the standby_host")
+		}
+		if _, err := strconv.ParseUint(standby.StandbyPort, 10, 16); err != nil {
+			return fmt.Errorf("standby_port %q must be a port number", standby.StandbyPort)
+		}
+	}
+	if spec.Clone.ClusterName != "" {
+		return fmt.Errorf("standby cluster cannot be a clone at the same time")
+	}
+	return nil
+}
+
+func validateWorkloadProfile(profile string) error {
+	switch profile {
+	case "", WorkloadProfileOLTP, WorkloadProfileOLAP, WorkloadProfileMixed:
+		return nil
+	}
+	return fmt.Errorf("unknown workload profile %q, must be one of %q, %q or %q",
+		profile, WorkloadProfileOLTP, WorkloadProfileOLAP, WorkloadProfileMixed)
+}
+
+func validateDCSQuorumLossAction(action string) error {
+	switch action {
+	case "", DCSQuorumLossActionProtect, DCSQuorumLossActionIgnore:
+		return nil
+	}
+	return fmt.Errorf("unknown DCS quorum loss action %q, must be one of %q or %q",
+		action, DCSQuorumLossActionProtect, DCSQuorumLossActionIgnore)
+}
+
+func validateSynchronousCommit(level string) error {
+	switch level {
+	case "", SynchronousCommitOn, SynchronousCommitOff, SynchronousCommitLocal,
+		SynchronousCommitRemoteWrite, SynchronousCommitRemoteApply:
+		return nil
+	}
+	return fmt.Errorf("unknown synchronous commit level %q, must be one of %q, %q, %q, %q or %q",
+		level, SynchronousCommitOn, SynchronousCommitOff, SynchronousCommitLocal,
+		SynchronousCommitRemoteWrite, SynchronousCommitRemoteApply)
+}
+
+func validateSSLMinProtocolVersion(spec *PostgresSpec) error {
+	if spec.SSLMinProtocolVersion == "" {
+		return nil
+	}
+	known := false
+	for _, version := range sslProtocolVersions {
+		if spec.SSLMinProtocolVersion == version {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown TLS protocol version %q, must be one of %q", spec.SSLMinProtocolVersion,
+			sslProtocolVersions)
+	}
+	// an unknown parameter would prevent Postgres from starting
+	if version, err := strconv.ParseFloat(spec.PgVersion, 64); err == nil && version < sslMinProtocolVersionPgVersion {
+		return fmt.Errorf("minimum TLS protocol version requires Postgres %d or later, got %s",
+			sslMinProtocolVersionPgVersion, spec.PgVersion)
+	}
+
+	return nil
+}
+
+func validateHugePages(spec *PostgresSpec) error {
+	switch spec.HugePages {
+	case "", HugePagesTry, HugePagesOff:
+		return nil
+	case HugePagesOn:
+		// Postgres refuses to start when it cannot get the huge pages
+		if spec.ResourceRequest.HugePages2Mi == "" && spec.ResourceLimits.HugePages2Mi == "" {
+			return fmt.Errorf("huge pages %q require the hugepages-2Mi resource", spec.HugePages)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown huge pages setting %q, must be one of %q, %q or %q",
+		spec.HugePages, HugePagesTry, HugePagesOn, HugePagesOff)
+}
+
+func validateBackup(backup *Backup) error {
+	if backup == nil {
+		return nil
+	}
+	if strings.Contains(backup.S3Bucket, "/") {
+		return fmt.Errorf("backup bucket %q must not contain a path, use the prefix instead", backup.S3Bucket)
+	}
+	if backup.Schedule != "" && !isCronSchedule(backup.Schedule) {
+		return fmt.Errorf("backup schedule %q must be a cron expression with 5 fields", backup.Schedule)
+	}
+	if backup.Retention != nil && *backup.Retention < 1 {
+		return fmt.Errorf("backup retention must keep at least 1 base backup, got %d", *backup.Retention)
+	}
+	if backup.RetentionDays != nil && *backup.RetentionDays < 1 {
+		return fmt.Errorf("backup retention must keep at least 1 day of base backups, got %d", *backup.RetentionDays)
+	}
+	switch backup.S3ServerSideEncryption {
+	case "", S3EncryptionKMS:
+	case S3EncryptionAES256:
+		if backup.S3KMSKeyID != "" {
+			return fmt.Errorf("backup KMS key requires the %q server-side encryption", S3EncryptionKMS)
+		}
+	default:
+		return fmt.Errorf("unknown backup server-side encryption %q, must be either %q or %q",
+			backup.S3ServerSideEncryption, S3EncryptionAES256, S3EncryptionKMS)
+	}
+	if backup.DeltaMaxSteps != nil && *backup.DeltaMaxSteps < 0 {
+		return fmt.Errorf("backup delta steps must not be negative, got %d", *backup.DeltaMaxSteps)
+	}
+	switch backup.DeltaOrigin {
+	case "", DeltaOriginLatest, DeltaOriginLatestFull:
+	default:
+		return fmt.Errorf("unknown backup delta origin %q, must be either %q or %q",
+			backup.DeltaOrigin, DeltaOriginLatest, DeltaOriginLatestFull)
+	}
+	if backup.DeltaOrigin != "" && (backup.DeltaMaxSteps == nil || *backup.DeltaMaxSteps == 0) {
+		return fmt.Errorf("backup delta origin requires deltaMaxSteps")
+	}
+	if snapshots := backup.VolumeSnapshots; snapshots != nil {
+		if interval, err := time.ParseDuration(snapshots.Interval); err != nil || interval <= 0 {
+			return fmt.Errorf("volume snapshot interval %q must be a positive duration, i.e. 24h", snapshots.Interval)
+		}
+		if snapshots.Retention != nil && *snapshots.Retention < 1 {
+			return fmt.Errorf("volume snapshot retention must keep at least 1 snapshot, got %d", *snapshots.Retention)
+		}
+	}
+	return nil
+}
+
+// validateRestoreVerification checks that the name of the restore test cluster fits the length limit of the names.
+func validateRestoreVerification(clusterName string, backup *Backup) error {
+	if backup == nil || !backup.VerifyRestore {
+		return nil
+	}
+	if maxLength := clusterNameMaxLength - len(RestoreTestClusterSuffix); len(clusterName) > maxLength {
+		return fmt.Errorf("name cannot be longer than %d characters to verify the restores, the restore test cluster "+
+			"adds %q to it", maxLength, RestoreTestClusterSuffix)
+	}
+	return nil
+}
+
+func validateLogicalBackupSchedule(schedule string) error {
+	if schedule != "" && !isCronSchedule(schedule) {
+		return fmt.Errorf("logical backup schedule %q must be a cron expression with 5 fields", schedule)
+	}
+	return nil
+}
+
+// isCronSchedule only counts the fields, the values themselves are checked by Spilo or Kubernetes.
+func isCronSchedule(schedule string) bool {
+	return len(strings.Fields(schedule)) == 5
+}
+
+// ValidateMaxUnavailable checks that the number of unavailable pods is a positive integer or a percentage.
+func ValidateMaxUnavailable(value intstr.IntOrString) error {
+	if value.Type == intstr.Int {
+		if value.IntVal < 1 {
+			return fmt.Errorf("maxUnavailable must be at least 1, got %d", value.IntVal)
+		}
+		return nil
+	}
+	percent, err := strconv.Atoi(strings.TrimSuffix(value.StrVal, "%"))
+	if err != nil || !strings.HasSuffix(value.StrVal, "%") || percent < 1 || percent > 100 {
+		return fmt.Errorf("maxUnavailable must be an integer or a percentage between 1%% and 100%%, got %q", value.StrVal)
+	}
+	return nil
+}
+
+func validateMaxUnavailable(value *intstr.IntOrString) error {
+	if value == nil {
+		return nil
+	}
+	return ValidateMaxUnavailable(*value)
+}
+
+func validateSuperuserReservedConnections(spec *PostgresSpec) error {
+	reserved := ""
+	if spec.SuperuserReservedConnections != nil {
+		reserved = strconv.FormatUint(uint64(*spec.SuperuserReservedConnections), 10)
+	} else if value, ok := spec.Parameters["superuser_reserved_connections"]; ok {
+		reserved = value
+	}
+	maxConnections, ok := spec.Parameters["max_connections"]
+	if reserved == "" || !ok {
+		return nil
+	}
+
+	reservedNum, err := strconv.Atoi(reserved)
+	if err != nil {
+		return fmt.Errorf("could not parse superuser_reserved_connections %q: %v", reserved, err)
+	}
+	maxConnectionsNum, err := strconv.Atoi(maxConnections)
+	if err != nil {
+		return fmt.Errorf("could not parse max_connections %q: %v", maxConnections, err)
+	}
+	if reservedNum >= maxConnectionsNum {
+		return fmt.Errorf("superuser_reserved_connections (%d) must be less than max_connections (%d)",
+			reservedNum, maxConnectionsNum)
+	}
+
+	return nil
+}
+
+// requiredSynchronousStandbys returns the number of standbys that must be running to avoid blocking writes.
+// In the non-strict synchronous mode Patroni falls back to the asynchronous replication when no
+// synchronous standby is available, so standbys are only required if their number is given explicitly.
+func (p *Patroni) requiredSynchronousStandbys() int32 {
+	if !p.SynchronousMode && !p.SynchronousModeStrict {
+		return 0
+	}
+	if p.SynchronousNodeCount > 0 {
+		return int32(p.SynchronousNodeCount)
+	}
+	if p.SynchronousModeStrict {
+		return 1
+	}
+	return 0
+}
+
+func validateSynchronousStandbys(spec *PostgresSpec) error {
+	required := spec.Patroni.requiredSynchronousStandbys()
+	// a cluster scaled down to zero accepts no writes, so there is nothing to block
+	if required == 0 || spec.NumberOfInstances == 0 {
+		return nil
+	}
+	if standbys := spec.NumberOfInstances - 1; standbys < required {
+		return fmt.Errorf("number of instances %d is too low for the synchronous mode: %d synchronous standby(s) "+
+			"require at least %d instances", spec.NumberOfInstances, required, required+1)
+	}
+
+	return nil
+}
+
+func validateVolumeMaxSize(volume *Volume) error {
+	if volume.MaxSize == "" {
+		return nil
+	}
+	maxSize, err := resource.ParseQuantity(volume.MaxSize)
+	if err != nil {
+		return fmt.Errorf("could not parse volume maximum size %q: %v", volume.MaxSize, err)
+	}
+	if volume.Size == "" {
+		return nil
+	}
+	size, err := resource.ParseQuantity(volume.Size)
+	if err != nil {
+		return fmt.Errorf("could not parse volume size %q: %v", volume.Size, err)
+	}
+	if size.Cmp(maxSize) > 0 {
+		return fmt.Errorf("volume size %s exceeds the maximum size %s", volume.Size, volume.MaxSize)
+	}
+
+	return nil
+}
+
+// the types EBS volumes can be modified to
+var ebsVolumeTypes = []string{"gp2", "gp3", "io1", "io2", "sc1", "st1", "standard"}
+
+func validateVolumeProperties(volume *Volume) error {
+	if volume.Iops != nil && *volume.Iops <= 0 {
+		return fmt.Errorf("volume iops %d must be positive", *volume.Iops)
+	}
+	if volume.Throughput != nil && *volume.Throughput <= 0 {
+		return fmt.Errorf("volume throughput %d must be positive", *volume.Throughput)
+	}
+	if volume.VolumeType == "" {
+		return nil
+	}
+	for _, volumeType := range ebsVolumeTypes {
+		if volume.VolumeType == volumeType {
+			return nil
+		}
+	}
+	return fmt.Errorf("volume type %q must be one of %s", volume.VolumeType, strings.Join(ebsVolumeTypes, ", "))
+}
+
+func validateVolumeMountPath(volume *Volume) error {
+	if volume.MountPath != "" && (!path.IsAbs(volume.MountPath) || path.Clean(volume.MountPath) != volume.MountPath ||
+		volume.MountPath == "/") {
+		return fmt.Errorf("volume mount path %q must be a clean absolute path other than /", volume.MountPath)
+	}
+	if volume.SubPath != "" && (path.IsAbs(volume.SubPath) || path.Clean(volume.SubPath) != volume.SubPath ||
+		volume.SubPath == ".." || strings.HasPrefix(volume.SubPath, "../")) {
+		return fmt.Errorf("volume sub path %q must be a clean relative path inside the volume", volume.SubPath)
+	}
+
+	return nil
+}
+
+func validateVolumeMode(spec *PostgresSpec) error {
+	switch spec.Volume.Mode {
+	case "", VolumeModePersistent:
+		return nil
+	case VolumeModeEphemeral:
+	default:
+		return fmt.Errorf("volume mode %q must be either %s or %s", spec.Volume.Mode, VolumeModePersistent,
+			VolumeModeEphemeral)
+	}
+	// there are no volumes of the provider to grow, modify or snapshot
+	if spec.Volume.AutoGrow != nil {
+		return fmt.Errorf("ephemeral volumes cannot grow automatically")
+	}
+	if spec.Volume.Iops != nil || spec.Volume.Throughput != nil || spec.Volume.VolumeType != "" {
+		return fmt.Errorf("ephemeral volumes have no iops, throughput or type")
+	}
+	if spec.Backup != nil && spec.Backup.VolumeSnapshots != nil {
+		return fmt.Errorf("ephemeral volumes cannot be snapshotted")
+	}
+
+	return nil
+}
+
+func validateVolumeAutoGrow(autoGrow *VolumeAutoGrow) error {
+	if autoGrow == nil {
+		return nil
+	}
+	if autoGrow.UsageThreshold <= 0 || autoGrow.UsageThreshold >= 100 {
+		return fmt.Errorf("volume auto grow usage threshold %d must be between 1 and 99 percent", autoGrow.UsageThreshold)
+	}
+	increment, err := resource.ParseQuantity(autoGrow.Increment)
+	if err != nil {
+		return fmt.Errorf("could not parse volume auto grow increment %q: %v", autoGrow.Increment, err)
+	}
+	if increment.Sign() <= 0 {
+		return fmt.Errorf("volume auto grow increment %s must be positive", autoGrow.Increment)
+	}
+	if autoGrow.MaxSize != "" {
+		if _, err := resource.ParseQuantity(autoGrow.MaxSize); err != nil {
+			return fmt.Errorf("could not parse volume auto grow maximum size %q: %v", autoGrow.MaxSize, err)
+		}
+	}
+
+	return nil
+}
+
+func validateTablespaces(tablespaces map[string]Tablespace) error {
+	for name, tablespace := range tablespaces {
+		if !tablespaceNameRegex.MatchString(name) || strings.HasPrefix(name, "pg_") {
+			return fmt.Errorf("tablespace name %q must consist of lower case letters, digits and underscores and "+
+				"must not start with pg_", name)
+		}
+		size, err := resource.ParseQuantity(tablespace.Size)
+		if err != nil {
+			return fmt.Errorf("could not parse size %q of tablespace %q: %v", tablespace.Size, name, err)
+		}
+		if size.Sign() <= 0 {
+			return fmt.Errorf("size %s of tablespace %q must be positive", tablespace.Size, name)
+		}
+	}
+
+	return nil
+}
+
+func validateClientCertificates(certs *ClientCertificates) error {
+	if certs == nil {
+		return nil
+	}
+	if certs.CAFile == "" {
+		return fmt.Errorf("client certificates require a CA file to verify them")
+	}
+	// the system users, like the superuser the operator connects with, must stay reachable without a certificate
+	if len(certs.Users) == 0 {
+		return fmt.Errorf("client certificates must list the users required to present them")
+	}
+	for _, user := range certs.Users {
+		if user == "all" {
+			return fmt.Errorf("client certificates cannot be required for all users, list the users instead")
+		}
+	}
+	for _, subnet := range certs.Subnets {
+		if _, _, err := net.ParseCIDR(subnet); err != nil {
+			return fmt.Errorf("could not parse client certificates subnet %q: %v", subnet, err)
+		}
+	}
+
 	return nil
 }
 
 type postgresqlListCopy PostgresqlList
 type postgresqlCopy Postgresql
+type postgresStatusCopy PostgresStatus
 
 // UnmarshalJSON converts a JSON into the PostgreSQL object.
 func (p *Postgresql) UnmarshalJSON(data []byte) error {
@@ -299,7 +957,7 @@ func (p *Postgresql) UnmarshalJSON(data []byte) error {
 		}
 
 		tmp.Error = err
-		tmp.Status = ClusterStatusInvalid
+		tmp.Status.Phase = ClusterStatusInvalid
 
 		*p = Postgresql(tmp)
 
@@ -309,10 +967,67 @@ func (p *Postgresql) UnmarshalJSON(data []byte) error {
 
 	if clusterName, err := extractClusterName(tmp2.ObjectMeta.Name, tmp2.Spec.TeamID); err != nil {
 		tmp2.Error = err
-		tmp2.Status = ClusterStatusInvalid
+		tmp2.Status.Phase = ClusterStatusInvalid
 	} else if err := validateCloneClusterDescription(&tmp2.Spec.Clone); err != nil {
 		tmp2.Error = err
-		tmp2.Status = ClusterStatusInvalid
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateWorkloadProfile(tmp2.Spec.WorkloadProfile); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateSuperuserReservedConnections(&tmp2.Spec); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateSynchronousStandbys(&tmp2.Spec); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateVolumeMaxSize(&tmp2.Spec.Volume); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateVolumeMountPath(&tmp2.Spec.Volume); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateVolumeMode(&tmp2.Spec); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateVolumeAutoGrow(tmp2.Spec.Volume.AutoGrow); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateVolumeProperties(&tmp2.Spec.Volume); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateTablespaces(tmp2.Spec.Tablespaces); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateClientCertificates(tmp2.Spec.ClientCertificates); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateDCSQuorumLossAction(tmp2.Spec.Patroni.DCSQuorumLossAction); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateMaxUnavailable(tmp2.Spec.MaxUnavailable); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateSynchronousCommit(tmp2.Spec.SynchronousCommit); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateSSLMinProtocolVersion(&tmp2.Spec); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateHugePages(&tmp2.Spec); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateBackup(tmp2.Spec.Backup); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateRestoreVerification(tmp2.ObjectMeta.Name, tmp2.Spec.Backup); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateLogicalBackupSchedule(tmp2.Spec.LogicalBackupSchedule); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
+	} else if err := validateStandbyCluster(&tmp2.Spec); err != nil {
+		tmp2.Error = err
+		tmp2.Status.Phase = ClusterStatusInvalid
 	} else {
 		tmp2.Spec.ClusterName = clusterName
 	}
@@ -335,3 +1050,21 @@ func (pl *PostgresqlList) UnmarshalJSON(data []byte) error {
 
 	return nil
 }
+
+// UnmarshalJSON converts a JSON into the status of the cluster. The status used to be the bare phase, which is still
+// accepted, so that the manifests written by the earlier operator versions decode.
+func (s *PostgresStatus) UnmarshalJSON(data []byte) error {
+	var phase string
+	if err := json.Unmarshal(data, &phase); err == nil {
+		*s = PostgresStatus{Phase: ClusterPhase(phase)}
+		return nil
+	}
+
+	var tmp postgresStatusCopy
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*s = PostgresStatus(tmp)
+
+	return nil
+}
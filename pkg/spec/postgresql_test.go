@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -57,12 +59,294 @@ var cloneClusterDescriptions = []struct {
 	in  *CloneDescription
 	err error
 }{
-	{&CloneDescription{"foo+bar", "", "NotEmpty"}, nil},
-	{&CloneDescription{"foo+bar", "", ""},
+	{&CloneDescription{ClusterName: "foo+bar", EndTimestamp: "2017-12-19T12:40:33+01:00"}, nil},
+	{&CloneDescription{ClusterName: "foobar", EndTimestamp: "NotEmpty"},
+		errors.New(`clone timestamp "NotEmpty" must be in the RFC 3339 format with a timezone`)},
+	{&CloneDescription{ClusterName: "foobar", EndTimestamp: "2017-12-19T12:40:33"},
+		errors.New(`clone timestamp "2017-12-19T12:40:33" must be in the RFC 3339 format with a timezone`)},
+	{&CloneDescription{ClusterName: "foobar", EndTimestamp: "2999-12-19T12:40:33+01:00"},
+		errors.New(`clone timestamp "2999-12-19T12:40:33+01:00" must be in the past`)},
+	{&CloneDescription{ClusterName: "foo+bar"},
 		errors.New(`clone cluster name must confirm to DNS-1035, regex used for validation is "^[a-z]([-a-z0-9]*[a-z0-9])?$"`)},
-	{&CloneDescription{"foobar123456789012345678901234567890123456789012345678901234567890", "", ""},
+	{&CloneDescription{ClusterName: "foobar123456789012345678901234567890123456789012345678901234567890"},
 		errors.New("clone cluster name must be no longer than 63 characters")},
-	{&CloneDescription{"foobar", "", ""}, nil},
+	{&CloneDescription{ClusterName: "foobar"}, nil},
+	{&CloneDescription{ClusterName: "foobar", Namespace: "team-acid"}, nil},
+	{&CloneDescription{ClusterName: "foobar", Namespace: "Team_Acid"},
+		errors.New(`clone namespace "Team_Acid" must confirm to DNS-1123, regex used for validation is "^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$"`)},
+}
+
+var workloadProfiles = []struct {
+	in  string
+	err error
+}{
+	{"", nil},
+	{"oltp", nil},
+	{"olap", nil},
+	{"mixed", nil},
+	{"OLTP", errors.New(`unknown workload profile "OLTP", must be one of "oltp", "olap" or "mixed"`)},
+	{"batch", errors.New(`unknown workload profile "batch", must be one of "oltp", "olap" or "mixed"`)},
+}
+
+var dcsQuorumLossActions = []struct {
+	in  string
+	err error
+}{
+	{"", nil},
+	{"protect", nil},
+	{"ignore", nil},
+	{"failover", errors.New(`unknown DCS quorum loss action "failover", must be one of "protect" or "ignore"`)},
+}
+
+var maxUnavailableValues = []struct {
+	in  intstr.IntOrString
+	err error
+}{
+	{intstr.FromInt(1), nil},
+	{intstr.FromInt(3), nil},
+	{intstr.FromString("25%"), nil},
+	{intstr.FromString("100%"), nil},
+	{intstr.FromInt(0), errors.New("maxUnavailable must be at least 1, got 0")},
+	{intstr.FromString("0%"), errors.New(`maxUnavailable must be an integer or a percentage between 1% and 100%, got "0%"`)},
+	{intstr.FromString("150%"), errors.New(`maxUnavailable must be an integer or a percentage between 1% and 100%, got "150%"`)},
+	{intstr.FromString("two"), errors.New(`maxUnavailable must be an integer or a percentage between 1% and 100%, got "two"`)},
+}
+
+var synchronousCommitLevels = []struct {
+	in  string
+	err error
+}{
+	{"", nil},
+	{"on", nil},
+	{"off", nil},
+	{"local", nil},
+	{"remote_write", nil},
+	{"remote_apply", nil},
+	{"true", errors.New(`unknown synchronous commit level "true", must be one of "on", "off", "local", ` +
+		`"remote_write" or "remote_apply"`)},
+}
+
+var sslMinProtocolVersions = []struct {
+	in  PostgresSpec
+	err error
+}{
+	{PostgresSpec{PostgresqlParam: PostgresqlParam{PgVersion: "10"}}, nil},
+	{PostgresSpec{PostgresqlParam: PostgresqlParam{PgVersion: "12"}, SSLMinProtocolVersion: "TLSv1.2"}, nil},
+	{PostgresSpec{PostgresqlParam: PostgresqlParam{PgVersion: "13"}, SSLMinProtocolVersion: "TLSv1.3"}, nil},
+	{PostgresSpec{PostgresqlParam: PostgresqlParam{PgVersion: "12"}, SSLMinProtocolVersion: "SSLv3"},
+		errors.New(`unknown TLS protocol version "SSLv3", must be one of ["TLSv1" "TLSv1.1" "TLSv1.2" "TLSv1.3"]`)},
+	{PostgresSpec{PostgresqlParam: PostgresqlParam{PgVersion: "10"}, SSLMinProtocolVersion: "TLSv1.2"},
+		errors.New("minimum TLS protocol version requires Postgres 12 or later, got 10")},
+}
+
+var hugePagesSettings = []struct {
+	in  PostgresSpec
+	err error
+}{
+	{PostgresSpec{}, nil},
+	{PostgresSpec{HugePages: "try"}, nil},
+	{PostgresSpec{HugePages: "off"}, nil},
+	{PostgresSpec{HugePages: "on", Resources: Resources{ResourceLimits: ResourceDescription{HugePages2Mi: "256Mi"}}}, nil},
+	{PostgresSpec{HugePages: "on"}, errors.New(`huge pages "on" require the hugepages-2Mi resource`)},
+	{PostgresSpec{HugePages: "yes"}, errors.New(`unknown huge pages setting "yes", must be one of "try", "on" or "off"`)},
+}
+
+var backups = []struct {
+	in  *Backup
+	err error
+}{
+	{nil, nil},
+	{&Backup{S3Bucket: "team-backups", S3Prefix: "acid/", Schedule: "30 2 * * *", Retention: int32Ptr(7)}, nil},
+	{&Backup{S3Bucket: "team-backups/acid"},
+		errors.New(`backup bucket "team-backups/acid" must not contain a path, use the prefix instead`)},
+	{&Backup{Schedule: "daily"}, errors.New(`backup schedule "daily" must be a cron expression with 5 fields`)},
+	{&Backup{Retention: int32Ptr(0)}, errors.New("backup retention must keep at least 1 base backup, got 0")},
+	{&Backup{Retention: int32Ptr(3), RetentionDays: int32Ptr(14)}, nil},
+	{&Backup{RetentionDays: int32Ptr(0)}, errors.New("backup retention must keep at least 1 day of base backups, got 0")},
+	{&Backup{S3ServerSideEncryption: "aws:kms", S3KMSKeyID: "arn:aws:kms:eu-central-1:123456789012:key/abcd"}, nil},
+	{&Backup{S3KMSKeyID: "arn:aws:kms:eu-central-1:123456789012:key/abcd"}, nil},
+	{&Backup{S3ServerSideEncryption: "AES256"}, nil},
+	{&Backup{S3ServerSideEncryption: "AES256", S3KMSKeyID: "arn:aws:kms:eu-central-1:123456789012:key/abcd"},
+		errors.New(`backup KMS key requires the "aws:kms" server-side encryption`)},
+	{&Backup{S3ServerSideEncryption: "aes256"},
+		errors.New(`unknown backup server-side encryption "aes256", must be either "AES256" or "aws:kms"`)},
+	{&Backup{Schedule: "0 * * * *", DeltaMaxSteps: int32Ptr(23), DeltaOrigin: "LATEST"}, nil},
+	{&Backup{DeltaMaxSteps: int32Ptr(-1)}, errors.New("backup delta steps must not be negative, got -1")},
+	{&Backup{DeltaMaxSteps: int32Ptr(6), DeltaOrigin: "FULL"},
+		errors.New(`unknown backup delta origin "FULL", must be either "LATEST" or "LATEST_FULL"`)},
+	{&Backup{DeltaOrigin: "LATEST"}, errors.New("backup delta origin requires deltaMaxSteps")},
+	{&Backup{VolumeSnapshots: &VolumeSnapshots{Interval: "12h", Retention: int32Ptr(4), SnapshotClassName: "csi-aws-ebs"}},
+		nil},
+	{&Backup{VolumeSnapshots: &VolumeSnapshots{Interval: "daily"}},
+		errors.New(`volume snapshot interval "daily" must be a positive duration, i.e. 24h`)},
+	{&Backup{VolumeSnapshots: &VolumeSnapshots{}},
+		errors.New(`volume snapshot interval "" must be a positive duration, i.e. 24h`)},
+	{&Backup{VolumeSnapshots: &VolumeSnapshots{Interval: "24h", Retention: int32Ptr(0)}},
+		errors.New("volume snapshot retention must keep at least 1 snapshot, got 0")},
+}
+
+var restoreVerifications = []struct {
+	name string
+	in   *Backup
+	err  error
+}{
+	{"acid-batman", nil, nil},
+	{"acid-batman", &Backup{VerifyRestore: true}, nil},
+	{"acid-" + strings.Repeat("a", 40), &Backup{VerifyRestore: true}, nil},
+	{"acid-" + strings.Repeat("a", 41), &Backup{VerifyRestore: true},
+		errors.New(`name cannot be longer than 45 characters to verify the restores, ` +
+			`the restore test cluster adds "-restore-test" to it`)},
+	{"acid-" + strings.Repeat("a", 41), &Backup{}, nil},
+}
+
+var logicalBackupSchedules = []struct {
+	in  string
+	err error
+}{
+	{"", nil},
+	{"30 00 * * *", nil},
+	{"@daily", errors.New(`logical backup schedule "@daily" must be a cron expression with 5 fields`)},
+}
+
+var superuserReservedConnections = []struct {
+	in  PostgresSpec
+	err error
+}{
+	{PostgresSpec{SuperuserReservedConnections: uint32Ptr(3)}, nil},
+	{PostgresSpec{
+		PostgresqlParam:              PostgresqlParam{Parameters: map[string]string{"max_connections": "100"}},
+		SuperuserReservedConnections: uint32Ptr(3)}, nil},
+	{PostgresSpec{
+		PostgresqlParam:              PostgresqlParam{Parameters: map[string]string{"max_connections": "3"}},
+		SuperuserReservedConnections: uint32Ptr(3)},
+		errors.New("superuser_reserved_connections (3) must be less than max_connections (3)")},
+	{PostgresSpec{
+		PostgresqlParam: PostgresqlParam{Parameters: map[string]string{
+			"max_connections": "10", "superuser_reserved_connections": "20"}}},
+		errors.New("superuser_reserved_connections (20) must be less than max_connections (10)")},
+	{PostgresSpec{
+		PostgresqlParam: PostgresqlParam{Parameters: map[string]string{
+			"max_connections": "many", "superuser_reserved_connections": "2"}}},
+		errors.New(`could not parse max_connections "many": strconv.Atoi: parsing "many": invalid syntax`)},
+}
+
+var synchronousStandbys = []struct {
+	in  PostgresSpec
+	err error
+}{
+	{PostgresSpec{NumberOfInstances: 1}, nil},
+	{PostgresSpec{NumberOfInstances: 1, Patroni: Patroni{SynchronousMode: true}}, nil},
+	{PostgresSpec{NumberOfInstances: 2, Patroni: Patroni{SynchronousModeStrict: true}}, nil},
+	{PostgresSpec{NumberOfInstances: 1, Patroni: Patroni{SynchronousModeStrict: true}},
+		errors.New("number of instances 1 is too low for the synchronous mode: 1 synchronous standby(s) require at least 2 instances")},
+	{PostgresSpec{NumberOfInstances: 3, Patroni: Patroni{SynchronousMode: true, SynchronousNodeCount: 2}}, nil},
+	{PostgresSpec{NumberOfInstances: 2, Patroni: Patroni{SynchronousMode: true, SynchronousNodeCount: 2}},
+		errors.New("number of instances 2 is too low for the synchronous mode: 2 synchronous standby(s) require at least 3 instances")},
+	{PostgresSpec{NumberOfInstances: 0, Patroni: Patroni{SynchronousModeStrict: true}}, nil},
+}
+
+var clientCertificates = []struct {
+	in  *ClientCertificates
+	err error
+}{
+	{nil, nil},
+	{&ClientCertificates{CAFile: "/tls/ca.crt", Users: []string{"foo"}}, nil},
+	{&ClientCertificates{CAFile: "/tls/ca.crt", Users: []string{"foo"}, Subnets: []string{"10.0.0.0/8"}}, nil},
+	{&ClientCertificates{Users: []string{"foo"}},
+		errors.New("client certificates require a CA file to verify them")},
+	{&ClientCertificates{CAFile: "/tls/ca.crt"},
+		errors.New("client certificates must list the users required to present them")},
+	{&ClientCertificates{CAFile: "/tls/ca.crt", Users: []string{"foo", "all"}},
+		errors.New("client certificates cannot be required for all users, list the users instead")},
+	{&ClientCertificates{CAFile: "/tls/ca.crt", Users: []string{"foo"}, Subnets: []string{"10.0.0.0"}},
+		errors.New(`could not parse client certificates subnet "10.0.0.0": invalid CIDR address: 10.0.0.0`)},
+}
+
+var volumeAutoGrows = []struct {
+	in  *VolumeAutoGrow
+	err error
+}{
+	{nil, nil},
+	{&VolumeAutoGrow{UsageThreshold: 80, Increment: "5Gi"}, nil},
+	{&VolumeAutoGrow{UsageThreshold: 80, Increment: "5Gi", MaxSize: "100Gi"}, nil},
+	{&VolumeAutoGrow{UsageThreshold: 100, Increment: "5Gi"},
+		errors.New("volume auto grow usage threshold 100 must be between 1 and 99 percent")},
+	{&VolumeAutoGrow{UsageThreshold: 80, Increment: "0"},
+		errors.New("volume auto grow increment 0 must be positive")},
+}
+
+var volumeProperties = []struct {
+	in  Volume
+	err error
+}{
+	{Volume{Size: "10Gi"}, nil},
+	{Volume{Size: "10Gi", Iops: int64Ptr(6000), Throughput: int64Ptr(250)}, nil},
+	{Volume{Size: "10Gi", Iops: int64Ptr(0)}, errors.New("volume iops 0 must be positive")},
+	{Volume{Size: "10Gi", Throughput: int64Ptr(-125)}, errors.New("volume throughput -125 must be positive")},
+	{Volume{Size: "10Gi", VolumeType: "gp3"}, nil},
+	{Volume{Size: "10Gi", VolumeType: "gp4"},
+		errors.New(`volume type "gp4" must be one of gp2, gp3, io1, io2, sc1, st1, standard`)},
+}
+
+var volumeMountPaths = []struct {
+	in  Volume
+	err error
+}{
+	{Volume{Size: "10Gi"}, nil},
+	{Volume{Size: "10Gi", MountPath: "/var/lib/postgresql", SubPath: "pgdata"}, nil},
+	{Volume{Size: "10Gi", SubPath: "data/pg"}, nil},
+	{Volume{Size: "10Gi", MountPath: "var/lib/postgresql"},
+		errors.New(`volume mount path "var/lib/postgresql" must be a clean absolute path other than /`)},
+	{Volume{Size: "10Gi", MountPath: "/var/lib/postgresql/"},
+		errors.New(`volume mount path "/var/lib/postgresql/" must be a clean absolute path other than /`)},
+	{Volume{Size: "10Gi", MountPath: "/"}, errors.New(`volume mount path "/" must be a clean absolute path other than /`)},
+	{Volume{Size: "10Gi", SubPath: "/pgdata"}, errors.New(`volume sub path "/pgdata" must be a clean relative path inside the volume`)},
+	{Volume{Size: "10Gi", SubPath: "../pgdata"},
+		errors.New(`volume sub path "../pgdata" must be a clean relative path inside the volume`)},
+}
+
+var volumeModes = []struct {
+	in  PostgresSpec
+	err error
+}{
+	{PostgresSpec{Volume: Volume{Size: "10Gi"}}, nil},
+	{PostgresSpec{Volume: Volume{Size: "10Gi", Mode: "persistent", AutoGrow: &VolumeAutoGrow{}}}, nil},
+	{PostgresSpec{Volume: Volume{Mode: "ephemeral"}}, nil},
+	{PostgresSpec{Volume: Volume{Mode: "tmpfs"}}, errors.New(`volume mode "tmpfs" must be either persistent or ephemeral`)},
+	{PostgresSpec{Volume: Volume{Mode: "ephemeral", AutoGrow: &VolumeAutoGrow{}}},
+		errors.New("ephemeral volumes cannot grow automatically")},
+	{PostgresSpec{Volume: Volume{Mode: "ephemeral", VolumeType: "gp3"}},
+		errors.New("ephemeral volumes have no iops, throughput or type")},
+	{PostgresSpec{Volume: Volume{Mode: "ephemeral"}, Backup: &Backup{VolumeSnapshots: &VolumeSnapshots{}}},
+		errors.New("ephemeral volumes cannot be snapshotted")},
+}
+
+var tablespaces = []struct {
+	in  map[string]Tablespace
+	err error
+}{
+	{nil, nil},
+	{map[string]Tablespace{"archive": {Size: "100Gi"}, "fast_2": {Size: "10Gi", StorageClass: "ssd"}}, nil},
+	{map[string]Tablespace{"Archive": {Size: "100Gi"}},
+		errors.New(`tablespace name "Archive" must consist of lower case letters, digits and underscores and must not start with pg_`)},
+	{map[string]Tablespace{"pg_archive": {Size: "100Gi"}},
+		errors.New(`tablespace name "pg_archive" must consist of lower case letters, digits and underscores and must not start with pg_`)},
+	{map[string]Tablespace{"archive": {Size: "0"}}, errors.New(`size 0 of tablespace "archive" must be positive`)},
+	{map[string]Tablespace{"archive": {}},
+		errors.New(`could not parse size "" of tablespace "archive": quantities must match the regular expression '^([+-]?[0-9.]+)([eEinumkKMGTP]*[-+]?[0-9]*)$'`)},
+}
+
+var volumeMaxSizes = []struct {
+	in  Volume
+	err error
+}{
+	{Volume{Size: "10Gi"}, nil},
+	{Volume{Size: "10Gi", MaxSize: "100Gi"}, nil},
+	{Volume{Size: "100Gi", MaxSize: "100Gi"}, nil},
+	{Volume{Size: "200Gi", MaxSize: "100Gi"},
+		errors.New("volume size 200Gi exceeds the maximum size 100Gi")},
+	{Volume{Size: "10Gi", MaxSize: "lots"},
+		errors.New(`could not parse volume maximum size "lots": quantities must match the regular expression '^([+-]?[0-9.]+)([eEinumkKMGTP]*[-+]?[0-9]*)$'`)},
 }
 
 var maintenanceWindows = []struct {
@@ -124,7 +408,7 @@ var unmarshalCluster = []struct {
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "acid-testcluster1",
 		},
-		Status: ClusterStatusInvalid,
+		Status: PostgresStatus{Phase: ClusterStatusInvalid},
 		Error: &json.UnmarshalTypeError{
 			Value:  "number",
 			Type:   reflect.TypeOf(""),
@@ -133,7 +417,7 @@ var unmarshalCluster = []struct {
 			Field:  "teamId",
 		},
 	},
-	[]byte(`{"kind":"Postgresql","apiVersion":"acid.zalan.do/v1","metadata":{"name":"acid-testcluster1","creationTimestamp":null},"spec":{"postgresql":{"version":"","parameters":null},"volume":{"size":"","storageClass":""},"patroni":{"initdb":null,"pg_hba":null,"ttl":0,"loop_wait":0,"retry_timeout":0,"maximum_lag_on_failover":0},"resources":{"requests":{"cpu":"","memory":""},"limits":{"cpu":"","memory":""}},"teamId":"","allowedSourceRanges":null,"numberOfInstances":0,"users":null,"clone":{}},"status":"Invalid"}`), nil},
+	[]byte(`{"kind":"Postgresql","apiVersion":"acid.zalan.do/v1","metadata":{"name":"acid-testcluster1","creationTimestamp":null},"spec":{"postgresql":{"version":"","parameters":null},"volume":{"size":"","storageClass":""},"patroni":{"initdb":null,"pg_hba":null,"ttl":0,"loop_wait":0,"retry_timeout":0,"maximum_lag_on_failover":0},"resources":{"requests":{"cpu":"","memory":""},"limits":{"cpu":"","memory":""}},"teamId":"","allowedSourceRanges":null,"numberOfInstances":0,"users":null,"clone":{}},"status":{"phase":"Invalid"}}`), nil},
 	{[]byte(`{
   "kind": "Postgresql",
   "apiVersion": "acid.zalan.do/v1",
@@ -278,10 +562,10 @@ var unmarshalCluster = []struct {
 				Name: "teapot-testcluster1",
 			},
 			Spec:   PostgresSpec{TeamID: "acid"},
-			Status: ClusterStatusInvalid,
+			Status: PostgresStatus{Phase: ClusterStatusInvalid},
 			Error:  errors.New("name must match {TEAM}-{NAME} format"),
 		},
-		[]byte(`{"kind":"Postgresql","apiVersion":"acid.zalan.do/v1","metadata":{"name":"teapot-testcluster1","creationTimestamp":null},"spec":{"postgresql":{"version":"","parameters":null},"volume":{"size":"","storageClass":""},"patroni":{"initdb":null,"pg_hba":null,"ttl":0,"loop_wait":0,"retry_timeout":0,"maximum_lag_on_failover":0},"resources":{"requests":{"cpu":"","memory":""},"limits":{"cpu":"","memory":""}},"teamId":"acid","allowedSourceRanges":null,"numberOfInstances":0,"users":null,"clone":{}},"status":"Invalid"}`), nil},
+		[]byte(`{"kind":"Postgresql","apiVersion":"acid.zalan.do/v1","metadata":{"name":"teapot-testcluster1","creationTimestamp":null},"spec":{"postgresql":{"version":"","parameters":null},"volume":{"size":"","storageClass":""},"patroni":{"initdb":null,"pg_hba":null,"ttl":0,"loop_wait":0,"retry_timeout":0,"maximum_lag_on_failover":0},"resources":{"requests":{"cpu":"","memory":""},"limits":{"cpu":"","memory":""}},"teamId":"acid","allowedSourceRanges":null,"numberOfInstances":0,"users":null,"clone":{}},"status":{"phase":"Invalid"}}`), nil},
 	{
 		in: []byte(`{"kind": "Postgresql","apiVersion": "acid.zalan.do/v1","metadata": {"name": "acid-testcluster1"}, "spec": {"teamId": "acid", "clone": {"cluster": "team-batman"}}}`),
 		out: Postgresql{
@@ -343,7 +627,7 @@ var postgresqlList = []struct {
 					AllowedSourceRanges: []string{"185.85.220.0/22"},
 					NumberOfInstances:   1,
 				},
-				Status: ClusterStatusRunning,
+				Status: PostgresStatus{Phase: ClusterStatusRunning},
 				Error:  nil,
 			}},
 		},
@@ -426,6 +710,301 @@ func TestCloneClusterDescription(t *testing.T) {
 	}
 }
 
+func TestWorkloadProfile(t *testing.T) {
+	for _, tt := range workloadProfiles {
+		if err := validateWorkloadProfile(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateWorkloadProfile expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestDCSQuorumLossAction(t *testing.T) {
+	for _, tt := range dcsQuorumLossActions {
+		if err := validateDCSQuorumLossAction(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateDCSQuorumLossAction expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestMaxUnavailable(t *testing.T) {
+	for _, tt := range maxUnavailableValues {
+		if err := ValidateMaxUnavailable(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("ValidateMaxUnavailable expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestSynchronousCommit(t *testing.T) {
+	for _, tt := range synchronousCommitLevels {
+		if err := validateSynchronousCommit(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateSynchronousCommit expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestSSLMinProtocolVersion(t *testing.T) {
+	for _, tt := range sslMinProtocolVersions {
+		if err := validateSSLMinProtocolVersion(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateSSLMinProtocolVersion expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestHugePages(t *testing.T) {
+	for _, tt := range hugePagesSettings {
+		if err := validateHugePages(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateHugePages expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	for _, tt := range backups {
+		if err := validateBackup(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateBackup expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestRestoreVerification(t *testing.T) {
+	for _, tt := range restoreVerifications {
+		if err := validateRestoreVerification(tt.name, tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateRestoreVerification expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestLogicalBackupSchedule(t *testing.T) {
+	for _, tt := range logicalBackupSchedules {
+		if err := validateLogicalBackupSchedule(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateLogicalBackupSchedule expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+var standbyClusters = []struct {
+	in  PostgresSpec
+	err error
+}{
+	{PostgresSpec{}, nil},
+	{PostgresSpec{StandbyCluster: &StandbyDescription{S3WalPath: "s3://team-bucket/spilo/acid-batman/uid/wal"}}, nil},
+	{PostgresSpec{StandbyCluster: &StandbyDescription{StandbyHost: "acid-batman.default", StandbyPort: "5433"}}, nil},
+	{PostgresSpec{StandbyCluster: &StandbyDescription{}},
+		errors.New("standby cluster must set exactly one of s3_wal_path and standby_host")},
+	{PostgresSpec{StandbyCluster: &StandbyDescription{S3WalPath: "s3://team-bucket/wal", StandbyHost: "acid-batman"}},
+		errors.New("standby cluster must set exactly one of s3_wal_path and standby_host")},
+	{PostgresSpec{StandbyCluster: &StandbyDescription{S3WalPath: "team-bucket/wal"}},
+		errors.New(`standby s3_wal_path "team-bucket/wal" must be an s3:// URL`)},
+	{PostgresSpec{StandbyCluster: &StandbyDescription{S3WalPath: "s3://team-bucket/wal", StandbyPort: "5433"}},
+		errors.New("standby_port requires the standby_host")},
+	{PostgresSpec{StandbyCluster: &StandbyDescription{StandbyHost: "acid-batman", StandbyPort: "postgres"}},
+		errors.New(`standby_port "postgres" must be a port number`)},
+	{PostgresSpec{StandbyCluster: &StandbyDescription{StandbyHost: "acid-batman"},
+		Clone: CloneDescription{ClusterName: "acid-robin"}},
+		errors.New("standby cluster cannot be a clone at the same time")},
+}
+
+func TestStandbyCluster(t *testing.T) {
+	for _, tt := range standbyClusters {
+		if err := validateStandbyCluster(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateStandbyCluster expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+var postgresRestores = []struct {
+	about string
+	in    PostgresRestoreSpec
+	err   string
+}{
+	{"backup", PostgresRestoreSpec{BackupName: "nightly", ClusterName: "acid-restored"}, ""},
+	{"WAL archive", PostgresRestoreSpec{Source: &RestoreSource{ClusterName: "acid-batman", UID: "uid"},
+		Timestamp: "2018-05-01T10:30:00+02:00", ClusterName: "acid-restored"}, ""},
+	{"no source", PostgresRestoreSpec{ClusterName: "acid-restored"}, "exactly one of backupName and source must be set"},
+	{"both sources", PostgresRestoreSpec{BackupName: "nightly", Source: &RestoreSource{ClusterName: "acid-batman", UID: "uid"},
+		ClusterName: "acid-restored"}, "exactly one of backupName and source must be set"},
+	{"WAL archive without uid", PostgresRestoreSpec{Source: &RestoreSource{ClusterName: "acid-batman"},
+		ClusterName: "acid-restored"}, "source must name the cluster and its uid"},
+	{"timestamp without timezone", PostgresRestoreSpec{BackupName: "nightly", Timestamp: "2018-05-01 10:30:00",
+		ClusterName: "acid-restored"}, `timestamp "2018-05-01 10:30:00" must be in the RFC 3339 format with a timezone`},
+	{"cluster of another team", PostgresRestoreSpec{BackupName: "nightly", ClusterName: "foo-restored"},
+		`invalid cluster name "foo-restored": name must match {TEAM}-{NAME} format`},
+}
+
+func TestPostgresRestoreValidate(t *testing.T) {
+	for _, tt := range postgresRestores {
+		restore := PostgresRestore{Spec: tt.in}
+		restore.Spec.Cluster.TeamID = "acid"
+		err := restore.Validate()
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.about, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: expected error %q, got %v", tt.about, tt.err, err)
+		}
+	}
+}
+
+func uint32Ptr(v uint32) *uint32 {
+	return &v
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestSuperuserReservedConnections(t *testing.T) {
+	for _, tt := range superuserReservedConnections {
+		if err := validateSuperuserReservedConnections(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateSuperuserReservedConnections expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestSynchronousStandbys(t *testing.T) {
+	for _, tt := range synchronousStandbys {
+		if err := validateSynchronousStandbys(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateSynchronousStandbys expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	for _, tt := range clientCertificates {
+		if err := validateClientCertificates(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateClientCertificates expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestVolumeAutoGrow(t *testing.T) {
+	for _, tt := range volumeAutoGrows {
+		if err := validateVolumeAutoGrow(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateVolumeAutoGrow expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestVolumeProperties(t *testing.T) {
+	for _, tt := range volumeProperties {
+		if err := validateVolumeProperties(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateVolumeProperties expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestVolumeMountPath(t *testing.T) {
+	for _, tt := range volumeMountPaths {
+		if err := validateVolumeMountPath(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateVolumeMountPath expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestVolumeMode(t *testing.T) {
+	for _, tt := range volumeModes {
+		if err := validateVolumeMode(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateVolumeMode expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestTablespaces(t *testing.T) {
+	for _, tt := range tablespaces {
+		if err := validateTablespaces(tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateTablespaces expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
+func TestVolumeMaxSize(t *testing.T) {
+	for _, tt := range volumeMaxSizes {
+		if err := validateVolumeMaxSize(&tt.in); err != nil {
+			if tt.err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("validateVolumeMaxSize expected error: %v, got: %v", tt.err, err)
+			}
+		} else if tt.err != nil {
+			t.Errorf("Expected error: %v", tt.err)
+		}
+	}
+}
+
 func TestUnmarshalMaintenanceWindow(t *testing.T) {
 	for _, tt := range maintenanceWindows {
 		var m MaintenanceWindow
@@ -557,3 +1136,51 @@ func TestPostgresqlClone(t *testing.T) {
 
 	}
 }
+
+func TestPostgresStatusUnmarshal(t *testing.T) {
+	lastBackup := metav1.NewTime(time.Date(2018, 10, 15, 2, 30, 0, 0, time.UTC))
+	lag := int64(42)
+	tests := []struct {
+		in  string
+		out PostgresStatus
+	}{
+		{`"Running"`, PostgresStatus{Phase: ClusterStatusRunning}},
+		{`{"phase":"Running"}`, PostgresStatus{Phase: ClusterStatusRunning}},
+		{`{"phase":"Degraded","lastBackupTime":"2018-10-15T02:30:00Z","walArchiveLagSeconds":42}`,
+			PostgresStatus{Phase: ClusterStatusDegraded, LastBackupTime: &lastBackup, WALArchiveLagSeconds: &lag}},
+	}
+	for _, tt := range tests {
+		var status PostgresStatus
+		if err := json.Unmarshal([]byte(tt.in), &status); err != nil {
+			t.Errorf("PostgresStatus unmarshal of %s: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if status.Phase != tt.out.Phase || !reflect.DeepEqual(status.WALArchiveLagSeconds, tt.out.WALArchiveLagSeconds) ||
+			(status.LastBackupTime == nil) != (tt.out.LastBackupTime == nil) ||
+			(status.LastBackupTime != nil && !status.LastBackupTime.Time.Equal(tt.out.LastBackupTime.Time)) {
+			t.Errorf("PostgresStatus unmarshal of %s: expected %#v, got %#v", tt.in, tt.out, status)
+		}
+	}
+}
+
+func TestPostgresStatusReplication(t *testing.T) {
+	in := `{"phase":"Running","replication":{"healthy":true,"connectedReplicas":2,"synchronousStandbys":1,` +
+		`"timeline":4,"collectedAt":"2018-10-15T02:30:00Z"}}`
+	expected := ReplicationStatus{Healthy: true, ConnectedReplicas: 2, SynchronousStandbys: 1, Timeline: 4,
+		CollectedAt: time.Date(2018, 10, 15, 2, 30, 0, 0, time.UTC)}
+
+	var status PostgresStatus
+	if err := json.Unmarshal([]byte(in), &status); err != nil {
+		t.Fatalf("PostgresStatus unmarshal of %s: unexpected error: %v", in, err)
+	}
+	if status.Replication == nil || !reflect.DeepEqual(*status.Replication, expected) {
+		t.Errorf("PostgresStatus unmarshal of %s: expected replication %#v, got %#v", in, expected, status.Replication)
+	}
+	out, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("PostgresStatus marshal: unexpected error: %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("PostgresStatus marshal: expected %s, got %s", in, out)
+	}
+}
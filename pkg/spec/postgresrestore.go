@@ -0,0 +1,115 @@
+package spec
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mohae/deepcopy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestorePhase describes whether the cluster of a restore has been created.
+type RestorePhase string
+
+// possible values for the restore phase, the empty one is a restore not picked up by the operator yet
+const (
+	RestorePhaseNew     RestorePhase = ""
+	RestorePhaseCreated RestorePhase = "Created"
+	RestorePhaseFailed  RestorePhase = "Failed"
+)
+
+// PostgresRestore requests a new cluster bootstrapped from a backup.
+type PostgresRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   PostgresRestoreSpec   `json:"spec"`
+	Status PostgresRestoreStatus `json:"status,omitempty"`
+}
+
+// PostgresRestoreSpec describes the new cluster and where its data comes from: either a completed backup in the
+// namespace of the restore or the WAL archive of a cluster in S3. The timestamp defaults to the completion of the
+// backup or, for the WAL archive, to the time the restore is processed.
+type PostgresRestoreSpec struct {
+	BackupName  string         `json:"backupName,omitempty"`
+	Source      *RestoreSource `json:"source,omitempty"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+	ClusterName string         `json:"clusterName"`
+	Cluster     PostgresSpec   `json:"cluster"`
+}
+
+// RestoreSource is the WAL archive of a cluster, the bucket defaults to the operator-wide one.
+type RestoreSource struct {
+	ClusterName string `json:"cluster"`
+	UID         string `json:"uid"`
+	S3Bucket    string `json:"s3Bucket,omitempty"`
+	S3Prefix    string `json:"s3Prefix,omitempty"`
+}
+
+// PostgresRestoreStatus is the outcome of the restore as reported by the operator.
+type PostgresRestoreStatus struct {
+	Phase   RestorePhase `json:"phase,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+// PostgresRestoreList is the list of restores.
+type PostgresRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []PostgresRestore `json:"items"`
+}
+
+// Clone makes a deepcopy of the restore, so that the object kept by the informer is not shared.
+func (r *PostgresRestore) Clone() *PostgresRestore {
+	if r == nil {
+		return nil
+	}
+	return deepcopy.Copy(r).(*PostgresRestore)
+}
+
+// Validate checks that the restore names exactly one source and a cluster the operator would accept.
+func (r *PostgresRestore) Validate() error {
+	if (r.Spec.BackupName == "") == (r.Spec.Source == nil) {
+		return fmt.Errorf("exactly one of backupName and source must be set")
+	}
+	if r.Spec.Source != nil && (r.Spec.Source.ClusterName == "" || r.Spec.Source.UID == "") {
+		return fmt.Errorf("source must name the cluster and its uid")
+	}
+	if r.Spec.Timestamp != "" {
+		if _, err := time.Parse(time.RFC3339, r.Spec.Timestamp); err != nil {
+			return fmt.Errorf("timestamp %q must be in the RFC 3339 format with a timezone", r.Spec.Timestamp)
+		}
+	}
+	if _, err := extractClusterName(r.Spec.ClusterName, r.Spec.Cluster.TeamID); err != nil {
+		return fmt.Errorf("invalid cluster name %q: %v", r.Spec.ClusterName, err)
+	}
+	return nil
+}
+
+// CloneDescription points the new cluster to the WAL archive of the source with the backup's location.
+func (r *PostgresRestore) CloneDescription(backup *PostgresBackup, now time.Time) CloneDescription {
+	timestamp := r.Spec.Timestamp
+	if backup != nil {
+		if timestamp == "" && backup.Status.CompletionTime != nil {
+			timestamp = backup.Status.CompletionTime.Format(time.RFC3339)
+		}
+		return CloneDescription{
+			ClusterName:  backup.Spec.ClusterName,
+			Uid:          backup.Status.ClusterUID,
+			EndTimestamp: timestamp,
+			S3Bucket:     backup.Status.S3Bucket,
+			S3Prefix:     backup.Status.S3Prefix,
+		}
+	}
+	if timestamp == "" {
+		timestamp = now.Format(time.RFC3339)
+	}
+	return CloneDescription{
+		ClusterName:  r.Spec.Source.ClusterName,
+		Uid:          r.Spec.Source.UID,
+		EndTimestamp: timestamp,
+		S3Bucket:     r.Spec.Source.S3Bucket,
+		S3Prefix:     r.Spec.Source.S3Prefix,
+	}
+}
@@ -57,11 +57,13 @@ type ClusterEvent struct {
 
 type syncUserOperation int
 
-// Possible values for the sync user operation (removal of users is not supported yet)
+// Possible values for the sync user operation
 const (
 	PGSyncUserAdd = iota
 	PGsyncUserAlter
 	PGSyncAlterSet // handle ALTER ROLE SET parameter = value
+	PGSyncUserDrop // drop a role removed from the manifest
+	PGSyncUserLock // strip a role removed from the manifest of the LOGIN flag and of its password
 )
 
 // PodEvent describes the event for a single Pod
@@ -81,6 +83,12 @@ type PgUser struct {
 	Flags      []string          `yaml:"user_flags"`
 	MemberOf   []string          `yaml:"inrole"`
 	Parameters map[string]string `yaml:"db_parameters"`
+	// memberships granted WITH ADMIN OPTION, so that the role can manage the members of those roles
+	AdminOf []string `yaml:"admin_inrole"`
+	// memberships revoked by an alter request, so that the roles of the manifest converge to their memberships
+	RevokeFrom []string `yaml:"-"`
+	// maximum number of concurrent connections of the role, -1 for no limit and nil to leave the limit alone
+	ConnectionLimit *int `yaml:"-"`
 }
 
 // PgUserMap maps user names to the definitions.
@@ -129,6 +137,51 @@ type ClusterStatus struct {
 	Status         PostgresStatus
 	Spec           PostgresSpec
 	Error          error
+
+	ResourceRecommendation *ResourceRecommendation
+	// number of data checksum failures per database, the empty name stands for the shared catalogs
+	ChecksumFailures map[string]int64
+	LogicalSlots     []LogicalSlot
+	Replication      *ReplicationStatus
+	// set for the standby clusters, which replay the WAL of another cluster and accept no writes
+	ReadOnly bool
+}
+
+// ReplicationStatus summarizes the streaming replication from the master to the replicas
+type ReplicationStatus struct {
+	Healthy             bool      `json:"healthy"`
+	ConnectedReplicas   int       `json:"connectedReplicas"`
+	SynchronousStandbys int       `json:"synchronousStandbys"`
+	Timeline            int       `json:"timeline"`
+	CollectedAt         time.Time `json:"collectedAt"`
+}
+
+// LogicalSlot describes the WAL a logical replication slot holds back, both sizes are in bytes
+type LogicalSlot struct {
+	Name             string
+	Database         string
+	Active           bool
+	RetainedWALBytes int64
+	LagBytes         int64
+}
+
+// ResourceRecommendation is an advisory suggestion of the postgres container requests, based on the peak usage
+// observed by the operator. The operator never applies it.
+type ResourceRecommendation struct {
+	CPURequest    string
+	MemoryRequest string
+	PeakCPU       string
+	PeakMemory    string
+	Reasons       []string
+	ComputedAt    time.Time
+}
+
+// ClusterMetrics describes the load of the cluster, as collected from the database during the last sync
+type ClusterMetrics struct {
+	ActiveConnections     int64
+	TransactionsPerSecond float64
+	CacheHitRatio         float64
+	CollectedAt           time.Time
 }
 
 // WorkerStatus describes status of the worker
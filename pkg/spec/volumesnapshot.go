@@ -0,0 +1,41 @@
+package spec
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VolumeSnapshot is the snapshot of a persistent volume claim taken by the CSI external snapshotter. The client-go
+// release the operator is built with does not know that resource, so only the fields the operator needs are defined.
+type VolumeSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   VolumeSnapshotSpec   `json:"spec"`
+	Status VolumeSnapshotStatus `json:"status,omitempty"`
+}
+
+// VolumeSnapshotSpec names the persistent volume claim to snapshot and the class of the snapshot.
+type VolumeSnapshotSpec struct {
+	Source                  *VolumeSnapshotSource `json:"source,omitempty"`
+	VolumeSnapshotClassName string                `json:"snapshotClassName,omitempty"`
+}
+
+// VolumeSnapshotSource is a reference to an object in the namespace of the snapshot.
+type VolumeSnapshotSource struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// VolumeSnapshotStatus is the state of the snapshot as reported by the snapshotter.
+type VolumeSnapshotStatus struct {
+	CreationTime *metav1.Time `json:"creationTime,omitempty"`
+	ReadyToUse   bool         `json:"readyToUse,omitempty"`
+}
+
+// VolumeSnapshotList is the list of volume snapshots.
+type VolumeSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []VolumeSnapshot `json:"items"`
+}
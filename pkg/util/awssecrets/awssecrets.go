@@ -0,0 +1,209 @@
+package awssecrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// Credentials is the value of a secret, in the layout of the secrets of RDS databases, so that the tools and
+// libraries rotating or reading those understand it as well.
+type Credentials struct {
+	Engine   string `json:"engine"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	DBName   string `json:"dbname"`
+}
+
+// Interface stores the credentials of the database roles in the secrets of AWS Secrets Manager.
+type Interface interface {
+	ReadCredentials(name string) (*Credentials, error)
+	WriteCredentials(name string, credentials Credentials, tags map[string]string) error
+	DeleteCredentials(name string) error
+}
+
+// API talks to AWS Secrets Manager, the connection is established on the first call.
+type API struct {
+	client             secretsmanageriface.SecretsManagerAPI
+	mu                 sync.Mutex
+	region             string
+	roleARN            string
+	proxy              string
+	kmsKeyID           string
+	recoveryWindowDays int64
+	logger             *logrus.Entry
+}
+
+// NewSecretsManagerAPI creates an object to store the credentials in AWS Secrets Manager. The secrets are encrypted
+// with the KMS key given, the default key of the account when empty, and deleted with the recovery window given, at
+// once when it is 0.
+func NewSecretsManagerAPI(region, roleARN, proxy, kmsKeyID string, recoveryWindowDays int64, log *logrus.Entry) *API {
+	return &API{
+		region:             region,
+		roleARN:            roleARN,
+		proxy:              proxy,
+		kmsKeyID:           kmsKeyID,
+		recoveryWindowDays: recoveryWindowDays,
+		logger:             log.WithField("pkg", "awssecrets"),
+	}
+}
+
+func (a *API) connect() (secretsmanageriface.SecretsManagerAPI, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.client != nil {
+		return a.client, nil
+	}
+
+	sessionConfig := aws.NewConfig().WithRegion(a.region)
+	if a.proxy != "" {
+		proxyURL, err := url.Parse(a.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse AWS proxy URL %q: %v", a.proxy, err)
+		}
+		sessionConfig = sessionConfig.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		})
+	}
+	sess, err := session.NewSession(sessionConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not establish AWS session: %v", err)
+	}
+	clientConfig := aws.NewConfig()
+	if a.roleARN != "" {
+		clientConfig = clientConfig.WithCredentials(stscreds.NewCredentials(sess, a.roleARN))
+	}
+	a.client = secretsmanager.New(sess, clientConfig)
+
+	return a.client, nil
+}
+
+// ReadCredentials returns the credentials stored in the secret, nil if there is none or it is scheduled for deletion.
+func (a *API) ReadCredentials(name string) (*Credentials, error) {
+	client, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	output, err := client.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if isErrorCode(err, secretsmanager.ErrCodeResourceNotFoundException) ||
+		isErrorCode(err, secretsmanager.ErrCodeInvalidRequestException) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get value of secret %q: %v", name, err)
+	}
+
+	var credentials Credentials
+	if err := json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &credentials); err != nil {
+		// a value of another layout is replaced by the next write
+		a.logger.Warningf("could not parse value of secret %q: %v", name, err)
+		return &Credentials{}, nil
+	}
+
+	return &credentials, nil
+}
+
+// WriteCredentials stores the credentials in the secret and tags it, the secret is created or restored as needed.
+func (a *API) WriteCredentials(name string, credentials Credentials, tags map[string]string) error {
+	client, err := a.connect()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(credentials)
+	if err != nil {
+		return fmt.Errorf("could not marshal credentials: %v", err)
+	}
+
+	description, err := client.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if isErrorCode(err, secretsmanager.ErrCodeResourceNotFoundException) {
+		input := &secretsmanager.CreateSecretInput{
+			Name:         aws.String(name),
+			Description:  aws.String("credentials of a Postgres role, managed by the Postgres operator"),
+			SecretString: aws.String(string(value)),
+			Tags:         secretTags(tags),
+		}
+		if a.kmsKeyID != "" {
+			input.KmsKeyId = aws.String(a.kmsKeyID)
+		}
+		if _, err := client.CreateSecret(input); err != nil {
+			return fmt.Errorf("could not create secret %q: %v", name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not describe secret %q: %v", name, err)
+	}
+
+	// the secret of a role removed from the manifest and added again
+	if description.DeletedDate != nil {
+		if _, err := client.RestoreSecret(&secretsmanager.RestoreSecretInput{SecretId: aws.String(name)}); err != nil {
+			return fmt.Errorf("could not restore secret %q: %v", name, err)
+		}
+	}
+	if _, err := client.PutSecretValue(&secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(name),
+		SecretString: aws.String(string(value)),
+	}); err != nil {
+		return fmt.Errorf("could not put value of secret %q: %v", name, err)
+	}
+	if _, err := client.TagResource(&secretsmanager.TagResourceInput{
+		SecretId: aws.String(name),
+		Tags:     secretTags(tags),
+	}); err != nil {
+		return fmt.Errorf("could not tag secret %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// DeleteCredentials deletes the secret, a missing one is no error.
+func (a *API) DeleteCredentials(name string) error {
+	client, err := a.connect()
+	if err != nil {
+		return err
+	}
+	input := &secretsmanager.DeleteSecretInput{SecretId: aws.String(name)}
+	if a.recoveryWindowDays == 0 {
+		input.ForceDeleteWithoutRecovery = aws.Bool(true)
+	} else {
+		input.RecoveryWindowInDays = aws.Int64(a.recoveryWindowDays)
+	}
+	if _, err := client.DeleteSecret(input); err != nil && !isErrorCode(err, secretsmanager.ErrCodeResourceNotFoundException) {
+		return fmt.Errorf("could not delete secret %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// secretTags converts the tags to the ones of the API, sorted by their keys.
+func secretTags(tags map[string]string) []*secretsmanager.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]*secretsmanager.Tag, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, &secretsmanager.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+func isErrorCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
@@ -0,0 +1,158 @@
+package awssecrets
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// fakeSecretsManager keeps the values of the secrets in memory and records the calls.
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	values  map[string]string
+	deleted map[string]bool
+	calls   []string
+	delete  *secretsmanager.DeleteSecretInput
+}
+
+func notFound() error {
+	return awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+}
+
+func (f *fakeSecretsManager) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	f.calls = append(f.calls, "get")
+	value, ok := f.values[*input.SecretId]
+	if !ok {
+		return nil, notFound()
+	}
+	if f.deleted[*input.SecretId] {
+		return nil, awserr.New(secretsmanager.ErrCodeInvalidRequestException, "secret marked for deletion", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+}
+
+func (f *fakeSecretsManager) DescribeSecret(input *secretsmanager.DescribeSecretInput) (*secretsmanager.DescribeSecretOutput, error) {
+	f.calls = append(f.calls, "describe")
+	if _, ok := f.values[*input.SecretId]; !ok {
+		return nil, notFound()
+	}
+	output := &secretsmanager.DescribeSecretOutput{Name: input.SecretId}
+	if f.deleted[*input.SecretId] {
+		output.DeletedDate = aws.Time(time.Now())
+	}
+	return output, nil
+}
+
+func (f *fakeSecretsManager) CreateSecret(input *secretsmanager.CreateSecretInput) (*secretsmanager.CreateSecretOutput, error) {
+	f.calls = append(f.calls, "create")
+	f.values[*input.Name] = *input.SecretString
+	return &secretsmanager.CreateSecretOutput{}, nil
+}
+
+func (f *fakeSecretsManager) RestoreSecret(input *secretsmanager.RestoreSecretInput) (*secretsmanager.RestoreSecretOutput, error) {
+	f.calls = append(f.calls, "restore")
+	delete(f.deleted, *input.SecretId)
+	return &secretsmanager.RestoreSecretOutput{}, nil
+}
+
+func (f *fakeSecretsManager) PutSecretValue(input *secretsmanager.PutSecretValueInput) (*secretsmanager.PutSecretValueOutput, error) {
+	f.calls = append(f.calls, "put")
+	f.values[*input.SecretId] = *input.SecretString
+	return &secretsmanager.PutSecretValueOutput{}, nil
+}
+
+func (f *fakeSecretsManager) TagResource(input *secretsmanager.TagResourceInput) (*secretsmanager.TagResourceOutput, error) {
+	f.calls = append(f.calls, "tag")
+	return &secretsmanager.TagResourceOutput{}, nil
+}
+
+func (f *fakeSecretsManager) DeleteSecret(input *secretsmanager.DeleteSecretInput) (*secretsmanager.DeleteSecretOutput, error) {
+	f.calls = append(f.calls, "delete")
+	f.delete = input
+	if _, ok := f.values[*input.SecretId]; !ok {
+		return nil, notFound()
+	}
+	f.deleted[*input.SecretId] = true
+	return &secretsmanager.DeleteSecretOutput{}, nil
+}
+
+func newTestAPI(values map[string]string, deleted map[string]bool, recoveryWindowDays int64) (*API, *fakeSecretsManager) {
+	fake := &fakeSecretsManager{values: values, deleted: deleted}
+	api := NewSecretsManagerAPI("eu-central-1", "", "", "", recoveryWindowDays, logrus.New().WithField("test", "awssecrets"))
+	api.client = fake
+	return api, fake
+}
+
+func TestWriteCredentials(t *testing.T) {
+	credentials := Credentials{Engine: "postgres", Host: "acid-test.test.svc.cluster.local", Port: 5432,
+		Username: "app", Password: "secret", DBName: "app"}
+	tests := []struct {
+		about   string
+		values  map[string]string
+		deleted map[string]bool
+		calls   []string
+	}{
+		{"new secret", map[string]string{}, map[string]bool{}, []string{"describe", "create"}},
+		{"existing secret", map[string]string{"app": "{}"}, map[string]bool{}, []string{"describe", "put", "tag"}},
+		{"deleted secret", map[string]string{"app": "{}"}, map[string]bool{"app": true},
+			[]string{"describe", "restore", "put", "tag"}},
+	}
+	for _, tt := range tests {
+		api, fake := newTestAPI(tt.values, tt.deleted, 30)
+		if err := api.WriteCredentials("app", credentials, map[string]string{"cluster": "acid-test"}); err != nil {
+			t.Errorf("TestWriteCredentials %s: unexpected error: %v", tt.about, err)
+			continue
+		}
+		if !reflect.DeepEqual(fake.calls, tt.calls) {
+			t.Errorf("TestWriteCredentials %s: expected calls %v, got %v", tt.about, tt.calls, fake.calls)
+		}
+		stored, err := api.ReadCredentials("app")
+		if err != nil || stored == nil || *stored != credentials {
+			t.Errorf("TestWriteCredentials %s: expected credentials %#v, got %#v (%v)", tt.about, credentials, stored, err)
+		}
+	}
+}
+
+func TestReadCredentials(t *testing.T) {
+	api, _ := newTestAPI(map[string]string{"deleted": "{}", "other": "not json"}, map[string]bool{"deleted": true}, 30)
+	for _, name := range []string{"missing", "deleted"} {
+		if stored, err := api.ReadCredentials(name); err != nil || stored != nil {
+			t.Errorf("TestReadCredentials %s: expected no credentials, got %#v (%v)", name, stored, err)
+		}
+	}
+	if stored, err := api.ReadCredentials("other"); err != nil || stored == nil || *stored != (Credentials{}) {
+		t.Errorf("TestReadCredentials other: expected empty credentials, got %#v (%v)", stored, err)
+	}
+}
+
+func TestDeleteCredentials(t *testing.T) {
+	tests := []struct {
+		about              string
+		recoveryWindowDays int64
+		force              bool
+	}{
+		{"recovery window", 7, false},
+		{"no recovery window", 0, true},
+	}
+	for _, tt := range tests {
+		api, fake := newTestAPI(map[string]string{"app": "{}"}, map[string]bool{}, tt.recoveryWindowDays)
+		if err := api.DeleteCredentials("app"); err != nil {
+			t.Errorf("TestDeleteCredentials %s: unexpected error: %v", tt.about, err)
+		}
+		if force := aws.BoolValue(fake.delete.ForceDeleteWithoutRecovery); force != tt.force {
+			t.Errorf("TestDeleteCredentials %s: expected force %t, got %t", tt.about, tt.force, force)
+		}
+		if days := aws.Int64Value(fake.delete.RecoveryWindowInDays); !tt.force && days != tt.recoveryWindowDays {
+			t.Errorf("TestDeleteCredentials %s: expected recovery window %d, got %d", tt.about, tt.recoveryWindowDays, days)
+		}
+		if err := api.DeleteCredentials("missing"); err != nil {
+			t.Errorf("TestDeleteCredentials %s: unexpected error for a missing secret: %v", tt.about, err)
+		}
+	}
+}
@@ -6,10 +6,47 @@ import (
 	"time"
 
 	"fmt"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
 )
 
+// possible reactions to the running postgres major version not matching the manifest
+const (
+	PgVersionMismatchActionWarn    = "warn"
+	PgVersionMismatchActionDegrade = "degrade"
+
+	InfrastructureRolesSecretFormatKeys = "keys"
+	InfrastructureRolesSecretFormatJSON = "json"
+
+	TargetSessionAttrsAny       = "any"
+	TargetSessionAttrsReadWrite = "read-write"
+
+	VolumeResizeModeProvider = "provider"
+	VolumeResizeModePVC      = "pvc"
+
+	FilesystemResizeModeExec = "exec"
+	FilesystemResizeModeJob  = "job"
+
+	RemovedRoleActionDrop   = "drop"
+	RemovedRoleActionLock   = "lock"
+	RemovedRoleActionIgnore = "ignore"
+
+	PasswordRotationModeInPlace      = "in-place"
+	PasswordRotationModeRotationUser = "rotation-user"
+
+	PasswordEncryptionMD5         = "md5"
+	PasswordEncryptionSCRAMSHA256 = "scram-sha-256"
+
+	CredentialsStoreVault             = "vault"
+	CredentialsStoreAWSSecretsManager = "aws-secrets-manager"
+)
+
+// the keys of a secret must consist of alphanumeric characters, '-', '_' or '.'
+var secretKeyRegexp = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
 // CRD describes CustomResourceDefinition specific configuration parameters
 type CRD struct {
 	ReadyWaitInterval time.Duration `name:"ready_wait_interval" default:"4s"`
@@ -24,14 +61,19 @@ type Resources struct {
 	PodLabelWaitTimeout     time.Duration     `name:"pod_label_wait_timeout" default:"10m"`
 	PodDeletionWaitTimeout  time.Duration     `name:"pod_deletion_wait_timeout" default:"10m"`
 	PodTerminateGracePeriod time.Duration     `name:"pod_terminate_grace_period" default:"5m"`
+	PgStatementTimeout      time.Duration     `name:"postgres_statement_timeout" default:"1m"`
+	PgTargetSessionAttrs    string            `name:"postgres_target_session_attrs" default:"read-write"`
+	PodMaxUnavailable       string            `name:"pod_max_unavailable" default:"1"`
 	ClusterLabels           map[string]string `name:"cluster_labels" default:"application:spilo"`
 	ClusterNameLabel        string            `name:"cluster_name_label" default:"cluster-name"`
 	PodRoleLabel            string            `name:"pod_role_label" default:"spilo-role"`
+	PodRoleLabelFallbacks   []string          `name:"pod_role_label_fallbacks" default:"role"`
 	PodToleration           map[string]string `name:"toleration" default:""`
 	DefaultCPURequest       string            `name:"default_cpu_request" default:"100m"`
 	DefaultMemoryRequest    string            `name:"default_memory_request" default:"100Mi"`
 	DefaultCPULimit         string            `name:"default_cpu_limit" default:"3"`
 	DefaultMemoryLimit      string            `name:"default_memory_limit" default:"1Gi"`
+	MaxVolumeSize           string            `name:"max_volume_size" default:""`
 	PodEnvironmentConfigMap string            `name:"pod_environment_configmap" default:""`
 	NodeReadinessLabel      map[string]string `name:"node_readiness_label" default:""`
 	MaxInstances            int32             `name:"max_instances" default:"-1"`
@@ -46,6 +88,7 @@ type Auth struct {
 	TeamsAPIUrl                   string              `name:"teams_api_url" default:"https://teams.example.com/api/"`
 	OAuthTokenSecretName          spec.NamespacedName `name:"oauth_token_secret_name" default:"postgresql-operator"`
 	InfrastructureRolesSecretName spec.NamespacedName `name:"infrastructure_roles_secret_name"`
+	InfrastructureRolesFormat     string              `name:"infrastructure_roles_secret_format" default:"keys"`
 	SuperUsername                 string              `name:"super_username" default:"postgres"`
 	ReplicationUsername           string              `name:"replication_username" default:"standby"`
 }
@@ -99,7 +142,96 @@ type Config struct {
 	ClusterHistoryEntries    int               `name:"cluster_history_entries" default:"1000"`
 	TeamAPIRoleConfiguration map[string]string `name:"team_api_role_configuration" default:"log_statement:all"`
 	PodTerminateGracePeriod  time.Duration     `name:"pod_terminate_grace_period" default:"5m"`
-	ProtectedRoles           []string          `name:"protected_role_names" default:"admin"`
+	ProtectedRoles           []string          `name:"protected_role_names" default:"postgres,standby,admin"`
+	PgVersionMismatchAction  string            `name:"pg_version_mismatch_action" default:"warn"`
+	// 0 keeps the default of the Spilo image
+	SuperuserReservedConnections uint32 `name:"superuser_reserved_connections" default:"0"`
+	// commands to run inside the postgres container, when empty the operator figures them out on its own
+	FilesystemInfoCommand   stringTemplate `name:"filesystem_info_command" default:""`
+	FilesystemResizeCommand stringTemplate `name:"filesystem_resize_command" default:""`
+	// OTLP/HTTP endpoint to export the reconcile traces to, tracing is disabled when empty
+	OTLPTracesEndpoint string `name:"otlp_traces_endpoint" default:""`
+	// sample the resource usage of the pods on every sync to recommend resource requests
+	ResourceRecommendations bool `name:"enable_resource_recommendations" default:"false"`
+	// remove the statefulset, pods and volumes of a failed clone, so that the next attempt starts clean
+	FailedCloneCleanup bool `name:"enable_failed_clone_cleanup" default:"false"`
+	// apply the changed default image and resources to the existing clusters that do not set their own
+	PropagateDefaults bool `name:"propagate_operator_defaults" default:"true"`
+	// stop the pods by scaling the statefulset to zero before deleting a cluster, optionally pausing Patroni first
+	DeleteScaleDown      bool `name:"enable_delete_scale_down" default:"true"`
+	DeletePauseFailovers bool `name:"enable_delete_pause_failovers" default:"false"`
+	// WAL retained by a logical replication slot that marks the cluster degraded, no limit when empty
+	LogicalSlotMaxRetainedWAL string `name:"logical_slot_max_retained_wal" default:""`
+	// the cron job dumping the clusters with the logical backup enabled to S3
+	LogicalBackupSchedule    string `name:"logical_backup_schedule" default:"30 00 * * *"`
+	LogicalBackupDockerImage string `name:"logical_backup_docker_image" default:"registry.opensource.zalan.do/acid/logical-backup"`
+	LogicalBackupS3Bucket    string `name:"logical_backup_s3_bucket" default:""`
+	// how often the base backups beyond the retention of the manifest are removed
+	BackupPruneInterval time.Duration `name:"backup_prune_interval" default:"24h"`
+	// take a base backup before deleting a cluster and keep its resources when that fails
+	FinalBackupOnDelete bool `name:"final_backup_on_delete" default:"false"`
+	// how often the WAL archive of the clusters asking for it is verified
+	WALVerifyInterval time.Duration `name:"wal_verify_interval" default:"24h"`
+	// how often the backups of the clusters asking for it are restored into a throwaway cluster
+	RestoreVerifyInterval time.Duration `name:"restore_verify_interval" default:"168h"`
+	// how long the throwaway cluster may take to start before the test restore counts as failed
+	RestoreVerifyTimeout time.Duration `name:"restore_verify_timeout" default:"2h"`
+	// project of the GCE persistent disks to resize, the one of the metadata server when empty
+	GCEProject string `name:"gce_project" default:""`
+	// resize the volumes through the API of the cloud provider or by growing the persistent volume claims
+	VolumeResizeMode string `name:"volume_resize_mode" default:"provider"`
+	// volume resizers tried in turn on each persistent volume in the provider mode
+	VolumeResizers []string `name:"volume_resizers" default:"ebs,gce,azure"`
+	// tag the provider volumes with the name, the namespace and the team of their cluster
+	EnableVolumeTagging bool `name:"enable_volume_tagging" default:"false"`
+	// number of volumes of a cluster resized at the same time
+	VolumeResizeWorkers uint32 `name:"volume_resize_workers" default:"4"`
+	// delete the persistent volume claims and volumes of a deleted cluster, otherwise they are retained
+	DeleteVolumesOnDelete bool `name:"delete_volumes_on_delete" default:"true"`
+	// role assumed by the EBS resizer, for volumes living in another AWS account than the operator
+	AWSRoleARN string `name:"aws_role_arn" default:""`
+	// EC2 endpoint used by the EBS resizer instead of the public one of the region, e.g. a VPC endpoint
+	AWSEC2Endpoint string `name:"aws_ec2_endpoint" default:""`
+	// HTTP proxy the EBS resizer reaches the AWS APIs through
+	AWSProxy string `name:"aws_proxy" default:""`
+	// snapshot each volume right before it is resized, the snapshots are listed in the status of the cluster
+	SnapshotVolumesBeforeResize bool `name:"snapshot_volumes_before_resize" default:"false"`
+	// how the filesystems of the grown volumes are resized: by exec into the Postgres container or by a privileged Job
+	FilesystemResizeMode string `name:"filesystem_resize_mode" default:"exec"`
+	// image of the filesystem resize Jobs, the docker_image when empty
+	FilesystemResizeJobImage string `name:"filesystem_resize_job_image" default:""`
+	// what happens to the roles removed from the users section of a manifest and to their secrets
+	RemovedRoleAction string `name:"removed_role_action" default:"ignore"`
+	// days after which the passwords of the roles from the manifests are rotated, 0 disables the rotation
+	PasswordRotationIntervalDays uint32 `name:"password_rotation_interval_days" default:"0"`
+	// whether the rotation changes the password of the role or hands out a new role inheriting from it
+	PasswordRotationMode string `name:"password_rotation_mode" default:"in-place"`
+	// method the passwords of the roles are hashed with, scram-sha-256 requires Postgres 10
+	PasswordEncryption string `name:"password_encryption" default:"md5"`
+	// additional keys of the user secrets, i.e. connection URIs, formatted from the credentials and the cluster
+	SecretDataTemplate secretDataTemplate `name:"secret_data_template" default:""`
+	// create the secrets of the manifest users of the form namespace.user in the namespace given by their names
+	EnableCrossNamespaceSecret bool `name:"enable_cross_namespace_secret" default:"false"`
+	// external store the credentials of the roles are written to, none when empty
+	CredentialsStore string `name:"credentials_store" default:""`
+	// address of Vault, the token with the access to the paths is in the token key of the secret
+	VaultAddress         string              `name:"vault_address" default:""`
+	VaultTokenSecretName spec.NamespacedName `name:"vault_token_secret_name" default:"postgresql-operator-vault"`
+	// version of the key/value secrets engine the credentials are stored in
+	VaultKVVersion    int            `name:"vault_kv_version" default:"2"`
+	VaultPathTemplate stringTemplate `name:"vault_path_template" default:"secret/data/postgres/{namespace}/{cluster}/{username}"`
+	// keep the credentials of the manifest users in Vault only, without Kubernetes secrets
+	VaultReplaceUserSecrets bool `name:"vault_replace_user_secrets" default:"false"`
+	// name of the secret of AWS Secrets Manager the credentials of a role are stored in
+	AWSSecretsManagerNameTemplate stringTemplate `name:"aws_secrets_manager_name_template" default:"postgres/{namespace}/{cluster}/{username}"`
+	// KMS key the secrets are encrypted with, the default key of the account when empty
+	AWSSecretsManagerKMSKeyID string `name:"aws_secrets_manager_kms_key_id" default:""`
+	// days the deleted secrets can be restored within, 0 deletes them at once
+	AWSSecretsManagerRecoveryWindowDays int64 `name:"aws_secrets_manager_recovery_window_days" default:"30"`
+	// flags of the team members besides LOGIN, enable_team_superuser and team_admin_role apply when none are given
+	TeamMemberFlags []string `name:"team_member_flags" default:""`
+	// roles granted to the team members besides the pam role, instead of team_admin_role
+	TeamMemberRoles []string `name:"team_member_roles" default:""`
 }
 
 // MustMarshal marshals the config or panics
@@ -159,5 +291,64 @@ func validate(cfg *Config) (err error) {
 	if cfg.Workers == 0 {
 		err = fmt.Errorf("number of workers should be higher than 0")
 	}
+	if cfg.PgVersionMismatchAction != "" &&
+		cfg.PgVersionMismatchAction != PgVersionMismatchActionWarn &&
+		cfg.PgVersionMismatchAction != PgVersionMismatchActionDegrade {
+		err = fmt.Errorf("pg_version_mismatch_action must be either %q or %q, got %q",
+			PgVersionMismatchActionWarn, PgVersionMismatchActionDegrade, cfg.PgVersionMismatchAction)
+	}
+	if cfg.InfrastructureRolesFormat != InfrastructureRolesSecretFormatKeys &&
+		cfg.InfrastructureRolesFormat != InfrastructureRolesSecretFormatJSON {
+		err = fmt.Errorf("infrastructure_roles_secret_format must be either %q or %q, got %q",
+			InfrastructureRolesSecretFormatKeys, InfrastructureRolesSecretFormatJSON, cfg.InfrastructureRolesFormat)
+	}
+	if cfg.PgTargetSessionAttrs != TargetSessionAttrsAny && cfg.PgTargetSessionAttrs != TargetSessionAttrsReadWrite {
+		err = fmt.Errorf("postgres_target_session_attrs must be either %q or %q, got %q",
+			TargetSessionAttrsAny, TargetSessionAttrsReadWrite, cfg.PgTargetSessionAttrs)
+	}
+	if cfg.VolumeResizeMode != VolumeResizeModeProvider && cfg.VolumeResizeMode != VolumeResizeModePVC {
+		err = fmt.Errorf("volume_resize_mode must be either %q or %q, got %q",
+			VolumeResizeModeProvider, VolumeResizeModePVC, cfg.VolumeResizeMode)
+	}
+	if cfg.FilesystemResizeMode != FilesystemResizeModeExec && cfg.FilesystemResizeMode != FilesystemResizeModeJob {
+		err = fmt.Errorf("filesystem_resize_mode must be either %q or %q, got %q",
+			FilesystemResizeModeExec, FilesystemResizeModeJob, cfg.FilesystemResizeMode)
+	}
+	if cfg.RemovedRoleAction != RemovedRoleActionDrop && cfg.RemovedRoleAction != RemovedRoleActionLock &&
+		cfg.RemovedRoleAction != RemovedRoleActionIgnore {
+		err = fmt.Errorf("removed_role_action must be one of %q, %q or %q, got %q",
+			RemovedRoleActionDrop, RemovedRoleActionLock, RemovedRoleActionIgnore, cfg.RemovedRoleAction)
+	}
+	if cfg.PasswordRotationMode != PasswordRotationModeInPlace &&
+		cfg.PasswordRotationMode != PasswordRotationModeRotationUser {
+		err = fmt.Errorf("password_rotation_mode must be either %q or %q, got %q",
+			PasswordRotationModeInPlace, PasswordRotationModeRotationUser, cfg.PasswordRotationMode)
+	}
+	if cfg.PasswordEncryption != PasswordEncryptionMD5 && cfg.PasswordEncryption != PasswordEncryptionSCRAMSHA256 {
+		err = fmt.Errorf("password_encryption must be either %q or %q, got %q",
+			PasswordEncryptionMD5, PasswordEncryptionSCRAMSHA256, cfg.PasswordEncryption)
+	}
+	for key := range cfg.SecretDataTemplate {
+		if key == "username" || key == "password" || !secretKeyRegexp.MatchString(key) {
+			err = fmt.Errorf("invalid key %q of secret_data_template", key)
+		}
+	}
+	if cfg.CredentialsStore != "" && cfg.CredentialsStore != CredentialsStoreVault &&
+		cfg.CredentialsStore != CredentialsStoreAWSSecretsManager {
+		err = fmt.Errorf("credentials_store must be either empty, %q or %q, got %q",
+			CredentialsStoreVault, CredentialsStoreAWSSecretsManager, cfg.CredentialsStore)
+	}
+	if cfg.CredentialsStore == CredentialsStoreVault && cfg.VaultAddress == "" {
+		err = fmt.Errorf("vault_address must be set for the %q credentials store", CredentialsStoreVault)
+	}
+	if cfg.VaultKVVersion != 1 && cfg.VaultKVVersion != 2 {
+		err = fmt.Errorf("vault_kv_version must be either 1 or 2, got %d", cfg.VaultKVVersion)
+	}
+	if w := cfg.AWSSecretsManagerRecoveryWindowDays; w != 0 && (w < 7 || w > 30) {
+		err = fmt.Errorf("aws_secrets_manager_recovery_window_days must be either 0 or between 7 and 30, got %d", w)
+	}
+	if maxUnavailableErr := spec.ValidateMaxUnavailable(intstr.Parse(cfg.PodMaxUnavailable)); maxUnavailableErr != nil {
+		err = fmt.Errorf("invalid pod_max_unavailable: %v", maxUnavailableErr)
+	}
 	return
 }
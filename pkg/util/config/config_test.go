@@ -29,3 +29,21 @@ func TestGetMapPairsFromString(t *testing.T) {
 		}
 	}
 }
+
+func TestSecretDataTemplateDecode(t *testing.T) {
+	var template secretDataTemplate
+	value := `{"uri": "postgresql://{username}:{password}@{host}:{port}/{database}", "port": "{port}"}`
+	if err := template.Decode(value); err != nil {
+		t.Fatalf("TestSecretDataTemplateDecode: unexpected error: %v", err)
+	}
+	expected := secretDataTemplate{
+		"uri":  "postgresql://{username}:{password}@{host}:{port}/{database}",
+		"port": "{port}",
+	}
+	if !reflect.DeepEqual(template, expected) {
+		t.Errorf("TestSecretDataTemplateDecode: expected %#v, got %#v", expected, template)
+	}
+	if err := template.Decode("uri:postgresql://{host}"); err == nil {
+		t.Errorf("TestSecretDataTemplateDecode: expected an error for a value that is not a JSON object")
+	}
+}
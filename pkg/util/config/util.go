@@ -21,6 +21,10 @@ type fieldInfo struct {
 
 type stringTemplate string
 
+// secretDataTemplate maps the keys of the generated user secrets to the templates of their values, given as a JSON
+// object since the values, like connection URIs, contain the separators of the other maps of the configuration
+type secretDataTemplate map[string]stringTemplate
+
 func decoderFrom(field reflect.Value) (d decoder) {
 	// it may be impossible for a struct field to fail this check
 	if !field.CanInterface() {
@@ -241,3 +245,16 @@ func (f *stringTemplate) Format(a ...string) string {
 func (f stringTemplate) MarshalJSON() ([]byte, error) {
 	return json.Marshal(string(f))
 }
+
+func (t *secretDataTemplate) Decode(value string) error {
+	var templates map[string]string
+	if err := json.Unmarshal([]byte(value), &templates); err != nil {
+		return fmt.Errorf("could not parse secret data template %q: %v", value, err)
+	}
+	*t = make(secretDataTemplate, len(templates))
+	for key, template := range templates {
+		(*t)[key] = stringTemplate(template)
+	}
+
+	return nil
+}
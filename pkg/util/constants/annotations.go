@@ -7,4 +7,13 @@ const (
 	ElbTimeoutAnnotationValue          = "3600"
 	KubeIAmAnnotation                  = "iam.amazonaws.com/role"
 	VolumeStorateProvisionerAnnotation = "pv.kubernetes.io/provisioned-by"
+	VolumeTypeAnnotation               = "acid.zalan.do/volume-type"
+	VolumeTagsAnnotation               = "acid.zalan.do/volume-tags"
+	// set to "true" on the postgresql object to shrink the volumes by recreating them one pod at a time
+	ForceVolumeShrinkAnnotation = "acid.zalan.do/force-volume-shrink"
+	// set by the operator on the secrets of the roles to the time the password has last been rotated, in RFC 3339
+	PasswordRotatedAtAnnotation = "acid.zalan.do/password-rotated-at"
+	// set on the postgresql object to any new value to rotate the passwords of the superuser and the replication user,
+	// the operator records the value it has handled on their secrets
+	RotateSystemPasswordsAnnotation = "acid.zalan.do/rotate-system-passwords"
 )
@@ -14,4 +14,8 @@ const (
 	EBSVolumeStateCompleted     = "completed"
 	EBSVolumeResizeWaitInterval = 2 * time.Second
 	EBSVolumeResizeWaitTimeout  = 30 * time.Second
+	// a change of the volume type takes longer to reach the optimizing state
+	EBSVolumeTypeChangeWaitTimeout = 10 * time.Minute
+	// EBS volumes can only be modified once in 6 hours
+	EBSVolumeModificationCooldown = 6 * time.Hour
 )
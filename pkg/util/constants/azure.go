@@ -0,0 +1,11 @@
+package constants
+
+import "time"
+
+// Azure specific constants used by other modules
+const (
+	// Azure disk related constants
+	AzureDiskProvisioner     = "kubernetes.io/azure-disk"
+	AzureManagedDiskIDPart   = "/providers/Microsoft.Compute/disks/"
+	AzureVolumeResizeTimeout = 5 * time.Minute
+)
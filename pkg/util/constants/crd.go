@@ -8,3 +8,31 @@ const (
 	CRDGroup      = "acid.zalan.do"
 	CRDApiVersion = "v1"
 )
+
+// Properties of the Custom Resource Definition for the on-demand backups
+const (
+	BackupCRDKind     = "postgresbackup"
+	BackupCRDResource = "postgresbackups"
+	BackupCRDShort    = "pgbackup"
+)
+
+// Properties of the Custom Resource Definition for the restores into new clusters
+const (
+	RestoreCRDKind     = "postgresrestore"
+	RestoreCRDResource = "postgresrestores"
+	RestoreCRDShort    = "pgrestore"
+)
+
+// Properties of the Custom Resource Definition for the roles created on every cluster
+const (
+	InfrastructureRoleCRDKind     = "infrastructurerole"
+	InfrastructureRoleCRDResource = "infrastructureroles"
+	InfrastructureRoleCRDShort    = "pginfrarole"
+)
+
+// Properties of the VolumeSnapshot resource of the CSI external snapshotter
+const (
+	VolumeSnapshotGroup      = "snapshot.storage.k8s.io"
+	VolumeSnapshotApiVersion = "v1alpha1"
+	VolumeSnapshotResource   = "volumesnapshots"
+)
@@ -0,0 +1,13 @@
+package constants
+
+import "time"
+
+// GCE specific constants used by other modules
+const (
+	// GCE persistent disk related constants
+	GCEPDProvisioner            = "kubernetes.io/gce-pd"
+	GCEZoneLabel                = "failure-domain.beta.kubernetes.io/zone"
+	GCEOperationStatusDone      = "DONE"
+	GCEVolumeResizeWaitInterval = 2 * time.Second
+	GCEVolumeResizeWaitTimeout  = 60 * time.Second
+)
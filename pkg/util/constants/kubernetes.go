@@ -12,4 +12,11 @@ const (
 	QueueResyncPeriodPod  = 5 * time.Minute
 	QueueResyncPeriodTPR  = 5 * time.Minute
 	QueueResyncPeriodNode = 5 * time.Minute
+
+	// set on the filesystem jobs and their pods to the name of the Postgres pod they work for, instead of the labels
+	// of the cluster, which would make them look like the pods of the cluster
+	FilesystemJobLabel = "acid.zalan.do/filesystem-job-of"
+	// set on the secrets of the users of the manifest to the name of the role, so that the roles removed from the
+	// manifest are found again by the syncs
+	ManifestRoleLabel = "acid.zalan.do/manifest-role"
 )
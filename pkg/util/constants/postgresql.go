@@ -6,7 +6,12 @@ import "time"
 const (
 	DataVolumeName    = "pgdata"
 	PostgresDataMount = "/home/postgres/pgdata"
-	PostgresDataPath  = PostgresDataMount + "/pgroot"
+	PostgresDataRoot  = "pgroot"
+	PostgresDataPath  = PostgresDataMount + "/" + PostgresDataRoot
+
+	// every tablespace has a volume of its own, mounted under the tablespaces directory
+	TablespaceVolumePrefix = "tablespace-"
+	TablespacesMount       = "/home/postgres/tablespaces"
 
 	PostgresConnectRetryTimeout = 2 * time.Minute
 	PostgresConnectTimeout      = 15 * time.Second
@@ -7,10 +7,13 @@ const (
 	ReplicationUserKeyName = "replication"
 	RoleFlagSuperuser      = "SUPERUSER"
 	RoleFlagInherit        = "INHERIT"
+	RoleFlagNoInherit      = "NOINHERIT"
 	RoleFlagLogin          = "LOGIN"
 	RoleFlagNoLogin        = "NOLOGIN"
 	RoleFlagCreateRole     = "CREATEROLE"
 	RoleFlagCreateDB       = "CREATEDB"
 	RoleFlagReplication    = "REPLICATION"
 	RoleFlagByPassRLS      = "BYPASSRLS"
+	// prefix of the entries of the manifest users naming the roles they are members of, i.e. inrole:readonly
+	RoleMembershipPrefix = "inrole:"
 )
@@ -0,0 +1,40 @@
+package filesystems
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	xfsSuccessRegexp = regexp.MustCompile(`data blocks changed from \d+ to \d+`)
+)
+
+const (
+	xfs       = "xfs"
+	xfsGrowfs = "xfs_growfs"
+)
+
+// XFSResize implements the FilesystemResizer interface for XFS. Unlike resize2fs, xfs_growfs works on the mount point
+// of the filesystem rather than on its device.
+type XFSResize struct {
+	MountPoint string
+}
+
+// CanResizeFilesystem checks whether XFSResize can resize this filesystem.
+func (c *XFSResize) CanResizeFilesystem(fstype string) bool {
+	return fstype == xfs
+}
+
+// ResizeFilesystem calls xfs_growfs to grow the filesystem to the size of its device if necessary.
+func (c *XFSResize) ResizeFilesystem(deviceName string, commandExecutor func(cmd string) (out string, err error)) error {
+	command := fmt.Sprintf("%s %s 2>&1", xfsGrowfs, c.MountPoint)
+	out, err := commandExecutor(command)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(out, "data size unchanged") || xfsSuccessRegexp.MatchString(out) {
+		return nil
+	}
+	return fmt.Errorf("unrecognized output: %q, assuming error", out)
+}
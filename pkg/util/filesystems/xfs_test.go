@@ -0,0 +1,36 @@
+package filesystems
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestXFSResizeFilesystem(t *testing.T) {
+	header := "meta-data=/dev/xvdb              isize=512    agcount=4, agsize=655360 blks\n" +
+		"data     =                       bsize=4096   blocks=2621440, imaxpct=25\n"
+	tests := []struct {
+		about  string
+		out    string
+		outErr error
+		err    bool
+	}{
+		{"grown", header + "data blocks changed from 2621440 to 5242880\n", nil, false},
+		{"already of the device size", header + "data size unchanged, skipping\n", nil, false},
+		{"not mounted", "xfs_growfs: /home/postgres/pgdata is not a mounted XFS filesystem\n", nil, true},
+		{"command failed", "", fmt.Errorf("command terminated with exit code 1"), true},
+	}
+	for _, tt := range tests {
+		var command string
+		resizer := &XFSResize{MountPoint: "/home/postgres/pgdata"}
+		err := resizer.ResizeFilesystem("/dev/xvdb", func(cmd string) (string, error) {
+			command = cmd
+			return tt.out, tt.outErr
+		})
+		if (err != nil) != tt.err {
+			t.Errorf("TestXFSResizeFilesystem %s: expected error %t, got %v", tt.about, tt.err, err)
+		}
+		if command != "xfs_growfs /home/postgres/pgdata 2>&1" {
+			t.Errorf("TestXFSResizeFilesystem %s: unexpected command %q", tt.about, command)
+		}
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/typed/apps/v1beta1"
+	batchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+	batchv2alpha1 "k8s.io/client-go/kubernetes/typed/batch/v2alpha1"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	policyv1beta1 "k8s.io/client-go/kubernetes/typed/policy/v1beta1"
 	"k8s.io/client-go/pkg/api"
@@ -34,12 +36,16 @@ type KubernetesClient struct {
 	v1core.NodesGetter
 	v1core.NamespacesGetter
 	v1core.ServiceAccountsGetter
+	v1core.EventsGetter
 	v1beta1.StatefulSetsGetter
 	policyv1beta1.PodDisruptionBudgetsGetter
+	batchv1.JobsGetter
+	batchv2alpha1.CronJobsGetter
 	apiextbeta1.CustomResourceDefinitionsGetter
 
-	RESTClient rest.Interface
-	CRDREST    rest.Interface
+	RESTClient   rest.Interface
+	CRDREST      rest.Interface
+	SnapshotREST rest.Interface
 }
 
 // RestConfig creates REST config
@@ -61,6 +67,11 @@ func ResourceNotFound(err error) bool {
 	return apierrors.IsNotFound(err)
 }
 
+// ResourceAccessForbidden checks if error corresponds to the lack of permissions on the resource
+func ResourceAccessForbidden(err error) bool {
+	return apierrors.IsForbidden(err)
+}
+
 // NewFromConfig create Kubernets Interface using REST config
 func NewFromConfig(cfg *rest.Config) (KubernetesClient, error) {
 	kubeClient := KubernetesClient{}
@@ -80,8 +91,11 @@ func NewFromConfig(cfg *rest.Config) (KubernetesClient, error) {
 	kubeClient.PersistentVolumesGetter = client.CoreV1()
 	kubeClient.NodesGetter = client.CoreV1()
 	kubeClient.NamespacesGetter = client.CoreV1()
+	kubeClient.EventsGetter = client.CoreV1()
 	kubeClient.StatefulSetsGetter = client.AppsV1beta1()
 	kubeClient.PodDisruptionBudgetsGetter = client.PolicyV1beta1()
+	kubeClient.JobsGetter = client.BatchV1()
+	kubeClient.CronJobsGetter = client.BatchV2alpha1()
 	kubeClient.RESTClient = client.CoreV1().RESTClient()
 
 	cfg2 := *cfg
@@ -98,6 +112,17 @@ func NewFromConfig(cfg *rest.Config) (KubernetesClient, error) {
 	}
 	kubeClient.CRDREST = crd
 
+	cfg3 := cfg2
+	cfg3.GroupVersion = &schema.GroupVersion{
+		Group:   constants.VolumeSnapshotGroup,
+		Version: constants.VolumeSnapshotApiVersion,
+	}
+	snapshot, err := rest.RESTClientFor(&cfg3)
+	if err != nil {
+		return kubeClient, fmt.Errorf("could not get volume snapshot rest client: %v", err)
+	}
+	kubeClient.SnapshotREST = snapshot
+
 	apiextClient, err := apiextclient.NewForConfig(cfg)
 	if err != nil {
 		return kubeClient, fmt.Errorf("could not create api client:%v", err)
@@ -116,6 +141,10 @@ func SameService(cur, new *v1.Service) (match bool, reason string) {
 			new.Spec.Type, cur.Spec.Type)
 	}
 
+	if !reflect.DeepEqual(cur.Spec.Selector, new.Spec.Selector) {
+		return false, "new service's selector doesn't match the current one"
+	}
+
 	oldSourceRanges := cur.Spec.LoadBalancerSourceRanges
 	newSourceRanges := new.Spec.LoadBalancerSourceRanges
 
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -15,6 +16,7 @@ import (
 const (
 	failoverPath = "/failover"
 	configPath   = "/config"
+	statusPath   = "/patroni"
 	apiPort      = 8008
 	timeout      = 30 * time.Second
 )
@@ -23,6 +25,36 @@ const (
 type Interface interface {
 	Switchover(master *v1.Pod, candidate string) error
 	SetPostgresParameters(server *v1.Pod, options map[string]string) error
+	GetMemberStatus(server *v1.Pod) (*MemberStatus, error)
+	Pause(server *v1.Pod) error
+	PromoteStandbyCluster(server *v1.Pod) error
+}
+
+// MemberStatus describes the state of a single Patroni member as reported by its API
+type MemberStatus struct {
+	State    string `json:"state"`
+	Role     string `json:"role"`
+	Timeline int    `json:"timeline"`
+	// set when no member holds the leader lock
+	ClusterUnlocked bool `json:"cluster_unlocked"`
+	// unix time of the last successful contact of the member with the DCS
+	DCSLastSeen int64 `json:"dcs_last_seen"`
+}
+
+// DCSUnreachable tells whether the member has not reached the DCS for longer than the given period while the cluster
+// has no leader. Patroni keeps answering the status requests in that case, only the status itself tells about it.
+func (s *MemberStatus) DCSUnreachable(now time.Time, period time.Duration) bool {
+	if !s.ClusterUnlocked || s.DCSLastSeen == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(s.DCSLastSeen, 0)) > period
+}
+
+// messages reported by Patroni when it cannot reach the DCS, i.e. when the DCS has lost its quorum
+var dcsErrorSignatures = []string{
+	"DCS is not accessible",
+	"Error communicating with DCS",
+	"failed to update leader lock",
 }
 
 // Patroni API client
@@ -94,3 +126,68 @@ func (p *Patroni) SetPostgresParameters(server *v1.Pod, parameters map[string]st
 	}
 	return p.httpPostOrPatch(http.MethodPatch, apiURL(server)+configPath, buf)
 }
+
+// Pause puts the cluster into the maintenance mode, in which Patroni does not fail over to other members.
+func (p *Patroni) Pause(server *v1.Pod) error {
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(map[string]bool{"pause": true})
+	if err != nil {
+		return fmt.Errorf("could not encode json: %v", err)
+	}
+	return p.httpPostOrPatch(http.MethodPatch, apiURL(server)+configPath, buf)
+}
+
+// PromoteStandbyCluster removes the standby cluster section from the dynamic configuration, upon which the standby
+// leader promotes itself and the cluster starts accepting writes.
+func (p *Patroni) PromoteStandbyCluster(server *v1.Pod) error {
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(map[string]interface{}{"standby_cluster": nil})
+	if err != nil {
+		return fmt.Errorf("could not encode json: %v", err)
+	}
+	return p.httpPostOrPatch(http.MethodPatch, apiURL(server)+configPath, buf)
+}
+
+// GetMemberStatus returns the state and the role of the Patroni member running in the pod.
+func (p *Patroni) GetMemberStatus(server *v1.Pod) (*MemberStatus, error) {
+	request, err := http.NewRequest(http.MethodGet, apiURL(server)+statusPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %v", err)
+	}
+
+	p.logger.Debugf("making GET http request: %s", request.URL.String())
+
+	resp, err := p.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("patroni returned '%s'", string(bodyBytes))
+	}
+
+	status := &MemberStatus{}
+	if err := json.Unmarshal(bodyBytes, status); err != nil {
+		return nil, fmt.Errorf("could not decode the member status: %v", err)
+	}
+
+	return status, nil
+}
+
+// IsDCSError tells whether the error returned by the Patroni API is caused by the DCS being unreachable.
+func IsDCSError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, signature := range dcsErrorSignatures {
+		if strings.Contains(err.Error(), signature) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	serviceName     = "postgres-operator"
+	exportQueueSize = 1024
+	exportTimeout   = 10 * time.Second
+)
+
+// SpanData describes a finished span.
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Attributes   map[string]string
+	StartTime    time.Time
+	EndTime      time.Time
+}
+
+// Exporter receives finished spans.
+type Exporter interface {
+	ExportSpan(span SpanData)
+}
+
+// Tracer creates spans and hands them over to the exporter once they end. A nil tracer disables tracing.
+type Tracer struct {
+	exporter Exporter
+}
+
+// Span is a single timed operation, possibly nested inside another one.
+type Span struct {
+	tracer *Tracer
+	parent *Span
+	data   SpanData
+}
+
+// NewTracer creates a tracer reporting to the given exporter.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start opens a new span. Spans without a parent start a new trace, the others inherit the trace and the
+// attributes of their parent.
+func (t *Tracer) Start(parent *Span, name string, attributes map[string]string) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{
+		tracer: t,
+		parent: parent,
+		data: SpanData{
+			SpanID:     randomID(8),
+			Name:       name,
+			Attributes: make(map[string]string),
+			StartTime:  time.Now(),
+		},
+	}
+	if parent != nil {
+		span.data.TraceID = parent.data.TraceID
+		span.data.ParentSpanID = parent.data.SpanID
+		for k, v := range parent.data.Attributes {
+			span.data.Attributes[k] = v
+		}
+	} else {
+		span.data.TraceID = randomID(16)
+	}
+	for k, v := range attributes {
+		span.data.Attributes[k] = v
+	}
+
+	return span
+}
+
+// Parent returns the span this one is nested in.
+func (s *Span) Parent() *Span {
+	if s == nil {
+		return nil
+	}
+	return s.parent
+}
+
+// End finishes the span and exports it.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.data.EndTime = time.Now()
+	s.tracer.exporter.ExportSpan(s.data)
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// InMemoryExporter keeps the exported spans in memory, mostly useful for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpan stores the span.
+func (e *InMemoryExporter) ExportSpan(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the spans exported so far, in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result := make([]SpanData, len(e.spans))
+	copy(result, e.spans)
+	return result
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using the JSON encoding of the OTLP/HTTP protocol.
+// Spans are sent in the background; when the collector cannot keep up, new spans are dropped.
+type OTLPExporter struct {
+	url        string
+	httpClient *http.Client
+	queue      chan SpanData
+	logger     *logrus.Entry
+}
+
+// NewOTLPExporter creates an exporter posting to the given traces endpoint, i.e. http://collector:4318/v1/traces.
+func NewOTLPExporter(url string, logger *logrus.Entry) *OTLPExporter {
+	e := &OTLPExporter{
+		url:        url,
+		httpClient: &http.Client{Timeout: exportTimeout},
+		queue:      make(chan SpanData, exportQueueSize),
+		logger:     logger.WithField("pkg", "tracing"),
+	}
+	go e.run()
+
+	return e
+}
+
+// ExportSpan queues the span for sending.
+func (e *OTLPExporter) ExportSpan(span SpanData) {
+	select {
+	case e.queue <- span:
+	default:
+		e.logger.Warningf("dropping span %q: export queue is full", span.Name)
+	}
+}
+
+func (e *OTLPExporter) run() {
+	for span := range e.queue {
+		if err := e.send(span); err != nil {
+			e.logger.Warningf("could not export span %q: %v", span.Name, err)
+		}
+	}
+}
+
+func (e *OTLPExporter) send(span SpanData) error {
+	body, err := json.Marshal(otlpRequest(span))
+	if err != nil {
+		return fmt.Errorf("could not marshal span: %v", err)
+	}
+
+	resp, err := e.httpClient.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attributes))
+	for k, v := range attributes {
+		result = append(result, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	return result
+}
+
+func otlpRequest(span SpanData) map[string]interface{} {
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": serviceName},
+						"spans": []otlpSpan{{
+							TraceID:           span.TraceID,
+							SpanID:            span.SpanID,
+							ParentSpanID:      span.ParentSpanID,
+							Name:              span.Name,
+							Kind:              1, // SPAN_KIND_INTERNAL
+							StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+							EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+							Attributes:        otlpAttributes(span.Attributes),
+						}},
+					},
+				},
+			},
+		},
+	}
+}
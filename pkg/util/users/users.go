@@ -3,29 +3,55 @@ package users
 import (
 	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/zalando-incubator/postgres-operator/pkg/spec"
 	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
 	"reflect"
 )
 
 const (
 	createUserSQL        = `SET LOCAL synchronous_commit = 'local'; CREATE ROLE "%s" %s %s;`
 	alterUserSQL         = `ALTER ROLE "%s" %s`
+	dropUserSQL          = `SET LOCAL synchronous_commit = 'local'; DROP ROLE "%s";`
+	lockUserSQL          = `SET LOCAL synchronous_commit = 'local'; ALTER ROLE "%s" NOLOGIN PASSWORD NULL;`
 	alterRoleResetAllSQL = `ALTER ROLE "%s" RESET ALL`
 	alterRoleSetSQL      = `ALTER ROLE "%s" SET %s TO %s`
 	grantToUserSQL       = `GRANT %s TO "%s"`
+	grantWithAdminSQL    = `GRANT %s TO "%s" WITH ADMIN OPTION`
+	revokeFromUserSQL    = `REVOKE %s FROM "%s"`
 	doBlockStmt          = `SET LOCAL synchronous_commit = 'local'; DO $$ BEGIN %s; END;$$;`
 	passwordTemplate     = "ENCRYPTED PASSWORD '%s'"
 	inRoleTemplate       = `IN ROLE %s`
+	connLimitTemplate    = `CONNECTION LIMIT %d`
 )
 
+// roleAttributes are the attributes of pg_authid the flags of the roles stand for, along with the values the roles get
+// unless their flags say otherwise.
+var roleAttributes = []struct {
+	flag         string
+	defaultValue bool
+}{
+	{constants.RoleFlagSuperuser, false},
+	{constants.RoleFlagInherit, true},
+	{constants.RoleFlagCreateRole, false},
+	{constants.RoleFlagCreateDB, false},
+	{constants.RoleFlagLogin, false},
+	{constants.RoleFlagReplication, false},
+	{constants.RoleFlagByPassRLS, false},
+}
+
 // DefaultUserSyncStrategy implements a user sync strategy that merges already existing database users
-// with those defined in the manifest, altering existing users when necessary. It will never strips
-// an existing roles of another role membership, nor it removes the already assigned flag
-// (except for the NOLOGIN). TODO: process other NOflags, i.e. NOSUPERUSER correctly.
+// with those defined in the manifest, altering existing users when necessary. It will never strip
+// an existing role of other origins of another role membership or of the ADMIN OPTION of one. The attributes
+// and the memberships of the roles of the manifest follow their definitions, the ones of the roles of other
+// origins are only ever added.
 type DefaultUserSyncStrategy struct {
+	// PasswordEncryption is the method the passwords are hashed with, md5 unless set to scram-sha-256
+	PasswordEncryption string
 }
 
 // ProduceSyncRequests figures out the types of changes that need to happen with the given users.
@@ -33,7 +59,8 @@ func (strategy DefaultUserSyncStrategy) ProduceSyncRequests(dbUsers spec.PgUserM
 	newUsers spec.PgUserMap) (reqs []spec.PgSyncUserRequest) {
 
 	// No existing roles are deleted or stripped of role memebership/flags
-	for name, newUser := range newUsers {
+	for _, name := range membershipOrder(newUsers) {
+		newUser := newUsers[name]
 		dbUser, exists := dbUsers[name]
 		if !exists {
 			reqs = append(reqs, spec.PgSyncUserRequest{Kind: spec.PGSyncUserAdd, User: newUser})
@@ -42,22 +69,41 @@ func (strategy DefaultUserSyncStrategy) ProduceSyncRequests(dbUsers spec.PgUserM
 			}
 		} else {
 			r := spec.PgSyncUserRequest{}
-			newMD5Password := util.PGUserPassword(newUser)
 
-			if dbUser.Password != newMD5Password {
-				r.User.Password = newMD5Password
+			// the password is hashed once the role is altered
+			if !strategy.passwordMatches(dbUser.Password, newUser) {
+				r.User.Password = newUser.Password
 				r.Kind = spec.PGsyncUserAlter
 			}
 			if addNewRoles, equal := util.SubstractStringSlices(newUser.MemberOf, dbUser.MemberOf); !equal {
 				r.User.MemberOf = addNewRoles
 				r.Kind = spec.PGsyncUserAlter
 			}
-			if addNewFlags, equal := util.SubstractStringSlices(newUser.Flags, dbUser.Flags); !equal {
+			if addNewAdminRoles, equal := util.SubstractStringSlices(newUser.AdminOf, dbUser.AdminOf); !equal {
+				r.User.AdminOf = addNewAdminRoles
+				r.Kind = spec.PGsyncUserAlter
+			}
+			if newUser.ConnectionLimit != nil &&
+				(dbUser.ConnectionLimit == nil || *dbUser.ConnectionLimit != *newUser.ConnectionLimit) {
+				r.User.ConnectionLimit = newUser.ConnectionLimit
+				r.Kind = spec.PGsyncUserAlter
+			}
+			if newUser.Origin == spec.RoleOriginManifest {
+				if revokedRoles, equal := util.SubstractStringSlices(dbUser.MemberOf, newUser.MemberOf); !equal {
+					r.User.RevokeFrom = revokedRoles
+					r.Kind = spec.PGsyncUserAlter
+				}
+				if changedFlags := flagChanges(newUser.Flags, dbUser.Flags); len(changedFlags) > 0 {
+					r.User.Flags = changedFlags
+					r.Kind = spec.PGsyncUserAlter
+				}
+			} else if addNewFlags, equal := util.SubstractStringSlices(newUser.Flags, dbUser.Flags); !equal {
 				r.User.Flags = addNewFlags
 				r.Kind = spec.PGsyncUserAlter
 			}
 			if r.Kind == spec.PGsyncUserAlter {
 				r.User.Name = newUser.Name
+				r.User.Origin = newUser.Origin
 				reqs = append(reqs, r)
 			}
 			if len(newUser.Parameters) > 0 && !reflect.DeepEqual(dbUser.Parameters, newUser.Parameters) {
@@ -69,34 +115,164 @@ func (strategy DefaultUserSyncStrategy) ProduceSyncRequests(dbUsers spec.PgUserM
 	return
 }
 
+// membershipOrder returns the names of the users sorted, except that the roles the users are granted come before
+// them, so that the roles exist by the time they are granted on the creation of the users.
+func membershipOrder(users spec.PgUserMap) []string {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]string, 0, len(names))
+	visited := make(map[string]bool, len(names))
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, roles := range [][]string{users[name].MemberOf, users[name].AdminOf} {
+			for _, role := range roles {
+				if _, ok := users[role]; ok {
+					visit(role)
+				}
+			}
+		}
+		result = append(result, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return result
+}
+
+// encryptPassword hashes the password of the user with the configured method. Passwords hashed already, like the ones
+// of the infrastructure roles, are kept as they are.
+func (strategy DefaultUserSyncStrategy) encryptPassword(user spec.PgUser) (string, error) {
+	if strategy.PasswordEncryption == config.PasswordEncryptionSCRAMSHA256 {
+		return util.PGUserPasswordSCRAM(user)
+	}
+	return util.PGUserPassword(user), nil
+}
+
+// passwordMatches tells whether the password hash of the role in the database is the one of the user, hashed with
+// the configured method. A hash of the other method does not match, so that the roles move to the configured one.
+func (strategy DefaultUserSyncStrategy) passwordMatches(hash string, user spec.PgUser) bool {
+	if strategy.PasswordEncryption == config.PasswordEncryptionSCRAMSHA256 {
+		return util.SCRAMPasswordMatches(hash, user)
+	}
+	return hash == util.PGUserPassword(user)
+}
+
+// flagChanges returns the flags that turn the attributes of the role in the database into the desired ones, the NO
+// flags for the attributes to be taken away. An attribute the desired flags do not mention gets its default value.
+func flagChanges(desired, current []string) []string {
+	has := func(flags []string, flag string) bool {
+		for _, f := range flags {
+			if f == flag {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := make([]string, 0)
+	for _, attribute := range roleAttributes {
+		want := attribute.defaultValue
+		if has(desired, attribute.flag) {
+			want = true
+		} else if has(desired, "NO"+attribute.flag) {
+			want = false
+		}
+		if want == has(current, attribute.flag) {
+			continue
+		}
+		if want {
+			result = append(result, attribute.flag)
+		} else {
+			result = append(result, "NO"+attribute.flag)
+		}
+	}
+	return result
+}
+
+// SyncErrors are the failures to sync some of the users other than the system ones, the rest of the users have been
+// synced.
+type SyncErrors []string
+
+func (e SyncErrors) Error() string {
+	return fmt.Sprintf("could not sync %d user(s): %s", len(e), strings.Join(e, "; "))
+}
+
 // ExecuteSyncRequests makes actual database changes from the requests passed in its arguments.
+// A failure to sync one user does not prevent syncing the others; the failures are collected and
+// returned together as SyncErrors, so that the next sync retries them. System users are critical:
+// the first failure to sync any of them aborts the execution.
 func (strategy DefaultUserSyncStrategy) ExecuteSyncRequests(reqs []spec.PgSyncUserRequest, db *sql.DB) error {
+	failedUsers := make(map[string]bool)
+	errors := make(SyncErrors, 0)
+
 	for _, r := range reqs {
+		// do not try to alter a user we could not create
+		if failedUsers[r.User.Name] {
+			continue
+		}
+		var err error
 		switch r.Kind {
 		case spec.PGSyncUserAdd:
-			if err := strategy.createPgUser(r.User, db); err != nil {
-				return fmt.Errorf("could not create user %q: %v", r.User.Name, err)
+			if err = strategy.createPgUser(r.User, db); err != nil {
+				err = fmt.Errorf("could not create user %q: %v", r.User.Name, err)
 			}
 		case spec.PGsyncUserAlter:
-			if err := strategy.alterPgUser(r.User, db); err != nil {
-				return fmt.Errorf("could not alter user %q: %v", r.User.Name, err)
+			if err = strategy.alterPgUser(r.User, db); err != nil {
+				err = fmt.Errorf("could not alter user %q: %v", r.User.Name, err)
 			}
 		case spec.PGSyncAlterSet:
-			if err := strategy.alterPgUserSet(r.User, db); err != nil {
-				return fmt.Errorf("could not set custom user %q parameters: %v", r.User.Name, err)
+			if err = strategy.alterPgUserSet(r.User, db); err != nil {
+				err = fmt.Errorf("could not set custom user %q parameters: %v", r.User.Name, err)
+			}
+		case spec.PGSyncUserDrop:
+			if err = execUserQuery(fmt.Sprintf(dropUserSQL, r.User.Name), db); err != nil {
+				err = fmt.Errorf("could not drop user %q: %v", r.User.Name, err)
+			}
+		case spec.PGSyncUserLock:
+			if err = execUserQuery(fmt.Sprintf(lockUserSQL, r.User.Name), db); err != nil {
+				err = fmt.Errorf("could not lock user %q: %v", r.User.Name, err)
 			}
 		default:
 			return fmt.Errorf("unrecognized operation: %v", r.Kind)
 		}
+		if err == nil {
+			continue
+		}
+		if r.User.Origin == spec.RoleOriginSystem {
+			return err
+		}
+		failedUsers[r.User.Name] = true
+		errors = append(errors, err.Error())
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
 
+// execUserQuery runs a single statement on a role. DROP ROLE fails as long as the role owns objects or holds
+// privileges in any database, the error tells which ones.
+func execUserQuery(query string, db *sql.DB) error {
+	if _, err := db.Exec(query); err != nil {
+		return queryError(err, query)
 	}
 	return nil
 }
+
 func (strategy DefaultUserSyncStrategy) alterPgUserSet(user spec.PgUser, db *sql.DB) (err error) {
 	queries := produceAlterRoleSetStmts(user)
 	query := fmt.Sprintf(doBlockStmt, strings.Join(queries, ";"))
 	if _, err = db.Exec(query); err != nil {
-		err = fmt.Errorf("dB error: %v, query: %s", err, query)
+		err = queryError(err, query)
 		return
 	}
 	return
@@ -109,20 +285,31 @@ func (strategy DefaultUserSyncStrategy) createPgUser(user spec.PgUser, db *sql.D
 	if len(user.Flags) > 0 {
 		userFlags = append(userFlags, user.Flags...)
 	}
-	if len(user.MemberOf) > 0 {
-		userFlags = append(userFlags, fmt.Sprintf(inRoleTemplate, quoteMemberList(user)))
+	if memberOf := quoteRoleList(plainMemberships(user)); memberOf != "" {
+		userFlags = append(userFlags, fmt.Sprintf(inRoleTemplate, memberOf))
+	}
+	if user.ConnectionLimit != nil {
+		userFlags = append(userFlags, fmt.Sprintf(connLimitTemplate, *user.ConnectionLimit))
 	}
 
 	if user.Password == "" {
 		userPassword = "PASSWORD NULL"
 	} else {
-		userPassword = fmt.Sprintf(passwordTemplate, util.PGUserPassword(user))
+		hash, err := strategy.encryptPassword(user)
+		if err != nil {
+			return err
+		}
+		userPassword = fmt.Sprintf(passwordTemplate, hash)
 	}
 	query := fmt.Sprintf(createUserSQL, user.Name, strings.Join(userFlags, " "), userPassword)
+	// CREATE ROLE ... IN ROLE has no ADMIN OPTION
+	if len(user.AdminOf) > 0 {
+		query = fmt.Sprintf("%s %s;", query, fmt.Sprintf(grantWithAdminSQL, quoteRoleList(user.AdminOf), user.Name))
+	}
 
 	_, err = db.Exec(query) // TODO: Try several times
 	if err != nil {
-		err = fmt.Errorf("dB error: %v, query: %s", err, query)
+		err = queryError(err, query)
 		return
 	}
 
@@ -132,13 +319,15 @@ func (strategy DefaultUserSyncStrategy) createPgUser(user spec.PgUser, db *sql.D
 func (strategy DefaultUserSyncStrategy) alterPgUser(user spec.PgUser, db *sql.DB) (err error) {
 	var resultStmt []string
 
-	if user.Password != "" || len(user.Flags) > 0 {
+	if user.Password, err = strategy.encryptPassword(user); err != nil {
+		return err
+	}
+	if user.Password != "" || len(user.Flags) > 0 || user.ConnectionLimit != nil {
 		alterStmt := produceAlterStmt(user)
 		resultStmt = append(resultStmt, alterStmt)
 	}
-	if len(user.MemberOf) > 0 {
-		grantStmt := produceGrantStmt(user)
-		resultStmt = append(resultStmt, grantStmt)
+	if len(user.MemberOf) > 0 || len(user.AdminOf) > 0 || len(user.RevokeFrom) > 0 {
+		resultStmt = append(resultStmt, produceGrantStmts(user)...)
 	}
 	if len(resultStmt) == 0 {
 		return nil
@@ -148,7 +337,7 @@ func (strategy DefaultUserSyncStrategy) alterPgUser(user spec.PgUser, db *sql.DB
 
 	_, err = db.Exec(query) // TODO: Try several times
 	if err != nil {
-		err = fmt.Errorf("dB error: %v query %s", err, query)
+		err = queryError(err, query)
 		return
 	}
 
@@ -167,6 +356,9 @@ func produceAlterStmt(user spec.PgUser) string {
 	if len(flags) != 0 {
 		result = append(result, strings.Join(flags, " "))
 	}
+	if user.ConnectionLimit != nil {
+		result = append(result, fmt.Sprintf(connLimitTemplate, *user.ConnectionLimit))
+	}
 	return fmt.Sprintf(alterUserSQL, user.Name, strings.Join(result, " "))
 }
 
@@ -179,17 +371,43 @@ func produceAlterRoleSetStmts(user spec.PgUser) []string {
 	return result
 }
 
-func produceGrantStmt(user spec.PgUser) string {
+func produceGrantStmts(user spec.PgUser) []string {
+	result := make([]string, 0)
 	// GRANT ROLE "foo", "bar" TO baz
-	return fmt.Sprintf(grantToUserSQL, quoteMemberList(user), user.Name)
+	if memberOf := quoteRoleList(plainMemberships(user)); memberOf != "" {
+		result = append(result, fmt.Sprintf(grantToUserSQL, memberOf, user.Name))
+	}
+	// granting an existing membership again adds the ADMIN OPTION to it
+	if len(user.AdminOf) > 0 {
+		result = append(result, fmt.Sprintf(grantWithAdminSQL, quoteRoleList(user.AdminOf), user.Name))
+	}
+	if len(user.RevokeFrom) > 0 {
+		result = append(result, fmt.Sprintf(revokeFromUserSQL, quoteRoleList(user.RevokeFrom), user.Name))
+	}
+	return result
 }
 
-func quoteMemberList(user spec.PgUser) string {
-	var memberof []string
+// plainMemberships returns the memberships of the user that are not granted with the ADMIN OPTION
+func plainMemberships(user spec.PgUser) []string {
+	admin := make(map[string]bool)
+	for _, role := range user.AdminOf {
+		admin[role] = true
+	}
+	result := make([]string, 0)
 	for _, member := range user.MemberOf {
-		memberof = append(memberof, fmt.Sprintf(`"%s"`, member))
+		if !admin[member] {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+func quoteRoleList(roles []string) string {
+	var quoted []string
+	for _, role := range roles {
+		quoted = append(quoted, fmt.Sprintf(`"%s"`, role))
 	}
-	return strings.Join(memberof, ",")
+	return strings.Join(quoted, ",")
 }
 
 // quoteVal quotes values to be used at ALTER ROLE SET param = value if necessary
@@ -215,3 +433,10 @@ func quoteParameterValue(name, val string) string {
 	}
 	return fmt.Sprintf(`'%s'`, strings.Trim(val, " "))
 }
+
+func queryError(err error, query string) error {
+	if util.IsStatementTimeout(err) {
+		return fmt.Errorf("statement timed out: %v, query: %s", err, query)
+	}
+	return fmt.Errorf("dB error: %v, query: %s", err, query)
+}
@@ -0,0 +1,416 @@
+package users
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/spec"
+	"github.com/zalando-incubator/postgres-operator/pkg/util"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+)
+
+// mockDriver records executed statements and fails those mentioning one of the failing users.
+type mockDriver struct {
+	mu           sync.Mutex
+	executed     []string
+	failingUsers []string
+}
+
+type mockConn struct {
+	driver *mockDriver
+}
+
+type mockStmt struct {
+	driver *mockDriver
+	query  string
+}
+
+func (d *mockDriver) Open(name string) (driver.Conn, error) {
+	return &mockConn{driver: d}, nil
+}
+
+func (c *mockConn) Prepare(query string) (driver.Stmt, error) {
+	return &mockStmt{driver: c.driver, query: query}, nil
+}
+
+func (c *mockConn) Close() error {
+	return nil
+}
+
+func (c *mockConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+func (s *mockStmt) Close() error {
+	return nil
+}
+
+func (s *mockStmt) NumInput() int {
+	return -1
+}
+
+func (s *mockStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.driver.mu.Lock()
+	defer s.driver.mu.Unlock()
+
+	for _, user := range s.driver.failingUsers {
+		if strings.Contains(s.query, fmt.Sprintf(`"%s"`, user)) {
+			return nil, fmt.Errorf("role %q is reserved", user)
+		}
+	}
+	s.driver.executed = append(s.driver.executed, s.query)
+
+	return driver.RowsAffected(0), nil
+}
+
+func (s *mockStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("queries are not supported")
+}
+
+func (d *mockDriver) createdUsers() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := make([]string, 0)
+	for _, query := range d.executed {
+		if strings.Contains(query, "CREATE ROLE") {
+			result = append(result, query)
+		}
+	}
+	return result
+}
+
+var mockDriverCounter int
+
+func newMockDB(t *testing.T, failingUsers ...string) (*sql.DB, *mockDriver) {
+	d := &mockDriver{failingUsers: failingUsers}
+	mockDriverCounter++
+	name := fmt.Sprintf("users-mock-%d", mockDriverCounter)
+	sql.Register(name, d)
+
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("could not open mock database: %v", err)
+	}
+	return db, d
+}
+
+func TestExecuteSyncRequestsContinuesOnUserFailure(t *testing.T) {
+	testName := "TestExecuteSyncRequestsContinuesOnUserFailure"
+	db, d := newMockDB(t, "pg_reserved")
+	defer db.Close()
+
+	reqs := []spec.PgSyncUserRequest{
+		{Kind: spec.PGSyncUserAdd, User: spec.PgUser{Origin: spec.RoleOriginManifest, Name: "foo", Password: "secret"}},
+		{Kind: spec.PGSyncUserAdd, User: spec.PgUser{Origin: spec.RoleOriginManifest, Name: "pg_reserved", Password: "secret"}},
+		{Kind: spec.PGSyncAlterSet, User: spec.PgUser{Origin: spec.RoleOriginManifest, Name: "pg_reserved",
+			Parameters: map[string]string{"work_mem": "16MB"}}},
+		{Kind: spec.PGSyncUserAdd, User: spec.PgUser{Origin: spec.RoleOriginManifest, Name: "bar", Password: "secret"}},
+	}
+
+	err := DefaultUserSyncStrategy{}.ExecuteSyncRequests(reqs, db)
+	if err == nil {
+		t.Fatalf("%s: expected an error for the failed user", testName)
+	}
+	if !strings.Contains(err.Error(), `could not sync 1 user(s): could not create user "pg_reserved"`) {
+		t.Errorf("%s: unexpected error: %v", testName, err)
+	}
+	if _, ok := err.(SyncErrors); !ok {
+		t.Errorf("%s: expected the failures of the users, got %T", testName, err)
+	}
+
+	created := d.createdUsers()
+	if len(created) != 2 {
+		t.Fatalf("%s: expected 2 users to be created, got %d: %v", testName, len(created), created)
+	}
+	for i, name := range []string{"foo", "bar"} {
+		if !strings.Contains(created[i], fmt.Sprintf(`CREATE ROLE "%s"`, name)) {
+			t.Errorf("%s: expected user %q to be created, got %q", testName, name, created[i])
+		}
+	}
+}
+
+func TestExecuteSyncRequestsAbortsOnSystemUserFailure(t *testing.T) {
+	testName := "TestExecuteSyncRequestsAbortsOnSystemUserFailure"
+	db, d := newMockDB(t, "postgres")
+	defer db.Close()
+
+	reqs := []spec.PgSyncUserRequest{
+		{Kind: spec.PGSyncUserAdd, User: spec.PgUser{Origin: spec.RoleOriginSystem, Name: "postgres", Password: "secret"}},
+		{Kind: spec.PGSyncUserAdd, User: spec.PgUser{Origin: spec.RoleOriginManifest, Name: "foo", Password: "secret"}},
+	}
+
+	err := DefaultUserSyncStrategy{}.ExecuteSyncRequests(reqs, db)
+	if err == nil || !strings.HasPrefix(err.Error(), `could not create user "postgres"`) {
+		t.Errorf("%s: expected the system user failure to be returned, got %v", testName, err)
+	}
+	if created := d.createdUsers(); len(created) != 0 {
+		t.Errorf("%s: expected no users to be created after the system user failure, got %v", testName, created)
+	}
+}
+
+func TestGrantWithAdminOption(t *testing.T) {
+	testName := "TestGrantWithAdminOption"
+	newUsers := spec.PgUserMap{
+		"manager": {Name: "manager", MemberOf: []string{"readers", "writers"}, AdminOf: []string{"writers"}},
+		"lead":    {Name: "lead", MemberOf: []string{"readers"}, AdminOf: []string{"readers"}},
+	}
+	dbUsers := spec.PgUserMap{
+		"lead": {Name: "lead", MemberOf: []string{"readers"}},
+	}
+	reqs := DefaultUserSyncStrategy{}.ProduceSyncRequests(dbUsers, newUsers)
+
+	db, d := newMockDB(t)
+	defer db.Close()
+	if err := (DefaultUserSyncStrategy{}).ExecuteSyncRequests(reqs, db); err != nil {
+		t.Fatalf("%s: could not execute sync requests: %v", testName, err)
+	}
+	executed := strings.Join(d.executed, "\n")
+	for _, stmt := range []string{
+		`IN ROLE "readers"`,
+		`GRANT "writers" TO "manager" WITH ADMIN OPTION`,
+		// the existing membership gets the ADMIN OPTION added
+		`GRANT "readers" TO "lead" WITH ADMIN OPTION`,
+	} {
+		if !strings.Contains(executed, stmt) {
+			t.Errorf("%s: expected %q to be executed, got %v", testName, stmt, d.executed)
+		}
+	}
+	if strings.Contains(executed, `IN ROLE "readers","writers"`) {
+		t.Errorf("%s: expected the membership with the ADMIN OPTION not to be granted without it, got %v",
+			testName, d.executed)
+	}
+
+	// nothing left to grant once the database has the memberships with the ADMIN OPTION
+	dbUsers["lead"] = spec.PgUser{Name: "lead", MemberOf: []string{"readers"}, AdminOf: []string{"readers"}}
+	delete(newUsers, "manager")
+	if reqs := (DefaultUserSyncStrategy{}).ProduceSyncRequests(dbUsers, newUsers); len(reqs) != 0 {
+		t.Errorf("%s: expected no requests for the granted ADMIN OPTION, got %#v", testName, reqs)
+	}
+}
+
+func TestRevokeManifestMemberships(t *testing.T) {
+	testName := "TestRevokeManifestMemberships"
+	newUsers := spec.PgUserMap{
+		"app":   {Name: "app", Origin: spec.RoleOriginManifest, MemberOf: []string{"readers"}},
+		"robot": {Name: "robot", Origin: spec.RoleOriginInfrastructure, MemberOf: []string{"readers"}},
+	}
+	dbUsers := spec.PgUserMap{
+		"app":   {Name: "app", Flags: []string{"INHERIT"}, MemberOf: []string{"readers", "writers"}},
+		"robot": {Name: "robot", MemberOf: []string{"readers", "writers"}},
+	}
+	reqs := DefaultUserSyncStrategy{}.ProduceSyncRequests(dbUsers, newUsers)
+
+	db, d := newMockDB(t)
+	defer db.Close()
+	if err := (DefaultUserSyncStrategy{}).ExecuteSyncRequests(reqs, db); err != nil {
+		t.Fatalf("%s: could not execute sync requests: %v", testName, err)
+	}
+	if len(d.executed) != 1 || !strings.Contains(d.executed[0], `REVOKE "writers" FROM "app"`) {
+		t.Errorf("%s: expected only the membership of the manifest role to be revoked, got %v", testName, d.executed)
+	}
+
+	dbUsers["app"] = spec.PgUser{Name: "app", Flags: []string{"INHERIT"}, MemberOf: []string{"readers"}}
+	if reqs := (DefaultUserSyncStrategy{}).ProduceSyncRequests(dbUsers, newUsers); len(reqs) != 0 {
+		t.Errorf("%s: expected no requests once the memberships match, got %#v", testName, reqs)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	testName := "TestConnectionLimit"
+	limit, unlimited := 10, -1
+	newUsers := spec.PgUserMap{
+		"app":   {Name: "app", Origin: spec.RoleOriginManifest, Flags: []string{"LOGIN"}, ConnectionLimit: &limit},
+		"new":   {Name: "new", Origin: spec.RoleOriginManifest, Flags: []string{"LOGIN"}, ConnectionLimit: &limit},
+		"robot": {Name: "robot", Origin: spec.RoleOriginInfrastructure, Flags: []string{"LOGIN"}},
+	}
+	dbUsers := spec.PgUserMap{
+		"app":   {Name: "app", Flags: []string{"INHERIT", "LOGIN"}, ConnectionLimit: &unlimited},
+		"robot": {Name: "robot", Flags: []string{"LOGIN"}, ConnectionLimit: &unlimited},
+	}
+	reqs := DefaultUserSyncStrategy{}.ProduceSyncRequests(dbUsers, newUsers)
+
+	db, d := newMockDB(t)
+	defer db.Close()
+	if err := (DefaultUserSyncStrategy{}).ExecuteSyncRequests(reqs, db); err != nil {
+		t.Fatalf("%s: could not execute sync requests: %v", testName, err)
+	}
+	executed := strings.Join(d.executed, "\n")
+	for _, stmt := range []string{`ALTER ROLE "app" CONNECTION LIMIT 10`, `CREATE ROLE "new" LOGIN CONNECTION LIMIT 10`} {
+		if !strings.Contains(executed, stmt) {
+			t.Errorf("%s: expected %q to be executed, got %v", testName, stmt, d.executed)
+		}
+	}
+	if strings.Contains(executed, `"robot"`) {
+		t.Errorf("%s: expected the role without a limit to be left alone, got %v", testName, d.executed)
+	}
+
+	dbUsers["app"] = spec.PgUser{Name: "app", Flags: []string{"INHERIT", "LOGIN"}, ConnectionLimit: &limit}
+	delete(newUsers, "new")
+	if reqs := (DefaultUserSyncStrategy{}).ProduceSyncRequests(dbUsers, newUsers); len(reqs) != 0 {
+		t.Errorf("%s: expected no requests once the limit is set, got %#v", testName, reqs)
+	}
+}
+
+func TestMembershipOrder(t *testing.T) {
+	testName := "TestMembershipOrder"
+	users := spec.PgUserMap{
+		"app":      {Name: "app", MemberOf: []string{"writers"}},
+		"admin":    {Name: "admin", MemberOf: []string{"readers"}, AdminOf: []string{"writers"}},
+		"readers":  {Name: "readers"},
+		"writers":  {Name: "writers", MemberOf: []string{"readers", "external"}},
+		"reporter": {Name: "reporter", MemberOf: []string{"readers"}},
+	}
+	expected := []string{"readers", "writers", "admin", "app", "reporter"}
+	if result := membershipOrder(users); !reflect.DeepEqual(result, expected) {
+		t.Errorf("%s: expected %v, got %v", testName, expected, result)
+	}
+
+	reqs := DefaultUserSyncStrategy{}.ProduceSyncRequests(spec.PgUserMap{}, users)
+	var created []string
+	for _, r := range reqs {
+		created = append(created, r.User.Name)
+	}
+	if !reflect.DeepEqual(created, expected) {
+		t.Errorf("%s: expected the users to be created in the order %v, got %v", testName, expected, created)
+	}
+}
+
+func TestNoInheritRole(t *testing.T) {
+	testName := "TestNoInheritRole"
+	newUsers := spec.PgUserMap{"app": {Name: "app", Flags: []string{"LOGIN", "NOINHERIT"}}}
+	dbUsers := spec.PgUserMap{"app": {Name: "app", Flags: []string{"INHERIT", "LOGIN"}}}
+	reqs := DefaultUserSyncStrategy{}.ProduceSyncRequests(dbUsers, newUsers)
+
+	db, d := newMockDB(t)
+	defer db.Close()
+	if err := (DefaultUserSyncStrategy{}).ExecuteSyncRequests(reqs, db); err != nil {
+		t.Fatalf("%s: could not execute sync requests: %v", testName, err)
+	}
+	if len(d.executed) != 1 || !strings.Contains(d.executed[0], `ALTER ROLE "app" NOINHERIT`) {
+		t.Errorf("%s: expected the role to be altered to NOINHERIT, got %v", testName, d.executed)
+	}
+
+	// the database reports NOINHERIT once the role has been altered
+	dbUsers["app"] = spec.PgUser{Name: "app", Flags: []string{"LOGIN", "NOINHERIT"}}
+	if reqs := (DefaultUserSyncStrategy{}).ProduceSyncRequests(dbUsers, newUsers); len(reqs) != 0 {
+		t.Errorf("%s: expected no requests for the NOINHERIT role, got %#v", testName, reqs)
+	}
+}
+
+func TestSCRAMPasswordEncryption(t *testing.T) {
+	testName := "TestSCRAMPasswordEncryption"
+	strategy := DefaultUserSyncStrategy{PasswordEncryption: config.PasswordEncryptionSCRAMSHA256}
+	newUsers := spec.PgUserMap{
+		"app": {Name: "app", Password: "secret", Flags: []string{"LOGIN"}},
+		"new": {Name: "new", Password: "secret", Flags: []string{"LOGIN"}},
+	}
+	// the role still has the md5 hash of the password
+	dbUsers := spec.PgUserMap{
+		"app": {Name: "app", Password: util.PGUserPassword(newUsers["app"]), Flags: []string{"LOGIN"}},
+	}
+	reqs := strategy.ProduceSyncRequests(dbUsers, newUsers)
+
+	db, d := newMockDB(t)
+	defer db.Close()
+	if err := strategy.ExecuteSyncRequests(reqs, db); err != nil {
+		t.Fatalf("%s: could not execute sync requests: %v", testName, err)
+	}
+	if len(d.executed) != 2 {
+		t.Fatalf("%s: expected the role to be altered and the new one to be created, got %v", testName, d.executed)
+	}
+	for _, query := range d.executed {
+		if !strings.Contains(query, "ENCRYPTED PASSWORD 'SCRAM-SHA-256$4096:") {
+			t.Errorf("%s: expected a SCRAM-SHA-256 verifier, got %q", testName, query)
+		}
+	}
+
+	// the database reports the verifier once the role has been altered
+	for _, query := range d.executed {
+		if strings.Contains(query, `ALTER ROLE "app"`) {
+			verifier := query[strings.Index(query, "SCRAM-SHA-256$"):]
+			dbUsers["app"] = spec.PgUser{Name: "app", Password: verifier[:strings.Index(verifier, "'")],
+				Flags: []string{"LOGIN"}}
+		}
+	}
+	delete(newUsers, "new")
+	if reqs := strategy.ProduceSyncRequests(dbUsers, newUsers); len(reqs) != 0 {
+		t.Errorf("%s: expected no requests for the matching verifier, got %#v", testName, reqs)
+	}
+}
+
+func TestExecuteSyncRequestsDeprovisionsUsers(t *testing.T) {
+	testName := "TestExecuteSyncRequestsDeprovisionsUsers"
+	db, d := newMockDB(t, "owner")
+	defer db.Close()
+
+	reqs := []spec.PgSyncUserRequest{
+		{Kind: spec.PGSyncUserDrop, User: spec.PgUser{Origin: spec.RoleOriginManifest, Name: "foo"}},
+		{Kind: spec.PGSyncUserLock, User: spec.PgUser{Origin: spec.RoleOriginManifest, Name: "bar"}},
+		{Kind: spec.PGSyncUserDrop, User: spec.PgUser{Origin: spec.RoleOriginManifest, Name: "owner"}},
+	}
+
+	err := DefaultUserSyncStrategy{}.ExecuteSyncRequests(reqs, db)
+	if err == nil || !strings.Contains(err.Error(), `could not drop user "owner"`) {
+		t.Errorf("%s: expected the failure to drop the user, got %v", testName, err)
+	}
+	expected := []string{
+		`SET LOCAL synchronous_commit = 'local'; DROP ROLE "foo";`,
+		`SET LOCAL synchronous_commit = 'local'; ALTER ROLE "bar" NOLOGIN PASSWORD NULL;`,
+	}
+	if strings.Join(d.executed, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("%s: expected statements %v, got %v", testName, expected, d.executed)
+	}
+}
+
+func TestFlagChanges(t *testing.T) {
+	testName := "TestFlagChanges"
+	tests := []struct {
+		about   string
+		desired []string
+		current []string
+		changes []string
+	}{
+		{"nothing changed", []string{"CREATEDB", "LOGIN"}, []string{"CREATEDB", "INHERIT", "LOGIN"}, []string{}},
+		{"flag added", []string{"CREATEDB", "LOGIN"}, []string{"INHERIT", "LOGIN"}, []string{"CREATEDB"}},
+		{"flag removed", []string{"LOGIN"}, []string{"CREATEDB", "INHERIT", "LOGIN", "REPLICATION"},
+			[]string{"NOCREATEDB", "NOREPLICATION"}},
+		{"login taken away", []string{}, []string{"INHERIT", "LOGIN"}, []string{"NOLOGIN"}},
+		{"inherit by default", []string{"LOGIN"}, []string{"LOGIN", "NOINHERIT"}, []string{"INHERIT"}},
+		{"explicit noinherit", []string{"LOGIN", "NOINHERIT"}, []string{"INHERIT", "LOGIN"}, []string{"NOINHERIT"}},
+		{"superuser revoked", []string{"LOGIN", "NOSUPERUSER"}, []string{"INHERIT", "LOGIN", "SUPERUSER"},
+			[]string{"NOSUPERUSER"}},
+	}
+	for _, tt := range tests {
+		if changes := flagChanges(tt.desired, tt.current); !reflect.DeepEqual(changes, tt.changes) {
+			t.Errorf("%s %s: expected %v, got %v", testName, tt.about, tt.changes, changes)
+		}
+	}
+}
+
+func TestAlterManifestRoleFlags(t *testing.T) {
+	testName := "TestAlterManifestRoleFlags"
+	dbUsers := spec.PgUserMap{
+		"app":   {Name: "app", Flags: []string{"CREATEDB", "INHERIT", "LOGIN"}},
+		"robot": {Name: "robot", Flags: []string{"CREATEDB", "INHERIT", "LOGIN"}},
+	}
+	newUsers := spec.PgUserMap{
+		"app":   {Origin: spec.RoleOriginManifest, Name: "app", Flags: []string{"CREATEROLE", "LOGIN"}},
+		"robot": {Origin: spec.RoleOriginInfrastructure, Name: "robot", Flags: []string{"LOGIN"}},
+	}
+	reqs := DefaultUserSyncStrategy{}.ProduceSyncRequests(dbUsers, newUsers)
+
+	db, d := newMockDB(t)
+	defer db.Close()
+	if err := (DefaultUserSyncStrategy{}).ExecuteSyncRequests(reqs, db); err != nil {
+		t.Fatalf("%s: could not execute sync requests: %v", testName, err)
+	}
+	if len(d.executed) != 1 || !strings.Contains(d.executed[0], `ALTER ROLE "app" CREATEROLE NOCREATEDB`) {
+		t.Errorf("%s: expected only the manifest role to be altered, got %v", testName, d.executed)
+	}
+}
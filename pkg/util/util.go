@@ -1,13 +1,20 @@
 package util
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"math/rand"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/motomux/pretty"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -16,6 +23,13 @@ import (
 
 const (
 	md5prefix = "md5"
+	// SCRAM-SHA-256 verifiers are stored as SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+	scramPrefix = "SCRAM-SHA-256$"
+	// the iteration count and salt length Postgres uses for the verifiers it computes itself
+	scramIterations = 4096
+	scramSaltLength = 16
+	// SQLSTATE of statements cancelled by the server, for instance because of the statement_timeout
+	pqQueryCanceled = "57014"
 )
 
 var passwordChars = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
@@ -44,7 +58,7 @@ func NameFromMeta(meta metav1.ObjectMeta) spec.NamespacedName {
 
 // PGUserPassword is used to generate md5 password hash for a given user. It does nothing for already hashed passwords.
 func PGUserPassword(user spec.PgUser) string {
-	if (len(user.Password) == md5.Size*2+len(md5prefix) && user.Password[:3] == md5prefix) || user.Password == "" {
+	if isPasswordHash(user.Password) || user.Password == "" {
 		// Avoid processing already encrypted or empty passwords
 		return user.Password
 	}
@@ -52,6 +66,87 @@ func PGUserPassword(user spec.PgUser) string {
 	return md5prefix + hex.EncodeToString(s[:])
 }
 
+// PGUserPasswordSCRAM is used to generate the SCRAM-SHA-256 verifier of the password of a given user with a random
+// salt. It does nothing for already hashed passwords.
+func PGUserPasswordSCRAM(user spec.PgUser) (string, error) {
+	if isPasswordHash(user.Password) || user.Password == "" {
+		return user.Password, nil
+	}
+	salt := make([]byte, scramSaltLength)
+	if _, err := crand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate the salt: %v", err)
+	}
+	return scramVerifier(user.Password, salt, scramIterations), nil
+}
+
+// SCRAMPasswordMatches tells whether the password hash of the database is the SCRAM-SHA-256 verifier of the password
+// of the user. Already hashed and empty passwords are compared as they are.
+func SCRAMPasswordMatches(hash string, user spec.PgUser) bool {
+	if isPasswordHash(user.Password) || user.Password == "" {
+		return hash == user.Password
+	}
+	if !strings.HasPrefix(hash, scramPrefix) {
+		return false
+	}
+	parts := strings.Split(strings.TrimPrefix(hash, scramPrefix), "$")
+	if len(parts) != 2 {
+		return false
+	}
+	iterationsAndSalt := strings.SplitN(parts[0], ":", 2)
+	if len(iterationsAndSalt) != 2 {
+		return false
+	}
+	iterations, err := strconv.Atoi(iterationsAndSalt[0])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(iterationsAndSalt[1])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(scramVerifier(user.Password, salt, iterations)), []byte(hash))
+}
+
+func isPasswordHash(password string) bool {
+	return (len(password) == md5.Size*2+len(md5prefix) && password[:3] == md5prefix) ||
+		strings.HasPrefix(password, scramPrefix)
+}
+
+// scramVerifier computes the verifier of RFC 5802 the way Postgres stores it. Postgres normalizes the password with
+// SASLprep first, which leaves the ASCII passwords the operator generates untouched.
+func scramVerifier(password string, salt []byte, iterations int) string {
+	salted := pbkdf2SHA256([]byte(password), salt, iterations)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := hmacSHA256(salted, []byte("Server Key"))
+	return fmt.Sprintf("%s%d:%s$%s:%s", scramPrefix, iterations, base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(storedKey[:]), base64.StdEncoding.EncodeToString(serverKey))
+}
+
+// pbkdf2SHA256 derives a key of the size of a SHA-256 hash, which takes the first block of PBKDF2 only.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+func hmacSHA256(key, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
 // Diff returns diffs between 2 objects
 func Diff(a, b interface{}) []string {
 	return pretty.Diff(a, b)
@@ -126,3 +221,9 @@ func Coalesce(val, defaultVal string) string {
 	}
 	return val
 }
+
+// IsStatementTimeout returns true if the error was caused by PostgreSQL cancelling a statement that ran for too long.
+func IsStatementTimeout(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == pqQueryCanceled
+}
@@ -1,9 +1,13 @@
 package util
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/lib/pq"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"regexp"
@@ -69,6 +73,16 @@ var substringMatch = []struct {
 	{regexp.MustCompile(`aaaa (\d+) bbbb`), "aaaa 123 bbbb", nil},
 }
 
+var statementTimeouts = []struct {
+	in  error
+	out bool
+}{
+	{&pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"}, true},
+	{&pq.Error{Code: "42710", Message: "role \"foo\" already exists"}, false},
+	{errors.New("canceling statement due to statement timeout"), false},
+	{nil, false},
+}
+
 func TestRandomPassword(t *testing.T) {
 	const pwdLength = 10
 	pwd := RandomPassword(pwdLength)
@@ -103,6 +117,48 @@ func TestPGUserPassword(t *testing.T) {
 	}
 }
 
+func TestSCRAMPassword(t *testing.T) {
+	user := spec.PgUser{Name: "test", Password: "password"}
+	salt := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	expected := "SCRAM-SHA-256$4096:AAECAwQFBgcICQoLDA0ODw==$4PSH04DiBM59z6mw0gs6x1r6+duXYQ+R0KwGZr+W5/o=:" +
+		"IgPInY95tTazYxnARISZb/eTxuX/JRwWgrM9ByaOUIk="
+	if verifier := scramVerifier(user.Password, salt, 4096); verifier != expected {
+		t.Errorf("scramVerifier expected: %q, got: %q", expected, verifier)
+	}
+
+	hashed, err := PGUserPasswordSCRAM(user)
+	if err != nil {
+		t.Fatalf("PGUserPasswordSCRAM returned an error: %v", err)
+	}
+	if other, _ := PGUserPasswordSCRAM(user); !strings.HasPrefix(hashed, "SCRAM-SHA-256$4096:") || hashed == other {
+		t.Errorf("PGUserPasswordSCRAM expected a salted verifier, got: %q", hashed)
+	}
+	if rehashed, _ := PGUserPasswordSCRAM(spec.PgUser{Name: "test", Password: hashed}); rehashed != hashed {
+		t.Errorf("PGUserPasswordSCRAM expected the verifier to be kept, got: %q", rehashed)
+	}
+	if md5 := PGUserPassword(spec.PgUser{Name: "test", Password: hashed}); md5 != hashed {
+		t.Errorf("PGUserPassword expected the verifier to be kept, got: %q", md5)
+	}
+
+	tests := []struct {
+		hash     string
+		user     spec.PgUser
+		expected bool
+	}{
+		{expected, user, true},
+		{hashed, user, true},
+		{expected, spec.PgUser{Name: "test", Password: "other"}, false},
+		{PGUserPassword(user), user, false},
+		{"SCRAM-SHA-256$invalid", user, false},
+		{PGUserPassword(user), spec.PgUser{Name: "test", Password: PGUserPassword(user)}, true},
+	}
+	for _, tt := range tests {
+		if result := SCRAMPasswordMatches(tt.hash, tt.user); result != tt.expected {
+			t.Errorf("SCRAMPasswordMatches of %q expected: %t, got: %t", tt.hash, tt.expected, result)
+		}
+	}
+}
+
 func TestPrettyDiff(t *testing.T) {
 	for _, tt := range prettyDiffTest {
 		if actual := PrettyDiff(tt.inA, tt.inB); actual != tt.out {
@@ -143,3 +199,11 @@ func TestMapContains(t *testing.T) {
 		}
 	}
 }
+
+func TestIsStatementTimeout(t *testing.T) {
+	for _, tt := range statementTimeouts {
+		if res := IsStatementTimeout(tt.in); res != tt.out {
+			t.Errorf("IsStatementTimeout for %v expected: %t, got: %t", tt.in, tt.out, res)
+		}
+	}
+}
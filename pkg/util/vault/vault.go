@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Interface stores the credentials of the database roles under the paths of a key/value secrets engine of Vault.
+type Interface interface {
+	ReadCredentials(path, token string) (username, password string, found bool, err error)
+	WriteCredentials(path, token, username, password string) error
+	DeleteCredentials(path, token string) error
+}
+
+// API talks to the HTTP API of Vault.
+type API struct {
+	httpClient
+	url       string
+	kvVersion int
+	logger    *logrus.Entry
+}
+
+// NewVaultAPI creates an object to store the credentials in Vault. With the version 2 of the key/value secrets engine
+// the paths include the data/ segment after the mount point, i.e. secret/data/postgres.
+func NewVaultAPI(url string, kvVersion int, log *logrus.Entry) *API {
+	return &API{
+		url:        strings.TrimRight(url, "/"),
+		kvVersion:  kvVersion,
+		httpClient: &http.Client{},
+		logger:     log.WithField("pkg", "vault"),
+	}
+}
+
+// ReadCredentials returns the credentials stored under the path, found is false if there are none.
+func (v *API) ReadCredentials(path, token string) (username, password string, found bool, err error) {
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	status, err := v.do("GET", path, token, nil, &response)
+	if err != nil {
+		return "", "", false, err
+	}
+	if status == http.StatusNotFound {
+		return "", "", false, nil
+	}
+
+	var data struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	// the version 2 of the engine wraps the secret into the metadata of its version
+	if v.kvVersion == 2 {
+		var versioned struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(response.Data, &versioned); err != nil {
+			return "", "", false, fmt.Errorf("could not parse Vault response: %v", err)
+		}
+		// a deleted version has no data
+		if len(versioned.Data) == 0 || string(versioned.Data) == "null" {
+			return "", "", false, nil
+		}
+		response.Data = versioned.Data
+	}
+	if err := json.Unmarshal(response.Data, &data); err != nil {
+		return "", "", false, fmt.Errorf("could not parse Vault response: %v", err)
+	}
+
+	return data.Username, data.Password, true, nil
+}
+
+// WriteCredentials stores the credentials under the path, replacing the ones stored before.
+func (v *API) WriteCredentials(path, token, username, password string) error {
+	var body interface{} = map[string]string{"username": username, "password": password}
+	if v.kvVersion == 2 {
+		body = map[string]interface{}{"data": body}
+	}
+	_, err := v.do("POST", path, token, body, nil)
+	return err
+}
+
+// DeleteCredentials removes the credentials stored under the path, with the version 2 of the engine the latest
+// version is deleted and can be undeleted in Vault.
+func (v *API) DeleteCredentials(path, token string) error {
+	_, err := v.do("DELETE", path, token, nil, nil)
+	return err
+}
+
+// do sends the request to Vault and decodes the response into result, if any. Not found is no error, since Vault
+// answers with it for the paths without secrets, the caller gets the status code instead.
+func (v *API) do(method, path, token string, body, result interface{}) (status int, err error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("could not marshal Vault request: %v", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s", v.url, strings.TrimLeft(path, "/"))
+	v.logger.Debugf("%s %s", method, url)
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("X-Vault-Token", token)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not reach Vault: %v", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error when closing response: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var raw struct {
+			Errors []string `json:"errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil || len(raw.Errors) == 0 {
+			return resp.StatusCode, fmt.Errorf("request %s %s to Vault failed with status code %d",
+				method, path, resp.StatusCode)
+		}
+		return resp.StatusCode, fmt.Errorf("request %s %s to Vault failed with status code %d and message: %s",
+			method, path, resp.StatusCode, strings.Join(raw.Errors, "; "))
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp.StatusCode, fmt.Errorf("could not parse Vault response: %v", err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
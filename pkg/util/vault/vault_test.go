@@ -0,0 +1,86 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var logger = logrus.New().WithField("test", "vault")
+
+// fakeVault is a key/value secrets engine of the given version keeping the secrets in memory.
+func fakeVault(t *testing.T, kvVersion int, secrets map[string]map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		switch r.Method {
+		case "GET":
+			data, ok := secrets[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors": []}`))
+				return
+			}
+			var response interface{} = map[string]interface{}{"data": data}
+			if kvVersion == 2 {
+				response = map[string]interface{}{"data": map[string]interface{}{"data": data, "metadata": nil}}
+			}
+			json.NewEncoder(w).Encode(response)
+		case "POST":
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatalf("could not decode request: %v", err)
+			}
+			if kvVersion == 2 {
+				body = body["data"].(map[string]interface{})
+			}
+			data := make(map[string]string)
+			for k, v := range body {
+				data[k] = v.(string)
+			}
+			secrets[r.URL.Path] = data
+			w.WriteHeader(http.StatusNoContent)
+		case "DELETE":
+			delete(secrets, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestCredentials(t *testing.T) {
+	for _, kvVersion := range []int{1, 2} {
+		secrets := make(map[string]map[string]string)
+		server := fakeVault(t, kvVersion, secrets)
+		api := NewVaultAPI(server.URL+"/", kvVersion, logger)
+		path := "secret/data/postgres/default/acid-test/app"
+
+		if _, _, found, err := api.ReadCredentials(path, "s.token"); err != nil || found {
+			t.Errorf("TestCredentials v%d: expected no credentials, got found %t, error %v", kvVersion, found, err)
+		}
+		if err := api.WriteCredentials(path, "s.token", "app", "secret"); err != nil {
+			t.Errorf("TestCredentials v%d: could not write credentials: %v", kvVersion, err)
+		}
+		if data := secrets["/v1/"+path]; data["username"] != "app" || data["password"] != "secret" {
+			t.Errorf("TestCredentials v%d: unexpected secret %v", kvVersion, data)
+		}
+		username, password, found, err := api.ReadCredentials(path, "s.token")
+		if err != nil || !found || username != "app" || password != "secret" {
+			t.Errorf("TestCredentials v%d: expected the written credentials, got %q, %q, found %t, error %v",
+				kvVersion, username, password, found, err)
+		}
+		if err := api.DeleteCredentials(path, "s.token"); err != nil || len(secrets) != 0 {
+			t.Errorf("TestCredentials v%d: expected the credentials to be deleted, got %v, error %v",
+				kvVersion, secrets, err)
+		}
+		if err := api.WriteCredentials(path, "s.other", "app", "secret"); err == nil {
+			t.Errorf("TestCredentials v%d: expected an error for a denied request", kvVersion)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,111 @@
+package volumes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+)
+
+// AzureVolumeResizer implements volume resizing interface for Azure managed disks.
+type AzureVolumeResizer struct {
+	authorizer autorest.Authorizer
+}
+
+// ConnectToProvider gets the Azure credentials from the environment of the operator, i.e. AZURE_CLIENT_ID,
+// AZURE_CLIENT_SECRET and AZURE_TENANT_ID, or the managed identity of the node.
+func (c *AzureVolumeResizer) ConnectToProvider() error {
+	authorizer, err := auth.NewAuthorizerFromEnvironment()
+	if err != nil {
+		return fmt.Errorf("could not get Azure credentials: %v", err)
+	}
+	c.authorizer = authorizer
+	return nil
+}
+
+// IsConnectedToProvider checks if the Azure credentials are available.
+func (c *AzureVolumeResizer) IsConnectedToProvider() bool {
+	return c.authorizer != nil
+}
+
+// VolumeBelongsToProvider checks if the given persistent volume is backed by an Azure disk.
+func (c *AzureVolumeResizer) VolumeBelongsToProvider(pv *v1.PersistentVolume) bool {
+	return pv.Spec.AzureDisk != nil && pv.Annotations[constants.VolumeStorateProvisionerAnnotation] == constants.AzureDiskProvisioner
+}
+
+// GetProviderVolumeID returns the resource id of the managed disk, i.e.
+// /subscriptions/{subscription}/resourceGroups/{group}/providers/Microsoft.Compute/disks/{disk}.
+// Disks kept as blobs in a storage account are not supported.
+func (c *AzureVolumeResizer) GetProviderVolumeID(pv *v1.PersistentVolume) (string, error) {
+	diskURI := pv.Spec.AzureDisk.DataDiskURI
+	if diskURI == "" {
+		return "", fmt.Errorf("disk URI is empty for volume %q", pv.Name)
+	}
+	if _, _, _, err := parseAzureDiskID(diskURI); err != nil {
+		return "", fmt.Errorf("volume %q is not a managed disk: %v", pv.Name, err)
+	}
+	return diskURI, nil
+}
+
+// ResizeVolume calls the Azure API to resize the managed disk if necessary and waits until it is done. Attached disks
+// are refused, Azure resizes them only while the virtual machine is deallocated.
+func (c *AzureVolumeResizer) ResizeVolume(diskID string, newSize int64) error {
+	subscription, group, diskName, err := parseAzureDiskID(diskID)
+	if err != nil {
+		return err
+	}
+	client := compute.NewDisksClient(subscription)
+	client.Authorizer = c.authorizer
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.AzureVolumeResizeTimeout)
+	defer cancel()
+
+	/* first check if the disk is already of a requested size */
+	disk, err := client.Get(ctx, group, diskName)
+	if err != nil {
+		return fmt.Errorf("could not get information about the disk: %v", err)
+	}
+	if disk.DiskProperties != nil && disk.DiskSizeGB != nil && int64(*disk.DiskSizeGB) == newSize {
+		// nothing to do
+		return nil
+	}
+	// Azure only resizes the attached disks of deallocated virtual machines, the pod would have to be stopped
+	if disk.DiskProperties != nil && disk.DiskState == compute.Attached {
+		return fmt.Errorf("managed disk %q is attached to a virtual machine and cannot be resized by the operator, "+
+			"use the %q volume resize mode instead", diskName, config.VolumeResizeModePVC)
+	}
+	size := int32(newSize)
+	future, err := client.Update(ctx, group, diskName, compute.DiskUpdate{
+		DiskUpdateProperties: &compute.DiskUpdateProperties{DiskSizeGB: &size},
+	})
+	if err != nil {
+		return fmt.Errorf("could not resize managed disk: %v", err)
+	}
+	if err := future.WaitForCompletionRef(ctx, client.Client); err != nil {
+		return fmt.Errorf("could not wait for the resize of managed disk %q: %v", diskName, err)
+	}
+	return nil
+}
+
+// DisconnectFromProvider forgets the Azure credentials
+func (c *AzureVolumeResizer) DisconnectFromProvider() error {
+	c.authorizer = nil
+	return nil
+}
+
+// parseAzureDiskID splits the resource id of a managed disk into the subscription, the resource group and the name.
+func parseAzureDiskID(diskID string) (subscription, group, diskName string, err error) {
+	parts := strings.Split(strings.Trim(diskID, "/"), "/")
+	if len(parts) != 8 || !strings.EqualFold(parts[0], "subscriptions") || !strings.EqualFold(parts[2], "resourceGroups") ||
+		!strings.Contains(strings.ToLower(diskID), strings.ToLower(constants.AzureManagedDiskIDPart)) {
+		return "", "", "", fmt.Errorf("malformed managed disk id %q", diskID)
+	}
+	return parts[1], parts[3], parts[7], nil
+}
@@ -0,0 +1,33 @@
+package volumes
+
+import (
+	"testing"
+)
+
+func TestParseAzureDiskID(t *testing.T) {
+	tests := []struct {
+		about        string
+		diskID       string
+		subscription string
+		group        string
+		diskName     string
+		err          bool
+	}{
+		{"managed disk", "/subscriptions/1234/resourceGroups/MC_aks_westeurope/providers/Microsoft.Compute/disks/kubernetes-dynamic-pvc-1",
+			"1234", "MC_aks_westeurope", "kubernetes-dynamic-pvc-1", false},
+		{"lower case resource id", "/subscriptions/1234/resourcegroups/mc_aks/providers/microsoft.compute/disks/pvc-2",
+			"1234", "mc_aks", "pvc-2", false},
+		{"blob disk", "https://account.blob.core.windows.net/vhds/pvc-3.vhd", "", "", "", true},
+		{"snapshot", "/subscriptions/1234/resourceGroups/aks/providers/Microsoft.Compute/snapshots/snap-1", "", "", "", true},
+	}
+	for _, tt := range tests {
+		subscription, group, diskName, err := parseAzureDiskID(tt.diskID)
+		if (err != nil) != tt.err {
+			t.Errorf("TestParseAzureDiskID %s: expected error %t, got %v", tt.about, tt.err, err)
+		}
+		if subscription != tt.subscription || group != tt.group || diskName != tt.diskName {
+			t.Errorf("TestParseAzureDiskID %s: expected %q %q %q, got %q %q %q", tt.about,
+				tt.subscription, tt.group, tt.diskName, subscription, group, diskName)
+		}
+	}
+}
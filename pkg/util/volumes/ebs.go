@@ -2,9 +2,13 @@ package volumes
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"k8s.io/client-go/pkg/api/v1"
@@ -15,17 +19,48 @@ import (
 
 // EBSVolumeResizer implements volume resizing interface for AWS EBS volumes.
 type EBSVolumeResizer struct {
-	connection *ec2.EC2
-	AWSRegion  string
+	connection  *ec2.EC2
+	AWSRegion   string
+	RoleARN     string
+	EC2Endpoint string
+	Proxy       string
+}
+
+// awsConfigs returns the configuration of the AWS session and the one of the EC2 client on top of it. The endpoint
+// only applies to EC2, since the session also talks to STS when assuming a role.
+func (c *EBSVolumeResizer) awsConfigs() (sessionConfig *aws.Config, ec2Config *aws.Config, err error) {
+	sessionConfig = aws.NewConfig().WithRegion(c.AWSRegion)
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse AWS proxy URL %q: %v", c.Proxy, err)
+		}
+		sessionConfig = sessionConfig.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		})
+	}
+
+	ec2Config = aws.NewConfig()
+	if c.EC2Endpoint != "" {
+		ec2Config = ec2Config.WithEndpoint(c.EC2Endpoint)
+	}
+	return sessionConfig, ec2Config, nil
 }
 
 // ConnectToProvider connects to AWS.
 func (c *EBSVolumeResizer) ConnectToProvider() error {
-	sess, err := session.NewSession(&aws.Config{Region: aws.String(c.AWSRegion)})
+	sessionConfig, ec2Config, err := c.awsConfigs()
+	if err != nil {
+		return err
+	}
+	sess, err := session.NewSession(sessionConfig)
 	if err != nil {
 		return fmt.Errorf("could not establish AWS session: %v", err)
 	}
-	c.connection = ec2.New(sess)
+	if c.RoleARN != "" {
+		ec2Config = ec2Config.WithCredentials(stscreds.NewCredentials(sess, c.RoleARN))
+	}
+	c.connection = ec2.New(sess, ec2Config)
 	return nil
 }
 
@@ -54,7 +89,13 @@ func (c *EBSVolumeResizer) GetProviderVolumeID(pv *v1.PersistentVolume) (string,
 
 // ResizeVolume actually calls AWS API to resize the EBS volume if necessary.
 func (c *EBSVolumeResizer) ResizeVolume(volumeID string, newSize int64) error {
-	/* first check if the volume is already of a requested size */
+	return c.ModifyVolume(volumeID, VolumeModification{Size: newSize})
+}
+
+// ModifyVolume calls AWS API to change the size, the type, the IOPS and the throughput of the EBS volume if necessary,
+// all in a single modification since EBS volumes can only be modified once in 6 hours.
+func (c *EBSVolumeResizer) ModifyVolume(volumeID string, modification VolumeModification) error {
+	/* first check if the volume is already of a requested size, type and performance */
 	volumeOutput, err := c.connection.DescribeVolumes(&ec2.DescribeVolumesInput{VolumeIds: []*string{&volumeID}})
 	if err != nil {
 		return fmt.Errorf("could not get information about the volume: %v", err)
@@ -63,12 +104,16 @@ func (c *EBSVolumeResizer) ResizeVolume(volumeID string, newSize int64) error {
 	if *vol.VolumeId != volumeID {
 		return fmt.Errorf("describe volume %q returned information about a non-matching volume %q", volumeID, *vol.VolumeId)
 	}
-	if *vol.Size == newSize {
+	input := ebsModifyVolumeInput(vol, modification)
+	if input == nil {
 		// nothing to do
 		return nil
 	}
-	input := ec2.ModifyVolumeInput{Size: &newSize, VolumeId: &volumeID}
-	output, err := c.connection.ModifyVolume(&input)
+	waitTimeout := constants.EBSVolumeResizeWaitTimeout
+	if input.VolumeType != nil {
+		waitTimeout = constants.EBSVolumeTypeChangeWaitTimeout
+	}
+	output, err := c.connection.ModifyVolume(input)
 	if err != nil {
 		return fmt.Errorf("could not modify persistent volume: %v", err)
 	}
@@ -83,9 +128,9 @@ func (c *EBSVolumeResizer) ResizeVolume(volumeID string, newSize int64) error {
 	if state == constants.EBSVolumeStateOptimizing || state == constants.EBSVolumeStateCompleted {
 		return nil
 	}
-	// wait until the volume reaches the "optimizing" or "completed" state
+	// wait until the volume reaches the "optimizing" or "completed" state, from then on it has the new size and type
 	in := ec2.DescribeVolumesModificationsInput{VolumeIds: []*string{&volumeID}}
-	return retryutil.Retry(constants.EBSVolumeResizeWaitInterval, constants.EBSVolumeResizeWaitTimeout,
+	return retryutil.Retry(constants.EBSVolumeResizeWaitInterval, waitTimeout,
 		func() (bool, error) {
 			out, err := c.connection.DescribeVolumesModifications(&in)
 			if err != nil {
@@ -102,6 +147,63 @@ func (c *EBSVolumeResizer) ResizeVolume(volumeID string, newSize int64) error {
 		})
 }
 
+// TagVolume adds the tags to the EBS volume, replacing the values of existing tags with the same keys.
+func (c *EBSVolumeResizer) TagVolume(volumeID string, tags map[string]string) error {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ec2Tags := make([]*ec2.Tag, 0, len(keys))
+	for _, key := range keys {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	if _, err := c.connection.CreateTags(&ec2.CreateTagsInput{Resources: []*string{&volumeID}, Tags: ec2Tags}); err != nil {
+		return fmt.Errorf("could not tag volume %q: %v", volumeID, err)
+	}
+	return nil
+}
+
+// SnapshotVolume starts a snapshot of the EBS volume. The snapshot holds the data of the moment it is started, so the
+// volume can be modified right away while the snapshot is still pending.
+func (c *EBSVolumeResizer) SnapshotVolume(volumeID, description string) (string, error) {
+	snapshot, err := c.connection.CreateSnapshot(&ec2.CreateSnapshotInput{
+		VolumeId:    aws.String(volumeID),
+		Description: aws.String(description),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not snapshot volume %q: %v", volumeID, err)
+	}
+	return aws.StringValue(snapshot.SnapshotId), nil
+}
+
+// ebsModifyVolumeInput returns the modification of the volume changing only the properties that differ from the
+// requested ones, nil if there are none.
+func ebsModifyVolumeInput(vol *ec2.Volume, modification VolumeModification) *ec2.ModifyVolumeInput {
+	input := &ec2.ModifyVolumeInput{VolumeId: vol.VolumeId}
+	changed := false
+	if vol.Size == nil || *vol.Size != modification.Size {
+		input.Size = &modification.Size
+		changed = true
+	}
+	if modification.VolumeType != "" && (vol.VolumeType == nil || *vol.VolumeType != modification.VolumeType) {
+		input.VolumeType = &modification.VolumeType
+		changed = true
+	}
+	if modification.Iops != nil && (vol.Iops == nil || *vol.Iops != *modification.Iops) {
+		input.Iops = modification.Iops
+		changed = true
+	}
+	if modification.Throughput != nil && (vol.Throughput == nil || *vol.Throughput != *modification.Throughput) {
+		input.Throughput = modification.Throughput
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return input
+}
+
 // DisconnectFromProvider closes connection to the EC2 instance
 func (c *EBSVolumeResizer) DisconnectFromProvider() error {
 	c.connection = nil
@@ -0,0 +1,91 @@
+package volumes
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestEBSModifyVolumeInput(t *testing.T) {
+	gp2 := &ec2.Volume{VolumeId: aws.String("vol-1"), Size: aws.Int64(100), VolumeType: aws.String("gp2"), Iops: aws.Int64(300)}
+	gp3 := &ec2.Volume{VolumeId: aws.String("vol-1"), Size: aws.Int64(100), VolumeType: aws.String("gp3"),
+		Iops: aws.Int64(3000), Throughput: aws.Int64(125)}
+	tests := []struct {
+		about        string
+		vol          *ec2.Volume
+		modification VolumeModification
+		expected     *ec2.ModifyVolumeInput
+	}{
+		{"nothing to change", gp2, VolumeModification{Size: 100}, nil},
+		{"same type", gp3, VolumeModification{Size: 100, VolumeType: "gp3", Iops: aws.Int64(3000)}, nil},
+		{"grow", gp2, VolumeModification{Size: 200},
+			&ec2.ModifyVolumeInput{VolumeId: aws.String("vol-1"), Size: aws.Int64(200)}},
+		{"migrate to gp3", gp2, VolumeModification{Size: 100, VolumeType: "gp3"},
+			&ec2.ModifyVolumeInput{VolumeId: aws.String("vol-1"), VolumeType: aws.String("gp3")}},
+		{"more throughput", gp3, VolumeModification{Size: 100, Throughput: aws.Int64(250)},
+			&ec2.ModifyVolumeInput{VolumeId: aws.String("vol-1"), Throughput: aws.Int64(250)}},
+	}
+	for _, tt := range tests {
+		input := ebsModifyVolumeInput(tt.vol, tt.modification)
+		if (input == nil) != (tt.expected == nil) {
+			t.Errorf("TestEBSModifyVolumeInput %s: expected %v, got %v", tt.about, tt.expected, input)
+			continue
+		}
+		if input != nil && input.String() != tt.expected.String() {
+			t.Errorf("TestEBSModifyVolumeInput %s: expected %v, got %v", tt.about, tt.expected, input)
+		}
+	}
+}
+
+func TestEBSAWSConfigs(t *testing.T) {
+	tests := []struct {
+		about    string
+		resizer  *EBSVolumeResizer
+		endpoint string
+		proxy    string
+		err      bool
+	}{
+		{"implicit defaults", &EBSVolumeResizer{AWSRegion: "eu-central-1"}, "", "", false},
+		{"custom endpoint", &EBSVolumeResizer{AWSRegion: "eu-central-1",
+			EC2Endpoint: "https://vpce-1.ec2.eu-central-1.vpce.amazonaws.com"},
+			"https://vpce-1.ec2.eu-central-1.vpce.amazonaws.com", "", false},
+		{"proxy", &EBSVolumeResizer{AWSRegion: "eu-central-1", Proxy: "http://proxy.example.com:3128"},
+			"", "http://proxy.example.com:3128", false},
+		{"malformed proxy", &EBSVolumeResizer{AWSRegion: "eu-central-1", Proxy: "http://proxy example.com"}, "", "", true},
+	}
+	for _, tt := range tests {
+		sessionConfig, ec2Config, err := tt.resizer.awsConfigs()
+		if (err != nil) != tt.err {
+			t.Errorf("TestEBSAWSConfigs %s: expected error %t, got %v", tt.about, tt.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if region := aws.StringValue(sessionConfig.Region); region != tt.resizer.AWSRegion {
+			t.Errorf("TestEBSAWSConfigs %s: expected region %q, got %q", tt.about, tt.resizer.AWSRegion, region)
+		}
+		if sessionConfig.Endpoint != nil {
+			t.Errorf("TestEBSAWSConfigs %s: the endpoint must not apply to the whole session", tt.about)
+		}
+		if endpoint := aws.StringValue(ec2Config.Endpoint); endpoint != tt.endpoint {
+			t.Errorf("TestEBSAWSConfigs %s: expected endpoint %q, got %q", tt.about, tt.endpoint, endpoint)
+		}
+		var proxy string
+		if sessionConfig.HTTPClient != nil {
+			transport := sessionConfig.HTTPClient.Transport.(*http.Transport)
+			proxyURL, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "ec2.amazonaws.com"}})
+			if err != nil {
+				t.Errorf("TestEBSAWSConfigs %s: could not get proxy: %v", tt.about, err)
+			} else if proxyURL != nil {
+				proxy = proxyURL.String()
+			}
+		}
+		if proxy != tt.proxy {
+			t.Errorf("TestEBSAWSConfigs %s: expected proxy %q, got %q", tt.about, tt.proxy, proxy)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package volumes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+	"github.com/zalando-incubator/postgres-operator/pkg/util/retryutil"
+)
+
+// GCEVolumeResizer implements volume resizing interface for GCE persistent disks.
+type GCEVolumeResizer struct {
+	connection *compute.Service
+	project    string
+	GCEProject string
+}
+
+// ConnectToProvider connects to the Compute Engine API with the application default credentials. The project is
+// taken from the metadata server unless configured explicitly.
+func (c *GCEVolumeResizer) ConnectToProvider() error {
+	client, err := google.DefaultClient(context.Background(), compute.ComputeScope)
+	if err != nil {
+		return fmt.Errorf("could not get GCE credentials: %v", err)
+	}
+	connection, err := compute.New(client)
+	if err != nil {
+		return fmt.Errorf("could not create GCE client: %v", err)
+	}
+	c.project = c.GCEProject
+	if c.project == "" {
+		if c.project, err = metadata.ProjectID(); err != nil {
+			return fmt.Errorf("could not get GCE project from the metadata server: %v", err)
+		}
+	}
+	c.connection = connection
+	return nil
+}
+
+// IsConnectedToProvider checks if GCE connection is established.
+func (c *GCEVolumeResizer) IsConnectedToProvider() bool {
+	return c.connection != nil
+}
+
+// VolumeBelongsToProvider checks if the given persistent volume is backed by a GCE persistent disk.
+func (c *GCEVolumeResizer) VolumeBelongsToProvider(pv *v1.PersistentVolume) bool {
+	return pv.Spec.GCEPersistentDisk != nil && pv.Annotations[constants.VolumeStorateProvisionerAnnotation] == constants.GCEPDProvisioner
+}
+
+// GetProviderVolumeID returns the zone and the name of the persistent disk, i.e. europe-west1-b/gke-pvc-1234,
+// regional disks replicated across several zones are not supported.
+func (c *GCEVolumeResizer) GetProviderVolumeID(pv *v1.PersistentVolume) (string, error) {
+	diskName := pv.Spec.GCEPersistentDisk.PDName
+	if diskName == "" {
+		return "", fmt.Errorf("disk name is empty for volume %q", pv.Name)
+	}
+	zone := pv.Labels[constants.GCEZoneLabel]
+	if zone == "" {
+		return "", fmt.Errorf("zone label is missing for volume %q", pv.Name)
+	}
+	if strings.Contains(zone, "__") {
+		return "", fmt.Errorf("volume %q is a regional disk in the zones %q", pv.Name, zone)
+	}
+	return zone + "/" + diskName, nil
+}
+
+// ResizeVolume calls the GCE API to resize the persistent disk if necessary and waits for the operation to finish.
+func (c *GCEVolumeResizer) ResizeVolume(volumeID string, newSize int64) error {
+	parts := strings.SplitN(volumeID, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed GCE volume id %q", volumeID)
+	}
+	zone, diskName := parts[0], parts[1]
+
+	/* first check if the disk is already of a requested size */
+	disk, err := c.connection.Disks.Get(c.project, zone, diskName).Do()
+	if err != nil {
+		return fmt.Errorf("could not get information about the disk: %v", err)
+	}
+	if disk.SizeGb == newSize {
+		// nothing to do
+		return nil
+	}
+	op, err := c.connection.Disks.Resize(c.project, zone, diskName, &compute.DisksResizeRequest{SizeGb: newSize}).Do()
+	if err != nil {
+		return fmt.Errorf("could not resize persistent disk: %v", err)
+	}
+
+	// wait until the zone operation is done, the disk can only be used with the new size afterwards
+	return retryutil.Retry(constants.GCEVolumeResizeWaitInterval, constants.GCEVolumeResizeWaitTimeout,
+		func() (bool, error) {
+			if op.Status == constants.GCEOperationStatusDone {
+				return true, operationError(op)
+			}
+			if op, err = c.connection.ZoneOperations.Get(c.project, zone, op.Name).Do(); err != nil {
+				return false, fmt.Errorf("could not get resize operation of disk %q: %v", diskName, err)
+			}
+			return op.Status == constants.GCEOperationStatusDone, operationError(op)
+		})
+}
+
+// DisconnectFromProvider drops the connection to the Compute Engine API
+func (c *GCEVolumeResizer) DisconnectFromProvider() error {
+	c.connection = nil
+	return nil
+}
+
+func operationError(op *compute.Operation) error {
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+	var messages []string
+	for _, e := range op.Error.Errors {
+		messages = append(messages, e.Message)
+	}
+	return fmt.Errorf("operation %q has failed: %s", op.Name, strings.Join(messages, "; "))
+}
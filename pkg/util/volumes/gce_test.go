@@ -0,0 +1,70 @@
+package volumes
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/util/constants"
+)
+
+func gcePersistentVolume(name, provisioner, zone, diskName string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{constants.VolumeStorateProvisionerAnnotation: provisioner},
+			Labels:      map[string]string{},
+		},
+	}
+	if zone != "" {
+		pv.Labels[constants.GCEZoneLabel] = zone
+	}
+	if diskName != "" {
+		pv.Spec.GCEPersistentDisk = &v1.GCEPersistentDiskVolumeSource{PDName: diskName}
+	}
+	return pv
+}
+
+func TestGCEVolumeBelongsToProvider(t *testing.T) {
+	resizer := &GCEVolumeResizer{}
+	tests := []struct {
+		about    string
+		pv       *v1.PersistentVolume
+		expected bool
+	}{
+		{"persistent disk", gcePersistentVolume("pv-1", constants.GCEPDProvisioner, "europe-west1-b", "gke-pvc-1"), true},
+		{"no persistent disk", gcePersistentVolume("pv-2", constants.GCEPDProvisioner, "europe-west1-b", ""), false},
+		{"other provisioner", gcePersistentVolume("pv-3", "pd.csi.storage.gke.io", "europe-west1-b", "gke-pvc-3"), false},
+	}
+	for _, tt := range tests {
+		if result := resizer.VolumeBelongsToProvider(tt.pv); result != tt.expected {
+			t.Errorf("TestGCEVolumeBelongsToProvider %s: expected %t, got %t", tt.about, tt.expected, result)
+		}
+	}
+}
+
+func TestGCEGetProviderVolumeID(t *testing.T) {
+	resizer := &GCEVolumeResizer{}
+	tests := []struct {
+		about    string
+		pv       *v1.PersistentVolume
+		expected string
+		err      bool
+	}{
+		{"zonal disk", gcePersistentVolume("pv-1", constants.GCEPDProvisioner, "europe-west1-b", "gke-pvc-1"),
+			"europe-west1-b/gke-pvc-1", false},
+		{"missing zone", gcePersistentVolume("pv-2", constants.GCEPDProvisioner, "", "gke-pvc-2"), "", true},
+		{"regional disk", gcePersistentVolume("pv-3", constants.GCEPDProvisioner, "europe-west1-b__europe-west1-c",
+			"gke-pvc-3"), "", true},
+	}
+	for _, tt := range tests {
+		id, err := resizer.GetProviderVolumeID(tt.pv)
+		if (err != nil) != tt.err {
+			t.Errorf("TestGCEGetProviderVolumeID %s: expected error %t, got %v", tt.about, tt.err, err)
+		}
+		if id != tt.expected {
+			t.Errorf("TestGCEGetProviderVolumeID %s: expected %q, got %q", tt.about, tt.expected, id)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package volumes
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
 )
 
 // VolumeResizer defines the set of methods used to implememnt provider-specific resizing of persistent volumes.
@@ -13,3 +19,74 @@ type VolumeResizer interface {
 	ResizeVolume(providerVolumeID string, newSize int64) error
 	DisconnectFromProvider() error
 }
+
+// VolumeModification describes the properties a volume should have, nil or empty ones are left as they are.
+type VolumeModification struct {
+	Size       int64
+	Iops       *int64
+	Throughput *int64
+	VolumeType string
+}
+
+// VolumeModifier is implemented by the resizers of providers whose volumes have a type and a provisioned performance.
+// The size, the type and the performance are changed by a single modification.
+type VolumeModifier interface {
+	ModifyVolume(providerVolumeID string, modification VolumeModification) error
+}
+
+// VolumeSnapshotter is implemented by the resizers of providers that snapshot their volumes, it returns the id of the
+// snapshot taken.
+type VolumeSnapshotter interface {
+	SnapshotVolume(providerVolumeID, description string) (string, error)
+}
+
+// VolumeTagger is implemented by the resizers of providers whose volumes carry tags, i.e. for the cost allocation.
+type VolumeTagger interface {
+	TagVolume(providerVolumeID string, tags map[string]string) error
+}
+
+// resizerFactories creates the resizers by the names the volume_resizers option of the operator refers to them.
+// A new provider only needs to implement the VolumeResizer interface and to be added here.
+var resizerFactories = map[string]func(cfg *config.Config) VolumeResizer{
+	"ebs": func(cfg *config.Config) VolumeResizer {
+		return &EBSVolumeResizer{
+			AWSRegion:   cfg.AWSRegion,
+			RoleARN:     cfg.AWSRoleARN,
+			EC2Endpoint: cfg.AWSEC2Endpoint,
+			Proxy:       cfg.AWSProxy,
+		}
+	},
+	"gce": func(cfg *config.Config) VolumeResizer {
+		return &GCEVolumeResizer{GCEProject: cfg.GCEProject}
+	},
+	"azure": func(cfg *config.Config) VolumeResizer {
+		return &AzureVolumeResizer{}
+	},
+}
+
+// NewVolumeResizers returns the resizers enabled in the operator configuration, in the configured order.
+func NewVolumeResizers(cfg *config.Config) ([]VolumeResizer, error) {
+	result := make([]VolumeResizer, 0, len(cfg.VolumeResizers))
+	for _, name := range cfg.VolumeResizers {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := resizerFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown volume resizer %q, supported are %s", name,
+				strings.Join(resizerNames(), ", "))
+		}
+		result = append(result, factory(cfg))
+	}
+	return result, nil
+}
+
+func resizerNames() []string {
+	names := make([]string, 0, len(resizerFactories))
+	for name := range resizerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,58 @@
+package volumes
+
+import (
+	"testing"
+
+	"github.com/zalando-incubator/postgres-operator/pkg/util/config"
+)
+
+func TestNewVolumeResizers(t *testing.T) {
+	tests := []struct {
+		about     string
+		resizers  []string
+		providers []string
+		err       bool
+	}{
+		{"all providers", []string{"ebs", "gce", "azure"}, []string{"ebs", "gce", "azure"}, false},
+		{"configured order", []string{"azure", " ebs"}, []string{"azure", "ebs"}, false},
+		{"no providers", []string{""}, []string{}, false},
+		{"unknown provider", []string{"ebs", "cinder"}, nil, true},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{AWSRegion: "eu-central-1", AWSRoleARN: "arn:aws:iam::123456789012:role/resizer",
+			AWSEC2Endpoint: "https://ec2.example.com", AWSProxy: "http://proxy.example.com:3128",
+			GCEProject: "acid", VolumeResizers: tt.resizers}
+		resizers, err := NewVolumeResizers(cfg)
+		if (err != nil) != tt.err {
+			t.Errorf("TestNewVolumeResizers %s: expected error %t, got %v", tt.about, tt.err, err)
+			continue
+		}
+		if len(resizers) != len(tt.providers) {
+			t.Errorf("TestNewVolumeResizers %s: expected %d resizers, got %d", tt.about, len(tt.providers), len(resizers))
+			continue
+		}
+		for i, resizer := range resizers {
+			var provider string
+			switch r := resizer.(type) {
+			case *EBSVolumeResizer:
+				provider = "ebs"
+				if r.AWSRegion != cfg.AWSRegion {
+					t.Errorf("TestNewVolumeResizers %s: expected region %q, got %q", tt.about, cfg.AWSRegion, r.AWSRegion)
+				}
+				if r.RoleARN != cfg.AWSRoleARN || r.EC2Endpoint != cfg.AWSEC2Endpoint || r.Proxy != cfg.AWSProxy {
+					t.Errorf("TestNewVolumeResizers %s: unexpected AWS session options %#v", tt.about, r)
+				}
+			case *GCEVolumeResizer:
+				provider = "gce"
+				if r.GCEProject != cfg.GCEProject {
+					t.Errorf("TestNewVolumeResizers %s: expected project %q, got %q", tt.about, cfg.GCEProject, r.GCEProject)
+				}
+			case *AzureVolumeResizer:
+				provider = "azure"
+			}
+			if provider != tt.providers[i] {
+				t.Errorf("TestNewVolumeResizers %s: expected resizer %q at %d, got %q", tt.about, tt.providers[i], i, provider)
+			}
+		}
+	}
+}